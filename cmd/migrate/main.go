@@ -0,0 +1,98 @@
+package main
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"github.com/opsagent/opsagent/internal/database"
+	"github.com/opsagent/opsagent/internal/deployer"
+)
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	db, err := database.Connect()
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+	switch os.Args[1] {
+	case "up":
+		fs := flag.NewFlagSet("up", flag.ExitOnError)
+		dir := fs.String("dir", "internal/database/migrations", "directory containing .sql migrations")
+		fs.Parse(os.Args[2:])
+		applied, err := migrateUp(ctx, db, *dir)
+		if err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		fmt.Printf("✅ Applied %d migrations\n", applied)
+	case "import-history":
+		fs := flag.NewFlagSet("import-history", flag.ExitOnError)
+		path := fs.String("path", "/var/lib/opsagent/history", "directory containing JSON deployment records")
+		batch := fs.Int("batch", 500, "records read per batch")
+		fs.Parse(os.Args[2:])
+		imported, err := deployer.ImportHistory(ctx, deployer.NewFileHistoryStore(*path), deployer.NewPostgresHistoryStore(db.DB), *batch)
+		if err != nil {
+			log.Fatalf("Import failed after %d records: %v", imported, err)
+		}
+		fmt.Printf("✅ Imported %d deployment records from %s\n", imported, *path)
+	default:
+		usage()
+	}
+}
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: migrate up [-dir path] | import-history [-path dir] [-batch n]")
+	os.Exit(2)
+}
+func migrateUp(ctx context.Context, db *database.DB, dir string) (int, error) {
+	if _, err := db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version VARCHAR(255) PRIMARY KEY,
+			applied_at TIMESTAMPTZ DEFAULT NOW()
+		)
+	`); err != nil {
+		return 0, fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.sql"))
+	if err != nil {
+		return 0, err
+	}
+	sort.Strings(files)
+	applied := 0
+	for _, file := range files {
+		version := filepath.Base(file)
+		var exists bool
+		if err := db.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM schema_migrations WHERE version = $1)`, version).Scan(&exists); err != nil {
+			return applied, fmt.Errorf("failed to check migration %s: %w", version, err)
+		}
+		if exists {
+			continue
+		}
+		sqlBytes, err := os.ReadFile(file)
+		if err != nil {
+			return applied, err
+		}
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return applied, err
+		}
+		if _, err := tx.ExecContext(ctx, string(sqlBytes)); err != nil {
+			tx.Rollback()
+			return applied, fmt.Errorf("failed to apply %s: %w", version, err)
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version) VALUES ($1)`, version); err != nil {
+			tx.Rollback()
+			return applied, fmt.Errorf("failed to record %s: %w", version, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return applied, err
+		}
+		fmt.Printf("📄 Applied %s\n", version)
+		applied++
+	}
+	return applied, nil
+}
@@ -0,0 +1,112 @@
+package api
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"github.com/go-chi/chi/v5"
+	"github.com/opsagent/opsagent/internal/database"
+	"github.com/opsagent/opsagent/internal/deployer"
+	"github.com/opsagent/opsagent/internal/rbac"
+)
+type ApprovalRequest struct {
+	Gate     string `json:"gate,omitempty"`
+	Decision string `json:"decision"`
+	Comment  string `json:"comment,omitempty"`
+}
+type ApprovalStatus struct {
+	DeploymentID    string                      `json:"deployment_id"`
+	Phase           deployer.DeploymentPhase    `json:"phase"`
+	PendingApproval *deployer.PendingApproval   `json:"pending_approval,omitempty"`
+	Approvals       []deployer.ApprovalDecision `json:"approvals"`
+}
+func handleGetApprovals(db *database.DB, svc *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		state, _, ok := loadDeploymentState(db, w, r, svc)
+		if !ok {
+			return
+		}
+		writeJSON(w, http.StatusOK, ApprovalStatus{
+			DeploymentID:    state.ID,
+			Phase:           state.Phase,
+			PendingApproval: state.PendingApproval,
+			Approvals:       state.Approvals,
+		})
+	}
+}
+func handleDecideApproval(db *database.DB, svc *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ApprovalRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		var decision deployer.ApprovalDecisionType
+		switch req.Decision {
+		case "approve", "approved":
+			decision = deployer.ApprovalApproved
+		case "reject", "rejected":
+			decision = deployer.ApprovalRejected
+		default:
+			writeError(w, http.StatusBadRequest, "decision must be approve or reject")
+			return
+		}
+		state, orgID, ok := loadDeploymentState(db, w, r, svc)
+		if !ok {
+			return
+		}
+		role, err := rbac.NewRBACService(db.DB).GetUserRole(r.Context(), getUserID(r), orgID)
+		if err != nil {
+			writeError(w, http.StatusForbidden, "not a member of this organization")
+			return
+		}
+		err = svc.Executor.DecideApproval(r.Context(), state.ID, deployer.ApprovalDecision{
+			Gate:      req.Gate,
+			Decision:  decision,
+			UserID:    getUserID(r),
+			UserEmail: getEmail(r),
+			Role:      string(role),
+			Comment:   req.Comment,
+		})
+		switch {
+		case errors.Is(err, deployer.ErrApproverNotAllowed):
+			writeError(w, http.StatusForbidden, err.Error())
+			return
+		case errors.Is(err, deployer.ErrNoPendingApproval), errors.Is(err, deployer.ErrAlreadyDecided):
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		case err != nil:
+			writeError(w, http.StatusInternalServerError, "failed to record decision")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{
+			"deployment_id": state.ID,
+			"decision":      string(decision),
+		})
+	}
+}
+func loadDeploymentState(db *database.DB, w http.ResponseWriter, r *http.Request, svc *Services) (*deployer.DeploymentState, string, bool) {
+	state, err := svc.Executor.GetState(r.Context(), chi.URLParam(r, "deploymentId"))
+	if errors.Is(err, deployer.ErrDeploymentStateNotFound) || (err == nil && state.Config.ProjectID != chi.URLParam(r, "projectId")) {
+		writeError(w, http.StatusNotFound, "deployment not found")
+		return nil, "", false
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load deployment")
+		return nil, "", false
+	}
+	var orgID string
+	err = db.QueryRowContext(r.Context(), `
+		SELECT organization_id FROM projects WHERE id = $1
+	`, state.Config.ProjectID).Scan(&orgID)
+	if err != nil || orgID != getOrgID(r) || (state.Config.OrganizationID != "" && state.Config.OrganizationID != orgID) {
+		writeError(w, http.StatusNotFound, "deployment not found")
+		return nil, "", false
+	}
+	if svc.Environments != nil {
+		err := svc.Environments.Authorize(r.Context(), state.Config.ProjectID, state.Config.Environment, deployer.EnvActionView)
+		if denyEnvironmentAccess(db, w, r, err) {
+			return nil, "", false
+		}
+	}
+	return state, orgID, true
+}
@@ -0,0 +1,60 @@
+package api
+import (
+	"errors"
+	"net/http"
+	"github.com/go-chi/chi/v5"
+	"github.com/opsagent/opsagent/internal/database"
+	"github.com/opsagent/opsagent/internal/deployer"
+	"github.com/opsagent/opsagent/internal/rbac"
+)
+func handleGetColours(db *database.DB, svc *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireEnvironmentAction(db, w, r, svc, deployer.EnvActionView) {
+			return
+		}
+		state, err := svc.History.GetColourState(r.Context(), chi.URLParam(r, "projectId"), chi.URLParam(r, "envName"))
+		if errors.Is(err, deployer.ErrColourStateNotFound) {
+			writeError(w, http.StatusNotFound, "no blue/green deployments for this environment")
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to load blue/green state")
+			return
+		}
+		writeJSON(w, http.StatusOK, state)
+	}
+}
+func handleSwitchBack(db *database.DB, svc *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireEnvironmentAction(db, w, r, svc, deployer.EnvActionDeploy) {
+			return
+		}
+		projectID := chi.URLParam(r, "projectId")
+		environment := chi.URLParam(r, "envName")
+		state, err := svc.Rollbacks.SwitchBack(r.Context(), projectID, environment, getUserID(r))
+		switch {
+		case errors.Is(err, deployer.ErrColourStateNotFound), errors.Is(err, deployer.ErrNoWarmColour):
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		case err != nil && state == nil:
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		rbac.NewRBACService(db.DB).LogAction(r.Context(), &rbac.AuditLog{
+			OrganizationID: getOrgID(r),
+			UserID:         getUserID(r),
+			UserEmail:      getEmail(r),
+			Action:         "deploy.switch_back",
+			ResourceType:   "environment",
+			ResourceID:     environment,
+			IPAddress:      r.RemoteAddr,
+			UserAgent:      r.UserAgent(),
+			Metadata: map[string]interface{}{
+				"project_id":   projectID,
+				"live_colour":  state.Live,
+				"live_version": state.LiveVersion,
+			},
+		})
+		writeJSON(w, http.StatusOK, state)
+	}
+}
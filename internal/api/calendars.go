@@ -0,0 +1,98 @@
+package api
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+	"github.com/go-chi/chi/v5"
+	"github.com/opsagent/opsagent/internal/database"
+	"github.com/opsagent/opsagent/internal/deployer"
+)
+type CalendarCheckResponse struct {
+	Open       bool       `json:"open"`
+	Reason     string     `json:"reason,omitempty"`
+	NextWindow *time.Time `json:"next_window_opens_at,omitempty"`
+}
+func handleListCalendars(svc *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		calendars, err := svc.Calendar.ListCalendars(r.Context(), getOrgID(r))
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to fetch calendars")
+			return
+		}
+		writeJSON(w, http.StatusOK, calendars)
+	}
+}
+func handleSaveCalendar(db *database.DB, svc *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requireOrgAdmin(db, w, r, "calendar.save"); !ok {
+			return
+		}
+		var calendar deployer.ChangeCalendar
+		if err := json.NewDecoder(r.Body).Decode(&calendar); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		calendar.OrganizationID = getOrgID(r)
+		if calendar.ID != "" {
+			existing, err := svc.Calendar.GetCalendar(r.Context(), calendar.ID)
+			if err != nil || existing.OrganizationID != calendar.OrganizationID {
+				writeError(w, http.StatusNotFound, "calendar not found")
+				return
+			}
+			calendar.CreatedAt = existing.CreatedAt
+		}
+		if err := svc.Calendar.SaveCalendar(r.Context(), &calendar); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, calendar)
+	}
+}
+func handleDeleteCalendar(db *database.DB, svc *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requireOrgAdmin(db, w, r, "calendar.delete"); !ok {
+			return
+		}
+		calendarID := chi.URLParam(r, "calendarId")
+		existing, err := svc.Calendar.GetCalendar(r.Context(), calendarID)
+		if err != nil || existing.OrganizationID != getOrgID(r) {
+			writeError(w, http.StatusNotFound, "calendar not found")
+			return
+		}
+		if err := svc.Calendar.DeleteCalendar(r.Context(), calendarID); err != nil && !errors.Is(err, os.ErrNotExist) {
+			writeError(w, http.StatusInternalServerError, "failed to delete calendar")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+func handleCheckCalendar(svc *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := svc.Calendar.Check(r.Context(), getOrgID(r), r.URL.Query().Get("project"), r.URL.Query().Get("environment"), time.Now())
+		var windowErr *deployer.DeployWindowError
+		switch {
+		case errors.As(err, &windowErr):
+			resp := CalendarCheckResponse{Reason: windowErr.Error()}
+			if !windowErr.NextOpen.IsZero() {
+				resp.NextWindow = &windowErr.NextOpen
+			}
+			writeJSON(w, http.StatusOK, resp)
+		case err != nil:
+			writeError(w, http.StatusInternalServerError, "failed to check calendars")
+		default:
+			writeJSON(w, http.StatusOK, CalendarCheckResponse{Open: true})
+		}
+	}
+}
+func writeDeployWindowError(w http.ResponseWriter, err error, windowErr *deployer.DeployWindowError) {
+	resp := map[string]interface{}{
+		"error":    err.Error(),
+		"calendar": windowErr.Calendar,
+	}
+	if !windowErr.NextOpen.IsZero() {
+		resp["next_window_opens_at"] = windowErr.NextOpen
+	}
+	writeJSON(w, http.StatusConflict, resp)
+}
@@ -0,0 +1,51 @@
+package api
+import (
+	"net/http"
+	"time"
+	"github.com/go-chi/chi/v5"
+	"github.com/opsagent/opsagent/internal/database"
+	"github.com/opsagent/opsagent/internal/deployer"
+	"github.com/opsagent/opsagent/internal/dora"
+)
+func handleDORAReport(db *database.DB, svc *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+		query := dora.Query{
+			OrganizationID: getOrgID(r),
+			ProjectID:      params.Get("project"),
+			Environment:    params.Get("environment"),
+			Team:           params.Get("team"),
+			GroupBy:        dora.GroupBy(params.Get("group_by")),
+		}
+		if projectID := chi.URLParam(r, "projectId"); projectID != "" {
+			query.ProjectID = projectID
+		}
+		if query.ProjectID != "" && !requireProjectID(db, w, r, query.ProjectID) {
+			return
+		}
+		if query.ProjectID != "" && query.Environment != "" && svc.Environments != nil {
+			err := svc.Environments.Authorize(r.Context(), query.ProjectID, query.Environment, deployer.EnvActionView)
+			if denyEnvironmentAccess(db, w, r, err) {
+				return
+			}
+		}
+		for name, dst := range map[string]*time.Time{"from": &query.From, "to": &query.To} {
+			raw := params.Get(name)
+			if raw == "" {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, name+" must be an RFC 3339 timestamp")
+				return
+			}
+			*dst = t
+		}
+		report, err := svc.DORA.Report(r.Context(), query)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, report)
+	}
+}
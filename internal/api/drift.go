@@ -0,0 +1,67 @@
+package api
+import (
+	"errors"
+	"net/http"
+	"github.com/go-chi/chi/v5"
+	"github.com/opsagent/opsagent/internal/database"
+	"github.com/opsagent/opsagent/internal/deployer"
+	"github.com/opsagent/opsagent/internal/rbac"
+)
+func writeDriftError(w http.ResponseWriter, err error) {
+	if errors.Is(err, deployer.ErrEnvironmentNotFound) {
+		writeError(w, http.StatusNotFound, "environment not found")
+		return
+	}
+	writeError(w, http.StatusBadGateway, err.Error())
+}
+func handleCheckDrift(db *database.DB, svc *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireEnvironmentAction(db, w, r, svc, deployer.EnvActionView) {
+			return
+		}
+		report, err := svc.Drift.CheckEnvironment(r.Context(), chi.URLParam(r, "projectId"), chi.URLParam(r, "envName"), false)
+		if err != nil {
+			writeDriftError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, report)
+	}
+}
+func handleReconcileDrift(db *database.DB, svc *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireEnvironmentAction(db, w, r, svc, deployer.EnvActionDeploy) {
+			return
+		}
+		projectID := chi.URLParam(r, "projectId")
+		environment := chi.URLParam(r, "envName")
+		report, err := svc.Drift.CheckEnvironment(r.Context(), projectID, environment, true)
+		if err != nil {
+			writeDriftError(w, err)
+			return
+		}
+		if report.Drifted {
+			rbac.NewRBACService(db.DB).LogAction(r.Context(), &rbac.AuditLog{
+				OrganizationID: getOrgID(r),
+				UserID:         getUserID(r),
+				UserEmail:      getEmail(r),
+				Action:         "environment.reconcile",
+				ResourceType:   "environment",
+				ResourceID:     report.EnvironmentID,
+				IPAddress:      r.RemoteAddr,
+				UserAgent:      r.UserAgent(),
+				Metadata: map[string]interface{}{
+					"project_id":      projectID,
+					"environment":     environment,
+					"fields":          len(report.Fields),
+					"reconciled":      report.Reconciled,
+					"reconcile_error": report.ReconcileError,
+				},
+			})
+		}
+		status := http.StatusOK
+		if report.Drifted && !report.Reconciled {
+			status = http.StatusConflict
+		}
+		writeJSON(w, status, report)
+	}
+}
@@ -0,0 +1,92 @@
+package api
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"github.com/opsagent/opsagent/internal/database"
+	"github.com/opsagent/opsagent/internal/deployer"
+)
+var envFormatContentTypes = map[deployer.EnvFormat]string{
+	deployer.FormatDotenv: "text/plain; charset=utf-8",
+	deployer.FormatJSON:   "application/json",
+	deployer.FormatYAML:   "application/yaml",
+}
+func handleImportEnvironment(db *database.DB, svc *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var opts deployer.ImportOptions
+		if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		env, ok := pathEnvironment(db, w, r, svc)
+		if !ok {
+			return
+		}
+		result, err := svc.Environments.ImportEnvironment(r.Context(), env.ID, requestAuthor(r), opts)
+		if writeAccessDenied(db, w, r, err) {
+			return
+		}
+		if errors.Is(err, deployer.ErrRevisionConflict) {
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		if !result.DryRun {
+			logEnvironmentAction(db, r, "environment.import", env, map[string]interface{}{
+				"format":    string(opts.Format),
+				"mode":      string(opts.Mode),
+				"variables": result.Variables,
+				"secrets":   result.Secrets,
+				"revision":  result.Revision,
+			})
+		}
+		writeJSON(w, http.StatusOK, result)
+	}
+}
+func handleExportEnvironment(db *database.DB, svc *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		format := deployer.EnvFormat(r.URL.Query().Get("format"))
+		if format == "" {
+			format = deployer.FormatDotenv
+		}
+		contentType, supported := envFormatContentTypes[format]
+		if !supported {
+			writeError(w, http.StatusBadRequest, "format must be dotenv, json or yaml")
+			return
+		}
+		reveal, _ := strconv.ParseBool(r.URL.Query().Get("reveal_secrets"))
+		env, ok := pathEnvironment(db, w, r, svc)
+		if !ok {
+			return
+		}
+		data, err := svc.Environments.ExportEnvironment(r.Context(), env.ID, format, reveal)
+		if writeAccessDenied(db, w, r, err) {
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if reveal {
+			logEnvironmentAction(db, r, "environment.export", env, map[string]interface{}{
+				"format":         string(format),
+				"reveal_secrets": true,
+			})
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", env.Name+"."+exportExtension(format)))
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	}
+}
+func exportExtension(format deployer.EnvFormat) string {
+	if format == deployer.FormatDotenv {
+		return "env"
+	}
+	return string(format)
+}
@@ -0,0 +1,213 @@
+package api
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"sync"
+	"github.com/go-chi/chi/v5"
+	"github.com/opsagent/opsagent/internal/database"
+	"github.com/opsagent/opsagent/internal/deployer"
+	"github.com/opsagent/opsagent/internal/rbac"
+)
+func PrincipalMiddleware(db *database.DB) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal := &deployer.Principal{
+				UserID:         getUserID(r),
+				Email:          getEmail(r),
+				OrganizationID: getOrgID(r),
+				ProjectRole:    projectRoleResolver(r.Context(), db, getUserID(r)),
+			}
+			if role, err := rbac.NewRBACService(db.DB).GetUserRole(r.Context(), principal.UserID, getOrgID(r)); err == nil {
+				principal.Role = string(role)
+			}
+			next.ServeHTTP(w, r.WithContext(deployer.WithPrincipal(r.Context(), principal)))
+		})
+	}
+}
+func projectRoleResolver(ctx context.Context, db *database.DB, userID string) func(string) string {
+	var mu sync.Mutex
+	roles := make(map[string]string)
+	return func(projectID string) string {
+		mu.Lock()
+		defer mu.Unlock()
+		if role, ok := roles[projectID]; ok {
+			return role
+		}
+		var role string
+		err := db.QueryRowContext(ctx, `
+			SELECT m.role FROM organization_members m
+			JOIN projects p ON p.organization_id = m.organization_id
+			WHERE p.id = $1 AND m.user_id = $2
+		`, projectID, userID).Scan(&role)
+		if err != nil {
+			role = ""
+		}
+		roles[projectID] = role
+		return role
+	}
+}
+func requireOrgAdmin(db *database.DB, w http.ResponseWriter, r *http.Request, action string) (rbac.Role, bool) {
+	service := rbac.NewRBACService(db.DB)
+	role, err := service.GetUserRole(r.Context(), getUserID(r), getOrgID(r))
+	if err == nil && (role == rbac.RoleOwner || role == rbac.RoleAdmin) {
+		return role, true
+	}
+	service.LogAction(r.Context(), &rbac.AuditLog{
+		OrganizationID: getOrgID(r),
+		UserID:         getUserID(r),
+		UserEmail:      getEmail(r),
+		Action:         "organization.access_denied",
+		ResourceType:   "organization",
+		ResourceID:     getOrgID(r),
+		IPAddress:      r.RemoteAddr,
+		UserAgent:      r.UserAgent(),
+		Metadata: map[string]interface{}{
+			"action": action,
+			"role":   string(role),
+			"method": r.Method,
+			"path":   r.URL.Path,
+		},
+	})
+	writeError(w, http.StatusForbidden, action+" requires an owner or admin role")
+	return role, false
+}
+func writeAccessDenied(db *database.DB, w http.ResponseWriter, r *http.Request, err error) bool {
+	var denied *deployer.AccessDeniedError
+	if !errors.As(err, &denied) {
+		return false
+	}
+	rbac.NewRBACService(db.DB).LogAction(r.Context(), &rbac.AuditLog{
+		OrganizationID: getOrgID(r),
+		UserID:         getUserID(r),
+		UserEmail:      getEmail(r),
+		Action:         "environment.access_denied",
+		ResourceType:   "environment",
+		ResourceID:     denied.EnvironmentID,
+		IPAddress:      r.RemoteAddr,
+		UserAgent:      r.UserAgent(),
+		Metadata: map[string]interface{}{
+			"project_id":  denied.ProjectID,
+			"environment": denied.Environment,
+			"action":      string(denied.Action),
+			"role":        denied.Principal.Role,
+			"method":      r.Method,
+			"path":        r.URL.Path,
+		},
+	})
+	writeError(w, http.StatusForbidden, err.Error())
+	return true
+}
+func denyEnvironmentAccess(db *database.DB, w http.ResponseWriter, r *http.Request, err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case writeAccessDenied(db, w, r, err):
+	case errors.Is(err, deployer.ErrEnvironmentNotFound) || errors.Is(err, os.ErrNotExist):
+		writeError(w, http.StatusNotFound, "environment not found")
+	default:
+		writeError(w, http.StatusInternalServerError, "failed to authorize environment access")
+	}
+	return true
+}
+func requireProject(db *database.DB, w http.ResponseWriter, r *http.Request) bool {
+	return requireProjectID(db, w, r, chi.URLParam(r, "projectId"))
+}
+func requireProjectID(db *database.DB, w http.ResponseWriter, r *http.Request, projectID string) bool {
+	var exists bool
+	err := db.QueryRowContext(r.Context(), `
+		SELECT EXISTS(SELECT 1 FROM projects WHERE id = $1 AND organization_id = $2)
+	`, projectID, getOrgID(r)).Scan(&exists)
+	if err != nil || !exists {
+		writeError(w, http.StatusNotFound, "project not found")
+		return false
+	}
+	return true
+}
+func requireEnvironmentAction(db *database.DB, w http.ResponseWriter, r *http.Request, svc *Services, action deployer.EnvironmentAction) bool {
+	if !requireProject(db, w, r) {
+		return false
+	}
+	err := svc.Environments.Authorize(r.Context(), chi.URLParam(r, "projectId"), chi.URLParam(r, "envName"), action)
+	return !denyEnvironmentAccess(db, w, r, err)
+}
+func handleGetEnvironment(db *database.DB, svc *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		env, ok := pathEnvironment(db, w, r, svc)
+		if !ok {
+			return
+		}
+		writeJSON(w, http.StatusOK, env)
+	}
+}
+func handleLockEnvironment(db *database.DB, svc *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		env, ok := pathEnvironment(db, w, r, svc)
+		if !ok {
+			return
+		}
+		err := svc.Environments.LockEnvironment(r.Context(), env.ID, getUserID(r))
+		if writeAccessDenied(db, w, r, err) {
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		}
+		logEnvironmentAction(db, r, "environment.lock", env, map[string]interface{}{})
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+func handleUnlockEnvironment(db *database.DB, svc *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		env, ok := pathEnvironment(db, w, r, svc)
+		if !ok {
+			return
+		}
+		err := svc.Environments.UnlockEnvironment(r.Context(), env.ID, getUserID(r))
+		if writeAccessDenied(db, w, r, err) {
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		}
+		logEnvironmentAction(db, r, "environment.unlock", env, map[string]interface{}{})
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+func handlePromoteEnvironment(db *database.DB, svc *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Target string `json:"target"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Target == "" {
+			writeError(w, http.StatusBadRequest, "target environment is required")
+			return
+		}
+		source, ok := pathEnvironment(db, w, r, svc)
+		if !ok {
+			return
+		}
+		target, err := svc.Environments.FindEnvironment(r.Context(), source.ProjectID, req.Target)
+		if writeAccessDenied(db, w, r, err) {
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusNotFound, "target environment not found")
+			return
+		}
+		err = svc.Environments.PromoteEnvironment(r.Context(), source.ID, target.ID)
+		if writeAccessDenied(db, w, r, err) {
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		}
+		logEnvironmentAction(db, r, "environment.promote", target, map[string]interface{}{"source": source.Name})
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
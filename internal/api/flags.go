@@ -0,0 +1,112 @@
+package api
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"github.com/go-chi/chi/v5"
+	"github.com/opsagent/opsagent/internal/database"
+	"github.com/opsagent/opsagent/internal/deployer"
+	"github.com/opsagent/opsagent/internal/flags"
+	sdk "github.com/opsagent/opsagent/pkg/flags"
+)
+type SaveFlagRequest struct {
+	sdk.Flag
+	Percentage *int `json:"percentage"`
+}
+type EvaluateFlagsRequest struct {
+	Context sdk.EvaluationContext `json:"context"`
+	Flags   []string              `json:"flags,omitempty"`
+}
+func handleListFlags(db *database.DB, svc *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireEnvironmentAction(db, w, r, svc, deployer.EnvActionView) {
+			return
+		}
+		list, err := svc.Flags.ListFlags(r.Context(), chi.URLParam(r, "projectId"), chi.URLParam(r, "envName"))
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to fetch flags")
+			return
+		}
+		writeJSON(w, http.StatusOK, list)
+	}
+}
+func handleSaveFlag(db *database.DB, svc *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireEnvironmentAction(db, w, r, svc, deployer.EnvActionDeploy) {
+			return
+		}
+		var req SaveFlagRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		flag := req.Flag
+		flag.ProjectID = chi.URLParam(r, "projectId")
+		flag.Environment = chi.URLParam(r, "envName")
+		if key := chi.URLParam(r, "flagKey"); key != "" {
+			flag.Key = key
+		}
+		switch existing, err := svc.Flags.GetFlag(r.Context(), flag.ProjectID, flag.Environment, flag.Key); {
+		case req.Percentage != nil:
+			flag.Percentage = *req.Percentage
+		case err == nil:
+			flag.Percentage = existing.Percentage
+		default:
+			flag.Percentage = 100
+		}
+		flag.UpdatedBy = getUserID(r)
+		if err := svc.Flags.SaveFlag(r.Context(), &flag); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, flag)
+	}
+}
+func handleGetFlag(db *database.DB, svc *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireEnvironmentAction(db, w, r, svc, deployer.EnvActionView) {
+			return
+		}
+		flag, err := svc.Flags.GetFlag(r.Context(), chi.URLParam(r, "projectId"), chi.URLParam(r, "envName"), chi.URLParam(r, "flagKey"))
+		if errors.Is(err, flags.ErrFlagNotFound) {
+			writeError(w, http.StatusNotFound, "flag not found")
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, flag)
+	}
+}
+func handleDeleteFlag(db *database.DB, svc *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireEnvironmentAction(db, w, r, svc, deployer.EnvActionDeploy) {
+			return
+		}
+		err := svc.Flags.DeleteFlag(r.Context(), chi.URLParam(r, "projectId"), chi.URLParam(r, "envName"), chi.URLParam(r, "flagKey"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+func handleEvaluateFlags(db *database.DB, svc *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireEnvironmentAction(db, w, r, svc, deployer.EnvActionView) {
+			return
+		}
+		var req EvaluateFlagsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		results, err := svc.Flags.Evaluate(r.Context(), chi.URLParam(r, "projectId"), chi.URLParam(r, "envName"), req.Flags, req.Context)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, results)
+	}
+}
@@ -1,7 +1,10 @@
 package api
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 	"github.com/go-chi/chi/v5"
@@ -9,6 +12,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/opsagent/opsagent/internal/config"
 	"github.com/opsagent/opsagent/internal/database"
+	"github.com/opsagent/opsagent/internal/deployer"
 	"golang.org/x/crypto/bcrypt"
 )
 type SignupRequest struct {
@@ -59,31 +63,18 @@ type Environment struct {
 	CreatedAt     time.Time      `json:"created_at"`
 	UpdatedAt     time.Time      `json:"updated_at"`
 }
-type Deployment struct {
-	ID            string         `json:"id"`
-	ProjectID     string         `json:"project_id"`
-	EnvironmentID string         `json:"environment_id"`
-	TriggeredBy   string         `json:"triggered_by,omitempty"`
-	GitRef        string         `json:"git_ref,omitempty"`
-	GitSHA        string         `json:"git_sha,omitempty"`
-	ImageTag      string         `json:"image_tag,omitempty"`
-	Strategy      string         `json:"strategy"`
-	Status        string         `json:"status"`
-	StartedAt     *time.Time     `json:"started_at,omitempty"`
-	CompletedAt   *time.Time     `json:"completed_at,omitempty"`
-	ErrorMessage  string         `json:"error_message,omitempty"`
-	Metadata      map[string]any `json:"metadata,omitempty"`
-	CreatedAt     time.Time      `json:"created_at"`
-}
 type CreateProjectRequest struct {
 	Name          string `json:"name"`
 	Description   string `json:"description,omitempty"`
 	RepositoryURL string `json:"repository_url,omitempty"`
 }
 type DeployRequest struct {
-	Environment string `json:"environment"`
-	GitRef      string `json:"git_ref,omitempty"`
-	Strategy    string `json:"strategy,omitempty"`
+	Environment      string `json:"environment"`
+	GitRef           string `json:"git_ref,omitempty"`
+	Strategy         string `json:"strategy,omitempty"`
+	GitCommit        string `json:"git_commit,omitempty"`
+	Team             string `json:"team,omitempty"`
+	BreakGlassReason string `json:"break_glass_reason,omitempty"`
 }
 func handleSignup(db *database.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -326,79 +317,159 @@ func handleAnalyzeProject(db *database.DB) http.HandlerFunc {
 		})
 	}
 }
-func handleDeploy(db *database.DB, cfg *config.Config) http.HandlerFunc {
+func handleDeploy(db *database.DB, cfg *config.Config, svc *Services) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req DeployRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			writeError(w, http.StatusBadRequest, "invalid request body")
 			return
 		}
+		if !requireProject(db, w, r) {
+			return
+		}
 		projectID := chi.URLParam(r, "projectId")
 		userID := getUserID(r)
 		deploymentID := uuid.New().String()
+		gitCommit := resolveGitCommit(db, r, projectID, req)
+		if svc.Environments != nil {
+			err := svc.Environments.Authorize(r.Context(), projectID, req.Environment, deployer.EnvActionDeploy)
+			if denyEnvironmentAccess(db, w, r, err) {
+				return
+			}
+		}
+		strategy := deployer.DeploymentStrategy(req.Strategy)
+		if strategy == "" {
+			strategy = deployer.StrategyDirect
+		}
+		deployConfig := &deployer.DeploymentConfig{
+			DeploymentID:   deploymentID,
+			OrganizationID: getOrgID(r),
+			ProjectID:      projectID,
+			Environment:    req.Environment,
+			Strategy:       strategy,
+			Version:        req.GitRef,
+			GitCommit:      gitCommit,
+			Team:           req.Team,
+		}
+		if svc.Calendar != nil {
+			if req.BreakGlassReason != "" {
+				role, ok := requireOrgAdmin(db, w, r, "deploy.break_glass")
+				if !ok {
+					return
+				}
+				deployConfig.BreakGlass = &deployer.BreakGlassOverride{
+					UserID:    userID,
+					UserEmail: getEmail(r),
+					Role:      string(role),
+					Reason:    req.BreakGlassReason,
+				}
+			}
+			err := svc.Calendar.Authorize(r.Context(), deployConfig, time.Now())
+			var windowErr *deployer.DeployWindowError
+			if errors.As(err, &windowErr) {
+				writeDeployWindowError(w, err, windowErr)
+				return
+			}
+			if err != nil {
+				writeError(w, http.StatusForbidden, err.Error())
+				return
+			}
+		}
 		_, err := db.Exec(`
-			INSERT INTO deployments (id, project_id, environment_id, triggered_by, strategy, status, started_at)
-			SELECT $1, $2, e.id, $3, $4, 'running', NOW()
+			INSERT INTO deployments (id, project_id, environment_id, triggered_by, git_ref, git_sha, strategy, status, started_at)
+			SELECT $1, $2, e.id, $3, $6, $7, $4, 'running', NOW()
 			FROM environments e
 			WHERE e.project_id = $2 AND e.name = $5
-		`, deploymentID, projectID, userID, req.Strategy, req.Environment)
+		`, deploymentID, projectID, userID, req.Strategy, req.Environment, req.GitRef, gitCommit)
 		if err != nil {
 			writeError(w, http.StatusInternalServerError, "failed to start deployment")
 			return
 		}
+		err = svc.History.RecordDeployment(r.Context(), &deployer.DeploymentRecord{
+			ID:             deploymentID,
+			OrganizationID: getOrgID(r),
+			ProjectID:      projectID,
+			Environment:    req.Environment,
+			Version:        req.GitRef,
+			GitCommit:      gitCommit,
+			Team:           req.Team,
+			Strategy:       strategy,
+			Status:         "running",
+			DeployedAt:     time.Now(),
+			DeployedBy:     userID,
+		})
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to record deployment")
+			return
+		}
+		if svc.Executor != nil {
+			go svc.runDeployment(deployer.WithSystemPrincipal(context.Background()), deployConfig)
+		}
 		writeJSON(w, http.StatusAccepted, map[string]string{
 			"deployment_id": deploymentID,
 			"status":        "running",
 		})
 	}
 }
-func handleListDeployments(db *database.DB) http.HandlerFunc {
+func resolveGitCommit(db *database.DB, r *http.Request, projectID string, req DeployRequest) string {
+	if req.GitCommit != "" || req.GitRef == "" {
+		return req.GitCommit
+	}
+	var commit string
+	err := db.QueryRowContext(r.Context(), `
+		SELECT git_commit FROM builds
+		WHERE project_id = $1 AND (git_commit = $2 OR git_branch = $2)
+		ORDER BY git_commit = $2 DESC, created_at DESC
+		LIMIT 1
+	`, projectID, req.GitRef).Scan(&commit)
+	if err != nil {
+		return ""
+	}
+	return commit
+}
+func handleListDeployments(db *database.DB, svc *Services) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		projectID := chi.URLParam(r, "projectId")
-		rows, err := db.Query(`
-			SELECT id, project_id, environment_id, triggered_by, git_ref, git_sha,
-			       image_tag, strategy, status, started_at, completed_at, created_at
-			FROM deployments WHERE project_id = $1
-			ORDER BY created_at DESC
-			LIMIT 50
-		`, projectID)
+		if !requireProject(db, w, r) {
+			return
+		}
+		limit := 50
+		if raw := r.URL.Query().Get("limit"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n <= 0 || n > 200 {
+				writeError(w, http.StatusBadRequest, "limit must be between 1 and 200")
+				return
+			}
+			limit = n
+		}
+		page, err := svc.History.QueryDeployments(r.Context(), deployer.HistoryQuery{
+			ProjectID:   chi.URLParam(r, "projectId"),
+			Environment: r.URL.Query().Get("environment"),
+			Status:      r.URL.Query().Get("status"),
+			Limit:       limit,
+			Cursor:      r.URL.Query().Get("cursor"),
+		})
+		if errors.Is(err, deployer.ErrInvalidCursor) {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
 		if err != nil {
 			writeError(w, http.StatusInternalServerError, "failed to fetch deployments")
 			return
 		}
-		defer rows.Close()
-		var deployments []Deployment
-		for rows.Next() {
-			var d Deployment
-			rows.Scan(&d.ID, &d.ProjectID, &d.EnvironmentID, &d.TriggeredBy, &d.GitRef, &d.GitSHA,
-				&d.ImageTag, &d.Strategy, &d.Status, &d.StartedAt, &d.CompletedAt, &d.CreatedAt)
-			deployments = append(deployments, d)
-		}
-		writeJSON(w, http.StatusOK, deployments)
+		writeJSON(w, http.StatusOK, page)
 	}
 }
-func handleGetDeployment(db *database.DB) http.HandlerFunc {
+func handleGetDeployment(db *database.DB, svc *Services) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		deploymentID := chi.URLParam(r, "deploymentId")
-		var d Deployment
-		err := db.QueryRow(`
-			SELECT id, project_id, environment_id, triggered_by, git_ref, git_sha,
-			       image_tag, strategy, status, started_at, completed_at, error_message, created_at
-			FROM deployments WHERE id = $1
-		`, deploymentID).Scan(&d.ID, &d.ProjectID, &d.EnvironmentID, &d.TriggeredBy, &d.GitRef, &d.GitSHA,
-			&d.ImageTag, &d.Strategy, &d.Status, &d.StartedAt, &d.CompletedAt, &d.ErrorMessage, &d.CreatedAt)
+		if !requireProject(db, w, r) {
+			return
+		}
+		record, status, err := rollbackTarget(r, svc)
 		if err != nil {
-			writeError(w, http.StatusNotFound, "deployment not found")
+			writeError(w, status, err.Error())
 			return
 		}
-		writeJSON(w, http.StatusOK, d)
-	}
-}
-func handleRollback(db *database.DB) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		writeJSON(w, http.StatusOK, map[string]string{
-			"status": "rolled back",
-		})
+		writeJSON(w, http.StatusOK, record)
 	}
 }
 func handleListEnvironments(db *database.DB) http.HandlerFunc {
@@ -427,31 +498,11 @@ func handleCreateEnvironment(db *database.DB) http.HandlerFunc {
 		writeError(w, http.StatusNotImplemented, "not implemented")
 	}
 }
-func handleGetEnvironment(db *database.DB) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		writeError(w, http.StatusNotImplemented, "not implemented")
-	}
-}
 func handleDeleteEnvironment(db *database.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusNotImplemented, "not implemented")
 	}
 }
-func handleListSecrets(db *database.DB) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		writeJSON(w, http.StatusOK, []map[string]string{})
-	}
-}
-func handleCreateSecret(db *database.DB) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		writeError(w, http.StatusNotImplemented, "not implemented")
-	}
-}
-func handleDeleteSecret(db *database.DB) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		writeError(w, http.StatusNotImplemented, "not implemented")
-	}
-}
 func handleGetLogs(db *database.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusOK, []map[string]any{})
@@ -62,6 +62,12 @@ func getUserID(r *http.Request) string {
 	}
 	return ""
 }
+func getEmail(r *http.Request) string {
+	if email, ok := r.Context().Value(ContextEmail).(string); ok {
+		return email
+	}
+	return ""
+}
 func getOrgID(r *http.Request) string {
 	if id, ok := r.Context().Value(ContextOrgID).(string); ok {
 		return id
@@ -0,0 +1,160 @@
+package api
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"github.com/go-chi/chi/v5"
+	"github.com/opsagent/opsagent/internal/database"
+	"github.com/opsagent/opsagent/internal/deployer"
+	"github.com/opsagent/opsagent/internal/rbac"
+)
+func decodePromotionRequest(db *database.DB, w http.ResponseWriter, r *http.Request, svc *Services) (deployer.PromotionRequest, bool) {
+	var req deployer.PromotionRequest
+	if !requireProject(db, w, r) {
+		return req, false
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Source == "" || req.Target == "" {
+		writeError(w, http.StatusBadRequest, "source and target environments are required")
+		return req, false
+	}
+	req.ProjectID = chi.URLParam(r, "projectId")
+	req.RequestedBy = requestAuthor(r)
+	err := svc.Environments.Authorize(r.Context(), req.ProjectID, req.Source, deployer.EnvActionView)
+	if denyEnvironmentAccess(db, w, r, err) {
+		return req, false
+	}
+	err = svc.Environments.Authorize(r.Context(), req.ProjectID, req.Target, deployer.EnvActionPromote)
+	if denyEnvironmentAccess(db, w, r, err) {
+		return req, false
+	}
+	return req, true
+}
+func handleGetPromotionRules(db *database.DB, svc *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireProject(db, w, r) {
+			return
+		}
+		rules, err := svc.Environments.GetPromotionRules(r.Context(), chi.URLParam(r, "projectId"))
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to fetch promotion rules")
+			return
+		}
+		writeJSON(w, http.StatusOK, rules)
+	}
+}
+func handleSavePromotionRules(db *database.DB, svc *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireProject(db, w, r) {
+			return
+		}
+		role, err := rbac.NewRBACService(db.DB).GetUserRole(r.Context(), getUserID(r), getOrgID(r))
+		if err != nil || (role != rbac.RoleOwner && role != rbac.RoleAdmin) {
+			err := svc.Environments.AuthorizeProduction(r.Context(), chi.URLParam(r, "projectId"), deployer.EnvActionPromote)
+			if writeAccessDenied(db, w, r, err) {
+				return
+			}
+			if err != nil {
+				if _, ok := requireOrgAdmin(db, w, r, "promotion.rules"); !ok {
+					return
+				}
+			}
+		}
+		rules := deployer.PromotionRules{RequireDigest: true}
+		if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		rules.ProjectID = chi.URLParam(r, "projectId")
+		if err := svc.Environments.SavePromotionRules(r.Context(), &rules); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		rbac.NewRBACService(db.DB).LogAction(r.Context(), &rbac.AuditLog{
+			OrganizationID: getOrgID(r),
+			UserID:         getUserID(r),
+			UserEmail:      getEmail(r),
+			Action:         "promotion.rules",
+			ResourceType:   "project",
+			ResourceID:     rules.ProjectID,
+			IPAddress:      r.RemoteAddr,
+			UserAgent:      r.UserAgent(),
+			Metadata: map[string]interface{}{
+				"exclude":        rules.Exclude,
+				"transforms":     len(rules.Transforms),
+				"require_digest": rules.RequireDigest,
+			},
+		})
+		writeJSON(w, http.StatusOK, rules)
+	}
+}
+func handlePromotionPreview(db *database.DB, svc *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, ok := decodePromotionRequest(db, w, r, svc)
+		if !ok {
+			return
+		}
+		preview, err := svc.Promotions.PreviewPromotion(r.Context(), req)
+		if writeAccessDenied(db, w, r, err) {
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, preview)
+	}
+}
+func handlePromote(db *database.DB, svc *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, ok := decodePromotionRequest(db, w, r, svc)
+		if !ok {
+			return
+		}
+		record, err := svc.Promotions.Promote(r.Context(), req)
+		if writeAccessDenied(db, w, r, err) {
+			return
+		}
+		var windowErr *deployer.DeployWindowError
+		if errors.As(err, &windowErr) {
+			writeDeployWindowError(w, err, windowErr)
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		}
+		rbac.NewRBACService(db.DB).LogAction(r.Context(), &rbac.AuditLog{
+			OrganizationID: getOrgID(r),
+			UserID:         getUserID(r),
+			UserEmail:      getEmail(r),
+			Action:         "deploy.promote",
+			ResourceType:   "deployment",
+			ResourceID:     record.ID,
+			IPAddress:      r.RemoteAddr,
+			UserAgent:      r.UserAgent(),
+			Metadata: map[string]interface{}{
+				"project_id":    req.ProjectID,
+				"source":        req.Source,
+				"target":        req.Target,
+				"promoted_from": record.PromotedFrom,
+				"image":         record.Image,
+				"status":        record.Status,
+			},
+		})
+		writeJSON(w, http.StatusOK, record)
+	}
+}
+func handleDeploymentLineage(db *database.DB, svc *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		target, ok := authorizedDeployment(db, w, r, svc, deployer.EnvActionView)
+		if !ok {
+			return
+		}
+		lineage, err := svc.Promotions.Lineage(r.Context(), target.ID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, lineage)
+	}
+}
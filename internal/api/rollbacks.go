@@ -0,0 +1,99 @@
+package api
+import (
+	"errors"
+	"net/http"
+	"github.com/go-chi/chi/v5"
+	"github.com/opsagent/opsagent/internal/database"
+	"github.com/opsagent/opsagent/internal/deployer"
+	"github.com/opsagent/opsagent/internal/rbac"
+)
+func rollbackTarget(r *http.Request, svc *Services) (*deployer.DeploymentRecord, int, error) {
+	record, err := svc.History.GetDeployment(r.Context(), chi.URLParam(r, "deploymentId"))
+	if errors.Is(err, deployer.ErrDeploymentNotFound) {
+		return nil, http.StatusNotFound, errors.New("deployment not found")
+	}
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("failed to load deployment")
+	}
+	if record.ProjectID != chi.URLParam(r, "projectId") {
+		return nil, http.StatusNotFound, errors.New("deployment not found")
+	}
+	return record, http.StatusOK, nil
+}
+func authorizedDeployment(db *database.DB, w http.ResponseWriter, r *http.Request, svc *Services, action deployer.EnvironmentAction) (*deployer.DeploymentRecord, bool) {
+	if !requireProject(db, w, r) {
+		return nil, false
+	}
+	target, status, err := rollbackTarget(r, svc)
+	if err != nil {
+		writeError(w, status, err.Error())
+		return nil, false
+	}
+	if svc.Environments != nil {
+		err := svc.Environments.Authorize(r.Context(), target.ProjectID, target.Environment, action)
+		if denyEnvironmentAccess(db, w, r, err) {
+			return nil, false
+		}
+	}
+	return target, true
+}
+func handleRollbackPreview(db *database.DB, svc *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		target, ok := authorizedDeployment(db, w, r, svc, deployer.EnvActionView)
+		if !ok {
+			return
+		}
+		preview, err := svc.Rollbacks.PreviewRollback(r.Context(), target.ProjectID, target.Environment, target.ID)
+		if err != nil {
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, preview)
+	}
+}
+func handleRollback(db *database.DB, svc *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		target, ok := authorizedDeployment(db, w, r, svc, deployer.EnvActionDeploy)
+		if !ok {
+			return
+		}
+		preview, err := svc.Rollbacks.PreviewRollback(r.Context(), target.ProjectID, target.Environment, target.ID)
+		if err != nil {
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		}
+		result, err := svc.Rollbacks.Rollback(r.Context(), target.ProjectID, target.Environment, target.ID)
+		var windowErr *deployer.DeployWindowError
+		if errors.As(err, &windowErr) {
+			writeDeployWindowError(w, err, windowErr)
+			return
+		}
+		if err != nil && result == nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		rbac.NewRBACService(db.DB).LogAction(r.Context(), &rbac.AuditLog{
+			OrganizationID: getOrgID(r),
+			UserID:         getUserID(r),
+			UserEmail:      getEmail(r),
+			Action:         "deploy.rollback",
+			ResourceType:   "deployment",
+			ResourceID:     target.ID,
+			IPAddress:      r.RemoteAddr,
+			UserAgent:      r.UserAgent(),
+			Metadata: map[string]interface{}{
+				"project_id":           target.ProjectID,
+				"environment":          target.Environment,
+				"rolled_back_from":     preview.CurrentDeployment,
+				"restores_environment": preview.RestoresEnvironment,
+				"changes":              len(preview.Changes),
+				"status":               result.Status,
+			},
+		})
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"deployment_id": result.DeploymentID,
+			"status":        result.Status,
+			"preview":       preview,
+		})
+	}
+}
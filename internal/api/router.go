@@ -1,5 +1,7 @@
 package api
 import (
+	"context"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"time"
@@ -8,8 +10,85 @@ import (
 	"github.com/go-chi/cors"
 	"github.com/opsagent/opsagent/internal/config"
 	"github.com/opsagent/opsagent/internal/database"
+	"github.com/opsagent/opsagent/internal/deployer"
+	"github.com/opsagent/opsagent/internal/dora"
+	"github.com/opsagent/opsagent/internal/flags"
+	"github.com/opsagent/opsagent/internal/monitoring"
 )
-func NewRouter(cfg *config.Config, db *database.DB, logger *slog.Logger) http.Handler {
+type Services struct {
+	Executor     *deployer.DeploymentExecutor
+	History      *deployer.DeploymentHistory
+	Rollbacks    *deployer.RollbackManager
+	Promotions   *deployer.PromotionManager
+	Calendar     *deployer.CalendarManager
+	Flags        *flags.FlagService
+	DORA         *dora.Service
+	Drift        *deployer.DriftDetector
+	Environments *deployer.EnvironmentManager
+	Previews     *deployer.PreviewManager
+}
+func (svc *Services) Start(ctx context.Context, cfg *config.Config, db *database.DB) {
+	if svc.Environments != nil && svc.History != nil {
+		svc.Environments.AttachHistory(svc.History)
+	}
+	if svc.Drift == nil && svc.Environments != nil {
+		live := deployer.NewKubectlLiveState(cfg.Drift.Kubeconfig, cfg.Drift.KubeContext)
+		svc.Drift = deployer.NewDriftDetector(svc.Environments, live, monitoring.NewMonitoringService(db.DB), cfg.Drift.AutoReconcile)
+	}
+	if svc.Drift != nil {
+		go svc.Drift.Run(deployer.WithSystemPrincipal(ctx), cfg.Drift.Interval)
+	}
+	if svc.Rollbacks != nil {
+		go svc.Rollbacks.RunColourJanitor(ctx, time.Minute)
+	}
+	if svc.Previews != nil {
+		go svc.Previews.RunReaper(deployer.WithSystemPrincipal(ctx), time.Minute)
+	}
+	if svc.Executor != nil && svc.History != nil {
+		go svc.recoverDeployments(deployer.WithSystemPrincipal(ctx), cfg.Deploy.ResumeOnRestart)
+	}
+}
+func (svc *Services) recoverDeployments(ctx context.Context, resume bool) {
+	results, err := svc.Executor.RecoverInFlight(ctx, resume)
+	if err != nil {
+		fmt.Printf("⚠️  Failed to recover in-flight deployments: %v\n", err)
+	}
+	for _, result := range results {
+		svc.recordResult(ctx, result)
+	}
+}
+func (svc *Services) runDeployment(ctx context.Context, config *deployer.DeploymentConfig) {
+	result, err := svc.Executor.Execute(ctx, config)
+	if result != nil {
+		svc.recordResult(ctx, result)
+		return
+	}
+	record, getErr := svc.History.GetDeployment(ctx, config.DeploymentID)
+	if getErr != nil {
+		fmt.Printf("⚠️  Failed to load deployment %s: %v\n", config.DeploymentID, getErr)
+		return
+	}
+	record.Status = "failed"
+	record.RollbackReason = err.Error()
+	if err := svc.History.RecordDeployment(ctx, record); err != nil {
+		fmt.Printf("⚠️  Failed to record deployment %s: %v\n", config.DeploymentID, err)
+	}
+}
+func (svc *Services) recordResult(ctx context.Context, result *deployer.DeploymentResult) {
+	state, err := svc.Executor.GetState(ctx, result.DeploymentID)
+	if err != nil {
+		fmt.Printf("⚠️  Failed to load deployment state %s: %v\n", result.DeploymentID, err)
+		return
+	}
+	deployedBy := ""
+	if record, err := svc.History.GetDeployment(ctx, result.DeploymentID); err == nil {
+		deployedBy = record.DeployedBy
+	}
+	if _, err := svc.History.RecordResult(ctx, state.Config, result, deployedBy); err != nil {
+		fmt.Printf("⚠️  Failed to record deployment %s: %v\n", result.DeploymentID, err)
+	}
+}
+func NewRouter(cfg *config.Config, db *database.DB, svc *Services, logger *slog.Logger) http.Handler {
 	r := chi.NewRouter()
 	r.Use(middleware.RequestID)
 	r.Use(middleware.RealIP)
@@ -36,6 +115,7 @@ func NewRouter(cfg *config.Config, db *database.DB, logger *slog.Logger) http.Ha
 		})
 		r.Group(func(r chi.Router) {
 			r.Use(AuthMiddleware(cfg))
+			r.Use(PrincipalMiddleware(db))
 			r.Get("/user", handleGetUser(db))
 			r.Patch("/user", handleUpdateUser(db))
 			r.Get("/organizations", handleListOrganizations(db))
@@ -48,17 +128,55 @@ func NewRouter(cfg *config.Config, db *database.DB, logger *slog.Logger) http.Ha
 			r.Patch("/projects/{projectId}", handleUpdateProject(db))
 			r.Delete("/projects/{projectId}", handleDeleteProject(db))
 			r.Post("/projects/{projectId}/analyze", handleAnalyzeProject(db))
-			r.Post("/projects/{projectId}/deploy", handleDeploy(db, cfg))
-			r.Get("/projects/{projectId}/deployments", handleListDeployments(db))
-			r.Get("/projects/{projectId}/deployments/{deploymentId}", handleGetDeployment(db))
-			r.Post("/projects/{projectId}/deployments/{deploymentId}/rollback", handleRollback(db))
+			r.Post("/projects/{projectId}/deploy", handleDeploy(db, cfg, svc))
+			r.Get("/projects/{projectId}/deployments", handleListDeployments(db, svc))
+			r.Get("/projects/{projectId}/deployments/{deploymentId}", handleGetDeployment(db, svc))
+			r.Post("/projects/{projectId}/deployments/{deploymentId}/rollback", handleRollback(db, svc))
+			r.Get("/projects/{projectId}/deployments/{deploymentId}/rollback/preview", handleRollbackPreview(db, svc))
+			r.Get("/projects/{projectId}/deployments/{deploymentId}/lineage", handleDeploymentLineage(db, svc))
+			r.Get("/projects/{projectId}/promotion-rules", handleGetPromotionRules(db, svc))
+			r.Put("/projects/{projectId}/promotion-rules", handleSavePromotionRules(db, svc))
+			r.Post("/projects/{projectId}/promotions/preview", handlePromotionPreview(db, svc))
+			r.Post("/projects/{projectId}/promotions", handlePromote(db, svc))
+			r.Get("/projects/{projectId}/metrics/dora", handleDORAReport(db, svc))
+			r.Get("/metrics/dora", handleDORAReport(db, svc))
+			r.Get("/projects/{projectId}/deployments/{deploymentId}/approvals", handleGetApprovals(db, svc))
+			r.Post("/projects/{projectId}/deployments/{deploymentId}/approvals", handleDecideApproval(db, svc))
+			r.Get("/calendars", handleListCalendars(svc))
+			r.Post("/calendars", handleSaveCalendar(db, svc))
+			r.Get("/calendars/check", handleCheckCalendar(svc))
+			r.Delete("/calendars/{calendarId}", handleDeleteCalendar(db, svc))
+			r.Get("/templates", handleListTemplates(svc))
+			r.Post("/templates", handleSaveTemplate(db, svc))
+			r.Get("/templates/{templateId}", handleGetTemplate(svc))
+			r.Put("/templates/{templateId}", handleSaveTemplate(db, svc))
+			r.Delete("/templates/{templateId}", handleDeleteTemplate(db, svc))
+			r.Post("/templates/{templateId}/propagate", handlePropagateTemplate(db, svc))
 			r.Get("/projects/{projectId}/environments", handleListEnvironments(db))
 			r.Post("/projects/{projectId}/environments", handleCreateEnvironment(db))
-			r.Get("/projects/{projectId}/environments/{envName}", handleGetEnvironment(db))
+			r.Get("/projects/{projectId}/environments/{envName}", handleGetEnvironment(db, svc))
 			r.Delete("/projects/{projectId}/environments/{envName}", handleDeleteEnvironment(db))
-			r.Get("/projects/{projectId}/environments/{envName}/secrets", handleListSecrets(db))
-			r.Post("/projects/{projectId}/environments/{envName}/secrets", handleCreateSecret(db))
-			r.Delete("/projects/{projectId}/environments/{envName}/secrets/{key}", handleDeleteSecret(db))
+			r.Post("/projects/{projectId}/environments/{envName}/lock", handleLockEnvironment(db, svc))
+			r.Post("/projects/{projectId}/environments/{envName}/unlock", handleUnlockEnvironment(db, svc))
+			r.Post("/projects/{projectId}/environments/{envName}/promote", handlePromoteEnvironment(db, svc))
+			r.Get("/projects/{projectId}/environments/{envName}/colours", handleGetColours(db, svc))
+			r.Post("/projects/{projectId}/environments/{envName}/switch-back", handleSwitchBack(db, svc))
+			r.Get("/projects/{projectId}/environments/{envName}/drift", handleCheckDrift(db, svc))
+			r.Post("/projects/{projectId}/environments/{envName}/drift/reconcile", handleReconcileDrift(db, svc))
+			r.Get("/projects/{projectId}/environments/{envName}/flags", handleListFlags(db, svc))
+			r.Post("/projects/{projectId}/environments/{envName}/flags", handleSaveFlag(db, svc))
+			r.Post("/projects/{projectId}/environments/{envName}/flags/evaluate", handleEvaluateFlags(db, svc))
+			r.Get("/projects/{projectId}/environments/{envName}/flags/{flagKey}", handleGetFlag(db, svc))
+			r.Put("/projects/{projectId}/environments/{envName}/flags/{flagKey}", handleSaveFlag(db, svc))
+			r.Delete("/projects/{projectId}/environments/{envName}/flags/{flagKey}", handleDeleteFlag(db, svc))
+			r.Post("/projects/{projectId}/environments/{envName}/import", handleImportEnvironment(db, svc))
+			r.Get("/projects/{projectId}/environments/{envName}/export", handleExportEnvironment(db, svc))
+			r.Get("/projects/{projectId}/environments/{envName}/secrets", handleListSecrets(db, svc))
+			r.Post("/projects/{projectId}/environments/{envName}/secrets", handleCreateSecret(db, svc))
+			r.Put("/projects/{projectId}/environments/{envName}/secrets/retention", handleSetSecretRetention(db, svc))
+			r.Delete("/projects/{projectId}/environments/{envName}/secrets/{key}", handleDeleteSecret(db, svc))
+			r.Get("/projects/{projectId}/environments/{envName}/secrets/{key}/versions", handleListSecretVersions(db, svc))
+			r.Post("/projects/{projectId}/environments/{envName}/secrets/{key}/versions/{version}/restore", handleRestoreSecret(db, svc))
 			r.Get("/projects/{projectId}/logs", handleGetLogs(db))
 			r.Get("/projects/{projectId}/metrics", handleGetMetrics(db))
 		})
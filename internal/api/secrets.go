@@ -0,0 +1,199 @@
+package api
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+	"github.com/go-chi/chi/v5"
+	"github.com/opsagent/opsagent/internal/database"
+	"github.com/opsagent/opsagent/internal/deployer"
+	"github.com/opsagent/opsagent/internal/rbac"
+)
+func pathEnvironment(db *database.DB, w http.ResponseWriter, r *http.Request, svc *Services) (*deployer.Environment, bool) {
+	if !requireProject(db, w, r) {
+		return nil, false
+	}
+	env, err := svc.Environments.FindEnvironment(r.Context(), chi.URLParam(r, "projectId"), chi.URLParam(r, "envName"))
+	if denyEnvironmentAccess(db, w, r, err) {
+		return nil, false
+	}
+	return env, true
+}
+func requestAuthor(r *http.Request) string {
+	if email := getEmail(r); email != "" {
+		return email
+	}
+	return getUserID(r)
+}
+func logEnvironmentAction(db *database.DB, r *http.Request, action string, env *deployer.Environment, metadata map[string]interface{}) {
+	metadata["project_id"] = env.ProjectID
+	metadata["environment"] = env.Name
+	rbac.NewRBACService(db.DB).LogAction(r.Context(), &rbac.AuditLog{
+		OrganizationID: getOrgID(r),
+		UserID:         getUserID(r),
+		UserEmail:      getEmail(r),
+		Action:         action,
+		ResourceType:   "environment",
+		ResourceID:     env.ID,
+		IPAddress:      r.RemoteAddr,
+		UserAgent:      r.UserAgent(),
+		Metadata:       metadata,
+	})
+}
+func handleListSecrets(db *database.DB, svc *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		env, ok := pathEnvironment(db, w, r, svc)
+		if !ok {
+			return
+		}
+		secrets, err := svc.Environments.ListSecrets(r.Context(), env.ID)
+		if writeAccessDenied(db, w, r, err) {
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, secrets)
+	}
+}
+func handleCreateSecret(db *database.DB, svc *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Key   string `json:"key"`
+			Value string `json:"value"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Key == "" {
+			writeError(w, http.StatusBadRequest, "key and value are required")
+			return
+		}
+		env, ok := pathEnvironment(db, w, r, svc)
+		if !ok {
+			return
+		}
+		version, err := svc.Environments.SetSecretAs(r.Context(), env.ID, req.Key, req.Value, requestAuthor(r))
+		if writeAccessDenied(db, w, r, err) {
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		}
+		logEnvironmentAction(db, r, "secret.set", env, map[string]interface{}{
+			"key":     req.Key,
+			"version": version.Version,
+		})
+		writeJSON(w, http.StatusCreated, version)
+	}
+}
+func handleDeleteSecret(db *database.DB, svc *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		env, ok := pathEnvironment(db, w, r, svc)
+		if !ok {
+			return
+		}
+		key := chi.URLParam(r, "key")
+		if _, exists := env.Secrets[key]; !exists {
+			writeError(w, http.StatusNotFound, "secret not found")
+			return
+		}
+		err := svc.Environments.DeleteSecret(r.Context(), env.ID, key, requestAuthor(r))
+		if writeAccessDenied(db, w, r, err) {
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		}
+		logEnvironmentAction(db, r, "secret.delete", env, map[string]interface{}{"key": key})
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+func handleListSecretVersions(db *database.DB, svc *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		env, ok := pathEnvironment(db, w, r, svc)
+		if !ok {
+			return
+		}
+		versions, err := svc.Environments.ListSecretVersions(r.Context(), env.ID, chi.URLParam(r, "key"))
+		if writeAccessDenied(db, w, r, err) {
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, versions)
+	}
+}
+func handleRestoreSecret(db *database.DB, svc *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		version, err := strconv.Atoi(chi.URLParam(r, "version"))
+		if err != nil || version < 1 {
+			writeError(w, http.StatusBadRequest, "invalid version")
+			return
+		}
+		env, ok := pathEnvironment(db, w, r, svc)
+		if !ok {
+			return
+		}
+		key := chi.URLParam(r, "key")
+		restored, err := svc.Environments.RestoreSecretVersion(r.Context(), env.ID, key, version, requestAuthor(r))
+		if writeAccessDenied(db, w, r, err) {
+			return
+		}
+		if errors.Is(err, deployer.ErrSecretVersionNotFound) {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		}
+		logEnvironmentAction(db, r, "secret.restore", env, map[string]interface{}{
+			"key":           key,
+			"restored_from": version,
+			"version":       restored.Version,
+		})
+		writeJSON(w, http.StatusOK, restored)
+	}
+}
+func handleSetSecretRetention(db *database.DB, svc *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			MaxVersions int    `json:"max_versions"`
+			MaxAge      string `json:"max_age"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		retention := deployer.SecretRetention{MaxVersions: req.MaxVersions}
+		if req.MaxAge != "" {
+			maxAge, err := time.ParseDuration(req.MaxAge)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "max_age must be a duration such as 720h")
+				return
+			}
+			retention.MaxAge = maxAge
+		}
+		env, ok := pathEnvironment(db, w, r, svc)
+		if !ok {
+			return
+		}
+		err := svc.Environments.SetSecretRetention(r.Context(), env.ID, retention)
+		if writeAccessDenied(db, w, r, err) {
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		logEnvironmentAction(db, r, "secret.retention", env, map[string]interface{}{
+			"max_versions": retention.MaxVersions,
+			"max_age":      retention.MaxAge.String(),
+		})
+		writeJSON(w, http.StatusOK, retention)
+	}
+}
@@ -0,0 +1,135 @@
+package api
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"github.com/go-chi/chi/v5"
+	"github.com/opsagent/opsagent/internal/database"
+	"github.com/opsagent/opsagent/internal/deployer"
+	"github.com/opsagent/opsagent/internal/rbac"
+)
+func orgTemplate(w http.ResponseWriter, r *http.Request, svc *Services) (*deployer.EnvironmentTemplate, bool) {
+	template, err := svc.Environments.GetTemplate(r.Context(), chi.URLParam(r, "templateId"))
+	if err != nil || template.OrganizationID != getOrgID(r) {
+		writeError(w, http.StatusNotFound, "template not found")
+		return nil, false
+	}
+	return template, true
+}
+func logTemplateAction(db *database.DB, r *http.Request, action string, template *deployer.EnvironmentTemplate, metadata map[string]interface{}) {
+	metadata["name"] = template.Name
+	metadata["version"] = template.Version
+	rbac.NewRBACService(db.DB).LogAction(r.Context(), &rbac.AuditLog{
+		OrganizationID: getOrgID(r),
+		UserID:         getUserID(r),
+		UserEmail:      getEmail(r),
+		Action:         action,
+		ResourceType:   "environment_template",
+		ResourceID:     template.ID,
+		IPAddress:      r.RemoteAddr,
+		UserAgent:      r.UserAgent(),
+		Metadata:       metadata,
+	})
+}
+func handleListTemplates(svc *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		templates, err := svc.Environments.ListTemplates(r.Context(), getOrgID(r))
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to fetch templates")
+			return
+		}
+		writeJSON(w, http.StatusOK, templates)
+	}
+}
+func handleGetTemplate(svc *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		template, ok := orgTemplate(w, r, svc)
+		if !ok {
+			return
+		}
+		writeJSON(w, http.StatusOK, template)
+	}
+}
+func handleSaveTemplate(db *database.DB, svc *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requireOrgAdmin(db, w, r, "template.save"); !ok {
+			return
+		}
+		var template deployer.EnvironmentTemplate
+		if err := json.NewDecoder(r.Body).Decode(&template); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		template.OrganizationID = getOrgID(r)
+		if templateID := chi.URLParam(r, "templateId"); templateID != "" {
+			if _, ok := orgTemplate(w, r, svc); !ok {
+				return
+			}
+			template.ID = templateID
+		} else {
+			template.ID = ""
+		}
+		if err := svc.Environments.SaveTemplate(r.Context(), &template); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		logTemplateAction(db, r, "template.save", &template, map[string]interface{}{})
+		writeJSON(w, http.StatusOK, template)
+	}
+}
+func handleDeleteTemplate(db *database.DB, svc *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requireOrgAdmin(db, w, r, "template.delete"); !ok {
+			return
+		}
+		template, ok := orgTemplate(w, r, svc)
+		if !ok {
+			return
+		}
+		err := svc.Environments.DeleteTemplate(r.Context(), template.ID)
+		if errors.Is(err, deployer.ErrTemplateNotFound) {
+			writeError(w, http.StatusNotFound, "template not found")
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		}
+		logTemplateAction(db, r, "template.delete", template, map[string]interface{}{})
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+func handlePropagateTemplate(db *database.DB, svc *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requireOrgAdmin(db, w, r, "template.propagate"); !ok {
+			return
+		}
+		var opts deployer.PropagateOptions
+		if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		template, ok := orgTemplate(w, r, svc)
+		if !ok {
+			return
+		}
+		results, err := svc.Environments.PropagateTemplate(r.Context(), template.ID, requestAuthor(r), opts)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if !opts.DryRun {
+			applied := make([]string, 0, len(results))
+			for _, result := range results {
+				if result.Applied {
+					applied = append(applied, result.EnvironmentID)
+				}
+			}
+			logTemplateAction(db, r, "template.propagate", template, map[string]interface{}{
+				"environments":   applied,
+				"keep_overrides": opts.KeepOverrides,
+			})
+		}
+		writeJSON(w, http.StatusOK, results)
+	}
+}
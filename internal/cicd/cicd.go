@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os/exec"
 	"time"
+	"github.com/lib/pq"
 )
 type BuildStatus string
 const (
@@ -164,6 +165,41 @@ func (cs *CICDService) GetBuilds(ctx context.Context, projectID string, limit in
 	}
 	return builds, nil
 }
+func (cs *CICDService) GetBuildsByCommits(ctx context.Context, projectID string, commits []string) (map[string]*Build, error) {
+	builds := make(map[string]*Build)
+	if len(commits) == 0 {
+		return builds, nil
+	}
+	rows, err := cs.db.QueryContext(ctx, `
+		SELECT DISTINCT ON (git_commit) id, project_id, git_commit, git_branch, git_author, status, metadata, created_at
+		FROM builds
+		WHERE project_id = $1 AND git_commit = ANY($2)
+		ORDER BY git_commit, created_at ASC
+	`, projectID, pq.Array(commits))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var b Build
+		var metadataJSON []byte
+		if err := rows.Scan(&b.ID, &b.ProjectID, &b.GitCommit, &b.GitBranch, &b.GitAuthor,
+			&b.Status, &metadataJSON, &b.CreatedAt); err != nil {
+			return nil, err
+		}
+		json.Unmarshal(metadataJSON, &b.Metadata)
+		builds[b.GitCommit] = &b
+	}
+	return builds, rows.Err()
+}
+func (b *Build) CommitTime() time.Time {
+	if raw, ok := b.Metadata["committed_at"].(string); ok {
+		if t, err := time.Parse(time.RFC3339, raw); err == nil {
+			return t
+		}
+	}
+	return b.CreatedAt
+}
 func (cs *CICDService) CreatePreviewEnvironment(ctx context.Context, projectID, prID, branch string) (*PreviewEnvironment, error) {
 	preview := &PreviewEnvironment{
 		ProjectID:     projectID,
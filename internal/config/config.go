@@ -13,6 +13,8 @@ type Config struct {
 	Auth     AuthConfig     `yaml:"auth"`
 	Cloud    CloudConfig    `yaml:"cloud"`
 	Logging  LoggingConfig  `yaml:"logging"`
+	Drift    DriftConfig    `yaml:"drift"`
+	Deploy   DeployConfig   `yaml:"deploy"`
 }
 type ServerConfig struct {
 	Port            int           `yaml:"port" envconfig:"PORT" default:"8080"`
@@ -84,6 +86,15 @@ type TerraformConfig struct {
 	StateBucket    string `yaml:"state_bucket" envconfig:"TERRAFORM_STATE_BUCKET"`
 	WorkspacePath  string `yaml:"workspace_path" envconfig:"TERRAFORM_WORKSPACE_PATH" default:"/tmp/terraform"`
 }
+type DriftConfig struct {
+	Interval      time.Duration `yaml:"interval" envconfig:"DRIFT_INTERVAL" default:"10m"`
+	AutoReconcile bool          `yaml:"auto_reconcile" envconfig:"DRIFT_AUTO_RECONCILE"`
+	Kubeconfig    string        `yaml:"kubeconfig" envconfig:"KUBECONFIG"`
+	KubeContext   string        `yaml:"kube_context" envconfig:"KUBE_CONTEXT"`
+}
+type DeployConfig struct {
+	ResumeOnRestart bool `yaml:"resume_on_restart" envconfig:"DEPLOY_RESUME_ON_RESTART"`
+}
 type LoggingConfig struct {
 	Level  string `yaml:"level" envconfig:"LOG_LEVEL" default:"info"`
 	Format string `yaml:"format" envconfig:"LOG_FORMAT" default:"json"`
@@ -101,6 +112,7 @@ func Load() (*Config, error) {
 	cfg.Auth.JWTExpiration = 24 * time.Hour
 	cfg.Auth.RefreshExpiration = 168 * time.Hour
 	cfg.Auth.BcryptCost = 12
+	cfg.Drift.Interval = 10 * time.Minute
 	configPaths := []string{
 		"config.yml",
 		"config.yaml",
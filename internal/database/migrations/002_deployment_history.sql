@@ -0,0 +1,23 @@
+-- OpsAgent Deployment History
+-- Version: 002_deployment_history.sql
+
+-- Deployment History (records written by the deployment executor)
+CREATE TABLE IF NOT EXISTS deployment_history (
+    id VARCHAR(255) PRIMARY KEY,
+    project_id VARCHAR(255) NOT NULL,
+    environment VARCHAR(255) NOT NULL,
+    version VARCHAR(255),
+    strategy VARCHAR(50),
+    status VARCHAR(50) NOT NULL,
+    deployed_at TIMESTAMPTZ NOT NULL,
+    deployed_by VARCHAR(255),
+    rollback_from VARCHAR(255),
+    record JSONB NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_deployment_history_project_env ON deployment_history(project_id, environment, deployed_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_deployment_history_project_status ON deployment_history(project_id, status, deployed_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_deployment_history_project_env_status ON deployment_history(project_id, environment, status, deployed_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_deployment_history_deployed ON deployment_history(deployed_at DESC, id DESC);
@@ -0,0 +1,19 @@
+-- OpsAgent Preview Environments
+-- Version: 003_preview_environments.sql
+
+-- Preview Environment Registry (claimed by reaper replicas with row locks)
+CREATE TABLE IF NOT EXISTS preview_environments (
+    id VARCHAR(255) PRIMARY KEY,
+    project_id VARCHAR(255) NOT NULL,
+    pull_request_id VARCHAR(255),
+    status VARCHAR(50) NOT NULL,
+    reap_at TIMESTAMPTZ,
+    claimed_by VARCHAR(255),
+    claim_expires_at TIMESTAMPTZ,
+    record JSONB NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_preview_environments_project ON preview_environments(project_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_preview_environments_reap ON preview_environments(reap_at) WHERE status <> 'deleted';
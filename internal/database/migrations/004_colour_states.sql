@@ -0,0 +1,13 @@
+-- OpsAgent Blue/Green Colour State
+-- Version: 004_colour_states.sql
+
+-- Live and idle colour per environment (written with a revision check)
+CREATE TABLE IF NOT EXISTS colour_states (
+    project_id VARCHAR(255) NOT NULL,
+    environment VARCHAR(255) NOT NULL,
+    revision BIGINT NOT NULL,
+    state JSONB NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW(),
+    PRIMARY KEY (project_id, environment)
+);
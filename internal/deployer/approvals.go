@@ -0,0 +1,191 @@
+package deployer
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+var (
+	ErrNoPendingApproval  = errors.New("deployment is not awaiting approval")
+	ErrApproverNotAllowed = errors.New("approver does not have a required role")
+	ErrAlreadyDecided     = errors.New("approver has already decided on this gate")
+)
+var approvalPollInterval = 5 * time.Second
+type ApprovalDecisionType string
+const (
+	ApprovalApproved ApprovalDecisionType = "approved"
+	ApprovalRejected ApprovalDecisionType = "rejected"
+)
+type ApprovalGate struct {
+	Name              string
+	AfterWeight       int
+	AfterBatch        int
+	AfterStage        string
+	RequiredRoles     []string
+	RequiredApprovals int
+	Timeout           time.Duration
+	AutoApprove       bool
+}
+type PendingApproval struct {
+	Gate          string    `json:"gate"`
+	RequiredRoles []string  `json:"required_roles,omitempty"`
+	Required      int       `json:"required"`
+	RequestedAt   time.Time `json:"requested_at"`
+	ExpiresAt     time.Time `json:"expires_at,omitempty"`
+}
+type ApprovalDecision struct {
+	Gate      string               `json:"gate"`
+	Decision  ApprovalDecisionType `json:"decision"`
+	UserID    string               `json:"user_id"`
+	UserEmail string               `json:"user_email,omitempty"`
+	Role      string               `json:"role,omitempty"`
+	Comment   string               `json:"comment,omitempty"`
+	DecidedAt time.Time            `json:"decided_at"`
+}
+func (de *DeploymentExecutor) GetState(ctx context.Context, deploymentID string) (*DeploymentState, error) {
+	return de.stateStore.Load(ctx, deploymentID)
+}
+func (de *DeploymentExecutor) DecideApproval(ctx context.Context, deploymentID string, decision ApprovalDecision) error {
+	for attempt := 0; ; attempt++ {
+		state, err := de.stateStore.Load(ctx, deploymentID)
+		if err != nil {
+			return err
+		}
+		pending := state.PendingApproval
+		if pending == nil || (decision.Gate != "" && decision.Gate != pending.Gate) {
+			return ErrNoPendingApproval
+		}
+		if len(pending.RequiredRoles) > 0 && !containsString(pending.RequiredRoles, decision.Role) {
+			return fmt.Errorf("%w: %s requires one of %v", ErrApproverNotAllowed, pending.Gate, pending.RequiredRoles)
+		}
+		for _, existing := range state.Approvals {
+			if existing.Gate == pending.Gate && existing.UserID == decision.UserID && !existing.DecidedAt.Before(pending.RequestedAt) {
+				return ErrAlreadyDecided
+			}
+		}
+		recorded := decision
+		recorded.Gate = pending.Gate
+		recorded.DecidedAt = time.Now()
+		state.Approvals = append(state.Approvals, recorded)
+		err = de.saveState(ctx, state)
+		if !errors.Is(err, ErrDeploymentStateConflict) || attempt+1 >= maxStateRetries {
+			return err
+		}
+	}
+}
+func (de *DeploymentExecutor) awaitApproval(ctx context.Context, run *deploymentRun, gate ApprovalGate) error {
+	return de.runStep(ctx, run, fmt.Sprintf("Await Approval: %s", gate.Name), nil, func(ctx context.Context) error {
+		state := run.state
+		required := gate.RequiredApprovals
+		if required <= 0 {
+			required = 1
+		}
+		if state.PendingApproval == nil || state.PendingApproval.Gate != gate.Name {
+			pending := &PendingApproval{
+				Gate:          gate.Name,
+				RequiredRoles: gate.RequiredRoles,
+				Required:      required,
+				RequestedAt:   time.Now(),
+			}
+			if gate.Timeout > 0 {
+				pending.ExpiresAt = pending.RequestedAt.Add(gate.Timeout)
+			}
+			state.PendingApproval = pending
+		}
+		state.Phase = PhaseAwaitingApproval
+		if err := de.saveState(ctx, state); err != nil {
+			return fmt.Errorf("failed to persist pending approval: %w", err)
+		}
+		fmt.Printf("⏸️  Deployment %s awaiting approval at gate %q\n", state.ID, gate.Name)
+		pending := state.PendingApproval
+		for {
+			if latest, err := de.stateStore.Load(ctx, state.ID); err == nil {
+				state.Approvals = mergeApprovals(latest.Approvals, state.Approvals)
+				state.Revision = latest.Revision
+			}
+			approvals := 0
+			for _, decision := range state.Approvals {
+				if decision.Gate != gate.Name || decision.DecidedAt.Before(pending.RequestedAt) {
+					continue
+				}
+				if decision.Decision == ApprovalRejected {
+					return de.closeApproval(ctx, state, fmt.Errorf("rejected by %s at %s: %s",
+						decision.UserID, decision.DecidedAt.Format(time.RFC3339), decision.Comment))
+				}
+				approvals++
+			}
+			if approvals >= required {
+				return de.closeApproval(ctx, state, nil)
+			}
+			if !pending.ExpiresAt.IsZero() && time.Now().After(pending.ExpiresAt) {
+				decision := ApprovalDecision{
+					Gate:      gate.Name,
+					Decision:  ApprovalRejected,
+					UserID:    "system",
+					Comment:   fmt.Sprintf("No decision within %v", gate.Timeout),
+					DecidedAt: time.Now(),
+				}
+				if gate.AutoApprove {
+					decision.Decision = ApprovalApproved
+				}
+				state.Approvals = append(state.Approvals, decision)
+				if decision.Decision == ApprovalApproved {
+					return de.closeApproval(ctx, state, nil)
+				}
+				return de.closeApproval(ctx, state, fmt.Errorf("approval timed out after %v", gate.Timeout))
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(approvalPollInterval):
+			}
+		}
+	})
+}
+func (de *DeploymentExecutor) awaitStageGates(ctx context.Context, run *deploymentRun, progCfg *ProgressiveConfig, stage string) error {
+	for _, gate := range progCfg.Gates {
+		if gate.AfterStage != stage {
+			continue
+		}
+		if err := de.awaitApproval(ctx, run, gate); err != nil {
+			return fmt.Errorf("approval gate %q not passed: %w", gate.Name, err)
+		}
+	}
+	return nil
+}
+func (de *DeploymentExecutor) closeApproval(ctx context.Context, state *DeploymentState, err error) error {
+	state.PendingApproval = nil
+	state.Phase = PhaseRunning
+	if saveErr := de.saveState(ctx, state); saveErr != nil && err == nil {
+		return fmt.Errorf("failed to persist approval: %w", saveErr)
+	}
+	return err
+}
+func mergeApprovals(latest, local []ApprovalDecision) []ApprovalDecision {
+	merged := append([]ApprovalDecision(nil), latest...)
+	for _, decision := range local {
+		known := false
+		for _, existing := range latest {
+			if existing.Gate == decision.Gate && existing.UserID == decision.UserID && existing.Decision == decision.Decision && existing.DecidedAt.Equal(decision.DecidedAt) {
+				known = true
+				break
+			}
+		}
+		if !known {
+			merged = append(merged, decision)
+		}
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].DecidedAt.Before(merged[j].DecidedAt)
+	})
+	return merged
+}
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package deployer
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+func pendingDeployment(t *testing.T) (*DeploymentExecutor, *DeploymentState) {
+	t.Helper()
+	ctx := context.Background()
+	store := NewFileStateStore(t.TempDir())
+	de := &DeploymentExecutor{stateStore: store}
+	state := newDeploymentState(&DeploymentConfig{ProjectID: "proj", Environment: "production", Strategy: StrategyCanary, Version: "v2"})
+	state.Phase = PhaseAwaitingApproval
+	state.PendingApproval = &PendingApproval{Gate: "release", Required: 2, RequestedAt: time.Now()}
+	if err := de.saveState(ctx, state); err != nil {
+		t.Fatalf("saveState: %v", err)
+	}
+	return de, state
+}
+func TestFileStateStoreRejectsStaleRevision(t *testing.T) {
+	ctx := context.Background()
+	de, state := pendingDeployment(t)
+	stale, err := de.stateStore.Load(ctx, state.ID)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	state.Phase = PhaseRunning
+	if err := de.stateStore.Save(ctx, state); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	stale.Error = "stale write"
+	if err := de.stateStore.Save(ctx, stale); !errors.Is(err, ErrDeploymentStateConflict) {
+		t.Fatalf("expected a revision conflict, got %v", err)
+	}
+	fresh := newDeploymentState(&DeploymentConfig{DeploymentID: state.ID})
+	if err := de.stateStore.Save(ctx, fresh); !errors.Is(err, ErrDeploymentStateConflict) {
+		t.Fatalf("expected creating an existing deployment to conflict, got %v", err)
+	}
+}
+func TestDecideApprovalAfterGateClosed(t *testing.T) {
+	ctx := context.Background()
+	de, state := pendingDeployment(t)
+	if err := de.closeApproval(ctx, state, nil); err != nil {
+		t.Fatalf("closeApproval: %v", err)
+	}
+	err := de.DecideApproval(ctx, state.ID, ApprovalDecision{Decision: ApprovalApproved, UserID: "u1"})
+	if !errors.Is(err, ErrNoPendingApproval) {
+		t.Fatalf("expected ErrNoPendingApproval, got %v", err)
+	}
+	latest, err := de.stateStore.Load(ctx, state.ID)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if latest.PendingApproval != nil || latest.Phase != PhaseRunning {
+		t.Fatalf("expected the closed gate to stay closed, got %+v in phase %s", latest.PendingApproval, latest.Phase)
+	}
+}
+func TestExecutorSaveKeepsConcurrentDecisions(t *testing.T) {
+	ctx := context.Background()
+	de, state := pendingDeployment(t)
+	for _, user := range []string{"u1", "u2"} {
+		if err := de.DecideApproval(ctx, state.ID, ApprovalDecision{Decision: ApprovalApproved, UserID: user}); err != nil {
+			t.Fatalf("DecideApproval(%s): %v", user, err)
+		}
+	}
+	state.Steps = append(state.Steps, DeploymentStep{Name: "Await Approval: release", Status: "running"})
+	if err := de.saveState(ctx, state); err != nil {
+		t.Fatalf("saveState: %v", err)
+	}
+	latest, err := de.stateStore.Load(ctx, state.ID)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(latest.Approvals) != 2 || len(latest.Steps) != 1 {
+		t.Fatalf("expected both decisions and the executor step to survive, got %d approvals and %d steps", len(latest.Approvals), len(latest.Steps))
+	}
+	if err := de.DecideApproval(ctx, state.ID, ApprovalDecision{Decision: ApprovalRejected, UserID: "u1"}); !errors.Is(err, ErrAlreadyDecided) {
+		t.Fatalf("expected ErrAlreadyDecided, got %v", err)
+	}
+}
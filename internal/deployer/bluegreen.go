@@ -0,0 +1,375 @@
+package deployer
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+var (
+	ErrColourStateNotFound = errors.New("no blue/green state for environment")
+	ErrNoWarmColour        = errors.New("no warm idle colour to switch back to")
+	ErrColourStateChanged  = errors.New("blue/green state was modified concurrently")
+)
+type Colour string
+const (
+	ColourBlue  Colour = "blue"
+	ColourGreen Colour = "green"
+)
+type IdleAction string
+const (
+	IdleScaleToZero IdleAction = "scale_to_zero"
+	IdleTeardown    IdleAction = "teardown"
+)
+type IdleStatus string
+const (
+	IdleEmpty        IdleStatus = "empty"
+	IdleWarm         IdleStatus = "warm"
+	IdleScaledToZero IdleStatus = "scaled_to_zero"
+	IdleTornDown     IdleStatus = "torn_down"
+)
+type BlueGreenConfig struct {
+	RollbackWindow time.Duration
+	IdleAction     IdleAction
+}
+type ColourState struct {
+	ProjectID        string        `json:"project_id"`
+	Environment      string        `json:"environment"`
+	Live             Colour        `json:"live"`
+	LiveVersion      string        `json:"live_version,omitempty"`
+	LiveDeploymentID string        `json:"live_deployment_id,omitempty"`
+	Idle             Colour        `json:"idle"`
+	IdleVersion      string        `json:"idle_version,omitempty"`
+	IdleDeploymentID string        `json:"idle_deployment_id,omitempty"`
+	IdleStatus       IdleStatus    `json:"idle_status"`
+	IdleSince        time.Time     `json:"idle_since,omitempty"`
+	IdleExpiresAt    time.Time     `json:"idle_expires_at,omitempty"`
+	RollbackWindow   time.Duration `json:"rollback_window"`
+	IdleAction       IdleAction    `json:"idle_action"`
+	Revision         int64         `json:"revision"`
+	UpdatedAt        time.Time     `json:"updated_at"`
+}
+type ColourStore interface {
+	GetColourState(ctx context.Context, projectID, environment string) (*ColourState, error)
+	SaveColourState(ctx context.Context, state *ColourState) error
+	ListColourStates(ctx context.Context) ([]*ColourState, error)
+}
+type ColourScaler interface {
+	ScaleColour(ctx context.Context, projectID, environment string, colour Colour, replicas int) error
+	TeardownColour(ctx context.Context, projectID, environment string, colour Colour) error
+}
+func (c Colour) Other() Colour {
+	if c == ColourGreen {
+		return ColourBlue
+	}
+	return ColourGreen
+}
+func (bg *BlueGreenConfig) withDefaults() BlueGreenConfig {
+	cfg := BlueGreenConfig{}
+	if bg != nil {
+		cfg = *bg
+	}
+	if cfg.RollbackWindow <= 0 {
+		cfg.RollbackWindow = time.Hour
+	}
+	if cfg.IdleAction == "" {
+		cfg.IdleAction = IdleScaleToZero
+	}
+	return cfg
+}
+func newColourState(projectID, environment string) *ColourState {
+	return &ColourState{
+		ProjectID:   projectID,
+		Environment: environment,
+		Live:        ColourBlue,
+		Idle:        ColourGreen,
+		IdleStatus:  IdleEmpty,
+	}
+}
+func (s *ColourState) swap(now time.Time) {
+	s.Live, s.Idle = s.Idle, s.Live
+	s.LiveVersion, s.IdleVersion = s.IdleVersion, s.LiveVersion
+	s.LiveDeploymentID, s.IdleDeploymentID = s.IdleDeploymentID, s.LiveDeploymentID
+	s.IdleStatus = IdleWarm
+	s.IdleSince = now
+	s.IdleExpiresAt = now.Add(s.RollbackWindow)
+	s.UpdatedAt = now
+}
+func (de *DeploymentExecutor) loadColourState(ctx context.Context, projectID, environment string) (*ColourState, error) {
+	state, err := de.colours.GetColourState(ctx, projectID, environment)
+	if errors.Is(err, ErrColourStateNotFound) {
+		return newColourState(projectID, environment), nil
+	}
+	return state, err
+}
+func (de *DeploymentExecutor) executeBlueGreen(ctx context.Context, run *deploymentRun, config *DeploymentConfig) (*DeploymentResult, error) {
+	bgCfg := config.BlueGreenConfig.withDefaults()
+	err := de.runStep(ctx, run, "Select Idle Colour", nil, func(ctx context.Context) error {
+		state, err := de.loadColourState(ctx, config.ProjectID, config.Environment)
+		if err != nil {
+			return err
+		}
+		run.current().Output = fmt.Sprintf("%s->%s", state.Live, state.Live.Other())
+		return nil
+	})
+	if err != nil {
+		return de.finish(ctx, run, "failed", err)
+	}
+	live, target, _ := strings.Cut(stepOutput(run, "Select Idle Colour"), "->")
+	liveColour, targetColour := Colour(live), Colour(target)
+	err = de.runStep(ctx, run, fmt.Sprintf("Deploy %s Environment", targetColour), nil, func(ctx context.Context) error {
+		if scaler, ok := de.loadBalancer.(ColourScaler); ok {
+			return scaler.ScaleColour(ctx, config.ProjectID, config.Environment, targetColour, config.Replicas)
+		}
+		time.Sleep(3 * time.Second)
+		return nil
+	})
+	if err != nil {
+		return de.finish(ctx, run, "failed", err)
+	}
+	if err := de.runStep(ctx, run, fmt.Sprintf("Health Check %s Environment", targetColour), nil, de.healthCheckStep(config)); err != nil {
+		return de.finish(ctx, run, "failed", err)
+	}
+	switchBack := &Compensation{Type: CompensationSwitchTraffic, FromVersion: string(targetColour), ToVersion: string(liveColour)}
+	err = de.runStep(ctx, run, fmt.Sprintf("Switch Traffic from %s to %s", liveColour, targetColour), switchBack, func(ctx context.Context) error {
+		return de.loadBalancer.SwitchTraffic(ctx, string(liveColour), string(targetColour))
+	})
+	if err != nil {
+		return de.rollback(ctx, run, fmt.Sprintf("Failed to switch traffic to %s environment", targetColour), err)
+	}
+	err = de.runStep(ctx, run, fmt.Sprintf("Monitor %s Environment", targetColour), nil, func(ctx context.Context) error {
+		time.Sleep(30 * time.Second)
+		metrics, err := de.monitor.GetMetrics(ctx, config.Version)
+		if err == nil && metrics.ErrorRate > 0.05 {
+			return fmt.Errorf("High error rate detected")
+		}
+		return nil
+	})
+	if err != nil {
+		return de.rollback(ctx, run, fmt.Sprintf("High error rate in %s environment", targetColour),
+			fmt.Errorf("deployment rolled back due to high error rate"))
+	}
+	restore := &Compensation{Type: CompensationRestoreColour, FromVersion: string(targetColour), ToVersion: string(liveColour)}
+	err = de.runStep(ctx, run, fmt.Sprintf("Mark %s Live", targetColour), restore, func(ctx context.Context) error {
+		state, err := de.loadColourState(ctx, config.ProjectID, config.Environment)
+		if err != nil {
+			return err
+		}
+		if state.Live != liveColour {
+			return nil
+		}
+		state.RollbackWindow = bgCfg.RollbackWindow
+		state.IdleAction = bgCfg.IdleAction
+		state.swap(time.Now())
+		state.LiveVersion = config.Version
+		state.LiveDeploymentID = config.DeploymentID
+		if state.IdleVersion == "" {
+			state.IdleStatus = IdleEmpty
+		}
+		return de.colours.SaveColourState(ctx, state)
+	})
+	if err != nil {
+		return de.rollback(ctx, run, "Failed to record live colour", err)
+	}
+	return de.complete(ctx, run)
+}
+func (de *DeploymentExecutor) restoreColour(ctx context.Context, config *DeploymentConfig, comp Compensation) error {
+	state, err := de.loadColourState(ctx, config.ProjectID, config.Environment)
+	if err != nil {
+		return err
+	}
+	if string(state.Live) != comp.FromVersion {
+		return nil
+	}
+	state.swap(time.Now())
+	return de.colours.SaveColourState(ctx, state)
+}
+func stepOutput(run *deploymentRun, name string) string {
+	for _, step := range run.state.Steps {
+		if step.Name == name {
+			return step.Output
+		}
+	}
+	return ""
+}
+func (rm *RollbackManager) SwitchBack(ctx context.Context, projectID, environment, requestedBy string) (*ColourState, error) {
+	state, err := rm.history.GetColourState(ctx, projectID, environment)
+	if err != nil {
+		return nil, err
+	}
+	if state.IdleStatus != IdleWarm || state.IdleVersion == "" {
+		return nil, ErrNoWarmColour
+	}
+	previous := *state
+	state.swap(time.Now())
+	if err := rm.history.SaveColourState(ctx, state); err != nil {
+		return nil, err
+	}
+	if err := rm.executor.loadBalancer.SwitchTraffic(ctx, string(previous.Live), string(previous.Idle)); err != nil {
+		restored := previous
+		restored.Revision = state.Revision
+		if saveErr := rm.history.SaveColourState(ctx, &restored); saveErr != nil {
+			fmt.Printf("⚠️  Failed to restore blue/green state for %s/%s: %v\n", projectID, environment, saveErr)
+		}
+		return nil, fmt.Errorf("failed to switch traffic to %s: %w", previous.Idle, err)
+	}
+	fmt.Printf("🔄 Switched %s back from %s (%s) to %s (%s)\n", environment,
+		previous.Live, previous.LiveVersion, state.Live, state.LiveVersion)
+	record := &DeploymentRecord{
+		ProjectID:      projectID,
+		Environment:    environment,
+		Version:        state.LiveVersion,
+		Strategy:       StrategyBlueGreen,
+		Status:         "success",
+		DeployedAt:     time.Now(),
+		DeployedBy:     requestedBy,
+		RollbackFrom:   previous.LiveDeploymentID,
+		Configuration:  map[string]interface{}{"colour": string(state.Live)},
+		RollbackReason: fmt.Sprintf("Switched back to %s", state.Live),
+	}
+	if err := rm.history.RecordDeployment(ctx, record); err != nil {
+		return state, fmt.Errorf("failed to record switch-back: %w", err)
+	}
+	return state, nil
+}
+func (rm *RollbackManager) TeardownIdleColours(ctx context.Context, now time.Time) error {
+	states, err := rm.history.ListColourStates(ctx)
+	if err != nil {
+		return err
+	}
+	scaler, _ := rm.executor.loadBalancer.(ColourScaler)
+	var errs []error
+	for _, state := range states {
+		if state.IdleStatus != IdleWarm || now.Before(state.IdleExpiresAt) {
+			continue
+		}
+		if scaler == nil {
+			errs = append(errs, fmt.Errorf("load balancer cannot scale %s/%s %s", state.ProjectID, state.Environment, state.Idle))
+			continue
+		}
+		warm := *state
+		state.IdleStatus = IdleScaledToZero
+		if state.IdleAction == IdleTeardown {
+			state.IdleStatus = IdleTornDown
+		}
+		if err := rm.history.SaveColourState(ctx, state); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if state.IdleAction == IdleTeardown {
+			err = scaler.TeardownColour(ctx, state.ProjectID, state.Environment, state.Idle)
+		} else {
+			err = scaler.ScaleColour(ctx, state.ProjectID, state.Environment, state.Idle, 0)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to retire %s/%s %s: %w", state.ProjectID, state.Environment, state.Idle, err))
+			warm.Revision = state.Revision
+			if err := rm.history.SaveColourState(ctx, &warm); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		fmt.Printf("🧹 Retired idle %s environment for %s/%s (%s)\n", state.Idle, state.ProjectID, state.Environment, state.IdleStatus)
+	}
+	return errors.Join(errs...)
+}
+func (rm *RollbackManager) RunColourJanitor(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if err := rm.TeardownIdleColours(ctx, time.Now()); err != nil {
+				fmt.Printf("⚠️  Idle colour cleanup: %v\n", err)
+			}
+		}
+	}
+}
+func (dh *DeploymentHistory) GetColourState(ctx context.Context, projectID, environment string) (*ColourState, error) {
+	return dh.store.GetColourState(ctx, projectID, environment)
+}
+func (dh *DeploymentHistory) SaveColourState(ctx context.Context, state *ColourState) error {
+	return dh.store.SaveColourState(ctx, state)
+}
+func (dh *DeploymentHistory) ListColourStates(ctx context.Context) ([]*ColourState, error) {
+	return dh.store.ListColourStates(ctx)
+}
+func (fs *FileHistoryStore) colourPath(projectID, environment string) string {
+	return filepath.Join(fs.storagePath, "colours", fmt.Sprintf("%s__%s.json", projectID, environment))
+}
+func (fs *FileHistoryStore) GetColourState(ctx context.Context, projectID, environment string) (*ColourState, error) {
+	data, err := os.ReadFile(fs.colourPath(projectID, environment))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrColourStateNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state ColourState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+func (fs *FileHistoryStore) SaveColourState(ctx context.Context, state *ColourState) error {
+	path := fs.colourPath(state.ProjectID, state.Environment)
+	unlock, err := lockFile(path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	var stored int64
+	current, err := fs.GetColourState(ctx, state.ProjectID, state.Environment)
+	switch {
+	case err == nil:
+		stored = current.Revision
+	case !errors.Is(err, ErrColourStateNotFound):
+		return err
+	}
+	if stored != state.Revision {
+		return fmt.Errorf("%w: %s/%s is at revision %d, not %d", ErrColourStateChanged, state.ProjectID, state.Environment, stored, state.Revision)
+	}
+	next := *state
+	next.Revision++
+	next.UpdatedAt = time.Now()
+	data, err := json.MarshalIndent(&next, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path, data, 0644); err != nil {
+		return err
+	}
+	*state = next
+	return nil
+}
+func (fs *FileHistoryStore) ListColourStates(ctx context.Context) ([]*ColourState, error) {
+	dir := filepath.Join(fs.storagePath, "colours")
+	files, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var states []*ColourState
+	for _, file := range files {
+		if filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			continue
+		}
+		var state ColourState
+		if err := json.Unmarshal(data, &state); err != nil {
+			continue
+		}
+		states = append(states, &state)
+	}
+	return states, nil
+}
@@ -0,0 +1,183 @@
+package deployer
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+type colourBalancer struct {
+	fakeBalancer
+	failSwitch bool
+	failScale  bool
+}
+func (c *colourBalancer) SwitchTraffic(ctx context.Context, fromVersion, toVersion string) error {
+	if c.failSwitch {
+		return errors.New("switch failed")
+	}
+	return c.fakeBalancer.SwitchTraffic(ctx, fromVersion, toVersion)
+}
+func (c *colourBalancer) ScaleColour(ctx context.Context, projectID, environment string, colour Colour, replicas int) error {
+	if c.failScale {
+		return errors.New("scale failed")
+	}
+	c.record("scale:" + string(colour))
+	return nil
+}
+func (c *colourBalancer) TeardownColour(ctx context.Context, projectID, environment string, colour Colour) error {
+	c.record("teardown:" + string(colour))
+	return nil
+}
+func warmColours(t *testing.T, history *DeploymentHistory, expiresAt time.Time) *ColourState {
+	t.Helper()
+	state := &ColourState{
+		ProjectID:        "proj",
+		Environment:      "production",
+		Live:             ColourGreen,
+		LiveVersion:      "v2",
+		LiveDeploymentID: "d2",
+		Idle:             ColourBlue,
+		IdleVersion:      "v1",
+		IdleDeploymentID: "d1",
+		IdleStatus:       IdleWarm,
+		IdleExpiresAt:    expiresAt,
+		IdleAction:       IdleScaleToZero,
+		RollbackWindow:   time.Hour,
+	}
+	if err := history.SaveColourState(context.Background(), state); err != nil {
+		t.Fatalf("SaveColourState: %v", err)
+	}
+	return state
+}
+func TestSaveColourStateChecksRevision(t *testing.T) {
+	ctx := context.Background()
+	history := NewDeploymentHistory(t.TempDir())
+	state := warmColours(t, history, time.Now().Add(time.Hour))
+	stale := *state
+	state.IdleStatus = IdleScaledToZero
+	if err := history.SaveColourState(ctx, state); err != nil {
+		t.Fatalf("SaveColourState: %v", err)
+	}
+	stale.Live, stale.Idle = stale.Idle, stale.Live
+	if err := history.SaveColourState(ctx, &stale); !errors.Is(err, ErrColourStateChanged) {
+		t.Fatalf("expected the stale write to be rejected, got %v", err)
+	}
+	stored, err := history.GetColourState(ctx, "proj", "production")
+	if err != nil {
+		t.Fatalf("GetColourState: %v", err)
+	}
+	if stored.Live != ColourGreen || stored.IdleStatus != IdleScaledToZero || stored.Revision != 2 {
+		t.Fatalf("unexpected stored state %+v", stored)
+	}
+}
+func TestSwitchBack(t *testing.T) {
+	tests := []struct {
+		name       string
+		failSwitch bool
+		wantLive   Colour
+		wantErr    bool
+	}{
+		{name: "switches to the warm colour", wantLive: ColourBlue},
+		{name: "keeps the state when traffic cannot move", failSwitch: true, wantLive: ColourGreen, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			history := NewDeploymentHistory(t.TempDir())
+			warmColours(t, history, time.Now().Add(time.Hour))
+			lb := &colourBalancer{failSwitch: tt.failSwitch}
+			rm := NewRollbackManager(history, &DeploymentExecutor{loadBalancer: lb}, nil, nil)
+			_, err := rm.SwitchBack(ctx, "proj", "production", "oncall")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SwitchBack error = %v, want error %v", err, tt.wantErr)
+			}
+			stored, err := history.GetColourState(ctx, "proj", "production")
+			if err != nil {
+				t.Fatalf("GetColourState: %v", err)
+			}
+			if stored.Live != tt.wantLive || stored.IdleStatus != IdleWarm {
+				t.Fatalf("expected %s live with a warm idle colour, got %+v", tt.wantLive, stored)
+			}
+			records, err := history.ListDeployments(ctx, "proj", "production", 10)
+			if err != nil {
+				t.Fatalf("ListDeployments: %v", err)
+			}
+			if tt.wantErr != (len(records) == 0) {
+				t.Fatalf("expected a switch-back record only on success, got %d records", len(records))
+			}
+		})
+	}
+}
+func TestConcurrentSwitchBacksStayConsistent(t *testing.T) {
+	ctx := context.Background()
+	history := NewDeploymentHistory(t.TempDir())
+	warmColours(t, history, time.Now().Add(time.Hour))
+	lb := &colourBalancer{}
+	rm := NewRollbackManager(history, &DeploymentExecutor{loadBalancer: lb}, nil, nil)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	switched := 0
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := rm.SwitchBack(ctx, "proj", "production", "oncall")
+			if err != nil && !errors.Is(err, ErrColourStateChanged) {
+				t.Errorf("SwitchBack: %v", err)
+			}
+			if err == nil {
+				mu.Lock()
+				switched++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if switched == 0 || len(lb.calls) != switched {
+		t.Fatalf("expected one traffic switch per successful switch-back, got %d switches for %d", len(lb.calls), switched)
+	}
+	stored, err := history.GetColourState(ctx, "proj", "production")
+	if err != nil {
+		t.Fatalf("GetColourState: %v", err)
+	}
+	want := ColourGreen
+	if switched%2 == 1 {
+		want = ColourBlue
+	}
+	if stored.Live != want || stored.Revision != int64(1+switched) {
+		t.Fatalf("expected %s live at revision %d after %d switches, got %s at %d", want, 1+switched, switched, stored.Live, stored.Revision)
+	}
+}
+func TestTeardownIdleColours(t *testing.T) {
+	tests := []struct {
+		name       string
+		expiresIn  time.Duration
+		failScale  bool
+		wantStatus IdleStatus
+		wantErr    bool
+	}{
+		{name: "inside the rollback window", expiresIn: time.Hour, wantStatus: IdleWarm},
+		{name: "expired", expiresIn: -time.Minute, wantStatus: IdleScaledToZero},
+		{name: "scaling fails", expiresIn: -time.Minute, failScale: true, wantStatus: IdleWarm, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			history := NewDeploymentHistory(t.TempDir())
+			warmColours(t, history, time.Now().Add(tt.expiresIn))
+			lb := &colourBalancer{failScale: tt.failScale}
+			rm := NewRollbackManager(history, &DeploymentExecutor{loadBalancer: lb}, nil, nil)
+			err := rm.TeardownIdleColours(ctx, time.Now())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("TeardownIdleColours error = %v, want error %v", err, tt.wantErr)
+			}
+			stored, err := history.GetColourState(ctx, "proj", "production")
+			if err != nil {
+				t.Fatalf("GetColourState: %v", err)
+			}
+			if stored.IdleStatus != tt.wantStatus {
+				t.Fatalf("expected idle status %s, got %s", tt.wantStatus, stored.IdleStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,392 @@
+package deployer
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"github.com/opsagent/opsagent/internal/rbac"
+)
+var ErrDeployWindowClosed = errors.New("deploy window closed")
+type AuditLogger interface {
+	LogAction(ctx context.Context, log *rbac.AuditLog) error
+}
+type ChangeCalendar struct {
+	ID             string            `json:"id"`
+	Name           string            `json:"name"`
+	OrganizationID string            `json:"organization_id"`
+	ProjectID      string            `json:"project_id,omitempty"`
+	Environment    string            `json:"environment,omitempty"`
+	Timezone       string            `json:"timezone"`
+	AllowedWindows []RecurringWindow `json:"allowed_windows,omitempty"`
+	FreezeWindows  []FreezeWindow    `json:"freeze_windows,omitempty"`
+	CreatedAt      time.Time         `json:"created_at"`
+	UpdatedAt      time.Time         `json:"updated_at"`
+}
+type RecurringWindow struct {
+	Name  string         `json:"name,omitempty"`
+	Days  []time.Weekday `json:"days"`
+	Start string         `json:"start"`
+	End   string         `json:"end"`
+}
+type FreezeWindow struct {
+	Name   string    `json:"name"`
+	Reason string    `json:"reason,omitempty"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+}
+var BreakGlassRoles = []string{string(rbac.RoleOwner), string(rbac.RoleAdmin)}
+type BreakGlassOverride struct {
+	UserID    string `json:"user_id"`
+	UserEmail string `json:"user_email,omitempty"`
+	Role      string `json:"role,omitempty"`
+	Reason    string `json:"reason"`
+}
+type DeployWindowError struct {
+	Calendar string
+	Reason   string
+	NextOpen time.Time
+}
+func (e *DeployWindowError) Error() string {
+	if e.NextOpen.IsZero() {
+		return fmt.Sprintf("deployments are blocked by calendar %q (%s) and no upcoming deploy window was found", e.Calendar, e.Reason)
+	}
+	return fmt.Sprintf("deployments are blocked by calendar %q (%s); next window opens at %s",
+		e.Calendar, e.Reason, e.NextOpen.Format(time.RFC1123))
+}
+func (e *DeployWindowError) Unwrap() error {
+	return ErrDeployWindowClosed
+}
+type CalendarManager struct {
+	storagePath string
+	audit       AuditLogger
+}
+func NewCalendarManager(storagePath string, audit AuditLogger) *CalendarManager {
+	return &CalendarManager{
+		storagePath: storagePath,
+		audit:       audit,
+	}
+}
+func (cm *CalendarManager) SaveCalendar(ctx context.Context, calendar *ChangeCalendar) error {
+	if calendar.OrganizationID == "" {
+		return errors.New("calendar requires an organization")
+	}
+	if _, err := calendar.location(); err != nil {
+		return err
+	}
+	for _, window := range calendar.AllowedWindows {
+		if _, _, err := window.clock(); err != nil {
+			return err
+		}
+	}
+	for _, freeze := range calendar.FreezeWindows {
+		if !freeze.End.After(freeze.Start) {
+			return fmt.Errorf("freeze window %q must end after it starts", freeze.Name)
+		}
+	}
+	if calendar.ID == "" {
+		calendar.ID = fmt.Sprintf("cal_%d", time.Now().UnixNano())
+		calendar.CreatedAt = time.Now()
+	}
+	calendar.UpdatedAt = time.Now()
+	if err := os.MkdirAll(cm.storagePath, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(calendar, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(cm.storagePath, calendar.ID+".json"), data, 0644)
+}
+func (cm *CalendarManager) GetCalendar(ctx context.Context, calendarID string) (*ChangeCalendar, error) {
+	data, err := os.ReadFile(filepath.Join(cm.storagePath, calendarID+".json"))
+	if err != nil {
+		return nil, err
+	}
+	var calendar ChangeCalendar
+	if err := json.Unmarshal(data, &calendar); err != nil {
+		return nil, err
+	}
+	return &calendar, nil
+}
+func (cm *CalendarManager) DeleteCalendar(ctx context.Context, calendarID string) error {
+	return os.Remove(filepath.Join(cm.storagePath, calendarID+".json"))
+}
+func (cm *CalendarManager) ListCalendars(ctx context.Context, orgID string) ([]*ChangeCalendar, error) {
+	files, err := os.ReadDir(cm.storagePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var calendars []*ChangeCalendar
+	for _, file := range files {
+		if filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+		calendar, err := cm.GetCalendar(ctx, strings.TrimSuffix(file.Name(), ".json"))
+		if err != nil {
+			continue
+		}
+		if calendar.OrganizationID == orgID {
+			calendars = append(calendars, calendar)
+		}
+	}
+	return calendars, nil
+}
+func (cm *CalendarManager) Check(ctx context.Context, orgID, projectID, environment string, at time.Time) error {
+	calendars, err := cm.ListCalendars(ctx, orgID)
+	if err != nil {
+		return fmt.Errorf("failed to load change calendars: %w", err)
+	}
+	for _, calendar := range calendars {
+		if calendar.ProjectID != "" && calendar.ProjectID != projectID {
+			continue
+		}
+		if calendar.Environment != "" && calendar.Environment != environment {
+			continue
+		}
+		open, reason, err := calendar.isOpen(at)
+		if err != nil {
+			return err
+		}
+		if !open {
+			return &DeployWindowError{
+				Calendar: calendar.Name,
+				Reason:   reason,
+				NextOpen: nextOpening(calendars, projectID, environment, at),
+			}
+		}
+	}
+	return nil
+}
+func (cm *CalendarManager) Authorize(ctx context.Context, config *DeploymentConfig, at time.Time) error {
+	err := cm.Check(ctx, config.OrganizationID, config.ProjectID, config.Environment, at)
+	if err == nil || !errors.Is(err, ErrDeployWindowClosed) {
+		return err
+	}
+	override := config.BreakGlass
+	if override == nil {
+		return err
+	}
+	if override.UserID == "" || strings.TrimSpace(override.Reason) == "" {
+		return fmt.Errorf("break-glass override requires a user and a reason: %w", err)
+	}
+	if !containsString(BreakGlassRoles, override.Role) {
+		return fmt.Errorf("break-glass override requires one of the roles %v: %w", BreakGlassRoles, err)
+	}
+	if cm.audit == nil {
+		return fmt.Errorf("break-glass override cannot be audited: %w", err)
+	}
+	auditErr := cm.audit.LogAction(ctx, &rbac.AuditLog{
+		OrganizationID: config.OrganizationID,
+		UserID:         override.UserID,
+		UserEmail:      override.UserEmail,
+		Action:         "deploy.break_glass",
+		ResourceType:   "deployment",
+		ResourceID:     config.DeploymentID,
+		Metadata: map[string]interface{}{
+			"project_id":  config.ProjectID,
+			"environment": config.Environment,
+			"version":     config.Version,
+			"reason":      override.Reason,
+			"role":        override.Role,
+			"blocked_by":  err.Error(),
+		},
+	})
+	if auditErr != nil {
+		return fmt.Errorf("failed to audit break-glass override: %w", auditErr)
+	}
+	fmt.Printf("🚨 Break-glass deployment to %s by %s: %s\n", config.Environment, override.UserID, override.Reason)
+	return nil
+}
+func (c *ChangeCalendar) location() (*time.Location, error) {
+	if c.Timezone == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone %q: %w", c.Timezone, err)
+	}
+	return loc, nil
+}
+func (c *ChangeCalendar) isOpen(at time.Time) (bool, string, error) {
+	for _, freeze := range c.FreezeWindows {
+		if !at.Before(freeze.Start) && at.Before(freeze.End) {
+			reason := "freeze window " + freeze.Name
+			if freeze.Reason != "" {
+				reason += ": " + freeze.Reason
+			}
+			return false, reason, nil
+		}
+	}
+	if len(c.AllowedWindows) == 0 {
+		return true, "", nil
+	}
+	loc, err := c.location()
+	if err != nil {
+		return false, "", err
+	}
+	local := at.In(loc)
+	for _, window := range c.AllowedWindows {
+		start, end, err := window.on(local)
+		if err != nil {
+			return false, "", err
+		}
+		if !local.Before(start) && local.Before(end) {
+			return true, "", nil
+		}
+	}
+	return false, "outside allowed deploy windows", nil
+}
+func (w RecurringWindow) clock() (time.Duration, time.Duration, error) {
+	start, err := parseClock(w.Start)
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := parseClock(w.End)
+	if err != nil {
+		return 0, 0, err
+	}
+	if end <= start {
+		return 0, 0, fmt.Errorf("deploy window %s-%s must end after it starts", w.Start, w.End)
+	}
+	return start, end, nil
+}
+func (w RecurringWindow) on(day time.Time) (time.Time, time.Time, error) {
+	start, end, err := w.clock()
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if len(w.Days) > 0 && !containsWeekday(w.Days, day.Weekday()) {
+		return time.Time{}, time.Time{}, nil
+	}
+	at := func(clock time.Duration) time.Time {
+		return time.Date(day.Year(), day.Month(), day.Day(), int(clock/time.Hour), int(clock%time.Hour/time.Minute), 0, 0, day.Location())
+	}
+	return at(start), at(end), nil
+}
+func nextOpening(calendars []*ChangeCalendar, projectID, environment string, at time.Time) time.Time {
+	var candidates []time.Time
+	var applicable []*ChangeCalendar
+	for _, calendar := range calendars {
+		if (calendar.ProjectID != "" && calendar.ProjectID != projectID) ||
+			(calendar.Environment != "" && calendar.Environment != environment) {
+			continue
+		}
+		applicable = append(applicable, calendar)
+		for _, freeze := range calendar.FreezeWindows {
+			if freeze.End.After(at) {
+				candidates = append(candidates, freeze.End)
+			}
+		}
+		loc, err := calendar.location()
+		if err != nil {
+			continue
+		}
+		local := at.In(loc)
+		for d := 0; d <= 400; d++ {
+			day := local.AddDate(0, 0, d)
+			for _, window := range calendar.AllowedWindows {
+				start, _, err := window.on(day)
+				if err == nil && !start.IsZero() && start.After(at) {
+					candidates = append(candidates, start)
+				}
+			}
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Before(candidates[j])
+	})
+	for _, candidate := range candidates {
+		open := true
+		for _, calendar := range applicable {
+			if ok, _, err := calendar.isOpen(candidate); err != nil || !ok {
+				open = false
+				break
+			}
+		}
+		if open {
+			return candidate
+		}
+	}
+	return time.Time{}
+}
+func scheduleOpen(windows []TimeWindow, at time.Time) (bool, time.Time, error) {
+	if len(windows) == 0 {
+		return true, time.Time{}, nil
+	}
+	var next time.Time
+	for _, window := range windows {
+		loc := time.UTC
+		if window.Timezone != "" {
+			var err error
+			if loc, err = time.LoadLocation(window.Timezone); err != nil {
+				return false, time.Time{}, fmt.Errorf("invalid timezone %q: %w", window.Timezone, err)
+			}
+		}
+		var starts, ends []time.Time
+		if window.Start.Year() <= 1 {
+			local := at.In(loc)
+			for d := 0; d <= 7; d++ {
+				day := local.AddDate(0, 0, d)
+				starts = append(starts, time.Date(day.Year(), day.Month(), day.Day(),
+					window.Start.Hour(), window.Start.Minute(), window.Start.Second(), 0, loc))
+				ends = append(ends, time.Date(day.Year(), day.Month(), day.Day(),
+					window.End.Hour(), window.End.Minute(), window.End.Second(), 0, loc))
+			}
+		} else {
+			starts = append(starts, window.Start)
+			ends = append(ends, window.End)
+		}
+		for i := range starts {
+			if !at.Before(starts[i]) && at.Before(ends[i]) {
+				return true, time.Time{}, nil
+			}
+			if starts[i].After(at) && (next.IsZero() || starts[i].Before(next)) {
+				next = starts[i]
+			}
+		}
+	}
+	return false, next, nil
+}
+func waitForDeployWindow(ctx context.Context, windows []TimeWindow) error {
+	for {
+		open, next, err := scheduleOpen(windows, time.Now())
+		if err != nil {
+			return err
+		}
+		if open {
+			return nil
+		}
+		if next.IsZero() {
+			return &DeployWindowError{Calendar: "progressive schedule", Reason: "no remaining time windows"}
+		}
+		fmt.Printf("⏳ Outside the progressive schedule, waiting until %s\n", next.Format(time.RFC1123))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Until(next)):
+		}
+	}
+}
+func parseClock(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+func containsWeekday(days []time.Weekday, day time.Weekday) bool {
+	for _, d := range days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
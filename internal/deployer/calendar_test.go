@@ -0,0 +1,143 @@
+package deployer
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"github.com/opsagent/opsagent/internal/rbac"
+)
+type recordingAudit struct {
+	logs []*rbac.AuditLog
+}
+func (a *recordingAudit) LogAction(ctx context.Context, log *rbac.AuditLog) error {
+	a.logs = append(a.logs, log)
+	return nil
+}
+var weekdays = []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+func calendarManager(t *testing.T, audit AuditLogger, calendars ...*ChangeCalendar) *CalendarManager {
+	t.Helper()
+	cm := NewCalendarManager(t.TempDir(), audit)
+	for _, calendar := range calendars {
+		if err := cm.SaveCalendar(context.Background(), calendar); err != nil {
+			t.Fatalf("SaveCalendar: %v", err)
+		}
+	}
+	return cm
+}
+func TestRecurringWindowOnDSTDays(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("timezone data unavailable: %v", err)
+	}
+	window := RecurringWindow{Start: "09:00", End: "17:30"}
+	for _, day := range []time.Time{
+		time.Date(2024, time.March, 10, 12, 0, 0, 0, loc),
+		time.Date(2024, time.November, 3, 12, 0, 0, 0, loc),
+	} {
+		start, end, err := window.on(day)
+		if err != nil {
+			t.Fatalf("on: %v", err)
+		}
+		if start.Hour() != 9 || start.Minute() != 0 || end.Hour() != 17 || end.Minute() != 30 {
+			t.Fatalf("%s: expected 09:00-17:30 local, got %s-%s", day.Format("2006-01-02"), start.Format("15:04"), end.Format("15:04"))
+		}
+	}
+}
+func TestCalendarCheck(t *testing.T) {
+	monday := time.Date(2024, time.June, 3, 0, 0, 0, 0, time.UTC)
+	calendars := []*ChangeCalendar{
+		{Name: "business hours", OrganizationID: "org", Timezone: "UTC", AllowedWindows: []RecurringWindow{{Days: weekdays, Start: "09:00", End: "17:00"}}},
+		{Name: "release freeze", OrganizationID: "org", Environment: "production", FreezeWindows: []FreezeWindow{{Name: "launch", Reason: "launch day", Start: monday.Add(10 * time.Hour), End: monday.Add(12 * time.Hour)}}},
+		{Name: "other project", OrganizationID: "org", ProjectID: "other", AllowedWindows: []RecurringWindow{{Start: "01:00", End: "02:00"}}},
+	}
+	tests := []struct {
+		name        string
+		environment string
+		at          time.Time
+		wantReason  string
+		wantNext    time.Time
+	}{
+		{name: "inside the window", environment: "production", at: monday.Add(9 * time.Hour)},
+		{name: "weekend", environment: "production", at: monday.Add(-36 * time.Hour), wantReason: "outside allowed deploy windows", wantNext: monday.Add(9 * time.Hour)},
+		{name: "after hours", environment: "production", at: monday.Add(18 * time.Hour), wantReason: "outside allowed deploy windows", wantNext: monday.Add(33 * time.Hour)},
+		{name: "freeze", environment: "production", at: monday.Add(11 * time.Hour), wantReason: "freeze window launch: launch day", wantNext: monday.Add(12 * time.Hour)},
+		{name: "freeze for another environment", environment: "staging", at: monday.Add(11 * time.Hour)},
+	}
+	cm := calendarManager(t, nil, calendars...)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := cm.Check(context.Background(), "org", "proj", tt.environment, tt.at)
+			if tt.wantReason == "" {
+				if err != nil {
+					t.Fatalf("expected deploys to be allowed, got %v", err)
+				}
+				return
+			}
+			var windowErr *DeployWindowError
+			if !errors.As(err, &windowErr) || !errors.Is(err, ErrDeployWindowClosed) {
+				t.Fatalf("expected a deploy window error, got %v", err)
+			}
+			if windowErr.Reason != tt.wantReason || !windowErr.NextOpen.Equal(tt.wantNext) {
+				t.Fatalf("expected %q until %s, got %q until %s", tt.wantReason, tt.wantNext, windowErr.Reason, windowErr.NextOpen)
+			}
+		})
+	}
+}
+func TestNextOpeningSkipsFrozenWindows(t *testing.T) {
+	friday := time.Date(2024, time.June, 7, 0, 0, 0, 0, time.UTC)
+	calendars := []*ChangeCalendar{
+		{OrganizationID: "org", AllowedWindows: []RecurringWindow{{Days: weekdays, Start: "09:00", End: "17:00"}}},
+		{OrganizationID: "org", FreezeWindows: []FreezeWindow{{Name: "weekend", Start: friday.Add(8 * time.Hour), End: friday.Add(80 * time.Hour)}}},
+	}
+	if got, want := nextOpening(calendars, "proj", "production", friday.Add(10*time.Hour)), friday.Add(81*time.Hour); !got.Equal(want) {
+		t.Fatalf("expected the next window after the freeze at %s, got %s", want, got)
+	}
+	calendars[1].FreezeWindows[0].End = friday.Add(13 * time.Hour)
+	if got, want := nextOpening(calendars, "proj", "production", friday.Add(10*time.Hour)), friday.Add(13*time.Hour); !got.Equal(want) {
+		t.Fatalf("expected the freeze end inside the window at %s, got %s", want, got)
+	}
+	if got := nextOpening(calendars[:1], "proj", "production", friday.Add(10*time.Hour)); !got.Equal(friday.Add(81 * time.Hour)) {
+		t.Fatalf("expected Monday's window, got %s", got)
+	}
+}
+func TestCalendarAuthorizeBreakGlass(t *testing.T) {
+	now := time.Now()
+	freeze := &ChangeCalendar{Name: "freeze", OrganizationID: "org", FreezeWindows: []FreezeWindow{{Name: "incident", Start: now.Add(-time.Hour), End: now.Add(time.Hour)}}}
+	tests := []struct {
+		name      string
+		override  *BreakGlassOverride
+		noAudit   bool
+		wantErr   string
+		wantAudit bool
+	}{
+		{name: "no override", wantErr: "blocked by calendar"},
+		{name: "admin with a reason", override: &BreakGlassOverride{UserID: "u1", Role: string(rbac.RoleAdmin), Reason: "hotfix"}, wantAudit: true},
+		{name: "developer", override: &BreakGlassOverride{UserID: "u1", Role: string(rbac.RoleDeveloper), Reason: "hotfix"}, wantErr: "requires one of the roles"},
+		{name: "missing reason", override: &BreakGlassOverride{UserID: "u1", Role: string(rbac.RoleOwner), Reason: " "}, wantErr: "requires a user and a reason"},
+		{name: "no audit log", override: &BreakGlassOverride{UserID: "u1", Role: string(rbac.RoleOwner), Reason: "hotfix"}, noAudit: true, wantErr: "cannot be audited"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			audit := &recordingAudit{}
+			var logger AuditLogger = audit
+			if tt.noAudit {
+				logger = nil
+			}
+			cm := calendarManager(t, logger, freeze)
+			err := cm.Authorize(context.Background(), &DeploymentConfig{DeploymentID: "d1", OrganizationID: "org", ProjectID: "proj", Environment: "production", BreakGlass: tt.override}, now)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("expected the override to be accepted, got %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr) || !errors.Is(err, ErrDeployWindowClosed)) {
+				t.Fatalf("expected %q, got %v", tt.wantErr, err)
+			}
+			if got := len(audit.logs) == 1; got != tt.wantAudit {
+				t.Fatalf("expected audit %v, got %d entries", tt.wantAudit, len(audit.logs))
+			}
+			if tt.wantAudit && (audit.logs[0].Action != "deploy.break_glass" || audit.logs[0].Metadata["reason"] != "hotfix") {
+				t.Fatalf("unexpected audit entry %+v", audit.logs[0])
+			}
+		})
+	}
+}
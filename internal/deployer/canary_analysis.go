@@ -0,0 +1,325 @@
+package deployer
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+type CanaryVerdict string
+const (
+	VerdictPass     CanaryVerdict = "pass"
+	VerdictMarginal CanaryVerdict = "marginal"
+	VerdictFail     CanaryVerdict = "fail"
+)
+type MetricDirection string
+const (
+	DirectionIncreaseIsBad MetricDirection = "increase_is_bad"
+	DirectionDecreaseIsBad MetricDirection = "decrease_is_bad"
+)
+const (
+	MetricErrorRate   = "error_rate"
+	MetricLatency     = "latency"
+	MetricLatencyP50  = "latency_p50"
+	MetricLatencyP95  = "latency_p95"
+	MetricLatencyP99  = "latency_p99"
+	MetricRequestRate = "request_rate"
+	MetricCPUUsage    = "cpu_usage"
+	MetricMemoryUsage = "memory_usage"
+)
+type MetricQuerier interface {
+	GetMetric(ctx context.Context, version, name string) (float64, error)
+}
+type CanaryAnalysisConfig struct {
+	BaselineVersion string
+	SampleCount     int
+	SampleInterval  time.Duration
+	Alpha           float64
+	PassScore       float64
+	MarginalScore   float64
+	Metrics         []CanaryMetricSpec
+}
+type CanaryMetricSpec struct {
+	Name      string
+	Direction MetricDirection
+	Tolerance float64
+}
+type CanaryAnalysis struct {
+	Weight          int                `json:"weight"`
+	BaselineVersion string             `json:"baseline_version"`
+	CanaryVersion   string             `json:"canary_version"`
+	Score           float64            `json:"score"`
+	Verdict         CanaryVerdict      `json:"verdict"`
+	Judgement       string             `json:"judgement"`
+	Metrics         []MetricComparison `json:"metrics"`
+	AnalyzedAt      time.Time          `json:"analyzed_at"`
+}
+type MetricComparison struct {
+	Name           string          `json:"name"`
+	Direction      MetricDirection `json:"direction"`
+	BaselineMedian float64         `json:"baseline_median"`
+	CanaryMedian   float64         `json:"canary_median"`
+	RelativeChange float64         `json:"relative_change"`
+	U              float64         `json:"u"`
+	PValue         float64         `json:"p_value"`
+	Samples        int             `json:"samples"`
+	Verdict        CanaryVerdict   `json:"verdict"`
+	Reason         string          `json:"reason"`
+}
+func (ac *CanaryAnalysisConfig) withDefaults(stepDuration time.Duration) CanaryAnalysisConfig {
+	cfg := *ac
+	if cfg.SampleCount <= 0 {
+		cfg.SampleCount = 10
+	}
+	if cfg.SampleInterval <= 0 {
+		cfg.SampleInterval = stepDuration / time.Duration(cfg.SampleCount)
+	}
+	if cfg.Alpha <= 0 {
+		cfg.Alpha = 0.05
+	}
+	if cfg.PassScore <= 0 {
+		cfg.PassScore = 95
+	}
+	if cfg.MarginalScore <= 0 {
+		cfg.MarginalScore = 75
+	}
+	return cfg
+}
+func validateCanary(cfg *CanaryConfig) error {
+	if len(cfg.SuccessMetrics) > 0 && cfg.Analysis == nil {
+		return fmt.Errorf("canary success metrics %s require statistical analysis against a baseline", strings.Join(cfg.SuccessMetrics, ", "))
+	}
+	return nil
+}
+func (de *DeploymentExecutor) canaryMetricSpecs(canaryCfg *CanaryConfig, analysisCfg CanaryAnalysisConfig) []CanaryMetricSpec {
+	specs := []CanaryMetricSpec{
+		{Name: MetricErrorRate, Direction: DirectionIncreaseIsBad, Tolerance: 0.1},
+	}
+	if _, ok := de.monitor.(MetricQuerier); ok {
+		specs = append(specs,
+			CanaryMetricSpec{Name: MetricLatencyP50, Direction: DirectionIncreaseIsBad, Tolerance: 0.1},
+			CanaryMetricSpec{Name: MetricLatencyP95, Direction: DirectionIncreaseIsBad, Tolerance: 0.15},
+			CanaryMetricSpec{Name: MetricLatencyP99, Direction: DirectionIncreaseIsBad, Tolerance: 0.2},
+		)
+	} else {
+		specs = append(specs, CanaryMetricSpec{Name: MetricLatency, Direction: DirectionIncreaseIsBad, Tolerance: 0.1})
+	}
+	for _, name := range canaryCfg.SuccessMetrics {
+		specs = append(specs, CanaryMetricSpec{Name: name, Direction: DirectionDecreaseIsBad, Tolerance: 0.05})
+	}
+	for _, override := range analysisCfg.Metrics {
+		replaced := false
+		for i := range specs {
+			if specs[i].Name == override.Name {
+				specs[i] = override
+				replaced = true
+			}
+		}
+		if !replaced {
+			specs = append(specs, override)
+		}
+	}
+	for i := range specs {
+		if specs[i].Direction == "" {
+			specs[i].Direction = DirectionIncreaseIsBad
+		}
+	}
+	return specs
+}
+func (de *DeploymentExecutor) analyzeCanary(ctx context.Context, config *DeploymentConfig, canaryCfg *CanaryConfig, weight int) (*CanaryAnalysis, error) {
+	analysisCfg := canaryCfg.Analysis.withDefaults(canaryCfg.StepDuration)
+	if analysisCfg.BaselineVersion == "" {
+		return nil, fmt.Errorf("canary analysis requires a baseline version")
+	}
+	specs := de.canaryMetricSpecs(canaryCfg, analysisCfg)
+	baseline := make(map[string][]float64)
+	canary := make(map[string][]float64)
+	for i := 0; i < analysisCfg.SampleCount; i++ {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(analysisCfg.SampleInterval):
+		}
+		for _, spec := range specs {
+			b, errB := de.sampleMetric(ctx, analysisCfg.BaselineVersion, spec.Name)
+			c, errC := de.sampleMetric(ctx, config.Version, spec.Name)
+			if errB != nil || errC != nil {
+				continue
+			}
+			baseline[spec.Name] = append(baseline[spec.Name], b)
+			canary[spec.Name] = append(canary[spec.Name], c)
+		}
+	}
+	analysis := &CanaryAnalysis{
+		Weight:          weight,
+		BaselineVersion: analysisCfg.BaselineVersion,
+		CanaryVersion:   config.Version,
+		AnalyzedAt:      time.Now(),
+	}
+	points := 0.0
+	regressed := false
+	for _, spec := range specs {
+		comparison := compareMetric(spec, baseline[spec.Name], canary[spec.Name], analysisCfg.Alpha)
+		switch comparison.Verdict {
+		case VerdictPass:
+			points += 1
+		case VerdictMarginal:
+			points += 0.5
+		case VerdictFail:
+			regressed = true
+		}
+		analysis.Metrics = append(analysis.Metrics, comparison)
+	}
+	if len(specs) > 0 {
+		analysis.Score = 100 * points / float64(len(specs))
+	}
+	switch {
+	case regressed:
+		analysis.Verdict = VerdictFail
+	case analysis.Score >= analysisCfg.PassScore:
+		analysis.Verdict = VerdictPass
+	case analysis.Score >= analysisCfg.MarginalScore:
+		analysis.Verdict = VerdictMarginal
+	default:
+		analysis.Verdict = VerdictFail
+	}
+	analysis.Judgement = writeJudgement(analysis)
+	return analysis, nil
+}
+func (de *DeploymentExecutor) sampleMetric(ctx context.Context, version, name string) (float64, error) {
+	if querier, ok := de.monitor.(MetricQuerier); ok {
+		return querier.GetMetric(ctx, version, name)
+	}
+	switch name {
+	case MetricErrorRate:
+		return de.monitor.GetErrorRate(ctx, version)
+	case MetricLatency:
+		latency, err := de.monitor.GetLatency(ctx, version)
+		if err != nil {
+			return 0, err
+		}
+		return latency.Seconds(), nil
+	default:
+		return 0, fmt.Errorf("monitor does not support metric %q", name)
+	}
+}
+func compareMetric(spec CanaryMetricSpec, baseline, canary []float64, alpha float64) MetricComparison {
+	comparison := MetricComparison{
+		Name:      spec.Name,
+		Direction: spec.Direction,
+		Samples:   len(canary),
+	}
+	if len(baseline) < 3 || len(canary) < 3 {
+		comparison.Verdict = VerdictMarginal
+		comparison.PValue = 1
+		comparison.Reason = fmt.Sprintf("insufficient data (%d samples)", len(canary))
+		return comparison
+	}
+	comparison.BaselineMedian = median(baseline)
+	comparison.CanaryMedian = median(canary)
+	comparison.RelativeChange = relativeChange(comparison.BaselineMedian, comparison.CanaryMedian)
+	var pValue float64
+	if spec.Direction == DirectionDecreaseIsBad {
+		comparison.U, pValue = mannWhitneyU(baseline, canary)
+	} else {
+		comparison.U, pValue = mannWhitneyU(canary, baseline)
+	}
+	comparison.PValue = pValue
+	degradation := comparison.RelativeChange
+	if spec.Direction == DirectionDecreaseIsBad {
+		degradation = -degradation
+	}
+	switch {
+	case pValue < alpha && degradation > spec.Tolerance:
+		comparison.Verdict = VerdictFail
+		comparison.Reason = fmt.Sprintf("significantly worse than baseline (%+.1f%%, p=%.3f)", comparison.RelativeChange*100, pValue)
+	case pValue < alpha:
+		comparison.Verdict = VerdictMarginal
+		comparison.Reason = fmt.Sprintf("significant but within tolerance (%+.1f%%, p=%.3f)", comparison.RelativeChange*100, pValue)
+	case pValue < 2*alpha:
+		comparison.Verdict = VerdictMarginal
+		comparison.Reason = fmt.Sprintf("trending worse than baseline (%+.1f%%, p=%.3f)", comparison.RelativeChange*100, pValue)
+	default:
+		comparison.Verdict = VerdictPass
+		comparison.Reason = fmt.Sprintf("no significant degradation (%+.1f%%, p=%.3f)", comparison.RelativeChange*100, pValue)
+	}
+	return comparison
+}
+func mannWhitneyU(x, y []float64) (float64, float64) {
+	type sample struct {
+		value float64
+		fromX bool
+	}
+	n1, n2 := float64(len(x)), float64(len(y))
+	all := make([]sample, 0, len(x)+len(y))
+	for _, v := range x {
+		all = append(all, sample{value: v, fromX: true})
+	}
+	for _, v := range y {
+		all = append(all, sample{value: v})
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].value < all[j].value
+	})
+	n := float64(len(all))
+	rankSumX := 0.0
+	tieCorrection := 0.0
+	for i := 0; i < len(all); {
+		j := i
+		for j < len(all) && all[j].value == all[i].value {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if all[k].fromX {
+				rankSumX += rank
+			}
+		}
+		t := float64(j - i)
+		tieCorrection += t*t*t - t
+		i = j
+	}
+	u := rankSumX - n1*(n1+1)/2
+	mean := n1 * n2 / 2
+	variance := n1 * n2 / 12 * ((n + 1) - tieCorrection/(n*(n-1)))
+	if variance <= 0 {
+		return u, 1
+	}
+	z := (u - mean - 0.5) / math.Sqrt(variance)
+	return u, 0.5 * math.Erfc(z/math.Sqrt2)
+}
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+func relativeChange(baseline, canary float64) float64 {
+	if baseline == canary {
+		return 0
+	}
+	denominator := math.Abs(baseline)
+	if denominator < 1e-9 {
+		denominator = 1e-9
+	}
+	return (canary - baseline) / denominator
+}
+func writeJudgement(analysis *CanaryAnalysis) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Canary %s at %d%% scored %.0f/100 against baseline %s: %s.",
+		analysis.CanaryVersion, analysis.Weight, analysis.Score, analysis.BaselineVersion, analysis.Verdict)
+	for _, metric := range analysis.Metrics {
+		if metric.Verdict == VerdictPass {
+			continue
+		}
+		fmt.Fprintf(&b, " %s is %s: %s.", metric.Name, metric.Verdict, metric.Reason)
+	}
+	if analysis.Verdict == VerdictPass {
+		b.WriteString(" All metrics are in line with the baseline.")
+	}
+	return b.String()
+}
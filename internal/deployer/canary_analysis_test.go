@@ -0,0 +1,196 @@
+package deployer
+import (
+	"context"
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+func TestMannWhitneyU(t *testing.T) {
+	tests := []struct {
+		name  string
+		x, y  []float64
+		wantU float64
+		minP  float64
+		maxP  float64
+	}{
+		{
+			name:  "x clearly larger",
+			x:     []float64{10, 11, 12, 13, 14},
+			y:     []float64{1, 2, 3, 4, 5},
+			wantU: 25,
+			maxP:  0.01,
+		},
+		{
+			name:  "x clearly smaller",
+			x:     []float64{1, 2, 3, 4, 5},
+			y:     []float64{10, 11, 12, 13, 14},
+			wantU: 0,
+			minP:  0.99,
+			maxP:  1,
+		},
+		{
+			name:  "all values tied",
+			x:     []float64{3, 3, 3},
+			y:     []float64{3, 3, 3},
+			wantU: 4.5,
+			minP:  1,
+			maxP:  1,
+		},
+		{
+			name:  "interleaved samples",
+			x:     []float64{1, 3, 5, 7},
+			y:     []float64{2, 4, 6, 8},
+			wantU: 6,
+			minP:  0.5,
+			maxP:  0.9,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, p := mannWhitneyU(tt.x, tt.y)
+			if u != tt.wantU {
+				t.Errorf("expected U=%v, got %v", tt.wantU, u)
+			}
+			if p < tt.minP || p > tt.maxP {
+				t.Errorf("expected p in [%v, %v], got %v", tt.minP, tt.maxP, p)
+			}
+		})
+	}
+}
+func TestCompareMetric(t *testing.T) {
+	stable := []float64{0.010, 0.011, 0.009, 0.010, 0.012, 0.010, 0.011, 0.009}
+	degraded := []float64{0.030, 0.032, 0.029, 0.031, 0.033, 0.030, 0.028, 0.031}
+	tests := []struct {
+		name     string
+		spec     CanaryMetricSpec
+		baseline []float64
+		canary   []float64
+		want     CanaryVerdict
+	}{
+		{
+			name:     "insufficient samples",
+			spec:     CanaryMetricSpec{Name: MetricErrorRate, Direction: DirectionIncreaseIsBad, Tolerance: 0.1},
+			baseline: stable[:2],
+			canary:   degraded[:2],
+			want:     VerdictMarginal,
+		},
+		{
+			name:     "unchanged error rate",
+			spec:     CanaryMetricSpec{Name: MetricErrorRate, Direction: DirectionIncreaseIsBad, Tolerance: 0.1},
+			baseline: stable,
+			canary:   stable,
+			want:     VerdictPass,
+		},
+		{
+			name:     "error rate tripled",
+			spec:     CanaryMetricSpec{Name: MetricErrorRate, Direction: DirectionIncreaseIsBad, Tolerance: 0.1},
+			baseline: stable,
+			canary:   degraded,
+			want:     VerdictFail,
+		},
+		{
+			name:     "increase within tolerance",
+			spec:     CanaryMetricSpec{Name: MetricErrorRate, Direction: DirectionIncreaseIsBad, Tolerance: 5},
+			baseline: stable,
+			canary:   degraded,
+			want:     VerdictMarginal,
+		},
+		{
+			name:     "success metric increased",
+			spec:     CanaryMetricSpec{Name: "checkout_rate", Direction: DirectionDecreaseIsBad, Tolerance: 0.05},
+			baseline: stable,
+			canary:   degraded,
+			want:     VerdictPass,
+		},
+		{
+			name:     "success metric dropped",
+			spec:     CanaryMetricSpec{Name: "checkout_rate", Direction: DirectionDecreaseIsBad, Tolerance: 0.05},
+			baseline: degraded,
+			canary:   stable,
+			want:     VerdictFail,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := compareMetric(tt.spec, tt.baseline, tt.canary, 0.05)
+			if got.Verdict != tt.want {
+				t.Fatalf("expected %s, got %s (%s)", tt.want, got.Verdict, got.Reason)
+			}
+		})
+	}
+}
+func TestMedianAndRelativeChange(t *testing.T) {
+	if got := median([]float64{5, 1, 3}); got != 3 {
+		t.Errorf("odd median: expected 3, got %v", got)
+	}
+	if got := median([]float64{4, 1, 3, 2}); got != 2.5 {
+		t.Errorf("even median: expected 2.5, got %v", got)
+	}
+	if got := relativeChange(2, 3); math.Abs(got-0.5) > 1e-9 {
+		t.Errorf("expected +50%%, got %v", got)
+	}
+	if got := relativeChange(0, 0); got != 0 {
+		t.Errorf("expected no change, got %v", got)
+	}
+}
+func TestValidateCanary(t *testing.T) {
+	if err := validateCanary(&CanaryConfig{SuccessMetrics: []string{"checkout_rate"}}); err == nil {
+		t.Fatal("expected success metrics without analysis to be rejected")
+	}
+	if err := validateCanary(&CanaryConfig{SuccessMetrics: []string{"checkout_rate"}, Analysis: &CanaryAnalysisConfig{BaselineVersion: "v1"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+type fakeMetrics struct {
+	mu      sync.Mutex
+	samples int
+	values  map[string]map[string]float64
+}
+func (f *fakeMetrics) GetMetrics(ctx context.Context, version string) (*DeploymentMetrics, error) {
+	return &DeploymentMetrics{}, nil
+}
+func (f *fakeMetrics) GetErrorRate(ctx context.Context, version string) (float64, error) {
+	return f.GetMetric(ctx, version, MetricErrorRate)
+}
+func (f *fakeMetrics) GetLatency(ctx context.Context, version string) (time.Duration, error) {
+	return 0, nil
+}
+func (f *fakeMetrics) GetMetric(ctx context.Context, version, name string) (float64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.samples++
+	return f.values[version][name] * (1 + float64(f.samples%7)/100), nil
+}
+func TestAnalyzeCanaryFailsOnSingleRegression(t *testing.T) {
+	latencies := map[string]float64{MetricLatencyP50: 0.1, MetricLatencyP95: 0.3, MetricLatencyP99: 0.5}
+	tests := []struct {
+		name          string
+		canaryErrRate float64
+		want          CanaryVerdict
+	}{
+		{name: "error rate five times the baseline", canaryErrRate: 0.05, want: VerdictFail},
+		{name: "error rate in line with the baseline", canaryErrRate: 0.01, want: VerdictPass},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			monitor := &fakeMetrics{values: map[string]map[string]float64{
+				"v1": {MetricErrorRate: 0.01},
+				"v2": {MetricErrorRate: tt.canaryErrRate},
+			}}
+			for name, value := range latencies {
+				monitor.values["v1"][name] = value
+				monitor.values["v2"][name] = value
+			}
+			de := &DeploymentExecutor{monitor: monitor}
+			canaryCfg := &CanaryConfig{Analysis: &CanaryAnalysisConfig{BaselineVersion: "v1", SampleCount: 12, SampleInterval: time.Millisecond}}
+			analysis, err := de.analyzeCanary(context.Background(), &DeploymentConfig{Version: "v2"}, canaryCfg, 10)
+			if err != nil {
+				t.Fatalf("analyzeCanary: %v", err)
+			}
+			if analysis.Verdict != tt.want {
+				t.Fatalf("expected %s, got %s (score %.0f): %s", tt.want, analysis.Verdict, analysis.Score, analysis.Judgement)
+			}
+		})
+	}
+}
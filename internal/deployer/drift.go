@@ -0,0 +1,251 @@
+package deployer
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+type DriftKind string
+const (
+	DriftChanged    DriftKind = "changed"
+	DriftMissing    DriftKind = "missing"
+	DriftUnexpected DriftKind = "unexpected"
+)
+type LiveEnvironment struct {
+	Variables  map[string]string  `json:"variables"`
+	Resources  ResourceAllocation `json:"resources"`
+	Domains    []string           `json:"domains"`
+	ObservedAt time.Time          `json:"observed_at"`
+}
+type LiveStateReader interface {
+	GetLiveEnvironment(ctx context.Context, env *Environment) (*LiveEnvironment, error)
+}
+type LiveStateApplier interface {
+	ApplyEnvironment(ctx context.Context, env *Environment) error
+}
+type AlertRaiser interface {
+	RaiseAlert(ctx context.Context, projectID, environmentID, alertType, severity, title, message string, metadata map[string]interface{}) error
+}
+type DriftField struct {
+	Field   string    `json:"field"`
+	Kind    DriftKind `json:"kind"`
+	Desired string    `json:"desired,omitempty"`
+	Live    string    `json:"live,omitempty"`
+}
+type DriftReport struct {
+	EnvironmentID  string          `json:"environment_id"`
+	ProjectID      string          `json:"project_id"`
+	Environment    string          `json:"environment"`
+	Type           EnvironmentType `json:"type"`
+	Drifted        bool            `json:"drifted"`
+	Fields         []DriftField    `json:"fields,omitempty"`
+	CheckedAt      time.Time       `json:"checked_at"`
+	Reconciled     bool            `json:"reconciled"`
+	ReconcileError string          `json:"reconcile_error,omitempty"`
+	AlertRaised    bool            `json:"alert_raised"`
+}
+type DriftDetector struct {
+	envs          *EnvironmentManager
+	live          LiveStateReader
+	alerts        AlertRaiser
+	autoReconcile bool
+}
+func NewDriftDetector(envs *EnvironmentManager, live LiveStateReader, alerts AlertRaiser, autoReconcile bool) *DriftDetector {
+	return &DriftDetector{
+		envs:          envs,
+		live:          live,
+		alerts:        alerts,
+		autoReconcile: autoReconcile,
+	}
+}
+func (dd *DriftDetector) CheckEnvironment(ctx context.Context, projectID, name string, reconcile bool) (*DriftReport, error) {
+	env, err := dd.envs.findEnvironment(projectID, name)
+	if err != nil {
+		return nil, err
+	}
+	return dd.check(ctx, env, reconcile)
+}
+func (dd *DriftDetector) CheckAll(ctx context.Context) ([]*DriftReport, error) {
+	envs, err := dd.envs.allEnvironments()
+	if err != nil {
+		return nil, err
+	}
+	var reports []*DriftReport
+	var errs []error
+	for _, env := range envs {
+		report, err := dd.check(ctx, env, dd.autoReconcile)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s/%s: %w", env.ProjectID, env.Name, err))
+			continue
+		}
+		reports = append(reports, report)
+	}
+	return reports, errors.Join(errs...)
+}
+func (dd *DriftDetector) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = 10 * time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := dd.CheckAll(ctx); err != nil {
+				fmt.Printf("⚠️  Drift detection: %v\n", err)
+			}
+		}
+	}
+}
+func (dd *DriftDetector) check(ctx context.Context, env *Environment, reconcile bool) (*DriftReport, error) {
+	live, err := dd.live.GetLiveEnvironment(ctx, env)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read live state: %w", err)
+	}
+	report := &DriftReport{
+		EnvironmentID: env.ID,
+		ProjectID:     env.ProjectID,
+		Environment:   env.Name,
+		Type:          env.Type,
+		Fields:        diffLiveEnvironment(env, live),
+		CheckedAt:     time.Now(),
+	}
+	report.Drifted = len(report.Fields) > 0
+	if !report.Drifted {
+		return report, nil
+	}
+	fmt.Printf("🧭 Drift detected in %s/%s: %d fields differ\n", env.ProjectID, env.Name, len(report.Fields))
+	if reconcile {
+		report.Reconciled, report.ReconcileError = dd.reconcile(ctx, env)
+	}
+	if env.Type == EnvironmentProduction && dd.alerts != nil {
+		if err := dd.raiseAlert(ctx, report); err != nil {
+			fmt.Printf("⚠️  Failed to raise drift alert for %s/%s: %v\n", env.ProjectID, env.Name, err)
+		} else {
+			report.AlertRaised = true
+		}
+	}
+	return report, nil
+}
+func (dd *DriftDetector) reconcile(ctx context.Context, env *Environment) (bool, string) {
+	applier, ok := dd.live.(LiveStateApplier)
+	if !ok {
+		return false, "deployment backend does not support reconciliation"
+	}
+	if env.Locked {
+		return false, fmt.Sprintf("environment is locked by %s", env.LockedBy)
+	}
+	desired, err := dd.envs.getEnvironment(env.ID)
+	if err != nil {
+		return false, err.Error()
+	}
+	if err := applier.ApplyEnvironment(ctx, desired); err != nil {
+		return false, err.Error()
+	}
+	fmt.Printf("🔧 Reconciled %s/%s to its stored configuration\n", env.ProjectID, env.Name)
+	return true, ""
+}
+func (dd *DriftDetector) raiseAlert(ctx context.Context, report *DriftReport) error {
+	fields := make([]string, 0, len(report.Fields))
+	for _, f := range report.Fields {
+		fields = append(fields, f.Field)
+	}
+	message := fmt.Sprintf("Live state of %s differs from its stored configuration in %s", report.Environment, strings.Join(fields, ", "))
+	if report.Reconciled {
+		message += " (reconciled automatically)"
+	}
+	return dd.alerts.RaiseAlert(ctx, report.ProjectID, report.EnvironmentID, "environment_drift", "critical",
+		fmt.Sprintf("Configuration drift in %s", report.Environment), message,
+		map[string]interface{}{
+			"fields":     report.Fields,
+			"reconciled": report.Reconciled,
+			"checked_at": report.CheckedAt,
+		})
+}
+func diffLiveEnvironment(env *Environment, live *LiveEnvironment) []DriftField {
+	var fields []DriftField
+	for _, key := range mapKeys(env.Variables, live.Variables) {
+		desired, inDesired := env.Variables[key]
+		actual, inLive := live.Variables[key]
+		field := DriftField{Field: "variables." + key, Desired: desired, Live: actual}
+		switch {
+		case !inLive:
+			field.Kind = DriftMissing
+		case !inDesired:
+			field.Kind = DriftUnexpected
+		case desired != actual:
+			field.Kind = DriftChanged
+		default:
+			continue
+		}
+		fields = append(fields, field)
+	}
+	resource := func(name, desired, actual string) {
+		if desired != actual {
+			fields = append(fields, DriftField{Field: "resources." + name, Kind: DriftChanged, Desired: desired, Live: actual})
+		}
+	}
+	d, l := env.Resources, live.Resources
+	resource("min_cpu", d.MinCPU, l.MinCPU)
+	resource("max_cpu", d.MaxCPU, l.MaxCPU)
+	resource("min_memory", d.MinMemory, l.MinMemory)
+	resource("max_memory", d.MaxMemory, l.MaxMemory)
+	resource("min_replicas", fmt.Sprint(d.MinReplicas), fmt.Sprint(l.MinReplicas))
+	resource("max_replicas", fmt.Sprint(d.MaxReplicas), fmt.Sprint(l.MaxReplicas))
+	resource("storage_size", d.StorageSize, l.StorageSize)
+	resource("auto_scale", fmt.Sprint(d.AutoScale), fmt.Sprint(l.AutoScale))
+	desiredDomains := make(map[string]bool, len(env.Domains))
+	for _, domain := range env.Domains {
+		desiredDomains[domain] = true
+	}
+	liveDomains := make(map[string]bool, len(live.Domains))
+	for _, domain := range live.Domains {
+		liveDomains[domain] = true
+	}
+	var domains []string
+	for domain := range desiredDomains {
+		domains = append(domains, domain)
+	}
+	for domain := range liveDomains {
+		if !desiredDomains[domain] {
+			domains = append(domains, domain)
+		}
+	}
+	sort.Strings(domains)
+	for _, domain := range domains {
+		switch {
+		case !liveDomains[domain]:
+			fields = append(fields, DriftField{Field: "domains", Kind: DriftMissing, Desired: domain})
+		case !desiredDomains[domain]:
+			fields = append(fields, DriftField{Field: "domains", Kind: DriftUnexpected, Live: domain})
+		}
+	}
+	return fields
+}
+func (em *EnvironmentManager) allEnvironments() ([]*Environment, error) {
+	files, err := os.ReadDir(em.storagePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var envs []*Environment
+	for _, file := range files {
+		if filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+		env, err := em.loadEnvironment(strings.TrimSuffix(file.Name(), ".json"))
+		if err != nil {
+			continue
+		}
+		envs = append(envs, env)
+	}
+	return envs, nil
+}
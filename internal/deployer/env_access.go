@@ -0,0 +1,175 @@
+package deployer
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+type EnvironmentAction string
+const (
+	EnvActionView    EnvironmentAction = "view"
+	EnvActionSecrets EnvironmentAction = "secrets"
+	EnvActionDeploy  EnvironmentAction = "deploy"
+	EnvActionLock    EnvironmentAction = "lock"
+	EnvActionPromote EnvironmentAction = "promote"
+)
+var ErrAccessDenied = errors.New("environment access denied")
+var environmentActions = map[EnvironmentAction]bool{
+	EnvActionView:    true,
+	EnvActionSecrets: true,
+	EnvActionDeploy:  true,
+	EnvActionLock:    true,
+	EnvActionPromote: true,
+}
+var DefaultAccessRoles = map[EnvironmentType]map[string][]string{
+	EnvironmentProduction: {
+		string(EnvActionSecrets): {"role:owner", "role:admin"},
+		string(EnvActionPromote): {"role:owner", "role:admin"},
+	},
+}
+type Principal struct {
+	UserID         string                        `json:"user_id"`
+	Email          string                        `json:"email,omitempty"`
+	Role           string                        `json:"role,omitempty"`
+	OrganizationID string                        `json:"organization_id,omitempty"`
+	ProjectRole    func(projectID string) string `json:"-"`
+	system         bool
+}
+type principalContextKey struct{}
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey{}, principal)
+}
+func WithSystemPrincipal(ctx context.Context) context.Context {
+	return WithPrincipal(ctx, &Principal{UserID: "system", system: true})
+}
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalContextKey{}).(*Principal)
+	return principal, ok && principal != nil
+}
+func (p *Principal) String() string {
+	if p.Email != "" {
+		return p.Email
+	}
+	return p.UserID
+}
+func (p *Principal) roleIn(projectID string) string {
+	if p.ProjectRole == nil {
+		return ""
+	}
+	return p.ProjectRole(projectID)
+}
+func (p *Principal) matches(member, role string) bool {
+	switch {
+	case member == "*":
+		return true
+	case strings.HasPrefix(member, "role:"):
+		return role != "" && strings.TrimPrefix(member, "role:") == role
+	case strings.HasPrefix(member, "user:"):
+		return p.UserID != "" && strings.TrimPrefix(member, "user:") == p.UserID
+	case strings.Contains(member, "@"):
+		return p.Email != "" && strings.EqualFold(member, p.Email)
+	default:
+		return p.UserID != "" && member == p.UserID
+	}
+}
+type AccessDeniedError struct {
+	EnvironmentID string
+	ProjectID     string
+	Environment   string
+	Action        EnvironmentAction
+	Principal     *Principal
+}
+func (e *AccessDeniedError) Error() string {
+	return fmt.Sprintf("%s is not allowed to %s environment %s", e.Principal, e.Action, e.Environment)
+}
+func (e *AccessDeniedError) Unwrap() error {
+	return ErrAccessDenied
+}
+func validateAccessRoles(roles map[string][]string) error {
+	for action := range roles {
+		if !environmentActions[EnvironmentAction(action)] {
+			return fmt.Errorf("unknown environment action %q in access roles", action)
+		}
+	}
+	return nil
+}
+func (env *Environment) accessRule(action EnvironmentAction) ([]string, bool) {
+	if members, ok := env.AccessRoles[string(action)]; ok {
+		return members, true
+	}
+	members, ok := DefaultAccessRoles[env.Type][string(action)]
+	return members, ok
+}
+func (env *Environment) Allows(principal *Principal, action EnvironmentAction) bool {
+	members, restricted := env.accessRule(action)
+	if !restricted || principal.system {
+		return true
+	}
+	role := principal.roleIn(env.ProjectID)
+	for _, member := range members {
+		if principal.matches(member, role) {
+			return true
+		}
+	}
+	return false
+}
+func (em *EnvironmentManager) authorize(ctx context.Context, env *Environment, actions ...EnvironmentAction) error {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return fmt.Errorf("%w: no principal in context for environment %s", ErrAccessDenied, env.Name)
+	}
+	for _, action := range actions {
+		if !env.Allows(principal, action) {
+			return &AccessDeniedError{
+				EnvironmentID: env.ID,
+				ProjectID:     env.ProjectID,
+				Environment:   env.Name,
+				Action:        action,
+				Principal:     principal,
+			}
+		}
+	}
+	return nil
+}
+func (em *EnvironmentManager) authorizeEnvironment(ctx context.Context, envID string, actions ...EnvironmentAction) error {
+	env, err := em.loadEnvironment(envID)
+	if err != nil {
+		return err
+	}
+	return em.authorize(ctx, env, actions...)
+}
+func (em *EnvironmentManager) Authorize(ctx context.Context, projectID, name string, action EnvironmentAction) error {
+	env, err := em.findEnvironment(projectID, name)
+	if err != nil {
+		return err
+	}
+	return em.authorize(ctx, env, action)
+}
+func (em *EnvironmentManager) AuthorizeProduction(ctx context.Context, projectID string, action EnvironmentAction) error {
+	envs, err := em.allEnvironments()
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, env := range envs {
+		if env.ProjectID != projectID || env.Type != EnvironmentProduction {
+			continue
+		}
+		found = true
+		if err := em.authorize(ctx, env, action); err != nil {
+			return err
+		}
+	}
+	if !found {
+		return fmt.Errorf("%w: %s has no production environment", ErrEnvironmentNotFound, projectID)
+	}
+	return nil
+}
+func (em *EnvironmentManager) maskSecrets(ctx context.Context, env *Environment) {
+	if principal, ok := PrincipalFromContext(ctx); ok && env.Allows(principal, EnvActionSecrets) {
+		return
+	}
+	for key := range env.Secrets {
+		env.Secrets[key] = MaskedSecretValue
+	}
+}
@@ -0,0 +1,29 @@
+package deployer
+import "testing"
+func projectMember(userID string, roles map[string]string) *Principal {
+	return &Principal{UserID: userID, ProjectRole: func(projectID string) string { return roles[projectID] }}
+}
+func TestEnvironmentAllows(t *testing.T) {
+	production := &Environment{ProjectID: "proj-b", Name: "production", Type: EnvironmentProduction}
+	locked := &Environment{ProjectID: "proj-b", Name: "production", Type: EnvironmentProduction, AccessRoles: map[string][]string{"secrets": {"role:admin"}}}
+	tests := []struct {
+		name      string
+		env       *Environment
+		principal *Principal
+		want      bool
+	}{
+		{name: "owner of the environment's org", env: production, principal: projectMember("u1", map[string]string{"proj-b": "owner"}), want: true},
+		{name: "owner of another org", env: production, principal: projectMember("u1", map[string]string{"proj-a": "owner"}), want: false},
+		{name: "caller role without project membership", env: production, principal: &Principal{UserID: "u1", Role: "owner"}, want: false},
+		{name: "owner outside explicit access roles", env: locked, principal: projectMember("u1", map[string]string{"proj-b": "owner"}), want: false},
+		{name: "admin listed in access roles", env: locked, principal: projectMember("u2", map[string]string{"proj-b": "admin"}), want: true},
+		{name: "system principal", env: locked, principal: &Principal{UserID: "system", system: true}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.env.Allows(tt.principal, EnvActionSecrets); got != tt.want {
+				t.Fatalf("expected Allows to return %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
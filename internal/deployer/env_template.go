@@ -0,0 +1,378 @@
+package deployer
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+var ErrTemplateNotFound = errors.New("environment template not found")
+type EnvironmentTemplate struct {
+	ID              string              `json:"id"`
+	OrganizationID  string              `json:"organization_id"`
+	Name            string              `json:"name"`
+	Description     string              `json:"description,omitempty"`
+	Type            EnvironmentType     `json:"type,omitempty"`
+	Variables       map[string]string   `json:"variables,omitempty"`
+	Resources       *ResourceAllocation `json:"resources,omitempty"`
+	RequiredSecrets []string            `json:"required_secrets,omitempty"`
+	AccessRoles     map[string][]string `json:"access_roles,omitempty"`
+	DomainPatterns  []string            `json:"domain_patterns,omitempty"`
+	Version         int                 `json:"version"`
+	CreatedAt       time.Time           `json:"created_at"`
+	UpdatedAt       time.Time           `json:"updated_at"`
+}
+type PropagateOptions struct {
+	EnvironmentIDs []string `json:"environment_ids,omitempty"`
+	DryRun         bool     `json:"dry_run"`
+	KeepOverrides  bool     `json:"keep_overrides"`
+}
+type TemplatePropagation struct {
+	EnvironmentID  string         `json:"environment_id"`
+	ProjectID      string         `json:"project_id"`
+	Environment    string         `json:"environment"`
+	FromVersion    int            `json:"from_version"`
+	ToVersion      int            `json:"to_version"`
+	Changes        []ConfigChange `json:"changes"`
+	MissingSecrets []string       `json:"missing_secrets,omitempty"`
+	Applied        bool           `json:"applied"`
+	Error          string         `json:"error,omitempty"`
+}
+type MissingSecretsError struct {
+	TemplateID string
+	Missing    []string
+}
+func (e *MissingSecretsError) Error() string {
+	return fmt.Sprintf("template %s requires secrets: %s", e.TemplateID, strings.Join(e.Missing, ", "))
+}
+func (t *EnvironmentTemplate) validate() error {
+	if t.OrganizationID == "" {
+		return errors.New("template requires an organization")
+	}
+	if t.Name == "" {
+		return errors.New("template requires a name")
+	}
+	for key := range t.Variables {
+		if !envKeyPattern.MatchString(key) {
+			return fmt.Errorf("invalid variable name %q", key)
+		}
+	}
+	for _, pattern := range t.DomainPatterns {
+		if strings.TrimSpace(pattern) == "" || strings.ContainsAny(pattern, " /") {
+			return fmt.Errorf("invalid domain pattern %q", pattern)
+		}
+	}
+	if err := validateAccessRoles(t.AccessRoles); err != nil {
+		return err
+	}
+	if t.Resources != nil && t.Resources.MinReplicas > t.Resources.MaxReplicas {
+		return errors.New("template min_replicas must not exceed max_replicas")
+	}
+	return nil
+}
+func (t *EnvironmentTemplate) domains(env *Environment) []string {
+	replacer := strings.NewReplacer("{name}", env.Name, "{project}", env.ProjectID, "{type}", string(env.Type))
+	domains := make([]string, 0, len(t.DomainPatterns))
+	for _, pattern := range t.DomainPatterns {
+		domains = append(domains, strings.ToLower(replacer.Replace(pattern)))
+	}
+	return domains
+}
+func (t *EnvironmentTemplate) missingSecrets(env *Environment) []string {
+	var missing []string
+	for _, key := range t.RequiredSecrets {
+		if _, ok := env.Secrets[key]; !ok {
+			missing = append(missing, key)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+func (t *EnvironmentTemplate) instantiate(env *Environment) {
+	if env.Type == "" {
+		env.Type = t.Type
+	}
+	if env.Variables == nil {
+		env.Variables = make(map[string]string)
+	}
+	for key, value := range t.Variables {
+		if _, set := env.Variables[key]; !set {
+			env.Variables[key] = value
+		}
+	}
+	if env.Resources.MinCPU == "" && t.Resources != nil {
+		env.Resources = *t.Resources
+	}
+	if env.AccessRoles == nil {
+		env.AccessRoles = make(map[string][]string)
+	}
+	for role, members := range t.AccessRoles {
+		env.AccessRoles[role] = mergeMembers(env.AccessRoles[role], members)
+	}
+	if len(env.Domains) == 0 {
+		env.Domains = t.domains(env)
+	}
+	env.TemplateID = t.ID
+	env.TemplateVersion = t.Version
+}
+func (t *EnvironmentTemplate) propagate(env *Environment, keepOverrides bool) []ConfigChange {
+	var changes []ConfigChange
+	if env.Variables == nil {
+		env.Variables = make(map[string]string)
+	}
+	for _, key := range mapKeys(t.Variables) {
+		current, set := env.Variables[key]
+		if set && (current == t.Variables[key] || keepOverrides) {
+			continue
+		}
+		changes = append(changes, ConfigChange{Field: "variables." + key, From: current, To: t.Variables[key]})
+		env.Variables[key] = t.Variables[key]
+	}
+	if t.Resources != nil && env.Resources != *t.Resources && !(keepOverrides && env.Resources != (ResourceAllocation{})) {
+		from, _ := json.Marshal(env.Resources)
+		to, _ := json.Marshal(t.Resources)
+		changes = append(changes, ConfigChange{Field: "resources", From: string(from), To: string(to)})
+		env.Resources = *t.Resources
+	}
+	if env.AccessRoles == nil {
+		env.AccessRoles = make(map[string][]string)
+	}
+	roles := make([]string, 0, len(t.AccessRoles))
+	for role := range t.AccessRoles {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	for _, role := range roles {
+		current, members := env.AccessRoles[role], t.AccessRoles[role]
+		if !sameMembers(current, members) {
+			changes = append(changes, ConfigChange{Field: "access_roles." + role, From: strings.Join(current, ","), To: strings.Join(members, ",")})
+			env.AccessRoles[role] = append([]string{}, members...)
+		}
+	}
+	existing := make(map[string]bool, len(env.Domains))
+	for _, domain := range env.Domains {
+		existing[domain] = true
+	}
+	for _, domain := range t.domains(env) {
+		if !existing[domain] {
+			changes = append(changes, ConfigChange{Field: "domains", To: domain})
+			env.Domains = append(env.Domains, domain)
+			existing[domain] = true
+		}
+	}
+	if env.TemplateVersion != t.Version {
+		changes = append(changes, ConfigChange{Field: "template_version", From: fmt.Sprint(env.TemplateVersion), To: fmt.Sprint(t.Version)})
+		env.TemplateVersion = t.Version
+	}
+	return changes
+}
+func mergeMembers(current, add []string) []string {
+	merged := append([]string(nil), current...)
+	seen := make(map[string]bool, len(current))
+	for _, member := range current {
+		seen[member] = true
+	}
+	for _, member := range add {
+		if !seen[member] {
+			seen[member] = true
+			merged = append(merged, member)
+		}
+	}
+	return merged
+}
+func (em *EnvironmentManager) templatePath(templateID string) string {
+	return filepath.Join(em.storagePath, "templates", templateID+".json")
+}
+func (em *EnvironmentManager) SaveTemplate(ctx context.Context, template *EnvironmentTemplate) error {
+	if err := template.validate(); err != nil {
+		return err
+	}
+	sort.Strings(template.RequiredSecrets)
+	now := time.Now()
+	if template.ID == "" {
+		template.ID = fmt.Sprintf("tpl_%d", now.UnixNano())
+		template.CreatedAt = now
+		template.Version = 0
+	} else if existing, err := em.GetTemplate(ctx, template.ID); err == nil {
+		template.CreatedAt = existing.CreatedAt
+		template.Version = existing.Version
+	}
+	template.Version++
+	template.UpdatedAt = now
+	path := em.templatePath(template.ID)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(template, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0644)
+}
+func (em *EnvironmentManager) GetTemplate(ctx context.Context, templateID string) (*EnvironmentTemplate, error) {
+	data, err := os.ReadFile(em.templatePath(templateID))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, templateID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var template EnvironmentTemplate
+	if err := json.Unmarshal(data, &template); err != nil {
+		return nil, err
+	}
+	return &template, nil
+}
+func (em *EnvironmentManager) ListTemplates(ctx context.Context, orgID string) ([]*EnvironmentTemplate, error) {
+	files, err := os.ReadDir(filepath.Join(em.storagePath, "templates"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var templates []*EnvironmentTemplate
+	for _, file := range files {
+		if filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+		template, err := em.GetTemplate(ctx, strings.TrimSuffix(file.Name(), ".json"))
+		if err != nil {
+			continue
+		}
+		if template.OrganizationID == orgID {
+			templates = append(templates, template)
+		}
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Name < templates[j].Name })
+	return templates, nil
+}
+func (em *EnvironmentManager) DeleteTemplate(ctx context.Context, templateID string) error {
+	derived, err := em.derivedEnvironments(templateID)
+	if err != nil {
+		return err
+	}
+	if len(derived) > 0 {
+		return fmt.Errorf("template %s is used by %d environments", templateID, len(derived))
+	}
+	err = os.Remove(em.templatePath(templateID))
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: %s", ErrTemplateNotFound, templateID)
+	}
+	return err
+}
+func (em *EnvironmentManager) applyTemplate(ctx context.Context, env *Environment, enforceSecrets bool) error {
+	if env.TemplateID == "" {
+		return nil
+	}
+	template, err := em.GetTemplate(ctx, env.TemplateID)
+	if err != nil {
+		return err
+	}
+	template.instantiate(env)
+	if missing := template.missingSecrets(env); enforceSecrets && len(missing) > 0 {
+		return &MissingSecretsError{TemplateID: template.ID, Missing: missing}
+	}
+	return nil
+}
+func sameMembers(current, members []string) bool {
+	if len(current) != len(members) {
+		return false
+	}
+	seen := make(map[string]bool, len(current))
+	for _, member := range current {
+		seen[member] = true
+	}
+	for _, member := range members {
+		if !seen[member] {
+			return false
+		}
+	}
+	return true
+}
+func previewEnvironment(env *Environment) *Environment {
+	preview := *env
+	preview.Variables = make(map[string]string, len(env.Variables))
+	for key, value := range env.Variables {
+		preview.Variables[key] = value
+	}
+	preview.AccessRoles = make(map[string][]string, len(env.AccessRoles))
+	for role, members := range env.AccessRoles {
+		preview.AccessRoles[role] = append([]string(nil), members...)
+	}
+	preview.Domains = append([]string(nil), env.Domains...)
+	return &preview
+}
+func (em *EnvironmentManager) derivedEnvironments(templateID string) ([]*Environment, error) {
+	envs, err := em.allEnvironments()
+	if err != nil {
+		return nil, err
+	}
+	var derived []*Environment
+	for _, env := range envs {
+		if env.TemplateID == templateID {
+			derived = append(derived, env)
+		}
+	}
+	sort.Slice(derived, func(i, j int) bool { return derived[i].ID < derived[j].ID })
+	return derived, nil
+}
+func (em *EnvironmentManager) PropagateTemplate(ctx context.Context, templateID, author string, opts PropagateOptions) ([]*TemplatePropagation, error) {
+	template, err := em.GetTemplate(ctx, templateID)
+	if err != nil {
+		return nil, err
+	}
+	derived, err := em.derivedEnvironments(templateID)
+	if err != nil {
+		return nil, err
+	}
+	selected := make(map[string]bool, len(opts.EnvironmentIDs))
+	for _, id := range opts.EnvironmentIDs {
+		selected[id] = true
+	}
+	var results []*TemplatePropagation
+	for _, env := range derived {
+		if len(selected) > 0 && !selected[env.ID] {
+			continue
+		}
+		result := &TemplatePropagation{
+			EnvironmentID: env.ID,
+			ProjectID:     env.ProjectID,
+			Environment:   env.Name,
+			FromVersion:   env.TemplateVersion,
+			ToVersion:     template.Version,
+		}
+		results = append(results, result)
+		if err := em.authorize(ctx, env, EnvActionDeploy); err != nil {
+			result.Error = err.Error()
+			continue
+		}
+		if opts.DryRun {
+			result.Changes = template.propagate(env, opts.KeepOverrides)
+			result.MissingSecrets = template.missingSecrets(env)
+			continue
+		}
+		if len(template.propagate(previewEnvironment(env), opts.KeepOverrides)) == 0 {
+			result.MissingSecrets = template.missingSecrets(env)
+			continue
+		}
+		err := em.modifyEnvironment(ctx, env.ID, author, func(env *Environment) (map[string]int, error) {
+			if env.Locked {
+				return nil, fmt.Errorf("environment is locked by %s", env.LockedBy)
+			}
+			result.Changes = template.propagate(env, opts.KeepOverrides)
+			result.MissingSecrets = template.missingSecrets(env)
+			return nil, nil
+		})
+		if err != nil {
+			result.Error = err.Error()
+			continue
+		}
+		result.Applied = true
+	}
+	return results, nil
+}
@@ -0,0 +1,86 @@
+package deployer
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+func TestPropagateReplacesMembersAndKeepsResourceOverrides(t *testing.T) {
+	template := &EnvironmentTemplate{
+		Version:     2,
+		Resources:   &ResourceAllocation{MinCPU: "250m", MinReplicas: 2, MaxReplicas: 2},
+		AccessRoles: map[string][]string{"deploy": {"role:admin"}},
+	}
+	tests := []struct {
+		name          string
+		keepOverrides bool
+		wantCPU       string
+	}{
+		{name: "keep overrides", keepOverrides: true, wantCPU: "1"},
+		{name: "replace overrides", keepOverrides: false, wantCPU: "250m"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := &Environment{
+				TemplateVersion: 1,
+				Resources:       ResourceAllocation{MinCPU: "1", MinReplicas: 3, MaxReplicas: 3},
+				AccessRoles:     map[string][]string{"deploy": {"role:admin", "dev@example.com"}},
+			}
+			preview := previewEnvironment(env)
+			changes := template.propagate(preview, tt.keepOverrides)
+			if len(env.AccessRoles["deploy"]) != 2 {
+				t.Fatalf("preview mutated the environment's access roles: %v", env.AccessRoles["deploy"])
+			}
+			if got := preview.AccessRoles["deploy"]; len(got) != 1 || got[0] != "role:admin" {
+				t.Fatalf("expected deploy members to match the template, got %v", got)
+			}
+			if preview.Resources.MinCPU != tt.wantCPU {
+				t.Fatalf("expected cpu %s, got %s", tt.wantCPU, preview.Resources.MinCPU)
+			}
+			removed := false
+			for _, change := range changes {
+				if change.Field == "access_roles.deploy" && change.From == "role:admin,dev@example.com" && change.To == "role:admin" {
+					removed = true
+				}
+			}
+			if !removed {
+				t.Fatalf("expected the member removal in the diff, got %+v", changes)
+			}
+		})
+	}
+}
+func TestPropagateTemplateAuthorizesEachEnvironment(t *testing.T) {
+	em := NewEnvironmentManagerWithKeyring(newStaticKeyring("k1", bytes.Repeat([]byte("k"), 32)), t.TempDir())
+	ctx := context.Background()
+	template := &EnvironmentTemplate{
+		OrganizationID: "org",
+		Name:           "service",
+		Variables:      map[string]string{"LOG_LEVEL": "info"},
+		AccessRoles:    map[string][]string{"deploy": {"role:admin"}},
+	}
+	if err := em.SaveTemplate(ctx, template); err != nil {
+		t.Fatalf("SaveTemplate: %v", err)
+	}
+	env := &Environment{ProjectID: "proj", Name: "production", Type: EnvironmentProduction, TemplateID: template.ID}
+	if err := em.CreateEnvironment(ctx, env); err != nil {
+		t.Fatalf("CreateEnvironment: %v", err)
+	}
+	template.Variables["LOG_LEVEL"] = "debug"
+	if err := em.SaveTemplate(ctx, template); err != nil {
+		t.Fatalf("SaveTemplate: %v", err)
+	}
+	developer := WithPrincipal(ctx, projectMember("u1", map[string]string{"proj": "developer"}))
+	results, err := em.PropagateTemplate(developer, template.ID, "u1", PropagateOptions{})
+	if err != nil {
+		t.Fatalf("PropagateTemplate: %v", err)
+	}
+	if len(results) != 1 || results[0].Applied || results[0].Error == "" {
+		t.Fatalf("expected propagation to be denied, got %+v", results)
+	}
+	stored, err := em.loadEnvironment(env.ID)
+	if err != nil {
+		t.Fatalf("loadEnvironment: %v", err)
+	}
+	if stored.Variables["LOG_LEVEL"] != "info" {
+		t.Fatalf("denied propagation changed LOG_LEVEL to %s", stored.Variables["LOG_LEVEL"])
+	}
+}
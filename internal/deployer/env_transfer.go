@@ -0,0 +1,436 @@
+package deployer
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"gopkg.in/yaml.v3"
+)
+type EnvFormat string
+const (
+	FormatDotenv EnvFormat = "dotenv"
+	FormatJSON   EnvFormat = "json"
+	FormatYAML   EnvFormat = "yaml"
+)
+const MaskedSecretValue = "********"
+type ImportMode string
+const (
+	ImportMerge   ImportMode = "merge"
+	ImportReplace ImportMode = "replace"
+)
+type EntryKind string
+const (
+	EntryVariable EntryKind = "variable"
+	EntrySecret   EntryKind = "secret"
+)
+type ChangeAction string
+const (
+	ChangeAdd        ChangeAction = "add"
+	ChangeUpdate     ChangeAction = "update"
+	ChangeRemove     ChangeAction = "remove"
+	ChangeReclassify ChangeAction = "reclassify"
+)
+var envKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+var DefaultSecretRules = SecretRules{
+	Patterns: []string{"*SECRET*", "*PASSWORD*", "*PASSWD*", "*TOKEN*", "*_KEY", "*PRIVATE*", "*CREDENTIAL*", "DATABASE_URL", "REDIS_URL"},
+}
+type SecretRules struct {
+	Patterns []string `json:"patterns,omitempty"`
+	Secrets  []string `json:"secrets,omitempty"`
+	Plain    []string `json:"plain,omitempty"`
+}
+type EnvEntries struct {
+	Variables map[string]string `json:"variables" yaml:"variables"`
+	Secrets   map[string]string `json:"secrets" yaml:"secrets"`
+}
+type ImportOptions struct {
+	Format   EnvFormat    `json:"format"`
+	Content  string       `json:"content"`
+	Mode     ImportMode   `json:"mode"`
+	DryRun   bool         `json:"dry_run"`
+	Rules    *SecretRules `json:"rules,omitempty"`
+	Revision int64        `json:"revision,omitempty"`
+}
+type EnvChange struct {
+	Key    string       `json:"key"`
+	Kind   EntryKind    `json:"kind"`
+	Action ChangeAction `json:"action"`
+	From   string       `json:"from,omitempty"`
+	To     string       `json:"to,omitempty"`
+}
+type ImportResult struct {
+	EnvironmentID string      `json:"environment_id"`
+	DryRun        bool        `json:"dry_run"`
+	Changes       []EnvChange `json:"changes"`
+	Variables     int         `json:"variables"`
+	Secrets       int         `json:"secrets"`
+	Revision      int64       `json:"revision"`
+}
+func (rules *SecretRules) IsSecret(key string) bool {
+	upper := strings.ToUpper(key)
+	for _, k := range rules.Plain {
+		if strings.EqualFold(k, key) {
+			return false
+		}
+	}
+	for _, k := range rules.Secrets {
+		if strings.EqualFold(k, key) {
+			return true
+		}
+	}
+	for _, pattern := range rules.Patterns {
+		if ok, _ := path.Match(strings.ToUpper(pattern), upper); ok {
+			return true
+		}
+	}
+	return false
+}
+func (rules *SecretRules) listsPlain(key string) bool {
+	if rules == nil {
+		return false
+	}
+	for _, k := range rules.Plain {
+		if strings.EqualFold(k, key) {
+			return true
+		}
+	}
+	return false
+}
+func ParseEnvEntries(format EnvFormat, data []byte, rules *SecretRules) (*EnvEntries, error) {
+	if rules == nil {
+		rules = &DefaultSecretRules
+	}
+	var flat map[string]string
+	switch format {
+	case FormatDotenv, "":
+		parsed, err := parseDotenv(data)
+		if err != nil {
+			return nil, err
+		}
+		flat = parsed
+	case FormatJSON, FormatYAML:
+		var raw map[string]interface{}
+		if format == FormatJSON {
+			decoder := json.NewDecoder(bytes.NewReader(data))
+			decoder.UseNumber()
+			if err := decoder.Decode(&raw); err != nil {
+				return nil, fmt.Errorf("invalid JSON: %w", err)
+			}
+		} else if err := yaml.Unmarshal(data, &raw); err != nil {
+			return nil, fmt.Errorf("invalid YAML: %w", err)
+		}
+		if entries, ok, err := structuredEntries(raw); ok || err != nil {
+			return entries, err
+		}
+		values, err := scalarMap(raw)
+		if err != nil {
+			return nil, err
+		}
+		flat = values
+	default:
+		return nil, fmt.Errorf("unsupported format %q", format)
+	}
+	entries := &EnvEntries{Variables: make(map[string]string), Secrets: make(map[string]string)}
+	for key, value := range flat {
+		if value == MaskedSecretValue || rules.IsSecret(key) {
+			entries.Secrets[key] = value
+		} else {
+			entries.Variables[key] = value
+		}
+	}
+	return entries, nil
+}
+func structuredEntries(raw map[string]interface{}) (*EnvEntries, bool, error) {
+	if len(raw) == 0 || len(raw) > 2 {
+		return nil, false, nil
+	}
+	sections := make(map[string]map[string]interface{})
+	for key, value := range raw {
+		if key != "variables" && key != "secrets" {
+			return nil, false, nil
+		}
+		section, ok := value.(map[string]interface{})
+		if !ok && value != nil {
+			return nil, false, nil
+		}
+		sections[key] = section
+	}
+	variables, err := scalarMap(sections["variables"])
+	if err != nil {
+		return nil, true, err
+	}
+	secrets, err := scalarMap(sections["secrets"])
+	if err != nil {
+		return nil, true, err
+	}
+	for key := range secrets {
+		if _, dup := variables[key]; dup {
+			return nil, true, fmt.Errorf("%s is listed as both a variable and a secret", key)
+		}
+	}
+	return &EnvEntries{Variables: variables, Secrets: secrets}, true, nil
+}
+func scalarMap(raw map[string]interface{}) (map[string]string, error) {
+	values := make(map[string]string, len(raw))
+	for key, value := range raw {
+		if !envKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("invalid variable name %q", key)
+		}
+		switch v := value.(type) {
+		case nil:
+			values[key] = ""
+		case string:
+			values[key] = v
+		case json.Number, bool, int, int64, uint64, float64:
+			values[key] = fmt.Sprint(v)
+		default:
+			return nil, fmt.Errorf("value of %s must be a string, number or boolean", key)
+		}
+	}
+	return values, nil
+}
+func parseDotenv(data []byte) (map[string]string, error) {
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || !envKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", lineNo)
+		}
+		value = strings.TrimSpace(value)
+		switch {
+		case strings.HasPrefix(value, `"`):
+			end := closingQuote(value)
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated quoted value for %s", lineNo, key)
+			}
+			unquoted, err := strconv.Unquote(value[:end+1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid quoted value for %s", lineNo, key)
+			}
+			value = unquoted
+		case strings.HasPrefix(value, "'"):
+			end := strings.Index(value[1:], "'")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated quoted value for %s", lineNo, key)
+			}
+			value = value[1 : end+1]
+		default:
+			if i := strings.Index(value, " #"); i >= 0 {
+				value = strings.TrimSpace(value[:i])
+			}
+		}
+		values[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+func closingQuote(value string) int {
+	for i := 1; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+func FormatEnvEntries(format EnvFormat, entries *EnvEntries) ([]byte, error) {
+	switch format {
+	case FormatDotenv, "":
+		var buf bytes.Buffer
+		for _, key := range mapKeys(entries.Variables, entries.Secrets) {
+			value, ok := entries.Variables[key]
+			if !ok {
+				value = entries.Secrets[key]
+			}
+			fmt.Fprintf(&buf, "%s=%s\n", key, dotenvValue(value))
+		}
+		return buf.Bytes(), nil
+	case FormatJSON:
+		return json.MarshalIndent(entries, "", "  ")
+	case FormatYAML:
+		return yaml.Marshal(entries)
+	default:
+		return nil, fmt.Errorf("unsupported format %q", format)
+	}
+}
+func dotenvValue(value string) string {
+	if value != "" && !strings.ContainsAny(value, " \t\n\r\"'#\\$`=") {
+		return value
+	}
+	return strconv.Quote(value)
+}
+func (em *EnvironmentManager) ExportEnvironment(ctx context.Context, envID string, format EnvFormat, revealSecrets bool) ([]byte, error) {
+	if revealSecrets {
+		if err := em.authorizeEnvironment(ctx, envID, EnvActionSecrets); err != nil {
+			return nil, err
+		}
+	}
+	env, err := em.GetEnvironment(ctx, envID)
+	if err != nil {
+		return nil, err
+	}
+	entries := &EnvEntries{Variables: env.Variables, Secrets: make(map[string]string, len(env.Secrets))}
+	if entries.Variables == nil {
+		entries.Variables = make(map[string]string)
+	}
+	for key, value := range env.Secrets {
+		if !revealSecrets {
+			value = MaskedSecretValue
+		}
+		entries.Secrets[key] = value
+	}
+	return FormatEnvEntries(format, entries)
+}
+func (em *EnvironmentManager) ImportEnvironment(ctx context.Context, envID, author string, opts ImportOptions) (*ImportResult, error) {
+	switch opts.Mode {
+	case "":
+		opts.Mode = ImportMerge
+	case ImportMerge, ImportReplace:
+	default:
+		return nil, fmt.Errorf("unsupported import mode %q", opts.Mode)
+	}
+	entries, err := ParseEnvEntries(opts.Format, []byte(opts.Content), opts.Rules)
+	if err != nil {
+		return nil, err
+	}
+	actions := []EnvironmentAction{EnvActionView}
+	if len(entries.Secrets) > 0 || opts.Mode == ImportReplace {
+		actions = append(actions, EnvActionSecrets)
+	}
+	if !opts.DryRun {
+		actions = append(actions, EnvActionDeploy)
+	}
+	if err := em.authorizeEnvironment(ctx, envID, actions...); err != nil {
+		return nil, err
+	}
+	result := &ImportResult{EnvironmentID: envID, DryRun: opts.DryRun}
+	if opts.DryRun {
+		env, err := em.GetEnvironment(ctx, envID)
+		if err != nil {
+			return nil, err
+		}
+		kept := retainSecrets(env, entries, opts.Rules)
+		if len(kept.Secrets) > 0 {
+			if err := em.authorize(ctx, env, EnvActionSecrets); err != nil {
+				return nil, err
+			}
+		}
+		result.Changes = applyEnvEntries(env, kept, opts.Mode)
+		result.Revision = env.Revision
+	} else {
+		err = em.modifyEnvironment(ctx, envID, author, func(env *Environment) (map[string]int, error) {
+			if opts.Revision != 0 && env.Revision != opts.Revision {
+				return nil, fmt.Errorf("%w: %s is at revision %d, import was based on %d", ErrRevisionConflict, envID, env.Revision, opts.Revision)
+			}
+			if env.Locked {
+				return nil, fmt.Errorf("environment is locked by %s", env.LockedBy)
+			}
+			kept := retainSecrets(env, entries, opts.Rules)
+			if len(kept.Secrets) > 0 {
+				if err := em.authorize(ctx, env, EnvActionSecrets); err != nil {
+					return nil, err
+				}
+			}
+			result.Changes = applyEnvEntries(env, kept, opts.Mode)
+			result.Revision = env.Revision + 1
+			return nil, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, change := range result.Changes {
+		if change.Kind == EntrySecret {
+			result.Secrets++
+		} else {
+			result.Variables++
+		}
+	}
+	return result, nil
+}
+func retainSecrets(env *Environment, entries *EnvEntries, rules *SecretRules) *EnvEntries {
+	kept := &EnvEntries{Variables: make(map[string]string, len(entries.Variables)), Secrets: make(map[string]string, len(entries.Secrets))}
+	for key, value := range entries.Secrets {
+		kept.Secrets[key] = value
+	}
+	for key, value := range entries.Variables {
+		if _, isSecret := env.Secrets[key]; isSecret && !rules.listsPlain(key) {
+			kept.Secrets[key] = value
+			continue
+		}
+		kept.Variables[key] = value
+	}
+	return kept
+}
+func applyEnvEntries(env *Environment, entries *EnvEntries, mode ImportMode) []EnvChange {
+	if env.Variables == nil {
+		env.Variables = make(map[string]string)
+	}
+	if env.Secrets == nil {
+		env.Secrets = make(map[string]string)
+	}
+	var changes []EnvChange
+	for key, value := range entries.Variables {
+		if old, ok := env.Variables[key]; ok {
+			if old != value {
+				changes = append(changes, EnvChange{Key: key, Kind: EntryVariable, Action: ChangeUpdate, From: old, To: value})
+			}
+		} else if _, wasSecret := env.Secrets[key]; wasSecret {
+			delete(env.Secrets, key)
+			changes = append(changes, EnvChange{Key: key, Kind: EntryVariable, Action: ChangeReclassify, To: value})
+		} else {
+			changes = append(changes, EnvChange{Key: key, Kind: EntryVariable, Action: ChangeAdd, To: value})
+		}
+		env.Variables[key] = value
+	}
+	for key, value := range entries.Secrets {
+		if value == MaskedSecretValue {
+			continue
+		}
+		old, exists := env.Secrets[key]
+		if _, wasVariable := env.Variables[key]; wasVariable {
+			delete(env.Variables, key)
+			changes = append(changes, EnvChange{Key: key, Kind: EntrySecret, Action: ChangeReclassify})
+		} else if !exists {
+			changes = append(changes, EnvChange{Key: key, Kind: EntrySecret, Action: ChangeAdd})
+		} else if old != value {
+			changes = append(changes, EnvChange{Key: key, Kind: EntrySecret, Action: ChangeUpdate})
+		}
+		env.Secrets[key] = value
+	}
+	if mode == ImportReplace {
+		for key, value := range env.Variables {
+			if _, keep := entries.Variables[key]; !keep {
+				delete(env.Variables, key)
+				changes = append(changes, EnvChange{Key: key, Kind: EntryVariable, Action: ChangeRemove, From: value})
+			}
+		}
+		for key := range env.Secrets {
+			if _, keep := entries.Secrets[key]; !keep {
+				delete(env.Secrets, key)
+				changes = append(changes, EnvChange{Key: key, Kind: EntrySecret, Action: ChangeRemove})
+			}
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+	return changes
+}
@@ -0,0 +1,123 @@
+package deployer
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+func TestParseDotenv(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    map[string]string
+		wantErr string
+	}{
+		{name: "plain values", input: "A=1\nB = two\n", want: map[string]string{"A": "1", "B": "two"}},
+		{name: "comments and blank lines", input: "# header\n\nA=1 # trailing\n  # indented\n", want: map[string]string{"A": "1"}},
+		{name: "export prefix", input: "export API_URL=https://api.example.com\n", want: map[string]string{"API_URL": "https://api.example.com"}},
+		{name: "double quoted escapes", input: `MSG="hello \"world\"\nbye" # note`, want: map[string]string{"MSG": "hello \"world\"\nbye"}},
+		{name: "single quoted literal", input: `RAW='a \n $HOME # kept'`, want: map[string]string{"RAW": `a \n $HOME # kept`}},
+		{name: "hash without space", input: "COLOR=#ff0000\n", want: map[string]string{"COLOR": "#ff0000"}},
+		{name: "empty value", input: "EMPTY=\n", want: map[string]string{"EMPTY": ""}},
+		{name: "value containing equals", input: "DSN=postgres://u:p@h/db?sslmode=disable\n", want: map[string]string{"DSN": "postgres://u:p@h/db?sslmode=disable"}},
+		{name: "later value wins", input: "A=1\nA=2\n", want: map[string]string{"A": "2"}},
+		{name: "missing equals", input: "A=1\nNOT_A_PAIR\n", wantErr: "line 2: expected KEY=VALUE"},
+		{name: "invalid key", input: "1BAD=x\n", wantErr: "line 1: expected KEY=VALUE"},
+		{name: "unterminated double quote", input: `A="open`, wantErr: "line 1: unterminated quoted value for A"},
+		{name: "unterminated single quote", input: "A='open", wantErr: "line 1: unterminated quoted value for A"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDotenv([]byte(tt.input))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseDotenv: %v", err)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+func TestDotenvRoundTrip(t *testing.T) {
+	entries := &EnvEntries{
+		Variables: map[string]string{"GREETING": "hello world", "QUOTE": `say "hi"`, "EMPTY": "", "PLAIN": "x"},
+		Secrets:   map[string]string{"API_TOKEN": "a#b$c"},
+	}
+	data, err := FormatEnvEntries(FormatDotenv, entries)
+	if err != nil {
+		t.Fatalf("FormatEnvEntries: %v", err)
+	}
+	parsed, err := ParseEnvEntries(FormatDotenv, data, nil)
+	if err != nil {
+		t.Fatalf("ParseEnvEntries: %v", err)
+	}
+	if fmt.Sprint(parsed.Variables) != fmt.Sprint(entries.Variables) || fmt.Sprint(parsed.Secrets) != fmt.Sprint(entries.Secrets) {
+		t.Fatalf("round trip changed entries: %+v", parsed)
+	}
+}
+func TestImportKeepsExistingSecrets(t *testing.T) {
+	ctx := WithSystemPrincipal(context.Background())
+	em := NewEnvironmentManagerWithKeyring(newStaticKeyring("k1", bytes.Repeat([]byte("k"), 32)), t.TempDir())
+	env := &Environment{ProjectID: "proj", Name: "staging", Type: EnvironmentStaging, Secrets: map[string]string{"STRIPE_ACCOUNT": "acct_1", "SIGNING_SALT": "pepper"}}
+	if err := em.CreateEnvironment(ctx, env); err != nil {
+		t.Fatalf("CreateEnvironment: %v", err)
+	}
+	result, err := em.ImportEnvironment(ctx, env.ID, "alice", ImportOptions{
+		Content: "STRIPE_ACCOUNT=acct_2\nSIGNING_SALT=salt\nREGION=eu\n",
+		Rules:   &SecretRules{Plain: []string{"SIGNING_SALT"}},
+	})
+	if err != nil {
+		t.Fatalf("ImportEnvironment: %v", err)
+	}
+	stored, err := em.GetEnvironment(ctx, env.ID)
+	if err != nil {
+		t.Fatalf("GetEnvironment: %v", err)
+	}
+	if stored.Secrets["STRIPE_ACCOUNT"] != "acct_2" || stored.Variables["STRIPE_ACCOUNT"] != "" {
+		t.Fatalf("expected STRIPE_ACCOUNT to stay a secret, got variables %v", stored.Variables)
+	}
+	if _, ok := stored.Secrets["SIGNING_SALT"]; ok || stored.Variables["SIGNING_SALT"] != "salt" {
+		t.Fatalf("expected SIGNING_SALT to become plain when listed under rules.plain, got variables %v", stored.Variables)
+	}
+	if stored.Variables["REGION"] != "eu" || result.Secrets != 1 || result.Variables != 2 {
+		t.Fatalf("unexpected import result %+v", result)
+	}
+}
+func TestImportRequiresWriteAccess(t *testing.T) {
+	ctx := WithSystemPrincipal(context.Background())
+	em := NewEnvironmentManagerWithKeyring(newStaticKeyring("k1", bytes.Repeat([]byte("k"), 32)), t.TempDir())
+	env := &Environment{ProjectID: "proj", Name: "production", Type: EnvironmentProduction, AccessRoles: map[string][]string{"deploy": {"role:admin"}}}
+	if err := em.CreateEnvironment(ctx, env); err != nil {
+		t.Fatalf("CreateEnvironment: %v", err)
+	}
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		dryRun  bool
+		allowed bool
+	}{
+		{name: "viewer dry run", ctx: WithPrincipal(ctx, projectMember("u1", map[string]string{"proj": "developer"})), dryRun: true, allowed: true},
+		{name: "viewer import", ctx: WithPrincipal(ctx, projectMember("u1", map[string]string{"proj": "developer"})), allowed: false},
+		{name: "admin import", ctx: WithPrincipal(ctx, projectMember("u2", map[string]string{"proj": "admin"})), allowed: true},
+		{name: "no principal", ctx: context.Background(), dryRun: true, allowed: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := em.ImportEnvironment(tt.ctx, env.ID, "alice", ImportOptions{Content: "REGION=eu\n", DryRun: tt.dryRun})
+			if tt.allowed && err != nil {
+				t.Fatalf("ImportEnvironment: %v", err)
+			}
+			if !tt.allowed && !errors.Is(err, ErrAccessDenied) {
+				t.Fatalf("expected access denied, got %v", err)
+			}
+		})
+	}
+}
@@ -1,16 +1,12 @@
 package deployer
 import (
 	"context"
-	"crypto/aes"
-	"crypto/cipher"
-	"crypto/rand"
-	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 )
 type EnvironmentType string
@@ -24,21 +20,24 @@ const (
 	EnvironmentCustom      EnvironmentType = "custom"
 )
 type Environment struct {
-	ID          string                 `json:"id"`
-	Name        string                 `json:"name"`
-	Type        EnvironmentType        `json:"type"`
-	ProjectID   string                 `json:"project_id"`
-	Variables   map[string]string      `json:"variables"`
-	Secrets     map[string]string      `json:"secrets"`
-	Domains     []string               `json:"domains"`
-	Resources   ResourceAllocation     `json:"resources"`
-	Locked      bool                   `json:"locked"`
-	LockedBy    string                 `json:"locked_by,omitempty"`
-	LockedAt    *time.Time             `json:"locked_at,omitempty"`
-	AccessRoles map[string][]string    `json:"access_roles"`
-	CreatedAt   time.Time              `json:"created_at"`
-	UpdatedAt   time.Time              `json:"updated_at"`
-	Metadata    map[string]interface{} `json:"metadata"`
+	ID              string                 `json:"id"`
+	Name            string                 `json:"name"`
+	Type            EnvironmentType        `json:"type"`
+	ProjectID       string                 `json:"project_id"`
+	Variables       map[string]string      `json:"variables"`
+	Secrets         map[string]string      `json:"secrets"`
+	Domains         []string               `json:"domains"`
+	Resources       ResourceAllocation     `json:"resources"`
+	Locked          bool                   `json:"locked"`
+	LockedBy        string                 `json:"locked_by,omitempty"`
+	LockedAt        *time.Time             `json:"locked_at,omitempty"`
+	AccessRoles     map[string][]string    `json:"access_roles"`
+	Revision        int64                  `json:"revision"`
+	TemplateID      string                 `json:"template_id,omitempty"`
+	TemplateVersion int                    `json:"template_version,omitempty"`
+	CreatedAt       time.Time              `json:"created_at"`
+	UpdatedAt       time.Time              `json:"updated_at"`
+	Metadata        map[string]interface{} `json:"metadata"`
 }
 type ResourceAllocation struct {
 	MinCPU      string `json:"min_cpu"`
@@ -50,34 +49,58 @@ type ResourceAllocation struct {
 	StorageSize string `json:"storage_size"`
 	AutoScale   bool   `json:"auto_scale"`
 }
+var ErrRevisionConflict = errors.New("environment was modified concurrently")
+const maxEnvironmentRetries = 5
 type EnvironmentManager struct {
-	encryptionKey []byte
-	storagePath   string
+	mu          sync.Mutex
+	keyring     *Keyring
+	storagePath string
+	history     *DeploymentHistory
 }
 func NewEnvironmentManager(encryptionKey string, storagePath string) (*EnvironmentManager, error) {
 	if len(encryptionKey) != 32 {
 		return nil, errors.New("encryption key must be 32 bytes")
 	}
+	return NewEnvironmentManagerWithKeyring(newStaticKeyring(LegacyKeyID, []byte(encryptionKey)), storagePath), nil
+}
+func NewEnvironmentManagerWithKeyring(keyring *Keyring, storagePath string) *EnvironmentManager {
 	return &EnvironmentManager{
-		encryptionKey: []byte(encryptionKey),
-		storagePath:   storagePath,
-	}, nil
+		keyring:     keyring,
+		storagePath: storagePath,
+	}
 }
 func (em *EnvironmentManager) CreateEnvironment(ctx context.Context, env *Environment) error {
+	return em.createEnvironment(ctx, env, true)
+}
+func (em *EnvironmentManager) createEnvironment(ctx context.Context, env *Environment, enforceSecrets bool) error {
+	if err := em.applyTemplate(ctx, env, enforceSecrets); err != nil {
+		return err
+	}
+	if err := validateAccessRoles(env.AccessRoles); err != nil {
+		return err
+	}
 	if env.ID == "" {
 		env.ID = generateID()
 	}
 	env.CreatedAt = time.Now()
-	env.UpdatedAt = time.Now()
-	if err := em.encryptSecrets(env); err != nil {
-		return fmt.Errorf("failed to encrypt secrets: %w", err)
-	}
+	env.Revision = 0
 	if env.Resources.MinCPU == "" {
 		em.setDefaultResources(env)
 	}
-	return em.saveEnvironment(env)
+	return em.commitEnvironment(env, true, "", nil)
 }
 func (em *EnvironmentManager) GetEnvironment(ctx context.Context, envID string) (*Environment, error) {
+	env, err := em.getEnvironment(envID)
+	if err != nil {
+		return nil, err
+	}
+	if err := em.authorize(ctx, env, EnvActionView); err != nil {
+		return nil, err
+	}
+	em.maskSecrets(ctx, env)
+	return env, nil
+}
+func (em *EnvironmentManager) getEnvironment(envID string) (*Environment, error) {
 	env, err := em.loadEnvironment(envID)
 	if err != nil {
 		return nil, err
@@ -88,16 +111,69 @@ func (em *EnvironmentManager) GetEnvironment(ctx context.Context, envID string)
 	return env, nil
 }
 func (em *EnvironmentManager) UpdateEnvironment(ctx context.Context, env *Environment) error {
+	return em.updateEnvironment(ctx, env, "", nil)
+}
+func (em *EnvironmentManager) updateEnvironment(ctx context.Context, env *Environment, author string, restoredFrom map[string]int) error {
 	if env.Locked {
 		return fmt.Errorf("environment is locked by %s", env.LockedBy)
 	}
+	return em.commitEnvironment(env, false, author, restoredFrom)
+}
+func (em *EnvironmentManager) commitEnvironment(env *Environment, create bool, author string, restoredFrom map[string]int) error {
+	unlock, err := em.lockEnvironment(env.ID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := em.checkRevision(env, create); err != nil {
+		return err
+	}
 	env.UpdatedAt = time.Now()
+	if err := em.appendSecretVersions(env, author, restoredFrom); err != nil {
+		return fmt.Errorf("failed to record secret versions: %w", err)
+	}
 	if err := em.encryptSecrets(env); err != nil {
 		return fmt.Errorf("failed to encrypt secrets: %w", err)
 	}
-	return em.saveEnvironment(env)
+	return em.writeRevision(env)
+}
+func (em *EnvironmentManager) modifyEnvironment(ctx context.Context, envID, author string, mutate func(env *Environment) (map[string]int, error)) error {
+	for attempt := 0; ; attempt++ {
+		env, err := em.getEnvironment(envID)
+		if err != nil {
+			return err
+		}
+		restoredFrom, err := mutate(env)
+		if err != nil {
+			return err
+		}
+		err = em.updateEnvironment(ctx, env, author, restoredFrom)
+		if !errors.Is(err, ErrRevisionConflict) || attempt+1 >= maxEnvironmentRetries {
+			return err
+		}
+	}
+}
+func (em *EnvironmentManager) swapEnvironment(envID string, mutate func(env *Environment) error) error {
+	for attempt := 0; ; attempt++ {
+		env, err := em.loadEnvironment(envID)
+		if err != nil {
+			return err
+		}
+		if err := mutate(env); err != nil {
+			return err
+		}
+		err = em.saveEnvironment(env)
+		if !errors.Is(err, ErrRevisionConflict) || attempt+1 >= maxEnvironmentRetries {
+			return err
+		}
+	}
 }
 func (em *EnvironmentManager) DeleteEnvironment(ctx context.Context, envID string) error {
+	unlock, err := em.lockEnvironment(envID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 	env, err := em.loadEnvironment(envID)
 	if err != nil {
 		return err
@@ -106,13 +182,28 @@ func (em *EnvironmentManager) DeleteEnvironment(ctx context.Context, envID strin
 		return fmt.Errorf("cannot delete locked environment")
 	}
 	envPath := filepath.Join(em.storagePath, envID+".json")
-	return os.Remove(envPath)
+	if err := os.Remove(envPath); err != nil {
+		return err
+	}
+	if err := os.Remove(em.secretHistoryPath(envID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
 }
 func (em *EnvironmentManager) CloneEnvironment(ctx context.Context, sourceID, targetName string, targetType EnvironmentType) (*Environment, error) {
-	source, err := em.GetEnvironment(ctx, sourceID)
+	return em.CloneEnvironmentFromTemplate(ctx, sourceID, targetName, targetType, "")
+}
+func (em *EnvironmentManager) CloneEnvironmentFromTemplate(ctx context.Context, sourceID, targetName string, targetType EnvironmentType, templateID string) (*Environment, error) {
+	source, err := em.getEnvironment(sourceID)
 	if err != nil {
 		return nil, err
 	}
+	if err := em.authorize(ctx, source, EnvActionView, EnvActionSecrets); err != nil {
+		return nil, err
+	}
+	if templateID == "" {
+		templateID = source.TemplateID
+	}
 	clone := &Environment{
 		ID:          generateID(),
 		Name:        targetName,
@@ -124,6 +215,7 @@ func (em *EnvironmentManager) CloneEnvironment(ctx context.Context, sourceID, ta
 		Resources:   source.Resources,
 		AccessRoles: make(map[string][]string),
 		Metadata:    make(map[string]interface{}),
+		TemplateID:  templateID,
 	}
 	for k, v := range source.Variables {
 		clone.Variables[k] = v
@@ -137,7 +229,7 @@ func (em *EnvironmentManager) CloneEnvironment(ctx context.Context, sourceID, ta
 		clone.Resources.MinReplicas = 1
 		clone.Resources.MaxReplicas = 2
 	}
-	if err := em.CreateEnvironment(ctx, clone); err != nil {
+	if err := em.createEnvironment(ctx, clone, false); err != nil {
 		return nil, err
 	}
 	return clone, nil
@@ -147,68 +239,56 @@ func (em *EnvironmentManager) PromoteEnvironment(ctx context.Context, sourceID,
 	if err != nil {
 		return err
 	}
-	target, err := em.GetEnvironment(ctx, targetID)
+	rules, err := em.GetPromotionRules(ctx, source.ProjectID)
 	if err != nil {
 		return err
 	}
-	if target.Locked {
-		return fmt.Errorf("target environment is locked")
-	}
-	excludeKeys := map[string]bool{
-		"DATABASE_URL": true,
-		"REDIS_URL":    true,
-		"API_URL":      true,
-	}
-	for k, v := range source.Variables {
-		if !excludeKeys[k] {
-			target.Variables[k] = v
-		}
-	}
-	return em.UpdateEnvironment(ctx, target)
+	return em.promoteVariables(ctx, targetID, "", rules, source.Variables)
 }
 func (em *EnvironmentManager) LockEnvironment(ctx context.Context, envID, userID string) error {
-	env, err := em.loadEnvironment(envID)
-	if err != nil {
-		return err
-	}
-	if env.Locked {
-		return fmt.Errorf("environment already locked by %s", env.LockedBy)
-	}
-	now := time.Now()
-	env.Locked = true
-	env.LockedBy = userID
-	env.LockedAt = &now
-	return em.saveEnvironment(env)
+	return em.swapEnvironment(envID, func(env *Environment) error {
+		if err := em.authorize(ctx, env, EnvActionLock); err != nil {
+			return err
+		}
+		if env.Locked {
+			return fmt.Errorf("environment already locked by %s", env.LockedBy)
+		}
+		now := time.Now()
+		env.Locked = true
+		env.LockedBy = userID
+		env.LockedAt = &now
+		return nil
+	})
 }
 func (em *EnvironmentManager) UnlockEnvironment(ctx context.Context, envID, userID string) error {
-	env, err := em.loadEnvironment(envID)
-	if err != nil {
-		return err
-	}
-	if !env.Locked {
-		return errors.New("environment is not locked")
-	}
-	if env.LockedBy != userID {
-		return fmt.Errorf("environment locked by different user: %s", env.LockedBy)
-	}
-	env.Locked = false
-	env.LockedBy = ""
-	env.LockedAt = nil
-	return em.saveEnvironment(env)
+	return em.swapEnvironment(envID, func(env *Environment) error {
+		if err := em.authorize(ctx, env, EnvActionLock); err != nil {
+			return err
+		}
+		if !env.Locked {
+			return errors.New("environment is not locked")
+		}
+		if env.LockedBy != userID {
+			return fmt.Errorf("environment locked by different user: %s", env.LockedBy)
+		}
+		env.Locked = false
+		env.LockedBy = ""
+		env.LockedAt = nil
+		return nil
+	})
 }
 func (em *EnvironmentManager) SetSecret(ctx context.Context, envID, key, value string) error {
-	env, err := em.GetEnvironment(ctx, envID)
-	if err != nil {
-		return err
-	}
-	env.Secrets[key] = value
-	return em.UpdateEnvironment(ctx, env)
+	_, err := em.SetSecretAs(ctx, envID, key, value, "")
+	return err
 }
 func (em *EnvironmentManager) GetSecret(ctx context.Context, envID, key string) (string, error) {
-	env, err := em.GetEnvironment(ctx, envID)
+	env, err := em.getEnvironment(envID)
 	if err != nil {
 		return "", err
 	}
+	if err := em.authorize(ctx, env, EnvActionSecrets); err != nil {
+		return "", err
+	}
 	value, ok := env.Secrets[key]
 	if !ok {
 		return "", fmt.Errorf("secret not found: %s", key)
@@ -231,9 +311,13 @@ func (em *EnvironmentManager) ListEnvironments(ctx context.Context, projectID st
 			continue
 		}
 		if env.ProjectID == projectID {
+			if em.authorize(ctx, env, EnvActionView) != nil {
+				continue
+			}
 			if err := em.decryptSecrets(env); err != nil {
 				continue
 			}
+			em.maskSecrets(ctx, env)
 			environments = append(environments, env)
 		}
 	}
@@ -308,46 +392,62 @@ func (em *EnvironmentManager) decryptSecrets(env *Environment) error {
 	return nil
 }
 func (em *EnvironmentManager) encrypt(plaintext string) (string, error) {
-	block, err := aes.NewCipher(em.encryptionKey)
+	return em.keyring.Encrypt(plaintext)
+}
+func (em *EnvironmentManager) decrypt(ciphertext string) (string, error) {
+	return em.keyring.Decrypt(ciphertext)
+}
+func (em *EnvironmentManager) lockEnvironment(envID string) (func(), error) {
+	em.mu.Lock()
+	unlock, err := lockFile(filepath.Join(em.storagePath, "locks", envID+".lock"))
 	if err != nil {
-		return "", err
+		em.mu.Unlock()
+		return nil, err
 	}
-	gcm, err := cipher.NewGCM(block)
+	return func() {
+		unlock()
+		em.mu.Unlock()
+	}, nil
+}
+func (em *EnvironmentManager) saveEnvironment(env *Environment) error {
+	unlock, err := em.lockEnvironment(env.ID)
 	if err != nil {
-		return "", err
+		return err
 	}
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		return "", err
+	defer unlock()
+	if err := em.checkRevision(env, false); err != nil {
+		return err
 	}
-	ciphertext := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
-	return base64.StdEncoding.EncodeToString(ciphertext), nil
+	return em.writeRevision(env)
 }
-func (em *EnvironmentManager) decrypt(ciphertext string) (string, error) {
-	data, err := base64.StdEncoding.DecodeString(ciphertext)
-	if err != nil {
-		return "", err
+func (em *EnvironmentManager) checkRevision(env *Environment, create bool) error {
+	current, err := em.loadEnvironment(env.ID)
+	if errors.Is(err, os.ErrNotExist) {
+		if !create {
+			return fmt.Errorf("%w: %s no longer exists", ErrRevisionConflict, env.ID)
+		}
+		return nil
 	}
-	block, err := aes.NewCipher(em.encryptionKey)
 	if err != nil {
-		return "", err
+		return err
 	}
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return "", err
+	if create {
+		return fmt.Errorf("%w: %s already exists", ErrRevisionConflict, env.ID)
 	}
-	nonceSize := gcm.NonceSize()
-	if len(data) < nonceSize {
-		return "", errors.New("ciphertext too short")
+	if current.Revision != env.Revision {
+		return fmt.Errorf("%w: %s is at revision %d, update was based on %d", ErrRevisionConflict, env.ID, current.Revision, env.Revision)
 	}
-	nonce, ciphertextBytes := data[:nonceSize], data[nonceSize:]
-	plaintext, err := gcm.Open(nil, nonce, ciphertextBytes, nil)
-	if err != nil {
-		return "", err
+	return nil
+}
+func (em *EnvironmentManager) writeRevision(env *Environment) error {
+	env.Revision++
+	if err := em.writeEnvironment(env); err != nil {
+		env.Revision--
+		return err
 	}
-	return string(plaintext), nil
+	return nil
 }
-func (em *EnvironmentManager) saveEnvironment(env *Environment) error {
+func (em *EnvironmentManager) writeEnvironment(env *Environment) error {
 	if err := os.MkdirAll(em.storagePath, 0755); err != nil {
 		return err
 	}
@@ -356,7 +456,7 @@ func (em *EnvironmentManager) saveEnvironment(env *Environment) error {
 		return err
 	}
 	envPath := filepath.Join(em.storagePath, env.ID+".json")
-	return os.WriteFile(envPath, data, 0600)
+	return writeFileAtomic(envPath, data, 0600)
 }
 func (em *EnvironmentManager) loadEnvironment(envID string) (*Environment, error) {
 	envPath := filepath.Join(em.storagePath, envID+".json")
@@ -0,0 +1,53 @@
+package deployer
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+func TestSaveEnvironmentAcrossManagers(t *testing.T) {
+	dir := t.TempDir()
+	keyring := newStaticKeyring("k1", bytes.Repeat([]byte("k"), 32))
+	replicas := []*EnvironmentManager{NewEnvironmentManagerWithKeyring(keyring, dir), NewEnvironmentManagerWithKeyring(keyring, dir)}
+	env := &Environment{ProjectID: "proj", Name: "staging", Type: EnvironmentStaging}
+	if err := replicas[0].CreateEnvironment(context.Background(), env); err != nil {
+		t.Fatalf("CreateEnvironment: %v", err)
+	}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	saved, conflicts := 0, 0
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(em *EnvironmentManager) {
+			defer wg.Done()
+			stale, err := em.loadEnvironment(env.ID)
+			if err != nil {
+				t.Errorf("loadEnvironment: %v", err)
+				return
+			}
+			err = em.saveEnvironment(stale)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				saved++
+			case errors.Is(err, ErrRevisionConflict):
+				conflicts++
+			default:
+				t.Errorf("saveEnvironment: %v", err)
+			}
+		}(replicas[i%2])
+	}
+	wg.Wait()
+	latest, err := replicas[1].loadEnvironment(env.ID)
+	if err != nil {
+		t.Fatalf("loadEnvironment: %v", err)
+	}
+	if latest.Revision != env.Revision+int64(saved) {
+		t.Fatalf("expected revision %d after %d saves, got %d", env.Revision+int64(saved), saved, latest.Revision)
+	}
+	if saved+conflicts != 16 || saved == 0 {
+		t.Fatalf("expected every save to either win or conflict, got %d saved and %d conflicts", saved, conflicts)
+	}
+}
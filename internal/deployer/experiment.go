@@ -0,0 +1,350 @@
+package deployer
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strings"
+	"time"
+)
+type ExperimentPromotion string
+const (
+	PromoteAutomatically ExperimentPromotion = "auto"
+	PromoteOnApproval    ExperimentPromotion = "approval"
+	PromoteNever         ExperimentPromotion = "none"
+)
+type ExperimentConfig struct {
+	Name           string
+	Variants       []ExperimentVariant
+	Control        string
+	Metric         string
+	Direction      MetricDirection
+	StickyKey      string
+	Duration       time.Duration
+	SampleInterval time.Duration
+	MinSamples     int
+	Alpha          float64
+	Promotion      ExperimentPromotion
+	ApprovalGate   *ApprovalGate
+}
+type ExperimentVariant struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Weight  int    `json:"weight"`
+}
+type ExperimentRouter interface {
+	SplitTraffic(ctx context.Context, experiment string, variants []ExperimentVariant, stickyKey string) error
+	ClearSplit(ctx context.Context, experiment string) error
+}
+type ConversionQuerier interface {
+	GetConversions(ctx context.Context, version, metric string) (int64, int64, error)
+}
+type VariantResult struct {
+	Variant        string  `json:"variant"`
+	Version        string  `json:"version"`
+	Samples        int64   `json:"samples"`
+	Conversions    int64   `json:"conversions,omitempty"`
+	Value          float64 `json:"value"`
+	RelativeChange float64 `json:"relative_change"`
+	PValue         float64 `json:"p_value"`
+	Significant    bool    `json:"significant"`
+}
+type ExperimentResult struct {
+	Name          string          `json:"name"`
+	Metric        string          `json:"metric"`
+	Test          string          `json:"test"`
+	Control       string          `json:"control"`
+	Variants      []VariantResult `json:"variants"`
+	Winner        string          `json:"winner"`
+	WinnerVersion string          `json:"winner_version"`
+	Conclusive    bool            `json:"conclusive"`
+	Promoted      bool            `json:"promoted"`
+	Summary       string          `json:"summary"`
+	StartedAt     time.Time       `json:"started_at"`
+	EndedAt       time.Time       `json:"ended_at"`
+}
+func (ec *ExperimentConfig) withDefaults(config *DeploymentConfig) ExperimentConfig {
+	cfg := *ec
+	if cfg.Name == "" {
+		cfg.Name = fmt.Sprintf("%s-experiment", config.DeploymentID)
+	}
+	if cfg.Control == "" && len(cfg.Variants) > 0 {
+		cfg.Control = cfg.Variants[0].Name
+	}
+	if cfg.Metric == "" {
+		cfg.Metric = "conversion_rate"
+	}
+	if cfg.Direction == "" {
+		cfg.Direction = DirectionDecreaseIsBad
+	}
+	if cfg.StickyKey == "" {
+		cfg.StickyKey = defaultUserIDHeader
+	}
+	if cfg.Duration <= 0 {
+		cfg.Duration = 24 * time.Hour
+	}
+	if cfg.SampleInterval <= 0 {
+		cfg.SampleInterval = cfg.Duration / 20
+	}
+	if cfg.MinSamples <= 0 {
+		cfg.MinSamples = 10
+	}
+	if cfg.Alpha <= 0 {
+		cfg.Alpha = 0.05
+	}
+	if cfg.Promotion == "" {
+		cfg.Promotion = PromoteAutomatically
+	}
+	return cfg
+}
+func validateExperiment(cfg *ExperimentConfig) error {
+	if len(cfg.Variants) < 2 {
+		return fmt.Errorf("experiment requires at least two variants")
+	}
+	total := 0
+	names := make(map[string]bool)
+	for _, variant := range cfg.Variants {
+		if variant.Name == "" || variant.Version == "" {
+			return fmt.Errorf("experiment variants require a name and a version")
+		}
+		if names[variant.Name] {
+			return fmt.Errorf("duplicate experiment variant %q", variant.Name)
+		}
+		names[variant.Name] = true
+		if variant.Weight <= 0 {
+			return fmt.Errorf("experiment variant %q must have a positive weight", variant.Name)
+		}
+		total += variant.Weight
+	}
+	if total != 100 {
+		return fmt.Errorf("experiment variant weights must add up to 100, got %d", total)
+	}
+	if cfg.Control != "" && !names[cfg.Control] {
+		return fmt.Errorf("control variant %q is not one of the variants", cfg.Control)
+	}
+	return nil
+}
+func AssignVariant(experiment, userID string, variants []ExperimentVariant) string {
+	if len(variants) == 0 {
+		return ""
+	}
+	h := fnv.New32a()
+	h.Write([]byte(experiment))
+	h.Write([]byte{0})
+	h.Write([]byte(userID))
+	bucket := int(h.Sum32() % 100)
+	for _, variant := range variants {
+		if bucket < variant.Weight {
+			return variant.Name
+		}
+		bucket -= variant.Weight
+	}
+	return variants[len(variants)-1].Name
+}
+func (de *DeploymentExecutor) executeExperiment(ctx context.Context, run *deploymentRun, config *DeploymentConfig) (*DeploymentResult, error) {
+	if config.ExperimentConfig == nil {
+		return de.finish(ctx, run, "failed", fmt.Errorf("experiment config required for experiment deployment"))
+	}
+	if err := validateExperiment(config.ExperimentConfig); err != nil {
+		return de.finish(ctx, run, "failed", err)
+	}
+	expCfg := config.ExperimentConfig.withDefaults(config)
+	router, ok := de.loadBalancer.(ExperimentRouter)
+	if !ok {
+		return de.finish(ctx, run, "failed", fmt.Errorf("load balancer does not support experiment traffic splits"))
+	}
+	if err := de.runStep(ctx, run, "Deploy Experiment Variants", nil, sleepStep(2*time.Second)); err != nil {
+		return de.finish(ctx, run, "failed", err)
+	}
+	if err := de.runStep(ctx, run, "Health Check Variants", nil, de.healthCheckStep(config)); err != nil {
+		return de.finish(ctx, run, "failed", err)
+	}
+	clearSplit := &Compensation{Type: CompensationClearSplit, Rule: expCfg.Name}
+	err := de.runStep(ctx, run, fmt.Sprintf("Split Traffic for %s", expCfg.Name), clearSplit, func(ctx context.Context) error {
+		return router.SplitTraffic(ctx, expCfg.Name, expCfg.Variants, expCfg.StickyKey)
+	})
+	if err != nil {
+		return de.rollback(ctx, run, "Failed to split experiment traffic", err)
+	}
+	var result *ExperimentResult
+	err = de.runStep(ctx, run, fmt.Sprintf("Run Experiment %s", expCfg.Name), nil, func(ctx context.Context) error {
+		var err error
+		result, err = de.runExperiment(ctx, expCfg, run.current().StartTime)
+		if err != nil {
+			return err
+		}
+		run.current().Experiment = result
+		run.current().Output = result.Summary
+		return nil
+	})
+	if err != nil {
+		return de.rollback(ctx, run, "Experiment failed", err)
+	}
+	for i := range run.state.Steps {
+		if run.state.Steps[i].Experiment != nil {
+			result = run.state.Steps[i].Experiment
+		}
+	}
+	if expCfg.Promotion == PromoteNever {
+		return de.complete(ctx, run)
+	}
+	if !result.Conclusive {
+		err = de.runStep(ctx, run, "Restore Original Traffic Split", nil, func(ctx context.Context) error {
+			return router.ClearSplit(ctx, expCfg.Name)
+		})
+		if err != nil {
+			return de.rollback(ctx, run, "Failed to restore the original traffic split", err)
+		}
+		return de.complete(ctx, run)
+	}
+	if expCfg.Promotion == PromoteOnApproval {
+		gate := ApprovalGate{Name: fmt.Sprintf("Promote %s", result.Winner)}
+		if expCfg.ApprovalGate != nil {
+			gate = *expCfg.ApprovalGate
+		}
+		if err := de.awaitApproval(ctx, run, gate); err != nil {
+			return de.rollback(ctx, run, fmt.Sprintf("Promotion of %s not approved", result.Winner), err)
+		}
+	}
+	resetWeight := &Compensation{Type: CompensationTrafficWeight, Version: result.WinnerVersion, Weight: 0}
+	err = de.runStep(ctx, run, "Promote Experiment Winner", resetWeight, func(ctx context.Context) error {
+		if err := de.loadBalancer.SetTrafficWeight(ctx, result.WinnerVersion, 100); err != nil {
+			return err
+		}
+		if err := router.ClearSplit(ctx, expCfg.Name); err != nil {
+			return err
+		}
+		result.Promoted = true
+		return nil
+	})
+	if err != nil {
+		return de.rollback(ctx, run, fmt.Sprintf("Failed to promote %s", result.Winner), err)
+	}
+	return de.complete(ctx, run)
+}
+func (de *DeploymentExecutor) runExperiment(ctx context.Context, cfg ExperimentConfig, startedAt time.Time) (*ExperimentResult, error) {
+	result := &ExperimentResult{
+		Name:      cfg.Name,
+		Metric:    cfg.Metric,
+		Control:   cfg.Control,
+		StartedAt: startedAt,
+	}
+	var control ExperimentVariant
+	for _, variant := range cfg.Variants {
+		if variant.Name == cfg.Control {
+			control = variant
+		}
+	}
+	if querier, ok := de.monitor.(ConversionQuerier); ok {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(cfg.Duration):
+		}
+		result.Test = "two-proportion z-test"
+		controlConv, controlN, err := querier.GetConversions(ctx, control.Version, cfg.Metric)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query %s for %s: %w", cfg.Metric, control.Name, err)
+		}
+		for _, variant := range cfg.Variants {
+			conversions, exposures, err := controlConv, controlN, error(nil)
+			if variant.Name != control.Name {
+				conversions, exposures, err = querier.GetConversions(ctx, variant.Version, cfg.Metric)
+				if err != nil {
+					return nil, fmt.Errorf("failed to query %s for %s: %w", cfg.Metric, variant.Name, err)
+				}
+			}
+			vr := VariantResult{Variant: variant.Name, Version: variant.Version, Samples: exposures, Conversions: conversions, PValue: 1}
+			if exposures > 0 {
+				vr.Value = float64(conversions) / float64(exposures)
+			}
+			if variant.Name != control.Name && controlN > 0 && exposures > 0 {
+				controlRate := float64(controlConv) / float64(controlN)
+				vr.RelativeChange = relativeChange(controlRate, vr.Value)
+				improvement := vr.Value - controlRate
+				if cfg.Direction == DirectionIncreaseIsBad {
+					improvement = -improvement
+				}
+				vr.PValue = twoProportionPValue(controlConv, controlN, conversions, exposures, improvement)
+			}
+			vr.Significant = variant.Name != control.Name && vr.PValue < cfg.Alpha && exposures >= int64(cfg.MinSamples)
+			result.Variants = append(result.Variants, vr)
+		}
+	} else {
+		result.Test = "Mann-Whitney U"
+		samples := make(map[string][]float64)
+		deadline := time.Now().Add(cfg.Duration)
+		for time.Now().Before(deadline) {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(cfg.SampleInterval):
+			}
+			for _, variant := range cfg.Variants {
+				value, err := de.sampleMetric(ctx, variant.Version, cfg.Metric)
+				if err == nil {
+					samples[variant.Name] = append(samples[variant.Name], value)
+				}
+			}
+		}
+		for _, variant := range cfg.Variants {
+			values := samples[variant.Name]
+			vr := VariantResult{Variant: variant.Name, Version: variant.Version, Samples: int64(len(values)), PValue: 1}
+			if len(values) > 0 {
+				vr.Value = median(values)
+			}
+			controlValues := samples[control.Name]
+			if variant.Name != control.Name && len(values) >= 3 && len(controlValues) >= 3 {
+				vr.RelativeChange = relativeChange(median(controlValues), vr.Value)
+				if cfg.Direction == DirectionIncreaseIsBad {
+					_, vr.PValue = mannWhitneyU(controlValues, values)
+				} else {
+					_, vr.PValue = mannWhitneyU(values, controlValues)
+				}
+			}
+			vr.Significant = variant.Name != control.Name && vr.PValue < cfg.Alpha && len(values) >= cfg.MinSamples
+			result.Variants = append(result.Variants, vr)
+		}
+	}
+	best := 0.0
+	for _, vr := range result.Variants {
+		gain := vr.RelativeChange
+		if cfg.Direction == DirectionIncreaseIsBad {
+			gain = -gain
+		}
+		if vr.Significant && gain > best {
+			best = gain
+			result.Winner, result.WinnerVersion = vr.Variant, vr.Version
+			result.Conclusive = true
+		}
+	}
+	result.EndedAt = time.Now()
+	result.Summary = summarizeExperiment(result)
+	return result, nil
+}
+func twoProportionPValue(controlConv, controlN, variantConv, variantN int64, improvement float64) float64 {
+	pooled := float64(controlConv+variantConv) / float64(controlN+variantN)
+	se := math.Sqrt(pooled * (1 - pooled) * (1/float64(controlN) + 1/float64(variantN)))
+	if se == 0 {
+		return 1
+	}
+	z := improvement / se
+	return 0.5 * math.Erfc(z/math.Sqrt2)
+}
+func summarizeExperiment(result *ExperimentResult) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Experiment %s on %s (%s): ", result.Name, result.Metric, result.Test)
+	if result.Conclusive {
+		fmt.Fprintf(&b, "%s wins over control %s.", result.Winner, result.Control)
+	} else {
+		fmt.Fprintf(&b, "no variant beat control %s significantly, keeping control.", result.Control)
+	}
+	for _, vr := range result.Variants {
+		if vr.Variant == result.Control {
+			fmt.Fprintf(&b, " %s=%.4f (control, n=%d).", vr.Variant, vr.Value, vr.Samples)
+			continue
+		}
+		fmt.Fprintf(&b, " %s=%.4f (%+.1f%%, p=%.3f, n=%d).", vr.Variant, vr.Value, vr.RelativeChange*100, vr.PValue, vr.Samples)
+	}
+	return b.String()
+}
@@ -0,0 +1,81 @@
+package deployer
+import (
+	"context"
+	"testing"
+	"time"
+)
+func (f *fakeBalancer) SplitTraffic(ctx context.Context, experiment string, variants []ExperimentVariant, stickyKey string) error {
+	f.record("split:" + experiment)
+	return nil
+}
+func (f *fakeBalancer) ClearSplit(ctx context.Context, experiment string) error {
+	f.record("clear:" + experiment)
+	return nil
+}
+type fakeConversions struct {
+	fakeMonitor
+	conversions map[string][2]int64
+}
+func (f fakeConversions) GetConversions(ctx context.Context, version, metric string) (int64, int64, error) {
+	c := f.conversions[version]
+	return c[0], c[1], nil
+}
+func experimentDeployment(promotion ExperimentPromotion) *DeploymentConfig {
+	return &DeploymentConfig{
+		ProjectID:   "proj",
+		Environment: "production",
+		Strategy:    StrategyExperiment,
+		Version:     "v2",
+		ExperimentConfig: &ExperimentConfig{
+			Name:      "checkout",
+			Variants:  []ExperimentVariant{{Name: "control", Version: "v1", Weight: 50}, {Name: "treatment", Version: "v2", Weight: 50}},
+			Duration:  time.Millisecond,
+			Promotion: promotion,
+		},
+	}
+}
+func TestExperimentInconclusiveRestoresSplit(t *testing.T) {
+	lb := &fakeBalancer{}
+	de, err := NewDeploymentExecutor(fakeHealth{}, lb, fakeConversions{conversions: map[string][2]int64{
+		"v1": {100, 1000},
+		"v2": {101, 1000},
+	}}, NewFileStateStore(t.TempDir()), nil, nil, nil, NewDeploymentHistory(t.TempDir()))
+	if err != nil {
+		t.Fatalf("NewDeploymentExecutor: %v", err)
+	}
+	result, err := de.Execute(context.Background(), experimentDeployment(PromoteAutomatically))
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if result.Experiment == nil || result.Experiment.Conclusive || result.Experiment.Promoted || result.Experiment.Winner != "" {
+		t.Fatalf("expected an inconclusive, unpromoted experiment, got %+v", result.Experiment)
+	}
+	for _, call := range lb.calls {
+		if call == "weight:v1" || call == "weight:v2" {
+			t.Fatalf("inconclusive experiment changed traffic weights: %v", lb.calls)
+		}
+	}
+	if last := lb.calls[len(lb.calls)-1]; last != "clear:checkout" {
+		t.Fatalf("expected the experiment split to be cleared, got %v", lb.calls)
+	}
+}
+func TestExperimentConclusivePromotesWinner(t *testing.T) {
+	lb := &fakeBalancer{}
+	de, err := NewDeploymentExecutor(fakeHealth{}, lb, fakeConversions{conversions: map[string][2]int64{
+		"v1": {100, 1000},
+		"v2": {180, 1000},
+	}}, NewFileStateStore(t.TempDir()), nil, nil, nil, NewDeploymentHistory(t.TempDir()))
+	if err != nil {
+		t.Fatalf("NewDeploymentExecutor: %v", err)
+	}
+	result, err := de.Execute(context.Background(), experimentDeployment(PromoteAutomatically))
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if !result.Experiment.Promoted || result.Experiment.WinnerVersion != "v2" {
+		t.Fatalf("expected v2 to be promoted, got %+v", result.Experiment)
+	}
+	if lb.weights["v2"] != 100 {
+		t.Fatalf("expected all traffic on v2, got %v", lb.weights)
+	}
+}
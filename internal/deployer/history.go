@@ -0,0 +1,185 @@
+package deployer
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+var ErrDeploymentNotFound = errors.New("deployment not found")
+var ErrInvalidCursor = errors.New("invalid history cursor")
+type HistoryQuery struct {
+	ProjectID   string
+	Environment string
+	Status      string
+	Limit       int
+	Cursor      string
+}
+type HistoryPage struct {
+	Records    []*DeploymentRecord `json:"records"`
+	NextCursor string              `json:"next_cursor,omitempty"`
+}
+type HistoryStore interface {
+	Save(ctx context.Context, record *DeploymentRecord) error
+	Get(ctx context.Context, deploymentID string) (*DeploymentRecord, error)
+	List(ctx context.Context, query HistoryQuery) (*HistoryPage, error)
+	ColourStore
+}
+type historyCursor struct {
+	DeployedAt time.Time
+	ID         string
+}
+func encodeHistoryCursor(record *DeploymentRecord) string {
+	raw := fmt.Sprintf("%d:%s", record.DeployedAt.UnixNano(), record.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+func decodeHistoryCursor(cursor string) (*historyCursor, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	nanos, id, ok := strings.Cut(string(raw), ":")
+	if !ok || id == "" {
+		return nil, ErrInvalidCursor
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &historyCursor{DeployedAt: time.Unix(0, n), ID: id}, nil
+}
+func (c *historyCursor) after(record *DeploymentRecord) bool {
+	if record.DeployedAt.Equal(c.DeployedAt) {
+		return record.ID < c.ID
+	}
+	return record.DeployedAt.Before(c.DeployedAt)
+}
+func (q HistoryQuery) matches(record *DeploymentRecord) bool {
+	return (q.ProjectID == "" || record.ProjectID == q.ProjectID) &&
+		(q.Environment == "" || record.Environment == q.Environment) &&
+		(q.Status == "" || record.Status == q.Status)
+}
+type FileHistoryStore struct {
+	storagePath string
+}
+func NewFileHistoryStore(storagePath string) *FileHistoryStore {
+	return &FileHistoryStore{
+		storagePath: storagePath,
+	}
+}
+func (fs *FileHistoryStore) Save(ctx context.Context, record *DeploymentRecord) error {
+	if err := os.MkdirAll(fs.storagePath, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(fs.storagePath, fmt.Sprintf("%s.json", record.ID)), data, 0644)
+}
+func (fs *FileHistoryStore) Get(ctx context.Context, deploymentID string) (*DeploymentRecord, error) {
+	data, err := os.ReadFile(filepath.Join(fs.storagePath, fmt.Sprintf("%s.json", deploymentID)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrDeploymentNotFound, deploymentID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var record DeploymentRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+func (fs *FileHistoryStore) List(ctx context.Context, query HistoryQuery) (*HistoryPage, error) {
+	cursor, err := decodeHistoryCursor(query.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	files, err := os.ReadDir(fs.storagePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return &HistoryPage{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var records []*DeploymentRecord
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(fs.storagePath, file.Name()))
+		if err != nil {
+			continue
+		}
+		var record DeploymentRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			continue
+		}
+		if !query.matches(&record) || (cursor != nil && !cursor.after(&record)) {
+			continue
+		}
+		records = append(records, &record)
+	}
+	sortHistory(records)
+	return paginateHistory(records, query.Limit), nil
+}
+func sortHistory(records []*DeploymentRecord) {
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].DeployedAt.Equal(records[j].DeployedAt) {
+			return records[i].ID > records[j].ID
+		}
+		return records[i].DeployedAt.After(records[j].DeployedAt)
+	})
+}
+func paginateHistory(records []*DeploymentRecord, limit int) *HistoryPage {
+	page := &HistoryPage{Records: records}
+	if limit > 0 && len(records) > limit {
+		page.Records = records[:limit]
+		page.NextCursor = encodeHistoryCursor(page.Records[limit-1])
+	}
+	return page
+}
+func ImportHistory(ctx context.Context, src, dst HistoryStore, batchSize int) (int, error) {
+	if batchSize <= 0 {
+		batchSize = 500
+	}
+	imported := 0
+	query := HistoryQuery{Limit: batchSize}
+	for {
+		page, err := src.List(ctx, query)
+		if err != nil {
+			return imported, fmt.Errorf("failed to read deployment history: %w", err)
+		}
+		for _, record := range page.Records {
+			if err := dst.Save(ctx, record); err != nil {
+				return imported, fmt.Errorf("failed to import deployment %s: %w", record.ID, err)
+			}
+			imported++
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		query.Cursor = page.NextCursor
+	}
+	states, err := src.ListColourStates(ctx)
+	if err != nil {
+		return imported, fmt.Errorf("failed to read colour states: %w", err)
+	}
+	for _, state := range states {
+		state.Revision = 0
+		if err := dst.SaveColourState(ctx, state); err != nil && !errors.Is(err, ErrColourStateChanged) {
+			return imported, fmt.Errorf("failed to import colour state for %s/%s: %w", state.ProjectID, state.Environment, err)
+		}
+	}
+	return imported, nil
+}
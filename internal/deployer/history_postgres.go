@@ -0,0 +1,182 @@
+package deployer
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+type PostgresHistoryStore struct {
+	db *sql.DB
+}
+func NewPostgresHistoryStore(db *sql.DB) *PostgresHistoryStore {
+	return &PostgresHistoryStore{db: db}
+}
+func (ps *PostgresHistoryStore) Save(ctx context.Context, record *DeploymentRecord) error {
+	record.DeployedAt = record.DeployedAt.Truncate(time.Microsecond)
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal deployment record: %w", err)
+	}
+	query := `
+		INSERT INTO deployment_history (id, project_id, environment, version, strategy, status, deployed_at, deployed_by, rollback_from, record)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (id) DO UPDATE SET
+			project_id = EXCLUDED.project_id,
+			environment = EXCLUDED.environment,
+			version = EXCLUDED.version,
+			strategy = EXCLUDED.strategy,
+			status = EXCLUDED.status,
+			deployed_at = EXCLUDED.deployed_at,
+			deployed_by = EXCLUDED.deployed_by,
+			rollback_from = EXCLUDED.rollback_from,
+			record = EXCLUDED.record,
+			updated_at = NOW()
+	`
+	_, err = ps.db.ExecContext(ctx, query,
+		record.ID, record.ProjectID, record.Environment, record.Version, string(record.Strategy),
+		record.Status, record.DeployedAt, record.DeployedBy, record.RollbackFrom, data,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save deployment record: %w", err)
+	}
+	return nil
+}
+func (ps *PostgresHistoryStore) Get(ctx context.Context, deploymentID string) (*DeploymentRecord, error) {
+	var data []byte
+	err := ps.db.QueryRowContext(ctx, `SELECT record FROM deployment_history WHERE id = $1`, deploymentID).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: %s", ErrDeploymentNotFound, deploymentID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get deployment record: %w", err)
+	}
+	var record DeploymentRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal deployment record: %w", err)
+	}
+	return &record, nil
+}
+func (ps *PostgresHistoryStore) List(ctx context.Context, query HistoryQuery) (*HistoryPage, error) {
+	cursor, err := decodeHistoryCursor(query.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	var conditions []string
+	var args []interface{}
+	if query.ProjectID != "" {
+		args = append(args, query.ProjectID)
+		conditions = append(conditions, fmt.Sprintf("project_id = $%d", len(args)))
+	}
+	if query.Environment != "" {
+		args = append(args, query.Environment)
+		conditions = append(conditions, fmt.Sprintf("environment = $%d", len(args)))
+	}
+	if query.Status != "" {
+		args = append(args, query.Status)
+		conditions = append(conditions, fmt.Sprintf("status = $%d", len(args)))
+	}
+	if cursor != nil {
+		args = append(args, cursor.DeployedAt, cursor.ID)
+		conditions = append(conditions, fmt.Sprintf("(deployed_at, id) < ($%d, $%d)", len(args)-1, len(args)))
+	}
+	sqlQuery := "SELECT record FROM deployment_history"
+	if len(conditions) > 0 {
+		sqlQuery += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	sqlQuery += " ORDER BY deployed_at DESC, id DESC"
+	if query.Limit > 0 {
+		args = append(args, query.Limit+1)
+		sqlQuery += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	rows, err := ps.db.QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list deployment records: %w", err)
+	}
+	defer rows.Close()
+	var records []*DeploymentRecord
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to scan deployment record: %w", err)
+		}
+		var record DeploymentRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal deployment record: %w", err)
+		}
+		records = append(records, &record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list deployment records: %w", err)
+	}
+	return paginateHistory(records, query.Limit), nil
+}
+func (ps *PostgresHistoryStore) GetColourState(ctx context.Context, projectID, environment string) (*ColourState, error) {
+	var data []byte
+	err := ps.db.QueryRowContext(ctx, `SELECT state FROM colour_states WHERE project_id = $1 AND environment = $2`, projectID, environment).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, ErrColourStateNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get colour state: %w", err)
+	}
+	var state ColourState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal colour state: %w", err)
+	}
+	return &state, nil
+}
+func (ps *PostgresHistoryStore) SaveColourState(ctx context.Context, state *ColourState) error {
+	next := *state
+	next.Revision++
+	next.UpdatedAt = time.Now()
+	data, err := json.Marshal(&next)
+	if err != nil {
+		return fmt.Errorf("failed to marshal colour state: %w", err)
+	}
+	var result sql.Result
+	if state.Revision == 0 {
+		result, err = ps.db.ExecContext(ctx, `
+			INSERT INTO colour_states (project_id, environment, revision, state)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (project_id, environment) DO NOTHING
+		`, next.ProjectID, next.Environment, next.Revision, data)
+	} else {
+		result, err = ps.db.ExecContext(ctx, `
+			UPDATE colour_states SET revision = $3, state = $4, updated_at = NOW()
+			WHERE project_id = $1 AND environment = $2 AND revision = $5
+		`, next.ProjectID, next.Environment, next.Revision, data, state.Revision)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save colour state: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("%w: %s/%s is no longer at revision %d", ErrColourStateChanged, state.ProjectID, state.Environment, state.Revision)
+	}
+	*state = next
+	return nil
+}
+func (ps *PostgresHistoryStore) ListColourStates(ctx context.Context) ([]*ColourState, error) {
+	rows, err := ps.db.QueryContext(ctx, `SELECT state FROM colour_states ORDER BY project_id, environment`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list colour states: %w", err)
+	}
+	defer rows.Close()
+	var states []*ColourState
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to scan colour state: %w", err)
+		}
+		var state ColourState
+		if err := json.Unmarshal(data, &state); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal colour state: %w", err)
+		}
+		states = append(states, &state)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list colour states: %w", err)
+	}
+	return states, nil
+}
@@ -0,0 +1,116 @@
+package deployer
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+func seedHistory(t *testing.T) *FileHistoryStore {
+	t.Helper()
+	store := NewFileHistoryStore(t.TempDir())
+	base := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 7; i++ {
+		record := &DeploymentRecord{
+			ID:          fmt.Sprintf("d%d", i),
+			ProjectID:   "proj",
+			Environment: "production",
+			Status:      "success",
+			DeployedAt:  base.Add(time.Duration(i/2) * time.Minute),
+		}
+		if i == 3 {
+			record.Environment = "staging"
+			record.Status = "failed"
+		}
+		if err := store.Save(context.Background(), record); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+	}
+	return store
+}
+func TestFileHistoryStorePagination(t *testing.T) {
+	tests := []struct {
+		name  string
+		query HistoryQuery
+		want  []string
+	}{
+		{name: "all records", query: HistoryQuery{ProjectID: "proj"}, want: []string{"d6", "d5", "d4", "d3", "d2", "d1", "d0"}},
+		{name: "pages of two", query: HistoryQuery{ProjectID: "proj", Limit: 2}, want: []string{"d6", "d5", "d4", "d3", "d2", "d1", "d0"}},
+		{name: "pages of three", query: HistoryQuery{ProjectID: "proj", Limit: 3}, want: []string{"d6", "d5", "d4", "d3", "d2", "d1", "d0"}},
+		{name: "environment filter", query: HistoryQuery{ProjectID: "proj", Environment: "production", Limit: 2}, want: []string{"d6", "d5", "d4", "d2", "d1", "d0"}},
+		{name: "status filter", query: HistoryQuery{Status: "failed", Limit: 1}, want: []string{"d3"}},
+		{name: "other project", query: HistoryQuery{ProjectID: "other"}, want: nil},
+	}
+	store := seedHistory(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			query := tt.query
+			for pages := 0; ; pages++ {
+				if pages > len(tt.want)+1 {
+					t.Fatalf("pagination did not terminate, got %v", got)
+				}
+				page, err := store.List(context.Background(), query)
+				if err != nil {
+					t.Fatalf("List: %v", err)
+				}
+				if query.Limit > 0 && len(page.Records) > query.Limit {
+					t.Fatalf("page of %d records exceeds limit %d", len(page.Records), query.Limit)
+				}
+				for _, record := range page.Records {
+					got = append(got, record.ID)
+				}
+				if page.NextCursor == "" {
+					break
+				}
+				query.Cursor = page.NextCursor
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+func TestDecodeHistoryCursor(t *testing.T) {
+	record := &DeploymentRecord{ID: "deploy:1", DeployedAt: time.Unix(0, 1700000000123456789)}
+	cursor, err := decodeHistoryCursor(encodeHistoryCursor(record))
+	if err != nil {
+		t.Fatalf("decodeHistoryCursor: %v", err)
+	}
+	if cursor.ID != record.ID || !cursor.DeployedAt.Equal(record.DeployedAt) {
+		t.Fatalf("expected cursor for %s at %v, got %+v", record.ID, record.DeployedAt, cursor)
+	}
+	if cursor, err := decodeHistoryCursor(""); cursor != nil || err != nil {
+		t.Fatalf("expected no cursor, got %+v, %v", cursor, err)
+	}
+	for _, invalid := range []string{"!!!", "bm9jb2xvbg", "YWJjOmQx", "MTIzOg"} {
+		if _, err := decodeHistoryCursor(invalid); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("%q: expected ErrInvalidCursor, got %v", invalid, err)
+		}
+	}
+}
+func TestImportHistory(t *testing.T) {
+	src := seedHistory(t)
+	if err := src.SaveColourState(context.Background(), &ColourState{ProjectID: "proj", Environment: "production", Live: ColourGreen, Idle: ColourBlue}); err != nil {
+		t.Fatalf("SaveColourState: %v", err)
+	}
+	dst := NewFileHistoryStore(t.TempDir())
+	imported, err := ImportHistory(context.Background(), src, dst, 2)
+	if err != nil {
+		t.Fatalf("ImportHistory: %v", err)
+	}
+	if imported != 7 {
+		t.Fatalf("expected 7 records imported, got %d", imported)
+	}
+	page, err := dst.List(context.Background(), HistoryQuery{})
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(page.Records) != 7 || page.NextCursor != "" {
+		t.Fatalf("expected all 7 records in the destination, got %d", len(page.Records))
+	}
+	state, err := dst.GetColourState(context.Background(), "proj", "production")
+	if err != nil || state.Live != ColourGreen {
+		t.Fatalf("expected the colour state to be imported, got %+v (%v)", state, err)
+	}
+}
@@ -0,0 +1,239 @@
+package deployer
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+)
+type HookPhase string
+const (
+	HookPreDeploy    HookPhase = "pre-deploy"
+	HookRelease      HookPhase = "release"
+	HookPostDeploy   HookPhase = "post-deploy"
+	HookPreRollback  HookPhase = "pre-rollback"
+	HookPostRollback HookPhase = "post-rollback"
+)
+type HookType string
+const (
+	HookCommand HookType = "command"
+	HookHTTP    HookType = "http"
+	HookScript  HookType = "script"
+)
+type HookFailurePolicy string
+const (
+	HookAbort    HookFailurePolicy = "abort"
+	HookContinue HookFailurePolicy = "continue"
+	HookRollback HookFailurePolicy = "rollback"
+)
+const maxHookOutput = 64 * 1024
+type DeploymentHook struct {
+	Name           string
+	Phase          HookPhase
+	Type           HookType
+	Command        []string
+	Script         string
+	URL            string
+	Method         string
+	Headers        map[string]string
+	Body           string
+	ExpectedStatus int
+	Env            map[string]string
+	Timeout        time.Duration
+	FailurePolicy  HookFailurePolicy
+}
+type HookRunner interface {
+	RunCommand(ctx context.Context, image string, command []string, env map[string]string) (string, error)
+}
+type DockerHookRunner struct{}
+func (DockerHookRunner) RunCommand(ctx context.Context, image string, command []string, env map[string]string) (string, error) {
+	args := []string{"run", "--rm"}
+	for _, key := range sortedKeys(env) {
+		args = append(args, "-e", key+"="+env[key])
+	}
+	args = append(args, image)
+	args = append(args, command...)
+	output, err := exec.CommandContext(ctx, "docker", args...).CombinedOutput()
+	return string(output), err
+}
+func (h DeploymentHook) policy() HookFailurePolicy {
+	if h.FailurePolicy != "" {
+		return h.FailurePolicy
+	}
+	switch h.Phase {
+	case HookPostDeploy:
+		return HookRollback
+	case HookPreRollback, HookPostRollback:
+		return HookContinue
+	default:
+		return HookAbort
+	}
+}
+func (h DeploymentHook) stepName() string {
+	return fmt.Sprintf("Hook %s: %s", h.Phase, h.Name)
+}
+func hooksFor(config *DeploymentConfig, phase HookPhase) []DeploymentHook {
+	var hooks []DeploymentHook
+	for _, hook := range config.Hooks {
+		if hook.Phase == phase {
+			hooks = append(hooks, hook)
+		}
+	}
+	return hooks
+}
+func (de *DeploymentExecutor) runHooks(ctx context.Context, run *deploymentRun, phase HookPhase) (HookFailurePolicy, error) {
+	for _, hook := range hooksFor(run.state.Config, phase) {
+		hook := hook
+		err := de.runStep(ctx, run, hook.stepName(), nil, func(ctx context.Context) error {
+			output, err := de.executeHook(ctx, run.state.Config, hook)
+			run.current().Output = output
+			return err
+		})
+		if err != nil && hook.policy() != HookContinue {
+			return hook.policy(), fmt.Errorf("%s hook %q failed: %w", phase, hook.Name, err)
+		}
+	}
+	return "", nil
+}
+func (de *DeploymentExecutor) runRollbackHooks(ctx context.Context, run *deploymentRun, phase HookPhase) error {
+	for _, hook := range hooksFor(run.state.Config, phase) {
+		name := hook.stepName()
+		done := false
+		for _, step := range run.state.Steps {
+			if step.Name == name && step.Status == "success" {
+				done = true
+			}
+		}
+		if done {
+			continue
+		}
+		run.state.Steps = append(run.state.Steps, DeploymentStep{
+			Name:      name,
+			Status:    "running",
+			StartTime: time.Now(),
+			Attempts:  1,
+		})
+		if err := de.saveState(ctx, run.state); err != nil {
+			return fmt.Errorf("failed to persist step %q: %w", name, err)
+		}
+		output, err := de.executeHook(ctx, run.state.Config, hook)
+		step := &run.state.Steps[len(run.state.Steps)-1]
+		step.EndTime = time.Now()
+		step.Output = output
+		if err != nil {
+			step.Status = "failed"
+			step.Error = err.Error()
+		} else {
+			step.Status = "success"
+		}
+		if saveErr := de.saveState(ctx, run.state); saveErr != nil {
+			return fmt.Errorf("failed to persist step %q: %w", name, saveErr)
+		}
+		if err != nil && hook.policy() == HookAbort {
+			return fmt.Errorf("%s hook %q failed: %w", phase, hook.Name, err)
+		}
+	}
+	return nil
+}
+func (de *DeploymentExecutor) handleHookFailure(ctx context.Context, run *deploymentRun, policy HookFailurePolicy, err error) (*DeploymentResult, error) {
+	if policy == HookRollback {
+		return de.rollback(ctx, run, err.Error(), err)
+	}
+	return de.finish(ctx, run, "failed", err)
+}
+func (de *DeploymentExecutor) executeHook(ctx context.Context, config *DeploymentConfig, hook DeploymentHook) (string, error) {
+	timeout := hook.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Minute
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	env := map[string]string{
+		"OPSAGENT_DEPLOYMENT_ID": config.DeploymentID,
+		"OPSAGENT_PROJECT_ID":    config.ProjectID,
+		"OPSAGENT_ENVIRONMENT":   config.Environment,
+		"OPSAGENT_VERSION":       config.Version,
+		"OPSAGENT_IMAGE":         config.Image,
+		"OPSAGENT_HOOK_PHASE":    string(hook.Phase),
+	}
+	for k, v := range hook.Env {
+		env[k] = v
+	}
+	var output string
+	var err error
+	switch hook.Type {
+	case HookCommand:
+		if len(hook.Command) == 0 {
+			return "", fmt.Errorf("command hook %q has no command", hook.Name)
+		}
+		output, err = de.hookRunner.RunCommand(ctx, config.Image, hook.Command, env)
+	case HookScript:
+		if hook.Script == "" {
+			return "", fmt.Errorf("script hook %q has no script", hook.Name)
+		}
+		cmd := exec.CommandContext(ctx, "sh", "-c", hook.Script)
+		cmd.Env = os.Environ()
+		for _, key := range sortedKeys(env) {
+			cmd.Env = append(cmd.Env, key+"="+env[key])
+		}
+		var out []byte
+		out, err = cmd.CombinedOutput()
+		output = string(out)
+	case HookHTTP:
+		output, err = executeHTTPHook(ctx, hook)
+	default:
+		return "", fmt.Errorf("unknown hook type: %s", hook.Type)
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %v", timeout)
+	}
+	if len(output) > maxHookOutput {
+		output = output[len(output)-maxHookOutput:]
+	}
+	return output, err
+}
+func executeHTTPHook(ctx context.Context, hook DeploymentHook) (string, error) {
+	method := hook.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	var body io.Reader
+	if hook.Body != "" {
+		body = strings.NewReader(hook.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, hook.URL, body)
+	if err != nil {
+		return "", err
+	}
+	for k, v := range hook.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var buf bytes.Buffer
+	io.Copy(&buf, io.LimitReader(resp.Body, maxHookOutput))
+	output := fmt.Sprintf("HTTP %d\n%s", resp.StatusCode, buf.String())
+	if hook.ExpectedStatus != 0 && resp.StatusCode != hook.ExpectedStatus {
+		return output, fmt.Errorf("expected status %d, got %d", hook.ExpectedStatus, resp.StatusCode)
+	}
+	if hook.ExpectedStatus == 0 && (resp.StatusCode < 200 || resp.StatusCode >= 300) {
+		return output, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return output, nil
+}
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
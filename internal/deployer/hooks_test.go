@@ -0,0 +1,155 @@
+package deployer
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+type recordingHooks struct {
+	failing map[string]bool
+	ran     []string
+}
+func (r *recordingHooks) RunCommand(ctx context.Context, image string, command []string, env map[string]string) (string, error) {
+	r.ran = append(r.ran, env["OPSAGENT_HOOK_PHASE"]+":"+command[0])
+	if command[0] == "hang" {
+		<-ctx.Done()
+		return "", ctx.Err()
+	}
+	if r.failing[command[0]] {
+		return "boom", errors.New("exit status 1")
+	}
+	return "ok", nil
+}
+func commandHook(phase HookPhase, name string, policy HookFailurePolicy) DeploymentHook {
+	return DeploymentHook{Name: name, Phase: phase, Type: HookCommand, Command: []string{name}, FailurePolicy: policy}
+}
+func hookExecutor(t *testing.T, runner *recordingHooks, health fakeHealth) (*DeploymentExecutor, *fakeBalancer, StateStore) {
+	t.Helper()
+	lb := &fakeBalancer{weights: map[string]int{"v1": 100}}
+	store := NewFileStateStore(t.TempDir())
+	de, err := NewDeploymentExecutor(health, lb, fakeMonitor{}, store, runner, nil, nil, NewDeploymentHistory(t.TempDir()))
+	if err != nil {
+		t.Fatalf("NewDeploymentExecutor: %v", err)
+	}
+	return de, lb, store
+}
+func TestHookTimeout(t *testing.T) {
+	runner := &recordingHooks{}
+	de, _, _ := hookExecutor(t, runner, fakeHealth{})
+	hook := commandHook(HookPreDeploy, "hang", "")
+	hook.Timeout = 20 * time.Millisecond
+	start := time.Now()
+	_, err := de.executeHook(context.Background(), &DeploymentConfig{Image: "app:v2"}, hook)
+	if err == nil || err.Error() != "timed out after 20ms" {
+		t.Fatalf("expected the hook to time out, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected the timeout to stop the hook, took %v", elapsed)
+	}
+}
+func TestPostDeployHookFailurePolicies(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     HookFailurePolicy
+		wantStatus string
+		wantCalls  string
+		wantRan    string
+	}{
+		{name: "abort", policy: HookAbort, wantStatus: "failed", wantRan: "post-deploy:smoke"},
+		{name: "continue", policy: HookContinue, wantStatus: "success", wantRan: "post-deploy:smoke,post-deploy:notify"},
+		{name: "rollback", policy: HookRollback, wantStatus: "rolled_back", wantCalls: "weight:v1", wantRan: "post-deploy:smoke,pre-rollback:drain,post-rollback:page"},
+		{name: "defaults to rollback after deploy", wantStatus: "rolled_back", wantCalls: "weight:v1", wantRan: "post-deploy:smoke,pre-rollback:drain,post-rollback:page"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			runner := &recordingHooks{failing: map[string]bool{"smoke": true}}
+			de, lb, store := hookExecutor(t, runner, fakeHealth{})
+			state := newDeploymentState(&DeploymentConfig{ProjectID: "proj", Environment: "production", Strategy: StrategyDirect, Version: "v2", Hooks: []DeploymentHook{
+				commandHook(HookPostDeploy, "smoke", tt.policy),
+				commandHook(HookPostDeploy, "notify", ""),
+				commandHook(HookPreRollback, "drain", ""),
+				commandHook(HookPostRollback, "page", ""),
+			}})
+			state.Phase = PhaseRunning
+			state.Steps = []DeploymentStep{{Name: "Deploy All Instances", Status: "success", Compensation: &Compensation{Type: CompensationTrafficWeight, Version: "v1"}}}
+			if err := de.stateStore.Save(ctx, state); err != nil {
+				t.Fatalf("Save: %v", err)
+			}
+			run := &deploymentRun{state: state, cursor: 1}
+			result, err := de.complete(ctx, run)
+			if result.Status != tt.wantStatus {
+				t.Fatalf("expected status %s, got %s (%v)", tt.wantStatus, result.Status, err)
+			}
+			if (err == nil) != (tt.wantStatus == "success") {
+				t.Fatalf("unexpected error %v for status %s", err, result.Status)
+			}
+			if got := strings.Join(runner.ran, ","); got != tt.wantRan {
+				t.Fatalf("expected hooks %s, got %s", tt.wantRan, got)
+			}
+			if got := strings.Join(lb.calls, ","); got != tt.wantCalls {
+				t.Fatalf("expected compensation %q, got %q", tt.wantCalls, got)
+			}
+			stored, err := store.Load(ctx, state.ID)
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if smoke := stored.Steps[1]; smoke.Name != "Hook post-deploy: smoke" || smoke.Status == "success" || smoke.Output != "boom" {
+				t.Fatalf("expected the failed hook step to be recorded with its output, got %+v", smoke)
+			}
+		})
+	}
+}
+func TestPreRollbackHookAbortStopsCompensation(t *testing.T) {
+	ctx := context.Background()
+	runner := &recordingHooks{failing: map[string]bool{"drain": true}}
+	de, lb, _ := hookExecutor(t, runner, fakeHealth{})
+	state := newDeploymentState(&DeploymentConfig{ProjectID: "proj", Environment: "production", Strategy: StrategyDirect, Version: "v2", Hooks: []DeploymentHook{
+		commandHook(HookPreRollback, "drain", HookAbort),
+		commandHook(HookPostRollback, "page", ""),
+	}})
+	state.Steps = []DeploymentStep{{Name: "Deploy All Instances", Status: "success", Compensation: &Compensation{Type: CompensationTrafficWeight, Version: "v1"}}}
+	result, err := de.rollbackState(ctx, state, "health check failed")
+	if err == nil || result.Status != "failed" || !strings.Contains(err.Error(), `pre-rollback hook "drain" failed`) {
+		t.Fatalf("expected the aborting hook to fail the rollback, got %+v (%v)", result, err)
+	}
+	if len(lb.calls) != 0 || strings.Join(runner.ran, ",") != "pre-rollback:drain" {
+		t.Fatalf("expected compensation and later hooks to be skipped, got calls %v hooks %v", lb.calls, runner.ran)
+	}
+}
+func TestHookPhasesRunAtTheirStage(t *testing.T) {
+	runner := &recordingHooks{}
+	de, lb, _ := hookExecutor(t, runner, fakeHealth{failing: map[string]bool{"http://app/health": true}})
+	result, err := de.Execute(context.Background(), &DeploymentConfig{
+		ProjectID:      "proj",
+		Environment:    "production",
+		Strategy:       StrategyDirect,
+		Version:        "v2",
+		HealthCheckURL: "http://app/health",
+		Hooks: []DeploymentHook{
+			commandHook(HookPostRollback, "page", ""),
+			commandHook(HookPostDeploy, "smoke", ""),
+			commandHook(HookRelease, "migrate", ""),
+			commandHook(HookPreRollback, "drain", ""),
+			commandHook(HookPreDeploy, "backup", ""),
+		},
+	})
+	if err == nil || result.Status != "rolled_back" {
+		t.Fatalf("expected the failed health check to roll back, got %+v (%v)", result, err)
+	}
+	if got := strings.Join(runner.ran, ","); got != "pre-deploy:backup,release:migrate,pre-rollback:drain,post-rollback:page" {
+		t.Fatalf("unexpected hook order %s", got)
+	}
+	var names []string
+	for _, step := range result.Steps {
+		names = append(names, step.Name)
+	}
+	want := "Hook pre-deploy: backup,Hook release: migrate,Deploy All Instances,Health Check,Hook pre-rollback: drain,Hook post-rollback: page"
+	if got := strings.Join(names, ","); got != want {
+		t.Fatalf("expected steps %s, got %s", want, got)
+	}
+	if got := strings.Join(lb.calls, ","); got != "switch:v2->v1" {
+		t.Fatalf("expected traffic to return to v1, got %s", got)
+	}
+}
@@ -0,0 +1,287 @@
+package deployer
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+type RotationReport struct {
+	ActiveKey    string    `json:"active_key"`
+	Environments int       `json:"environments"`
+	Deployments  int       `json:"deployments"`
+	Reencrypted  int       `json:"reencrypted_secrets"`
+	Failed       []string  `json:"failed,omitempty"`
+	StartedAt    time.Time `json:"started_at"`
+	CompletedAt  time.Time `json:"completed_at"`
+}
+type KeyVerification struct {
+	KeyID      string         `json:"key_id"`
+	Usage      map[string]int `json:"usage"`
+	Unreadable []string       `json:"unreadable,omitempty"`
+}
+func (em *EnvironmentManager) Keyring() *Keyring {
+	return em.keyring
+}
+func (em *EnvironmentManager) AttachHistory(history *DeploymentHistory) {
+	em.history = history
+}
+func (em *EnvironmentManager) RotateKey(ctx context.Context, keyID string) (*RotationReport, error) {
+	known := false
+	for _, id := range em.keyring.KeyIDs() {
+		known = known || id == keyID
+	}
+	if !known {
+		if err := em.keyring.GenerateKey(ctx, keyID); err != nil {
+			return nil, fmt.Errorf("failed to generate key %s: %w", keyID, err)
+		}
+	}
+	if err := em.keyring.Activate(ctx, keyID); err != nil {
+		return nil, err
+	}
+	fmt.Printf("🔑 Activated encryption key %s\n", keyID)
+	return em.ReencryptAll(ctx)
+}
+func (em *EnvironmentManager) ReencryptAll(ctx context.Context) (*RotationReport, error) {
+	report := &RotationReport{ActiveKey: em.keyring.ActiveKeyID(), StartedAt: time.Now()}
+	ids, err := em.environmentIDs()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		count, err := em.reencryptEnvironment(id)
+		if err != nil {
+			report.Failed = append(report.Failed, fmt.Sprintf("%s: %v", id, err))
+			continue
+		}
+		report.Environments++
+		report.Reencrypted += count
+	}
+	err = em.sealedSnapshots(ctx, func(record *DeploymentRecord) error {
+		count, err := em.reencryptSnapshot(ctx, record)
+		if err != nil {
+			report.Failed = append(report.Failed, fmt.Sprintf("deployment %s: %v", record.ID, err))
+			return nil
+		}
+		if count > 0 {
+			report.Deployments++
+			report.Reencrypted += count
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+	report.CompletedAt = time.Now()
+	fmt.Printf("🔐 Re-encrypted %d secrets across %d environments and %d deployment snapshots with key %s\n", report.Reencrypted, report.Environments, report.Deployments, report.ActiveKey)
+	if len(report.Failed) > 0 {
+		return report, fmt.Errorf("failed to re-encrypt %d environments or deployment snapshots", len(report.Failed))
+	}
+	return report, nil
+}
+func (em *EnvironmentManager) reencryptEnvironment(envID string) (int, error) {
+	unlock, err := em.lockEnvironment(envID)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+	env, err := em.loadEnvironment(envID)
+	if err != nil {
+		return 0, err
+	}
+	active := em.keyring.ActiveKeyID()
+	count := 0
+	for key, sealed := range env.Secrets {
+		if ciphertextKeyID(sealed) == active {
+			continue
+		}
+		plaintext, err := em.decrypt(sealed)
+		if err != nil {
+			return 0, fmt.Errorf("failed to decrypt secret %s: %w", key, err)
+		}
+		if env.Secrets[key], err = em.encrypt(plaintext); err != nil {
+			return 0, err
+		}
+		count++
+	}
+	history, err := em.loadSecretHistory(envID)
+	if err != nil {
+		return 0, err
+	}
+	rewrapped := 0
+	for key, versions := range history.Secrets {
+		for i, v := range versions {
+			if v.Deleted || ciphertextKeyID(v.Value) == active {
+				continue
+			}
+			plaintext, err := em.decrypt(v.Value)
+			if err != nil {
+				return 0, fmt.Errorf("failed to decrypt secret %s@%d: %w", key, v.Version, err)
+			}
+			if versions[i].Value, err = em.encrypt(plaintext); err != nil {
+				return 0, err
+			}
+			rewrapped++
+		}
+	}
+	if rewrapped > 0 {
+		if err := em.writeSecretHistory(history); err != nil {
+			return 0, err
+		}
+	}
+	if count == 0 {
+		return rewrapped, nil
+	}
+	return count + rewrapped, em.writeEnvironment(env)
+}
+func (em *EnvironmentManager) sealedSnapshots(ctx context.Context, visit func(record *DeploymentRecord) error) error {
+	if em.history == nil {
+		return nil
+	}
+	query := HistoryQuery{Limit: 500}
+	for {
+		page, err := em.history.QueryDeployments(ctx, query)
+		if err != nil {
+			return fmt.Errorf("failed to read deployment history: %w", err)
+		}
+		for _, record := range page.Records {
+			if record.Snapshot == nil || len(record.Snapshot.SealedSecrets) == 0 {
+				continue
+			}
+			if err := visit(record); err != nil {
+				return err
+			}
+		}
+		if page.NextCursor == "" {
+			return nil
+		}
+		query.Cursor = page.NextCursor
+	}
+}
+func (em *EnvironmentManager) reencryptSnapshot(ctx context.Context, record *DeploymentRecord) (int, error) {
+	active := em.keyring.ActiveKeyID()
+	count := 0
+	for key, sealed := range record.Snapshot.SealedSecrets {
+		if ciphertextKeyID(sealed) == active {
+			continue
+		}
+		plaintext, err := em.decrypt(sealed)
+		if err != nil {
+			return 0, fmt.Errorf("failed to decrypt secret %s: %w", key, err)
+		}
+		if record.Snapshot.SealedSecrets[key], err = em.encrypt(plaintext); err != nil {
+			return 0, err
+		}
+		count++
+	}
+	if count == 0 {
+		return 0, nil
+	}
+	return count, em.history.RecordDeployment(ctx, record)
+}
+func (em *EnvironmentManager) VerifyKey(ctx context.Context, keyID string) (*KeyVerification, error) {
+	verification := &KeyVerification{KeyID: keyID, Usage: make(map[string]int)}
+	ids, err := em.environmentIDs()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		env, err := em.loadEnvironment(id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read environment %s: %w", id, err)
+		}
+		for key, sealed := range env.Secrets {
+			if ciphertextKeyID(sealed) == keyID {
+				verification.Usage[env.ID]++
+				continue
+			}
+			if _, err := em.decrypt(sealed); err != nil {
+				verification.Unreadable = append(verification.Unreadable, env.ID+"."+key)
+			}
+		}
+		history, err := em.loadSecretHistory(id)
+		if err != nil {
+			return nil, err
+		}
+		for key, versions := range history.Secrets {
+			for _, v := range versions {
+				if v.Deleted {
+					continue
+				}
+				if ciphertextKeyID(v.Value) == keyID {
+					verification.Usage[env.ID]++
+					continue
+				}
+				if _, err := em.decrypt(v.Value); err != nil {
+					verification.Unreadable = append(verification.Unreadable, fmt.Sprintf("%s.%s@%d", env.ID, key, v.Version))
+				}
+			}
+		}
+	}
+	err = em.sealedSnapshots(ctx, func(record *DeploymentRecord) error {
+		for key, sealed := range record.Snapshot.SealedSecrets {
+			if ciphertextKeyID(sealed) == keyID {
+				verification.Usage["deployment "+record.ID]++
+				continue
+			}
+			if _, err := em.decrypt(sealed); err != nil {
+				verification.Unreadable = append(verification.Unreadable, fmt.Sprintf("deployment %s.%s", record.ID, key))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(verification.Unreadable)
+	return verification, nil
+}
+func (em *EnvironmentManager) RetireKey(ctx context.Context, keyID string) error {
+	if keyID == em.keyring.ActiveKeyID() {
+		return ErrActiveKey
+	}
+	em.mu.Lock()
+	defer em.mu.Unlock()
+	verification, err := em.VerifyKey(ctx, keyID)
+	if err != nil {
+		return err
+	}
+	if len(verification.Usage) > 0 {
+		envs := make([]string, 0, len(verification.Usage))
+		for id := range verification.Usage {
+			envs = append(envs, id)
+		}
+		sort.Strings(envs)
+		return fmt.Errorf("%w: %s encrypts secrets in %s", ErrKeyInUse, keyID, strings.Join(envs, ", "))
+	}
+	if len(verification.Unreadable) > 0 {
+		return fmt.Errorf("refusing to retire %s: %d secrets cannot be decrypted with the remaining keys", keyID, len(verification.Unreadable))
+	}
+	if err := em.keyring.retire(ctx, keyID); err != nil {
+		return err
+	}
+	fmt.Printf("🗝️  Retired encryption key %s\n", keyID)
+	return nil
+}
+func (em *EnvironmentManager) environmentIDs() ([]string, error) {
+	files, err := os.ReadDir(em.storagePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, file := range files {
+		if filepath.Ext(file.Name()) == ".json" {
+			ids = append(ids, strings.TrimSuffix(file.Name(), ".json"))
+		}
+	}
+	return ids, nil
+}
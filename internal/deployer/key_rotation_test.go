@@ -0,0 +1,61 @@
+package deployer
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+func TestRetireKeyChecksSealedSnapshots(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	keyring, err := NewKeyringWithSeed(ctx, NewFileKeySource(dir+"/keys.json"), "k1", bytes.Repeat([]byte("a"), 32))
+	if err != nil {
+		t.Fatalf("NewKeyringWithSeed: %v", err)
+	}
+	em := NewEnvironmentManagerWithKeyring(keyring, dir+"/envs")
+	history := NewDeploymentHistory(dir + "/history")
+	em.AttachHistory(history)
+	sealed, err := keyring.Encrypt("hunter2")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	record := &DeploymentRecord{
+		ID:          "legacy",
+		ProjectID:   "proj",
+		Environment: "production",
+		Status:      "success",
+		DeployedAt:  time.Now(),
+		Snapshot:    &ConfigSnapshot{EnvironmentID: "env", SealedSecrets: map[string]string{"DB_PASSWORD": sealed}},
+	}
+	if err := history.RecordDeployment(ctx, record); err != nil {
+		t.Fatalf("RecordDeployment: %v", err)
+	}
+	if err := keyring.GenerateKey(ctx, "k2"); err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	if err := keyring.Activate(ctx, "k2"); err != nil {
+		t.Fatalf("Activate: %v", err)
+	}
+	if err := em.RetireKey(ctx, "k1"); !errors.Is(err, ErrKeyInUse) {
+		t.Fatalf("expected k1 to be reported in use by the deployment snapshot, got %v", err)
+	}
+	report, err := em.ReencryptAll(ctx)
+	if err != nil {
+		t.Fatalf("ReencryptAll: %v", err)
+	}
+	if report.Deployments != 1 || report.Reencrypted != 1 {
+		t.Fatalf("expected one snapshot secret to be re-encrypted, got %+v", report)
+	}
+	if err := em.RetireKey(ctx, "k1"); err != nil {
+		t.Fatalf("RetireKey: %v", err)
+	}
+	stored, err := history.GetDeployment(ctx, "legacy")
+	if err != nil {
+		t.Fatalf("GetDeployment: %v", err)
+	}
+	plaintext, err := keyring.Decrypt(stored.Snapshot.SealedSecrets["DB_PASSWORD"])
+	if err != nil || plaintext != "hunter2" {
+		t.Fatalf("expected the snapshot secret to stay readable, got %q, %v", plaintext, err)
+	}
+}
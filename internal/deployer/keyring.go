@@ -0,0 +1,398 @@
+package deployer
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+const (
+	ciphertextVersion = "v1"
+	LegacyKeyID       = "legacy"
+)
+var (
+	ErrUnknownKey   = errors.New("unknown encryption key")
+	ErrKeyInUse     = errors.New("encryption key still in use")
+	ErrActiveKey    = errors.New("cannot retire the active encryption key")
+	ErrReadOnlyKeys = errors.New("key source is read-only")
+)
+type KeySet struct {
+	Active  string            `json:"active"`
+	Keys    map[string][]byte `json:"keys"`
+	Retired []string          `json:"retired,omitempty"`
+}
+type KeySource interface {
+	Load(ctx context.Context) (*KeySet, error)
+}
+type KeySink interface {
+	Save(ctx context.Context, keys *KeySet) error
+}
+type Keyring struct {
+	mu      sync.RWMutex
+	source  KeySource
+	active  string
+	keys    map[string][]byte
+	retired []string
+}
+func NewKeyring(ctx context.Context, source KeySource) (*Keyring, error) {
+	kr := &Keyring{source: source}
+	if err := kr.Reload(ctx); err != nil {
+		return nil, err
+	}
+	return kr, nil
+}
+func NewKeyringWithSeed(ctx context.Context, source KeySource, keyID string, key []byte) (*Keyring, error) {
+	kr, err := NewKeyring(ctx, source)
+	if !errors.Is(err, os.ErrNotExist) {
+		return kr, err
+	}
+	sink, ok := source.(KeySink)
+	if !ok {
+		return nil, err
+	}
+	seed := &KeySet{Active: keyID, Keys: map[string][]byte{keyID: key}}
+	if err := seed.validate(); err != nil {
+		return nil, err
+	}
+	if err := sink.Save(ctx, seed); err != nil {
+		return nil, fmt.Errorf("failed to seed encryption keys: %w", err)
+	}
+	return NewKeyring(ctx, source)
+}
+func newStaticKeyring(keyID string, key []byte) *Keyring {
+	return &Keyring{
+		active: keyID,
+		keys:   map[string][]byte{keyID: key},
+	}
+}
+func (kr *Keyring) Reload(ctx context.Context) error {
+	set, err := kr.source.Load(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load encryption keys: %w", err)
+	}
+	if err := set.validate(); err != nil {
+		return err
+	}
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	kr.active = set.Active
+	kr.keys = set.Keys
+	kr.retired = set.Retired
+	return nil
+}
+func (ks *KeySet) validate() error {
+	if len(ks.Keys) == 0 {
+		return errors.New("key set contains no keys")
+	}
+	for id, key := range ks.Keys {
+		if id == "" || strings.Contains(id, ":") {
+			return fmt.Errorf("invalid key id %q", id)
+		}
+		if len(key) != 32 {
+			return fmt.Errorf("encryption key %s must be 32 bytes", id)
+		}
+	}
+	if _, ok := ks.Keys[ks.Active]; !ok {
+		return fmt.Errorf("%w: active key %q", ErrUnknownKey, ks.Active)
+	}
+	return nil
+}
+func (kr *Keyring) ActiveKeyID() string {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	return kr.active
+}
+func (kr *Keyring) KeyIDs() []string {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	ids := make([]string, 0, len(kr.keys))
+	for id := range kr.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+func (kr *Keyring) snapshot() *KeySet {
+	keys := make(map[string][]byte, len(kr.keys))
+	for id, key := range kr.keys {
+		keys[id] = key
+	}
+	return &KeySet{Active: kr.active, Keys: keys, Retired: append([]string(nil), kr.retired...)}
+}
+func (kr *Keyring) persist(ctx context.Context, set *KeySet) error {
+	sink, ok := kr.source.(KeySink)
+	if !ok {
+		return ErrReadOnlyKeys
+	}
+	if err := sink.Save(ctx, set); err != nil {
+		return fmt.Errorf("failed to persist encryption keys: %w", err)
+	}
+	kr.active = set.Active
+	kr.keys = set.Keys
+	kr.retired = set.Retired
+	return nil
+}
+func (kr *Keyring) GenerateKey(ctx context.Context, keyID string) error {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return err
+	}
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	if _, exists := kr.keys[keyID]; exists {
+		return fmt.Errorf("encryption key %s already exists", keyID)
+	}
+	set := kr.snapshot()
+	set.Keys[keyID] = key
+	if err := set.validate(); err != nil {
+		return err
+	}
+	return kr.persist(ctx, set)
+}
+func (kr *Keyring) Activate(ctx context.Context, keyID string) error {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	if _, ok := kr.keys[keyID]; !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownKey, keyID)
+	}
+	set := kr.snapshot()
+	set.Active = keyID
+	return kr.persist(ctx, set)
+}
+func (kr *Keyring) retire(ctx context.Context, keyID string) error {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	if keyID == kr.active {
+		return ErrActiveKey
+	}
+	if _, ok := kr.keys[keyID]; !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownKey, keyID)
+	}
+	set := kr.snapshot()
+	delete(set.Keys, keyID)
+	set.Retired = append(set.Retired, keyID)
+	return kr.persist(ctx, set)
+}
+func (kr *Keyring) Encrypt(plaintext string) (string, error) {
+	kr.mu.RLock()
+	keyID, key := kr.active, kr.keys[kr.active]
+	kr.mu.RUnlock()
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), []byte(keyID))
+	return fmt.Sprintf("%s:%s:%s", ciphertextVersion, keyID, base64.StdEncoding.EncodeToString(sealed)), nil
+}
+func (kr *Keyring) Decrypt(ciphertext string) (string, error) {
+	keyID, payload, aad := LegacyKeyID, ciphertext, []byte(nil)
+	if parts := strings.SplitN(ciphertext, ":", 3); len(parts) == 3 && parts[0] == ciphertextVersion {
+		keyID, payload, aad = parts[1], parts[2], []byte(parts[1])
+	}
+	kr.mu.RLock()
+	key, ok := kr.keys[keyID]
+	kr.mu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownKey, keyID)
+	}
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < gcm.NonceSize() {
+		return "", errors.New("ciphertext too short")
+	}
+	plaintext, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], aad)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+func (kr *Keyring) Fingerprint(value string) string {
+	kr.mu.RLock()
+	keyID, key := kr.active, kr.keys[kr.active]
+	kr.mu.RUnlock()
+	return keyID + ":" + fingerprintWith(key, value)
+}
+func (kr *Keyring) MatchesFingerprint(fingerprint, value string) bool {
+	keyID, sum, ok := strings.Cut(fingerprint, ":")
+	if !ok {
+		return false
+	}
+	kr.mu.RLock()
+	key, known := kr.keys[keyID]
+	kr.mu.RUnlock()
+	return known && hmac.Equal([]byte(sum), []byte(fingerprintWith(key, value)))
+}
+func fingerprintWith(key []byte, value string) string {
+	derived := sha256.Sum256(append([]byte("opsagent secret fingerprint\x00"), key...))
+	mac := hmac.New(sha256.New, derived[:])
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+func ciphertextKeyID(ciphertext string) string {
+	parts := strings.SplitN(ciphertext, ":", 3)
+	if len(parts) == 3 && parts[0] == ciphertextVersion {
+		return parts[1]
+	}
+	return LegacyKeyID
+}
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+type FileKeySource struct {
+	path string
+}
+func NewFileKeySource(path string) *FileKeySource {
+	return &FileKeySource{path: path}
+}
+func (fs *FileKeySource) Load(ctx context.Context) (*KeySet, error) {
+	data, err := os.ReadFile(fs.path)
+	if err != nil {
+		return nil, err
+	}
+	var set KeySet
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse key file: %w", err)
+	}
+	return &set, nil
+}
+func (fs *FileKeySource) Save(ctx context.Context, set *KeySet) error {
+	if err := os.MkdirAll(filepath.Dir(fs.path), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(set, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(fs.path, data, 0600)
+}
+type EnvKeySource struct {
+	keysVar   string
+	activeVar string
+}
+func NewEnvKeySource(keysVar, activeVar string) *EnvKeySource {
+	return &EnvKeySource{keysVar: keysVar, activeVar: activeVar}
+}
+func (es *EnvKeySource) Load(ctx context.Context) (*KeySet, error) {
+	raw := os.Getenv(es.keysVar)
+	if raw == "" {
+		return nil, fmt.Errorf("%s is not set", es.keysVar)
+	}
+	set := &KeySet{Keys: make(map[string][]byte), Active: os.Getenv(es.activeVar)}
+	for _, entry := range strings.Split(raw, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok {
+			return nil, fmt.Errorf("%s entries must be id:base64key", es.keysVar)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("key %s is not valid base64: %w", id, err)
+		}
+		set.Keys[id] = key
+		if set.Active == "" {
+			set.Active = id
+		}
+	}
+	return set, nil
+}
+type LocalKMS struct {
+	dir string
+}
+type wrappedKeySet struct {
+	Active  string            `json:"active"`
+	Keys    map[string]string `json:"wrapped_keys"`
+	Retired []string          `json:"retired,omitempty"`
+}
+func NewLocalKMS(dir string) *LocalKMS {
+	return &LocalKMS{dir: dir}
+}
+func (kms *LocalKMS) masterKey() ([]byte, error) {
+	path := filepath.Join(kms.dir, "master.key")
+	key, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		key = make([]byte, 32)
+		if _, err := io.ReadFull(rand.Reader, key); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(kms.dir, 0700); err != nil {
+			return nil, err
+		}
+		return key, writeFileAtomic(path, key, 0400)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != 32 {
+		return nil, errors.New("local KMS master key must be 32 bytes")
+	}
+	return key, nil
+}
+func (kms *LocalKMS) Load(ctx context.Context) (*KeySet, error) {
+	master, err := kms.masterKey()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(kms.dir, "keys.json"))
+	if err != nil {
+		return nil, err
+	}
+	var wrapped wrappedKeySet
+	if err := json.Unmarshal(data, &wrapped); err != nil {
+		return nil, fmt.Errorf("failed to parse wrapped keys: %w", err)
+	}
+	unwrap := newStaticKeyring("kms", master)
+	set := &KeySet{Active: wrapped.Active, Keys: make(map[string][]byte, len(wrapped.Keys)), Retired: wrapped.Retired}
+	for id, sealed := range wrapped.Keys {
+		plain, err := unwrap.Decrypt(sealed)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unwrap key %s: %w", id, err)
+		}
+		set.Keys[id] = []byte(plain)
+	}
+	return set, nil
+}
+func (kms *LocalKMS) Save(ctx context.Context, set *KeySet) error {
+	master, err := kms.masterKey()
+	if err != nil {
+		return err
+	}
+	wrap := newStaticKeyring("kms", master)
+	wrapped := wrappedKeySet{Active: set.Active, Keys: make(map[string]string, len(set.Keys)), Retired: set.Retired}
+	for id, key := range set.Keys {
+		sealed, err := wrap.Encrypt(string(key))
+		if err != nil {
+			return err
+		}
+		wrapped.Keys[id] = sealed
+	}
+	data, err := json.MarshalIndent(wrapped, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(kms.dir, "keys.json"), data, 0600)
+}
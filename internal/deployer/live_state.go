@@ -0,0 +1,239 @@
+package deployer
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+var errKubernetesNotFound = errors.New("kubernetes object not found")
+type KubectlLiveState struct {
+	Kubeconfig string
+	Context    string
+	run        func(ctx context.Context, args ...string) ([]byte, error)
+}
+func NewKubectlLiveState(kubeconfig, kubeContext string) *KubectlLiveState {
+	ls := &KubectlLiveState{Kubeconfig: kubeconfig, Context: kubeContext}
+	ls.run = ls.kubectl
+	return ls
+}
+func (ls *KubectlLiveState) kubectl(ctx context.Context, args ...string) ([]byte, error) {
+	if ls.Kubeconfig != "" {
+		args = append([]string{"--kubeconfig", ls.Kubeconfig}, args...)
+	}
+	if ls.Context != "" {
+		args = append([]string{"--context", ls.Context}, args...)
+	}
+	output, err := exec.CommandContext(ctx, "kubectl", args...).CombinedOutput()
+	if err != nil {
+		if strings.Contains(string(output), "NotFound") {
+			return nil, errKubernetesNotFound
+		}
+		return nil, fmt.Errorf("kubectl %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return output, nil
+}
+func kubernetesTarget(env *Environment) (string, string) {
+	namespace, workload := env.ProjectID, env.Name
+	if ns, ok := env.Metadata["kubernetes_namespace"].(string); ok && ns != "" {
+		namespace = ns
+	}
+	if name, ok := env.Metadata["kubernetes_workload"].(string); ok && name != "" {
+		workload = name
+	}
+	return namespace, workload
+}
+type kubeDeployment struct {
+	Spec struct {
+		Replicas *int `json:"replicas"`
+		Template struct {
+			Spec struct {
+				Containers []struct {
+					Env []struct {
+						Name      string          `json:"name"`
+						Value     string          `json:"value"`
+						ValueFrom json.RawMessage `json:"valueFrom"`
+					} `json:"env"`
+					Resources struct {
+						Requests map[string]string `json:"requests"`
+						Limits   map[string]string `json:"limits"`
+					} `json:"resources"`
+				} `json:"containers"`
+			} `json:"spec"`
+		} `json:"template"`
+	} `json:"spec"`
+}
+type kubeAutoscaler struct {
+	Spec struct {
+		MinReplicas *int `json:"minReplicas"`
+		MaxReplicas int  `json:"maxReplicas"`
+	} `json:"spec"`
+}
+type kubeIngress struct {
+	Spec struct {
+		Rules []struct {
+			Host string `json:"host"`
+		} `json:"rules"`
+	} `json:"spec"`
+}
+type kubeClaim struct {
+	Spec struct {
+		Resources struct {
+			Requests map[string]string `json:"requests"`
+		} `json:"resources"`
+	} `json:"spec"`
+}
+func (ls *KubectlLiveState) get(ctx context.Context, kind, namespace, name string, v interface{}) error {
+	output, err := ls.run(ctx, "get", kind, name, "--namespace", namespace, "--output", "json")
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(output, v); err != nil {
+		return fmt.Errorf("failed to decode %s %s/%s: %w", kind, namespace, name, err)
+	}
+	return nil
+}
+func (ls *KubectlLiveState) GetLiveEnvironment(ctx context.Context, env *Environment) (*LiveEnvironment, error) {
+	namespace, workload := kubernetesTarget(env)
+	var deployment kubeDeployment
+	if err := ls.get(ctx, "deployment", namespace, workload, &deployment); err != nil {
+		return nil, err
+	}
+	if len(deployment.Spec.Template.Spec.Containers) == 0 {
+		return nil, fmt.Errorf("deployment %s/%s has no containers", namespace, workload)
+	}
+	container := deployment.Spec.Template.Spec.Containers[0]
+	live := &LiveEnvironment{
+		Variables:  make(map[string]string),
+		ObservedAt: time.Now(),
+		Resources: ResourceAllocation{
+			MinCPU:    container.Resources.Requests["cpu"],
+			MaxCPU:    container.Resources.Limits["cpu"],
+			MinMemory: container.Resources.Requests["memory"],
+			MaxMemory: container.Resources.Limits["memory"],
+		},
+	}
+	for _, v := range container.Env {
+		if len(v.ValueFrom) == 0 {
+			live.Variables[v.Name] = v.Value
+		}
+	}
+	if deployment.Spec.Replicas != nil {
+		live.Resources.MinReplicas = *deployment.Spec.Replicas
+		live.Resources.MaxReplicas = *deployment.Spec.Replicas
+	}
+	var autoscaler kubeAutoscaler
+	switch err := ls.get(ctx, "horizontalpodautoscaler", namespace, workload, &autoscaler); {
+	case err == nil:
+		live.Resources.AutoScale = true
+		live.Resources.MinReplicas = 1
+		if autoscaler.Spec.MinReplicas != nil {
+			live.Resources.MinReplicas = *autoscaler.Spec.MinReplicas
+		}
+		live.Resources.MaxReplicas = autoscaler.Spec.MaxReplicas
+	case !errors.Is(err, errKubernetesNotFound):
+		return nil, err
+	}
+	var ingress kubeIngress
+	switch err := ls.get(ctx, "ingress", namespace, workload, &ingress); {
+	case err == nil:
+		for _, rule := range ingress.Spec.Rules {
+			if rule.Host != "" {
+				live.Domains = append(live.Domains, rule.Host)
+			}
+		}
+	case !errors.Is(err, errKubernetesNotFound):
+		return nil, err
+	}
+	var claim kubeClaim
+	switch err := ls.get(ctx, "persistentvolumeclaim", namespace, workload+"-data", &claim); {
+	case err == nil:
+		live.Resources.StorageSize = claim.Spec.Resources.Requests["storage"]
+	case !errors.Is(err, errKubernetesNotFound):
+		return nil, err
+	}
+	return live, nil
+}
+func (ls *KubectlLiveState) ApplyEnvironment(ctx context.Context, env *Environment) error {
+	live, err := ls.GetLiveEnvironment(ctx, env)
+	if err != nil {
+		return err
+	}
+	for _, field := range diffLiveEnvironment(env, live) {
+		if field.Field == "domains" || field.Field == "resources.storage_size" {
+			return fmt.Errorf("%s drift must be reconciled by reprovisioning the environment", field.Field)
+		}
+	}
+	namespace, workload := kubernetesTarget(env)
+	target := "deployment/" + workload
+	var assignments []string
+	for _, key := range sortedKeys(env.Variables) {
+		if live.Variables[key] != env.Variables[key] {
+			assignments = append(assignments, key+"="+env.Variables[key])
+		}
+	}
+	for _, key := range sortedKeys(live.Variables) {
+		if _, ok := env.Variables[key]; !ok {
+			assignments = append(assignments, key+"-")
+		}
+	}
+	if len(assignments) > 0 {
+		args := append([]string{"set", "env", target, "--namespace", namespace}, assignments...)
+		if _, err := ls.run(ctx, args...); err != nil {
+			return err
+		}
+	}
+	if env.Resources != live.Resources {
+		var limits []string
+		if requests := resourceList(env.Resources.MinCPU, env.Resources.MinMemory); requests != "" {
+			limits = append(limits, "--requests="+requests)
+		}
+		if ceiling := resourceList(env.Resources.MaxCPU, env.Resources.MaxMemory); ceiling != "" {
+			limits = append(limits, "--limits="+ceiling)
+		}
+		if len(limits) > 0 {
+			args := append([]string{"set", "resources", target, "--namespace", namespace}, limits...)
+			if _, err := ls.run(ctx, args...); err != nil {
+				return err
+			}
+		}
+		if err := ls.applyReplicas(ctx, namespace, workload, env.Resources, live.Resources); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+func (ls *KubectlLiveState) applyReplicas(ctx context.Context, namespace, workload string, desired, live ResourceAllocation) error {
+	if desired.MinReplicas <= 0 {
+		return fmt.Errorf("environment does not declare a replica count for %s/%s", namespace, workload)
+	}
+	if !desired.AutoScale {
+		if live.AutoScale {
+			if _, err := ls.run(ctx, "delete", "horizontalpodautoscaler", workload, "--namespace", namespace); err != nil {
+				return err
+			}
+		}
+		_, err := ls.run(ctx, "scale", "deployment/"+workload, "--namespace", namespace, fmt.Sprintf("--replicas=%d", desired.MinReplicas))
+		return err
+	}
+	if !live.AutoScale {
+		_, err := ls.run(ctx, "autoscale", "deployment/"+workload, "--namespace", namespace,
+			fmt.Sprintf("--min=%d", desired.MinReplicas), fmt.Sprintf("--max=%d", desired.MaxReplicas))
+		return err
+	}
+	patch := fmt.Sprintf(`{"spec":{"minReplicas":%d,"maxReplicas":%d}}`, desired.MinReplicas, desired.MaxReplicas)
+	_, err := ls.run(ctx, "patch", "horizontalpodautoscaler", workload, "--namespace", namespace, "--type", "merge", "--patch", patch)
+	return err
+}
+func resourceList(cpu, memory string) string {
+	var parts []string
+	if cpu != "" {
+		parts = append(parts, "cpu="+cpu)
+	}
+	if memory != "" {
+		parts = append(parts, "memory="+memory)
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,82 @@
+package deployer
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+type fakeKubectl struct {
+	objects map[string]string
+	calls   []string
+}
+func (f *fakeKubectl) run(ctx context.Context, args ...string) ([]byte, error) {
+	call := strings.Join(args, " ")
+	f.calls = append(f.calls, call)
+	if args[0] != "get" {
+		return nil, nil
+	}
+	object, ok := f.objects[args[1]+"/"+args[2]]
+	if !ok {
+		return nil, errKubernetesNotFound
+	}
+	return []byte(object), nil
+}
+func kubectlEnvironment() *Environment {
+	return &Environment{
+		ProjectID: "shop",
+		Name:      "production",
+		Variables: map[string]string{"LOG_LEVEL": "info", "REGION": "eu"},
+		Domains:   []string{"shop.example.com"},
+		Resources: ResourceAllocation{MinCPU: "250m", MaxCPU: "1", MinMemory: "256Mi", MaxMemory: "1Gi", MinReplicas: 2, MaxReplicas: 6, AutoScale: true},
+	}
+}
+func TestKubectlLiveStateReadsWorkload(t *testing.T) {
+	kube := &fakeKubectl{objects: map[string]string{
+		"deployment/production":              `{"spec":{"replicas":3,"template":{"spec":{"containers":[{"env":[{"name":"LOG_LEVEL","value":"debug"},{"name":"DB_PASSWORD","valueFrom":{"secretKeyRef":{"name":"db","key":"password"}}},{"name":"EXTRA","value":"1"}],"resources":{"requests":{"cpu":"250m","memory":"256Mi"},"limits":{"cpu":"1","memory":"1Gi"}}}]}}}}`,
+		"horizontalpodautoscaler/production": `{"spec":{"minReplicas":2,"maxReplicas":6}}`,
+		"ingress/production":                 `{"spec":{"rules":[{"host":"shop.example.com"}]}}`,
+	}}
+	ls := &KubectlLiveState{run: kube.run}
+	env := kubectlEnvironment()
+	live, err := ls.GetLiveEnvironment(context.Background(), env)
+	if err != nil {
+		t.Fatalf("GetLiveEnvironment: %v", err)
+	}
+	if _, ok := live.Variables["DB_PASSWORD"]; ok {
+		t.Fatal("secret-backed variables must not be reported as plain variables")
+	}
+	if live.Resources != env.Resources {
+		t.Fatalf("unexpected live resources %+v", live.Resources)
+	}
+	var drifted []string
+	for _, field := range diffLiveEnvironment(env, live) {
+		drifted = append(drifted, fmt.Sprintf("%s:%s", field.Field, field.Kind))
+	}
+	sort.Strings(drifted)
+	if want := "[variables.EXTRA:unexpected variables.LOG_LEVEL:changed variables.REGION:missing]"; fmt.Sprint(drifted) != want {
+		t.Fatalf("expected %s, got %v", want, drifted)
+	}
+	if err := ls.ApplyEnvironment(context.Background(), env); err != nil {
+		t.Fatalf("ApplyEnvironment: %v", err)
+	}
+	want := "set env deployment/production --namespace shop LOG_LEVEL=info REGION=eu EXTRA-"
+	if last := kube.calls[len(kube.calls)-1]; last != want {
+		t.Fatalf("expected %q, got %q", want, last)
+	}
+}
+func TestKubectlLiveStateRefusesDomainReconcile(t *testing.T) {
+	kube := &fakeKubectl{objects: map[string]string{
+		"deployment/production": `{"spec":{"replicas":2,"template":{"spec":{"containers":[{"env":[]}]}}}}`,
+	}}
+	ls := &KubectlLiveState{run: kube.run}
+	err := ls.ApplyEnvironment(context.Background(), kubectlEnvironment())
+	if err == nil || !strings.Contains(err.Error(), "domains drift") {
+		t.Fatalf("expected domain drift to be refused, got %v", err)
+	}
+	for _, call := range kube.calls {
+		if !strings.HasPrefix(call, "get ") {
+			t.Fatalf("expected no changes to be applied, got %q", call)
+		}
+	}
+}
@@ -3,13 +3,21 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
 	"time"
 )
+const (
+	previewClaimLease         = 5 * time.Minute
+	previewBasicAuthSecretKey = "PREVIEW_BASIC_AUTH_PASSWORD"
+)
 type PreviewEnvironment struct {
 	ID             string             `json:"id"`
 	ProjectID      string             `json:"project_id"`
+	EnvironmentID  string             `json:"environment_id,omitempty"`
 	PullRequestID  string             `json:"pull_request_id"`
 	Branch         string             `json:"branch"`
 	URL            string             `json:"url"`
@@ -28,8 +36,9 @@ type PreviewEnvironment struct {
 	Metadata       map[string]string  `json:"metadata"`
 }
 type BasicAuth struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username  string `json:"username"`
+	Password  string `json:"-"`
+	SecretKey string `json:"secret_key"`
 }
 type PreviewManager struct {
 	envManager    *EnvironmentManager
@@ -37,6 +46,8 @@ type PreviewManager struct {
 	sslProvider   SSLProvider
 	dbSeeder      DatabaseSeeder
 	serviceMocker ServiceMocker
+	store         PreviewStore
+	owner         string
 }
 type DNSProvider interface {
 	CreateRecord(ctx context.Context, subdomain, target string) error
@@ -62,17 +73,30 @@ func NewPreviewManager(
 	dbSeeder DatabaseSeeder,
 	serviceMocker ServiceMocker,
 ) *PreviewManager {
+	return NewPreviewManagerWithStore(envManager, dnsProvider, sslProvider, dbSeeder, serviceMocker, NewFilePreviewStore(filepath.Join(envManager.storagePath, "previews")))
+}
+func NewPreviewManagerWithStore(
+	envManager *EnvironmentManager,
+	dnsProvider DNSProvider,
+	sslProvider SSLProvider,
+	dbSeeder DatabaseSeeder,
+	serviceMocker ServiceMocker,
+	store PreviewStore,
+) *PreviewManager {
+	hostname, _ := os.Hostname()
 	return &PreviewManager{
 		envManager:    envManager,
 		dnsProvider:   dnsProvider,
 		sslProvider:   sslProvider,
 		dbSeeder:      dbSeeder,
 		serviceMocker: serviceMocker,
+		store:         store,
+		owner:         fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), generateRandomPassword(8)),
 	}
 }
 func (pm *PreviewManager) CreatePreviewEnvironment(ctx context.Context, config *PreviewEnvironmentConfig) (*PreviewEnvironment, error) {
 	subdomain := pm.generateSubdomain(config.ProjectID, config.PullRequestID)
-	url := fmt.Sprintf("https:
+	url := fmt.Sprintf("https://%s.preview.opsagent.dev", subdomain)
 	preview := &PreviewEnvironment{
 		ID:             generatePreviewID(),
 		ProjectID:      config.ProjectID,
@@ -100,18 +124,27 @@ func (pm *PreviewManager) CreatePreviewEnvironment(ctx context.Context, config *
 		},
 		Metadata: make(map[string]string),
 	}
+	if err := pm.store.Save(ctx, preview); err != nil {
+		return nil, fmt.Errorf("failed to register preview environment: %w", err)
+	}
+	secrets := make(map[string]string, len(config.Secrets)+1)
+	for key, value := range config.Secrets {
+		secrets[key] = value
+	}
 	if config.ProtectWithAuth {
 		preview.BasicAuth = &BasicAuth{
-			Username: "preview",
-			Password: generateRandomPassword(16),
+			Username:  "preview",
+			Password:  generateRandomPassword(16),
+			SecretKey: previewBasicAuthSecretKey,
 		}
+		secrets[previewBasicAuthSecretKey] = preview.BasicAuth.Password
 	}
 	env := &Environment{
 		Name:      fmt.Sprintf("preview-%s", preview.ID),
 		Type:      EnvironmentPreview,
 		ProjectID: config.ProjectID,
 		Variables: config.EnvVars,
-		Secrets:   config.Secrets,
+		Secrets:   secrets,
 		Domains:   []string{url},
 		Resources: preview.Resources,
 		Metadata: map[string]interface{}{
@@ -121,41 +154,90 @@ func (pm *PreviewManager) CreatePreviewEnvironment(ctx context.Context, config *
 		},
 	}
 	if err := pm.envManager.CreateEnvironment(ctx, env); err != nil {
-		return nil, fmt.Errorf("failed to create environment: %w", err)
+		return nil, pm.fail(ctx, preview, fmt.Errorf("failed to create environment: %w", err))
 	}
+	preview.EnvironmentID = env.ID
 	if err := pm.dnsProvider.CreateRecord(ctx, subdomain, config.TargetIP); err != nil {
-		return nil, fmt.Errorf("failed to create DNS record: %w", err)
+		return nil, pm.fail(ctx, preview, fmt.Errorf("failed to create DNS record: %w", err))
 	}
 	if preview.SSL {
 		if err := pm.sslProvider.IssueCertificate(ctx, url); err != nil {
-			return nil, fmt.Errorf("failed to issue SSL certificate: %w", err)
+			return nil, pm.fail(ctx, preview, fmt.Errorf("failed to issue SSL certificate: %w", err))
 		}
 	}
 	if config.SeedDatabase {
 		if err := pm.dbSeeder.SeedDatabase(ctx, config.DatabaseURL, config.SanitizeData); err != nil {
-			return nil, fmt.Errorf("failed to seed database: %w", err)
+			return nil, pm.fail(ctx, preview, fmt.Errorf("failed to seed database: %w", err))
 		}
 		preview.DatabaseSeeded = true
 	}
 	for _, service := range config.MockServices {
 		mockURL, err := pm.serviceMocker.MockService(ctx, service, config.ServiceEndpoints[service])
 		if err != nil {
-			return nil, fmt.Errorf("failed to mock service %s: %w", service, err)
+			return nil, pm.fail(ctx, preview, fmt.Errorf("failed to mock service %s: %w", service, err))
 		}
 		preview.MockedServices = append(preview.MockedServices, service)
 		preview.Metadata[fmt.Sprintf("mock_%s_url", service)] = mockURL
 	}
 	preview.Status = "active"
 	preview.UpdatedAt = time.Now()
+	if err := pm.store.Save(ctx, preview); err != nil {
+		return nil, fmt.Errorf("failed to register preview environment: %w", err)
+	}
 	return preview, nil
 }
+func (pm *PreviewManager) fail(ctx context.Context, preview *PreviewEnvironment, err error) error {
+	preview.Status = "failed"
+	preview.UpdatedAt = time.Now()
+	preview.Metadata["error"] = err.Error()
+	if saveErr := pm.store.Save(ctx, preview); saveErr != nil {
+		fmt.Printf("Warning: failed to record preview failure for %s: %v\n", preview.ID, saveErr)
+	}
+	return err
+}
+func (pm *PreviewManager) GetPreviewEnvironment(ctx context.Context, previewID string) (*PreviewEnvironment, error) {
+	return pm.store.Get(ctx, previewID)
+}
+func (pm *PreviewManager) ListPreviewEnvironments(ctx context.Context, projectID string) ([]*PreviewEnvironment, error) {
+	return pm.store.List(ctx, projectID)
+}
+func (pm *PreviewManager) TouchPreviewEnvironment(ctx context.Context, previewID string) error {
+	preview, err := pm.store.Get(ctx, previewID)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	preview.LastAccessedAt = &now
+	preview.UpdatedAt = now
+	return pm.store.Save(ctx, preview)
+}
 func (pm *PreviewManager) UpdatePreviewEnvironment(ctx context.Context, previewID, commitSHA string) error {
+	preview, err := pm.store.Get(ctx, previewID)
+	if err != nil {
+		return err
+	}
 	fmt.Printf("📦 Updating preview environment %s with commit %s\n", previewID, commitSHA[:7])
 	time.Sleep(5 * time.Second)
+	preview.Metadata["commit"] = commitSHA
+	preview.UpdatedAt = time.Now()
+	if err := pm.store.Save(ctx, preview); err != nil {
+		return err
+	}
 	fmt.Printf("✅ Preview environment updated successfully\n")
 	return nil
 }
-func (pm *PreviewManager) DeletePreviewEnvironment(ctx context.Context, previewID string, preview *PreviewEnvironment) error {
+func (pm *PreviewManager) DeletePreviewEnvironment(ctx context.Context, previewID string) error {
+	preview, err := pm.store.Get(ctx, previewID)
+	if err != nil {
+		return err
+	}
+	err = pm.deletePreview(ctx, preview)
+	if saveErr := pm.store.Save(ctx, preview); saveErr != nil && err == nil {
+		err = saveErr
+	}
+	return err
+}
+func (pm *PreviewManager) deletePreview(ctx context.Context, preview *PreviewEnvironment) error {
 	preview.Status = "deleting"
 	for _, service := range preview.MockedServices {
 		if err := pm.serviceMocker.UnmockService(ctx, service); err != nil {
@@ -168,62 +250,129 @@ func (pm *PreviewManager) DeletePreviewEnvironment(ctx context.Context, previewI
 		}
 	}
 	subdomain := strings.Split(preview.URL, ".")[0]
-	subdomain = strings.TrimPrefix(subdomain, "https:
+	subdomain = strings.TrimPrefix(subdomain, "https://")
 	if err := pm.dnsProvider.DeleteRecord(ctx, subdomain); err != nil {
 		fmt.Printf("Warning: failed to delete DNS record: %v\n", err)
 	}
-	envName := fmt.Sprintf("preview-%s", preview.ID)
-	if err := pm.envManager.DeleteEnvironment(ctx, envName); err != nil {
-		return fmt.Errorf("failed to delete environment: %w", err)
+	envID := preview.EnvironmentID
+	if envID == "" {
+		if env, err := pm.envManager.findEnvironment(preview.ProjectID, fmt.Sprintf("preview-%s", preview.ID)); err == nil {
+			envID = env.ID
+		}
+	}
+	if envID != "" {
+		if err := pm.envManager.DeleteEnvironment(ctx, envID); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to delete environment: %w", err)
+		}
 	}
 	preview.Status = "deleted"
+	preview.UpdatedAt = time.Now()
 	return nil
 }
-func (pm *PreviewManager) SleepPreviewEnvironment(ctx context.Context, previewID string, preview *PreviewEnvironment) error {
+func (pm *PreviewManager) SleepPreviewEnvironment(ctx context.Context, previewID string) error {
+	preview, err := pm.store.Get(ctx, previewID)
+	if err != nil {
+		return err
+	}
+	pm.sleepPreview(preview)
+	return pm.store.Save(ctx, preview)
+}
+func (pm *PreviewManager) sleepPreview(preview *PreviewEnvironment) {
 	preview.Status = "sleeping"
 	preview.UpdatedAt = time.Now()
 	preview.Resources.MinReplicas = 0
 	preview.Resources.MaxReplicas = 0
-	fmt.Printf("💤 Preview environment %s is now sleeping\n", previewID)
-	return nil
+	fmt.Printf("💤 Preview environment %s is now sleeping\n", preview.ID)
 }
-func (pm *PreviewManager) WakePreviewEnvironment(ctx context.Context, previewID string, preview *PreviewEnvironment) error {
+func (pm *PreviewManager) WakePreviewEnvironment(ctx context.Context, previewID string) error {
+	preview, err := pm.store.Get(ctx, previewID)
+	if err != nil {
+		return err
+	}
+	if preview.Status == "deleted" || preview.Status == "deleting" {
+		return fmt.Errorf("preview environment %s has been deleted", previewID)
+	}
 	preview.Status = "active"
 	preview.UpdatedAt = time.Now()
 	now := time.Now()
 	preview.LastAccessedAt = &now
 	preview.Resources.MinReplicas = 1
 	preview.Resources.MaxReplicas = 1
+	if err := pm.store.Save(ctx, preview); err != nil {
+		return err
+	}
 	fmt.Printf("🌅 Preview environment %s is now awake\n", previewID)
 	return nil
 }
-func (pm *PreviewManager) MonitorPreviewEnvironments(ctx context.Context, previews []*PreviewEnvironment) error {
-	for _, preview := range previews {
-		if preview.Status == "deleted" {
+func (pm *PreviewManager) MonitorPreviewEnvironments(ctx context.Context) (int, error) {
+	now := time.Now()
+	claimed, err := pm.store.Claim(ctx, pm.owner, now, previewClaimLease, 0)
+	if err != nil {
+		return 0, err
+	}
+	var errs []error
+	reaped := 0
+	for _, claim := range claimed {
+		preview, err := pm.store.Get(ctx, claim.ID)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("preview %s: %w", claim.ID, err))
 			continue
 		}
-		now := time.Now()
-		if preview.Status == "active" && preview.SleepAfter > 0 {
-			lastAccessed := preview.UpdatedAt
-			if preview.LastAccessedAt != nil {
-				lastAccessed = *preview.LastAccessedAt
-			}
-			if now.Sub(lastAccessed) > preview.SleepAfter {
-				if err := pm.SleepPreviewEnvironment(ctx, preview.ID, preview); err != nil {
-					fmt.Printf("Error sleeping preview %s: %v\n", preview.ID, err)
-				}
-			}
+		observed := preview.UpdatedAt
+		if err := pm.reapPreview(ctx, preview, now); err != nil {
+			errs = append(errs, fmt.Errorf("preview %s: %w", preview.ID, err))
+		} else {
+			reaped++
 		}
-		if preview.AutoDelete && preview.DeleteAfter > 0 {
-			if now.Sub(preview.CreatedAt) > preview.DeleteAfter {
-				if err := pm.DeletePreviewEnvironment(ctx, preview.ID, preview); err != nil {
-					fmt.Printf("Error deleting preview %s: %v\n", preview.ID, err)
-				}
-			}
+		err = pm.store.Release(ctx, preview, pm.owner, observed)
+		switch {
+		case errors.Is(err, ErrPreviewChanged) && preview.Status == "deleted":
+			err = pm.recordDeletion(ctx, preview)
+		case errors.Is(err, ErrPreviewChanged):
+			fmt.Printf("⚠️  Preview environment %s changed while it was being reaped; leaving it for the next pass\n", preview.ID)
+			err = nil
+		}
+		if err != nil {
+			errs = append(errs, err)
 		}
 	}
+	return reaped, errors.Join(errs...)
+}
+func (pm *PreviewManager) recordDeletion(ctx context.Context, deleted *PreviewEnvironment) error {
+	preview, err := pm.store.Get(ctx, deleted.ID)
+	if err != nil {
+		return err
+	}
+	preview.Status = deleted.Status
+	preview.UpdatedAt = deleted.UpdatedAt
+	return pm.store.Save(ctx, preview)
+}
+func (pm *PreviewManager) reapPreview(ctx context.Context, preview *PreviewEnvironment, now time.Time) error {
+	if preview.AutoDelete && preview.DeleteAfter > 0 && now.Sub(preview.CreatedAt) > preview.DeleteAfter {
+		return pm.deletePreview(ctx, preview)
+	}
+	if preview.Status == "active" && preview.SleepAfter > 0 && now.Sub(preview.lastAccessed()) > preview.SleepAfter {
+		pm.sleepPreview(preview)
+	}
 	return nil
 }
+func (pm *PreviewManager) RunReaper(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := pm.MonitorPreviewEnvironments(ctx); err != nil {
+				fmt.Printf("⚠️  Preview reaper: %v\n", err)
+			}
+		}
+	}
+}
 func (pm *PreviewManager) CompareWithProduction(ctx context.Context, previewID string, monitor DeploymentMonitor) (*PerformanceComparison, error) {
 	previewMetrics, err := monitor.GetMetrics(ctx, previewID)
 	if err != nil {
@@ -0,0 +1,194 @@
+package deployer
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+var (
+	ErrPreviewNotFound  = errors.New("preview environment not found")
+	ErrPreviewClaimLost = errors.New("preview environment claim lost")
+	ErrPreviewChanged   = errors.New("preview environment changed during claim")
+)
+type PreviewStore interface {
+	Save(ctx context.Context, preview *PreviewEnvironment) error
+	Get(ctx context.Context, previewID string) (*PreviewEnvironment, error)
+	List(ctx context.Context, projectID string) ([]*PreviewEnvironment, error)
+	Claim(ctx context.Context, owner string, now time.Time, lease time.Duration, limit int) ([]*PreviewEnvironment, error)
+	Release(ctx context.Context, preview *PreviewEnvironment, owner string, observed time.Time) error
+}
+func (p *PreviewEnvironment) lastAccessed() time.Time {
+	if p.LastAccessedAt != nil && p.LastAccessedAt.After(p.UpdatedAt) {
+		return *p.LastAccessedAt
+	}
+	return p.UpdatedAt
+}
+func (p *PreviewEnvironment) reapAt() *time.Time {
+	var next *time.Time
+	consider := func(at time.Time) {
+		if next == nil || at.Before(*next) {
+			next = &at
+		}
+	}
+	if p.Status == "deleted" {
+		return nil
+	}
+	if p.Status == "active" && p.SleepAfter > 0 {
+		consider(p.lastAccessed().Add(p.SleepAfter))
+	}
+	if p.AutoDelete && p.DeleteAfter > 0 {
+		consider(p.CreatedAt.Add(p.DeleteAfter))
+	}
+	return next
+}
+func (p *PreviewEnvironment) due(now time.Time) bool {
+	at := p.reapAt()
+	return at != nil && !at.After(now)
+}
+type FilePreviewStore struct {
+	storagePath string
+}
+func NewFilePreviewStore(storagePath string) *FilePreviewStore {
+	return &FilePreviewStore{
+		storagePath: storagePath,
+	}
+}
+func (fs *FilePreviewStore) path(previewID string) string {
+	return filepath.Join(fs.storagePath, previewID+".json")
+}
+func (fs *FilePreviewStore) claimPath(previewID string) string {
+	return filepath.Join(fs.storagePath, previewID+".claim")
+}
+func (fs *FilePreviewStore) lockPath(previewID string) string {
+	return filepath.Join(fs.storagePath, "locks", previewID+".lock")
+}
+func (fs *FilePreviewStore) Save(ctx context.Context, preview *PreviewEnvironment) error {
+	unlock, err := lockFile(fs.lockPath(preview.ID))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return fs.write(preview)
+}
+func (fs *FilePreviewStore) write(preview *PreviewEnvironment) error {
+	if err := os.MkdirAll(fs.storagePath, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(preview, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(fs.path(preview.ID), data, 0600)
+}
+func (fs *FilePreviewStore) Get(ctx context.Context, previewID string) (*PreviewEnvironment, error) {
+	data, err := os.ReadFile(fs.path(previewID))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrPreviewNotFound, previewID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var preview PreviewEnvironment
+	if err := json.Unmarshal(data, &preview); err != nil {
+		return nil, err
+	}
+	return &preview, nil
+}
+func (fs *FilePreviewStore) List(ctx context.Context, projectID string) ([]*PreviewEnvironment, error) {
+	files, err := os.ReadDir(fs.storagePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var previews []*PreviewEnvironment
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+		preview, err := fs.Get(ctx, strings.TrimSuffix(file.Name(), ".json"))
+		if err != nil {
+			continue
+		}
+		if projectID == "" || preview.ProjectID == projectID {
+			previews = append(previews, preview)
+		}
+	}
+	sort.Slice(previews, func(i, j int) bool { return previews[i].CreatedAt.Before(previews[j].CreatedAt) })
+	return previews, nil
+}
+func (fs *FilePreviewStore) Claim(ctx context.Context, owner string, now time.Time, lease time.Duration, limit int) ([]*PreviewEnvironment, error) {
+	previews, err := fs.List(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	var claimed []*PreviewEnvironment
+	for _, preview := range previews {
+		if limit > 0 && len(claimed) >= limit {
+			break
+		}
+		if !preview.due(now) || !fs.acquire(preview.ID, owner, now, lease) {
+			continue
+		}
+		claimed = append(claimed, preview)
+	}
+	return claimed, nil
+}
+func (fs *FilePreviewStore) acquire(previewID, owner string, now time.Time, lease time.Duration) bool {
+	unlock, err := lockFile(fs.lockPath(previewID))
+	if err != nil {
+		return false
+	}
+	defer unlock()
+	holder, expires, err := fs.readClaim(previewID)
+	if err == nil && (holder == owner || now.Before(expires)) {
+		return false
+	}
+	claim := fmt.Sprintf("%s\n%d\n", owner, now.Add(lease).UnixNano())
+	return writeFileAtomic(fs.claimPath(previewID), []byte(claim), 0600) == nil
+}
+func (fs *FilePreviewStore) readClaim(previewID string) (string, time.Time, error) {
+	data, err := os.ReadFile(fs.claimPath(previewID))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		return "", time.Time{}, fmt.Errorf("malformed claim for %s", previewID)
+	}
+	nanos, err := strconv.ParseInt(lines[1], 10, 64)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return lines[0], time.Unix(0, nanos), nil
+}
+func (fs *FilePreviewStore) Release(ctx context.Context, preview *PreviewEnvironment, owner string, observed time.Time) error {
+	unlock, err := lockFile(fs.lockPath(preview.ID))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	holder, _, err := fs.readClaim(preview.ID)
+	if err != nil || holder != owner {
+		return fmt.Errorf("%w: %s", ErrPreviewClaimLost, preview.ID)
+	}
+	current, err := fs.Get(ctx, preview.ID)
+	switch {
+	case err != nil:
+	case current.UpdatedAt.Equal(observed):
+		err = fs.write(preview)
+	default:
+		err = fmt.Errorf("%w: %s was updated at %s", ErrPreviewChanged, preview.ID, current.UpdatedAt.Format(time.RFC3339Nano))
+	}
+	if removeErr := os.Remove(fs.claimPath(preview.ID)); removeErr != nil && err == nil {
+		err = removeErr
+	}
+	return err
+}
@@ -0,0 +1,132 @@
+package deployer
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+type PostgresPreviewStore struct {
+	db *sql.DB
+}
+func NewPostgresPreviewStore(db *sql.DB) *PostgresPreviewStore {
+	return &PostgresPreviewStore{db: db}
+}
+func (ps *PostgresPreviewStore) Save(ctx context.Context, preview *PreviewEnvironment) error {
+	data, err := json.Marshal(preview)
+	if err != nil {
+		return fmt.Errorf("failed to marshal preview environment: %w", err)
+	}
+	_, err = ps.db.ExecContext(ctx, `
+		INSERT INTO preview_environments (id, project_id, pull_request_id, status, reap_at, record)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (id) DO UPDATE SET
+			project_id = EXCLUDED.project_id,
+			pull_request_id = EXCLUDED.pull_request_id,
+			status = EXCLUDED.status,
+			reap_at = EXCLUDED.reap_at,
+			record = EXCLUDED.record,
+			updated_at = NOW()
+	`, preview.ID, preview.ProjectID, preview.PullRequestID, preview.Status, preview.reapAt(), data)
+	if err != nil {
+		return fmt.Errorf("failed to save preview environment: %w", err)
+	}
+	return nil
+}
+func (ps *PostgresPreviewStore) Get(ctx context.Context, previewID string) (*PreviewEnvironment, error) {
+	var data []byte
+	err := ps.db.QueryRowContext(ctx, `SELECT record FROM preview_environments WHERE id = $1`, previewID).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: %s", ErrPreviewNotFound, previewID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get preview environment: %w", err)
+	}
+	var preview PreviewEnvironment
+	if err := json.Unmarshal(data, &preview); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal preview environment: %w", err)
+	}
+	return &preview, nil
+}
+func (ps *PostgresPreviewStore) List(ctx context.Context, projectID string) ([]*PreviewEnvironment, error) {
+	rows, err := ps.db.QueryContext(ctx, `
+		SELECT record FROM preview_environments
+		WHERE $1 = '' OR project_id = $1
+		ORDER BY created_at, id
+	`, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list preview environments: %w", err)
+	}
+	return scanPreviews(rows)
+}
+func (ps *PostgresPreviewStore) Claim(ctx context.Context, owner string, now time.Time, lease time.Duration, limit int) ([]*PreviewEnvironment, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	rows, err := ps.db.QueryContext(ctx, `
+		UPDATE preview_environments
+		SET claimed_by = $1, claim_expires_at = $2
+		WHERE id IN (
+			SELECT id FROM preview_environments
+			WHERE status <> 'deleted'
+				AND reap_at <= $3
+				AND (claimed_by IS NULL OR claim_expires_at < $3)
+			ORDER BY reap_at
+			LIMIT $4
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING record
+	`, owner, now.Add(lease), now, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim preview environments: %w", err)
+	}
+	return scanPreviews(rows)
+}
+func (ps *PostgresPreviewStore) Release(ctx context.Context, preview *PreviewEnvironment, owner string, observed time.Time) error {
+	data, err := json.Marshal(preview)
+	if err != nil {
+		return fmt.Errorf("failed to marshal preview environment: %w", err)
+	}
+	result, err := ps.db.ExecContext(ctx, `
+		UPDATE preview_environments
+		SET status = $3, reap_at = $4, record = $5, claimed_by = NULL, claim_expires_at = NULL, updated_at = NOW()
+		WHERE id = $1 AND claimed_by = $2 AND record->>'updated_at' = $6
+	`, preview.ID, owner, preview.Status, preview.reapAt(), data, observed.Format(time.RFC3339Nano))
+	if err != nil {
+		return fmt.Errorf("failed to release preview environment: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	result, err = ps.db.ExecContext(ctx, `
+		UPDATE preview_environments
+		SET claimed_by = NULL, claim_expires_at = NULL
+		WHERE id = $1 AND claimed_by = $2
+	`, preview.ID, owner)
+	if err != nil {
+		return fmt.Errorf("failed to release preview environment: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%w: %s", ErrPreviewClaimLost, preview.ID)
+	}
+	return fmt.Errorf("%w: %s", ErrPreviewChanged, preview.ID)
+}
+func scanPreviews(rows *sql.Rows) ([]*PreviewEnvironment, error) {
+	defer rows.Close()
+	var previews []*PreviewEnvironment
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to scan preview environment: %w", err)
+		}
+		var preview PreviewEnvironment
+		if err := json.Unmarshal(data, &preview); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal preview environment: %w", err)
+		}
+		previews = append(previews, &preview)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list preview environments: %w", err)
+	}
+	return previews, nil
+}
@@ -0,0 +1,169 @@
+package deployer
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+func duePreview(t *testing.T, store PreviewStore, now time.Time) *PreviewEnvironment {
+	t.Helper()
+	preview := &PreviewEnvironment{
+		ID:         "preview_1",
+		ProjectID:  "proj",
+		Status:     "active",
+		CreatedAt:  now.Add(-3 * time.Hour),
+		UpdatedAt:  now.Add(-2 * time.Hour),
+		SleepAfter: time.Hour,
+		Metadata:   map[string]string{},
+	}
+	if err := store.Save(context.Background(), preview); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	return preview
+}
+func touchPreview(t *testing.T, store PreviewStore, previewID string, at time.Time) {
+	t.Helper()
+	preview, err := store.Get(context.Background(), previewID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	preview.LastAccessedAt = &at
+	preview.UpdatedAt = at
+	if err := store.Save(context.Background(), preview); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+}
+func TestFilePreviewStoreClaimLease(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := NewFilePreviewStore(t.TempDir())
+	preview := duePreview(t, store, now)
+	claimed, err := store.Claim(ctx, "a", now, time.Minute, 0)
+	if err != nil || len(claimed) != 1 {
+		t.Fatalf("expected a to claim the preview, got %d claims: %v", len(claimed), err)
+	}
+	if claimed, _ := store.Claim(ctx, "b", now.Add(30*time.Second), time.Minute, 0); len(claimed) != 0 {
+		t.Fatalf("expected the live lease to block b, got %d claims", len(claimed))
+	}
+	if claimed, _ := store.Claim(ctx, "b", now.Add(2*time.Minute), time.Minute, 0); len(claimed) != 1 {
+		t.Fatalf("expected b to take over the expired lease, got %d claims", len(claimed))
+	}
+	if err := store.Release(ctx, preview, "a", preview.UpdatedAt); !errors.Is(err, ErrPreviewClaimLost) {
+		t.Fatalf("expected a's release to report a lost claim, got %v", err)
+	}
+	if err := store.Release(ctx, preview, "b", preview.UpdatedAt); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+}
+func TestFilePreviewStoreExpiredClaimHasOneTaker(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := NewFilePreviewStore(t.TempDir())
+	duePreview(t, store, now)
+	if claimed, _ := store.Claim(ctx, "stale", now, time.Minute, 0); len(claimed) != 1 {
+		t.Fatal("expected the first claim to succeed")
+	}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	takers := 0
+	for _, owner := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		wg.Add(1)
+		go func(owner string) {
+			defer wg.Done()
+			claimed, err := store.Claim(ctx, owner, now.Add(2*time.Minute), time.Minute, 0)
+			if err != nil {
+				t.Errorf("Claim: %v", err)
+			}
+			mu.Lock()
+			takers += len(claimed)
+			mu.Unlock()
+		}(owner)
+	}
+	wg.Wait()
+	if takers != 1 {
+		t.Fatalf("expected exactly one replica to take over the expired claim, got %d", takers)
+	}
+}
+func TestFilePreviewStoreReleaseKeepsConcurrentUpdates(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := NewFilePreviewStore(t.TempDir())
+	preview := duePreview(t, store, now)
+	if claimed, _ := store.Claim(ctx, "a", now, time.Minute, 0); len(claimed) != 1 {
+		t.Fatal("expected the claim to succeed")
+	}
+	observed := preview.UpdatedAt
+	touchPreview(t, store, preview.ID, now)
+	preview.Status = "sleeping"
+	if err := store.Release(ctx, preview, "a", observed); !errors.Is(err, ErrPreviewChanged) {
+		t.Fatalf("expected the release to detect the touch, got %v", err)
+	}
+	stored, err := store.Get(ctx, preview.ID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if stored.Status != "active" || stored.LastAccessedAt == nil {
+		t.Fatalf("expected the touch to survive the release, got status %s", stored.Status)
+	}
+	if claimed, _ := store.Claim(ctx, "b", now, time.Minute, 0); len(claimed) != 0 {
+		t.Fatal("expected the touched preview to no longer be due")
+	}
+}
+type hookedPreviewStore struct {
+	PreviewStore
+	afterClaim func()
+	afterGet   func()
+}
+func (s *hookedPreviewStore) Claim(ctx context.Context, owner string, now time.Time, lease time.Duration, limit int) ([]*PreviewEnvironment, error) {
+	claimed, err := s.PreviewStore.Claim(ctx, owner, now, lease, limit)
+	if s.afterClaim != nil {
+		s.afterClaim()
+	}
+	return claimed, err
+}
+func (s *hookedPreviewStore) Get(ctx context.Context, previewID string) (*PreviewEnvironment, error) {
+	preview, err := s.PreviewStore.Get(ctx, previewID)
+	if s.afterGet != nil {
+		hook := s.afterGet
+		s.afterGet = nil
+		hook()
+	}
+	return preview, err
+}
+func TestMonitorPreviewEnvironmentsKeepsTouches(t *testing.T) {
+	tests := []struct {
+		name  string
+		claim bool
+	}{
+		{name: "touched before the re-read", claim: true},
+		{name: "touched after the re-read", claim: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := NewFilePreviewStore(t.TempDir())
+			preview := duePreview(t, files, time.Now())
+			store := &hookedPreviewStore{PreviewStore: files}
+			touch := func() { touchPreview(t, files, preview.ID, time.Now()) }
+			if tt.claim {
+				store.afterClaim = touch
+			} else {
+				store.afterGet = touch
+			}
+			pm := NewPreviewManagerWithStore(nil, nil, nil, nil, nil, store)
+			if _, err := pm.MonitorPreviewEnvironments(context.Background()); err != nil {
+				t.Fatalf("MonitorPreviewEnvironments: %v", err)
+			}
+			stored, err := files.Get(context.Background(), preview.ID)
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			if stored.Status != "active" {
+				t.Fatalf("expected the touched preview to stay active, got %s", stored.Status)
+			}
+			if _, _, err := files.readClaim(preview.ID); err == nil {
+				t.Fatal("expected the claim to be released")
+			}
+		})
+	}
+}
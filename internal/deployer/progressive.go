@@ -0,0 +1,261 @@
+package deployer
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+type RouteMatchType string
+const (
+	MatchHeader   RouteMatchType = "header"
+	MatchCookie   RouteMatchType = "cookie"
+	MatchCountry  RouteMatchType = "country"
+	MatchRegion   RouteMatchType = "region"
+	MatchUserHash RouteMatchType = "user_hash"
+)
+const defaultUserIDHeader = "X-User-ID"
+type RouteMatch struct {
+	Type   RouteMatchType `json:"type"`
+	Key    string         `json:"key,omitempty"`
+	Values []string       `json:"values,omitempty"`
+}
+type RoutingRule struct {
+	Name       string       `json:"name"`
+	Version    string       `json:"version"`
+	Matches    []RouteMatch `json:"matches,omitempty"`
+	Percentage int          `json:"percentage"`
+	HashKey    string       `json:"hash_key,omitempty"`
+}
+type RuleRouter interface {
+	ApplyRoutingRule(ctx context.Context, rule RoutingRule) error
+	RemoveRoutingRule(ctx context.Context, name string) error
+}
+type RegionalMonitor interface {
+	GetRegionalErrorRate(ctx context.Context, version, region string) (float64, error)
+}
+func (p *ProgressiveConfig) withDefaults() ProgressiveConfig {
+	cfg := *p
+	if cfg.StageDuration <= 0 {
+		cfg.StageDuration = 30 * time.Second
+	}
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = 0.05
+	}
+	return cfg
+}
+func compileSegment(config *DeploymentConfig, segment UserSegment) (RoutingRule, error) {
+	if segment.Percentage < 0 || segment.Percentage > 100 {
+		return RoutingRule{}, fmt.Errorf("segment %q has invalid percentage %d", segment.Name, segment.Percentage)
+	}
+	rule := RoutingRule{
+		Name:       fmt.Sprintf("%s-segment-%s", config.Version, segment.Name),
+		Version:    config.Version,
+		Percentage: segment.Percentage,
+		HashKey:    defaultUserIDHeader,
+	}
+	for _, key := range sortedKeys(segment.Criteria) {
+		value := segment.Criteria[key]
+		switch {
+		case strings.HasPrefix(key, "header:"):
+			rule.Matches = append(rule.Matches, RouteMatch{
+				Type:   MatchHeader,
+				Key:    http.CanonicalHeaderKey(strings.TrimPrefix(key, "header:")),
+				Values: splitList(value, false),
+			})
+		case strings.HasPrefix(key, "cookie:"):
+			rule.Matches = append(rule.Matches, RouteMatch{
+				Type:   MatchCookie,
+				Key:    strings.TrimPrefix(key, "cookie:"),
+				Values: splitList(value, false),
+			})
+		case key == "country":
+			rule.Matches = append(rule.Matches, RouteMatch{
+				Type:   MatchCountry,
+				Values: splitList(value, true),
+			})
+		case key == "user_id_hash":
+			if value != "" {
+				rule.HashKey = http.CanonicalHeaderKey(value)
+			}
+			rule.Matches = append(rule.Matches, RouteMatch{
+				Type: MatchUserHash,
+				Key:  rule.HashKey,
+			})
+		default:
+			return RoutingRule{}, fmt.Errorf("segment %q has unsupported criterion %q", segment.Name, key)
+		}
+	}
+	for _, match := range rule.Matches {
+		if match.Type != MatchUserHash && len(match.Values) == 0 {
+			return RoutingRule{}, fmt.Errorf("segment %q criterion %s:%s has no values", segment.Name, match.Type, match.Key)
+		}
+	}
+	return rule, nil
+}
+func regionRule(config *DeploymentConfig, region string) RoutingRule {
+	return RoutingRule{
+		Name:       fmt.Sprintf("%s-region-%s", config.Version, region),
+		Version:    config.Version,
+		Matches:    []RouteMatch{{Type: MatchRegion, Values: []string{region}}},
+		Percentage: 100,
+	}
+}
+func (r RoutingRule) Applies(req *http.Request, country, region string) bool {
+	for _, match := range r.Matches {
+		switch match.Type {
+		case MatchHeader:
+			if !containsString(match.Values, req.Header.Get(match.Key)) {
+				return false
+			}
+		case MatchCookie:
+			cookie, err := req.Cookie(match.Key)
+			if err != nil || !containsString(match.Values, cookie.Value) {
+				return false
+			}
+		case MatchCountry:
+			if !containsString(match.Values, strings.ToUpper(country)) {
+				return false
+			}
+		case MatchRegion:
+			if !containsString(match.Values, region) {
+				return false
+			}
+		case MatchUserHash:
+			if req.Header.Get(match.Key) == "" {
+				return false
+			}
+		}
+	}
+	if r.Percentage >= 100 {
+		return true
+	}
+	key := r.HashKey
+	if key == "" {
+		key = defaultUserIDHeader
+	}
+	userID := req.Header.Get(key)
+	if userID == "" {
+		return false
+	}
+	return UserBucket(r.Name, userID) < r.Percentage
+}
+func UserBucket(salt, userID string) int {
+	h := fnv.New32a()
+	h.Write([]byte(salt))
+	h.Write([]byte{0})
+	h.Write([]byte(userID))
+	return int(h.Sum32() % 100)
+}
+func splitList(value string, upper bool) []string {
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if upper {
+			v = strings.ToUpper(v)
+		}
+		values = append(values, v)
+	}
+	sort.Strings(values)
+	return values
+}
+func (de *DeploymentExecutor) routeStep(rule RoutingRule) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		router, ok := de.loadBalancer.(RuleRouter)
+		if !ok {
+			return fmt.Errorf("load balancer does not support rule-based routing")
+		}
+		return router.ApplyRoutingRule(ctx, rule)
+	}
+}
+func (de *DeploymentExecutor) progressiveStage(ctx context.Context, run *deploymentRun, config *DeploymentConfig, progCfg ProgressiveConfig, stage string, rule RoutingRule, region string) error {
+	if err := waitForDeployWindow(ctx, progCfg.TimeSchedule); err != nil {
+		return err
+	}
+	removeRule := &Compensation{Type: CompensationRemoveRoute, Rule: rule.Name}
+	if err := de.runStep(ctx, run, fmt.Sprintf("Route %s to %s", stage, config.Version), removeRule, de.routeStep(rule)); err != nil {
+		return fmt.Errorf("failed to route %s: %w", stage, err)
+	}
+	healthURL := config.HealthCheckURL
+	if region != "" && progCfg.RegionHealthCheckURLs[region] != "" {
+		healthURL = progCfg.RegionHealthCheckURLs[region]
+	}
+	err := de.runStep(ctx, run, fmt.Sprintf("Health Check %s", stage), nil, func(ctx context.Context) error {
+		return de.healthChecker.Check(ctx, healthURL, config.HealthCheckTimeout)
+	})
+	if err != nil {
+		return fmt.Errorf("health check failed for %s: %w", stage, err)
+	}
+	err = de.runStep(ctx, run, fmt.Sprintf("Monitor %s", stage), nil, func(ctx context.Context) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(progCfg.StageDuration):
+		}
+		errorRate, err := de.stageErrorRate(ctx, config.Version, region)
+		if err != nil {
+			return err
+		}
+		run.current().Output = "error_rate=" + strconv.FormatFloat(errorRate, 'f', 4, 64)
+		if errorRate > progCfg.FailureThreshold {
+			return fmt.Errorf("error rate %.2f%% exceeds threshold %.2f%%", errorRate*100, progCfg.FailureThreshold*100)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("metric gate failed for %s: %w", stage, err)
+	}
+	return nil
+}
+func (de *DeploymentExecutor) stageErrorRate(ctx context.Context, version, region string) (float64, error) {
+	if regional, ok := de.monitor.(RegionalMonitor); ok && region != "" {
+		return regional.GetRegionalErrorRate(ctx, version, region)
+	}
+	return de.monitor.GetErrorRate(ctx, version)
+}
+type FlagStore interface {
+	SetFlag(ctx context.Context, projectID, environment, key string, enabled bool) (bool, bool, error)
+	DeleteFlag(ctx context.Context, projectID, environment, key string) error
+}
+func (de *DeploymentExecutor) applyFeatureFlags(ctx context.Context, run *deploymentRun, flags map[string]bool) error {
+	if len(flags) == 0 {
+		return nil
+	}
+	if de.flags == nil {
+		return fmt.Errorf("deployment sets feature flags but no feature flag store is configured")
+	}
+	config := run.state.Config
+	keys := make([]string, 0, len(flags))
+	for key := range flags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		key, enabled := key, flags[key]
+		err := de.runStep(ctx, run, fmt.Sprintf("Set Feature Flag %s=%t", key, enabled), nil, func(ctx context.Context) error {
+			previous, existed, err := de.flags.SetFlag(ctx, config.ProjectID, config.Environment, key, enabled)
+			if err != nil {
+				return err
+			}
+			if run.current().Compensation == nil {
+				run.current().Compensation = &Compensation{
+					Type:       CompensationFeatureFlag,
+					Flag:       key,
+					Enabled:    previous,
+					RemoveFlag: !existed,
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to set feature flag %q: %w", key, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,290 @@
+package deployer
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"github.com/opsagent/opsagent/internal/flags"
+	sdk "github.com/opsagent/opsagent/pkg/flags"
+)
+type routingBalancer struct {
+	fakeBalancer
+}
+func (r *routingBalancer) ApplyRoutingRule(ctx context.Context, rule RoutingRule) error {
+	r.record("route:" + rule.Name)
+	return nil
+}
+func (r *routingBalancer) RemoveRoutingRule(ctx context.Context, name string) error {
+	r.record("unroute:" + name)
+	return nil
+}
+type regionalMonitor struct {
+	fakeMonitor
+	errorRates map[string]float64
+}
+func (m regionalMonitor) GetRegionalErrorRate(ctx context.Context, version, region string) (float64, error) {
+	return m.errorRates[region], nil
+}
+func progressiveExecutor(t *testing.T, health fakeHealth, errorRates map[string]float64) (*DeploymentExecutor, *routingBalancer) {
+	t.Helper()
+	lb := &routingBalancer{fakeBalancer{weights: map[string]int{"v1": 100}}}
+	de, err := NewDeploymentExecutor(health, lb, regionalMonitor{errorRates: errorRates}, NewFileStateStore(t.TempDir()), nil, nil, nil, NewDeploymentHistory(t.TempDir()))
+	if err != nil {
+		t.Fatalf("NewDeploymentExecutor: %v", err)
+	}
+	return de, lb
+}
+func TestCompileSegment(t *testing.T) {
+	tests := []struct {
+		name        string
+		segment     UserSegment
+		wantMatches []RouteMatch
+		wantHashKey string
+		wantErr     string
+	}{
+		{
+			name:        "header values are trimmed and sorted",
+			segment:     UserSegment{Name: "beta", Percentage: 100, Criteria: map[string]string{"header:x-beta-user": "yes, true"}},
+			wantMatches: []RouteMatch{{Type: MatchHeader, Key: "X-Beta-User", Values: []string{"true", "yes"}}},
+			wantHashKey: defaultUserIDHeader,
+		},
+		{
+			name:        "cookie keeps its case",
+			segment:     UserSegment{Name: "staff", Percentage: 100, Criteria: map[string]string{"cookie:Staff": "1"}},
+			wantMatches: []RouteMatch{{Type: MatchCookie, Key: "Staff", Values: []string{"1"}}},
+			wantHashKey: defaultUserIDHeader,
+		},
+		{
+			name:        "countries are upper-cased",
+			segment:     UserSegment{Name: "nordics", Percentage: 20, Criteria: map[string]string{"country": "se,no, dk"}},
+			wantMatches: []RouteMatch{{Type: MatchCountry, Values: []string{"DK", "NO", "SE"}}},
+			wantHashKey: defaultUserIDHeader,
+		},
+		{
+			name:        "user hash on a custom header",
+			segment:     UserSegment{Name: "ten", Percentage: 10, Criteria: map[string]string{"user_id_hash": "x-account-id"}},
+			wantMatches: []RouteMatch{{Type: MatchUserHash, Key: "X-Account-Id"}},
+			wantHashKey: "X-Account-Id",
+		},
+		{
+			name:    "unsupported criterion",
+			segment: UserSegment{Name: "ios", Percentage: 100, Criteria: map[string]string{"platform": "ios"}},
+			wantErr: `unsupported criterion "platform"`,
+		},
+		{
+			name:    "criterion without values",
+			segment: UserSegment{Name: "empty", Percentage: 100, Criteria: map[string]string{"header:x-beta": " , "}},
+			wantErr: "criterion header:X-Beta has no values",
+		},
+		{
+			name:    "invalid percentage",
+			segment: UserSegment{Name: "too-many", Percentage: 120},
+			wantErr: "invalid percentage 120",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := compileSegment(&DeploymentConfig{Version: "v2"}, tt.segment)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("compileSegment: %v", err)
+			}
+			if rule.Name != "v2-segment-"+tt.segment.Name || rule.Version != "v2" || rule.Percentage != tt.segment.Percentage || rule.HashKey != tt.wantHashKey {
+				t.Fatalf("unexpected rule %+v", rule)
+			}
+			if !reflect.DeepEqual(rule.Matches, tt.wantMatches) {
+				t.Fatalf("expected matches %+v, got %+v", tt.wantMatches, rule.Matches)
+			}
+		})
+	}
+}
+func TestRoutingRuleApplies(t *testing.T) {
+	request := func(headers map[string]string, cookie *http.Cookie) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		return req
+	}
+	header := RoutingRule{Name: "beta", Percentage: 100, Matches: []RouteMatch{{Type: MatchHeader, Key: "X-Beta", Values: []string{"yes"}}}}
+	cookie := RoutingRule{Name: "staff", Percentage: 100, Matches: []RouteMatch{{Type: MatchCookie, Key: "staff", Values: []string{"1"}}}}
+	country := RoutingRule{Name: "nordics", Percentage: 100, Matches: []RouteMatch{{Type: MatchCountry, Values: []string{"NO", "SE"}}}}
+	userHash := RoutingRule{Name: "accounts", Percentage: 100, HashKey: "X-Account-Id", Matches: []RouteMatch{{Type: MatchUserHash, Key: "X-Account-Id"}}}
+	none := RoutingRule{Name: "none", HashKey: "X-Account-Id"}
+	tests := []struct {
+		name    string
+		rule    RoutingRule
+		req     *http.Request
+		country string
+		want    bool
+	}{
+		{name: "header matches", rule: header, req: request(map[string]string{"X-Beta": "yes"}, nil), want: true},
+		{name: "header differs", rule: header, req: request(map[string]string{"X-Beta": "no"}, nil)},
+		{name: "header missing", rule: header, req: request(nil, nil)},
+		{name: "cookie matches", rule: cookie, req: request(nil, &http.Cookie{Name: "staff", Value: "1"}), want: true},
+		{name: "cookie differs", rule: cookie, req: request(nil, &http.Cookie{Name: "staff", Value: "0"})},
+		{name: "cookie missing", rule: cookie, req: request(nil, nil)},
+		{name: "country ignores case", rule: country, req: request(nil, nil), country: "se", want: true},
+		{name: "country outside the list", rule: country, req: request(nil, nil), country: "DK"},
+		{name: "user hash with the key", rule: userHash, req: request(map[string]string{"X-Account-Id": "42"}, nil), want: true},
+		{name: "user hash without the key", rule: userHash, req: request(map[string]string{"X-User-ID": "42"}, nil)},
+		{name: "zero percent", rule: none, req: request(map[string]string{"X-Account-Id": "42"}, nil)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rule.Applies(tt.req, tt.country, "eu-west"); got != tt.want {
+				t.Fatalf("Applies = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+func TestRoutingRuleBucketsUsersByPercentage(t *testing.T) {
+	rule := RoutingRule{Name: "half", Percentage: 50}
+	routed := 0
+	for i := 0; i < 1000; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		userID := "user-" + strconv.Itoa(i)
+		req.Header.Set(defaultUserIDHeader, userID)
+		got := rule.Applies(req, "", "")
+		if got != (UserBucket("half", userID) < 50) {
+			t.Fatalf("user %s was routed inconsistently with its bucket", userID)
+		}
+		if got != rule.Applies(req, "", "") {
+			t.Fatalf("user %s was not routed consistently", userID)
+		}
+		if got {
+			routed++
+		}
+	}
+	if routed < 400 || routed > 600 {
+		t.Fatalf("expected about half the users to be routed, got %d of 1000", routed)
+	}
+	if rule.Applies(httptest.NewRequest(http.MethodGet, "/", nil), "", "") {
+		t.Fatal("expected requests without a user id to stay on the current version")
+	}
+}
+func TestProgressiveRegionGates(t *testing.T) {
+	tests := []struct {
+		name    string
+		region  string
+		wantErr string
+	}{
+		{name: "healthy region", region: "eu-west"},
+		{name: "error rate over the threshold", region: "us-east", wantErr: "metric gate failed for region us-east: error rate 20.00% exceeds threshold 5.00%"},
+		{name: "regional health check", region: "ap-south", wantErr: "health check failed for region ap-south"},
+	}
+	health := fakeHealth{failing: map[string]bool{"http://ap-south/health": true}}
+	errorRates := map[string]float64{"eu-west": 0.01, "us-east": 0.2, "ap-south": 0}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			de, lb := progressiveExecutor(t, health, errorRates)
+			config := &DeploymentConfig{ProjectID: "proj", Environment: "production", Strategy: StrategyProgressive, Version: "v2", HealthCheckURL: "http://app/health"}
+			progCfg := (&ProgressiveConfig{
+				StageDuration:         time.Millisecond,
+				RegionHealthCheckURLs: map[string]string{"ap-south": "http://ap-south/health"},
+			}).withDefaults()
+			run := &deploymentRun{state: newDeploymentState(config)}
+			stage := "region " + tt.region
+			err := de.progressiveStage(context.Background(), run, config, progCfg, stage, regionRule(config, tt.region), tt.region)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("expected the region to pass its gates, got %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("expected %q, got %v", tt.wantErr, err)
+			}
+			if got := strings.Join(lb.calls, ","); got != "route:v2-region-"+tt.region {
+				t.Fatalf("expected the region rule to be applied, got %s", got)
+			}
+			if comp := run.state.Steps[0].Compensation; comp == nil || comp.Type != CompensationRemoveRoute || comp.Rule != "v2-region-"+tt.region {
+				t.Fatalf("expected the route step to record its removal, got %+v", comp)
+			}
+		})
+	}
+}
+func TestProgressiveRollsBackWhenARegionFails(t *testing.T) {
+	interval := approvalPollInterval
+	approvalPollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { approvalPollInterval = interval })
+	de, lb := progressiveExecutor(t, fakeHealth{}, map[string]float64{"eu-west": 0.01, "us-east": 0.2})
+	result, err := de.Execute(context.Background(), &DeploymentConfig{
+		ProjectID:      "proj",
+		Environment:    "production",
+		Strategy:       StrategyProgressive,
+		Version:        "v2",
+		HealthCheckURL: "http://app/health",
+		ProgressiveConfig: &ProgressiveConfig{
+			UserSegments:      []UserSegment{{Name: "beta", Percentage: 100, Criteria: map[string]string{"header:x-beta": "yes"}}},
+			GeographicRollout: []string{"eu-west", "us-east", "ap-south"},
+			Gates:             []ApprovalGate{{Name: "eu sign-off", AfterStage: "eu-west", Timeout: time.Millisecond, AutoApprove: true}},
+			StageDuration:     time.Millisecond,
+		},
+	})
+	if err == nil || result.Status != "rolled_back" || !strings.Contains(result.RollbackReason, "failed in region us-east") {
+		t.Fatalf("expected the us-east stage to roll the release back, got %+v (%v)", result, err)
+	}
+	want := "route:v2-segment-beta,route:v2-region-eu-west,route:v2-region-us-east,unroute:v2-region-us-east,unroute:v2-region-eu-west,unroute:v2-segment-beta"
+	if got := strings.Join(lb.calls, ","); got != want {
+		t.Fatalf("expected %s, got %s", want, got)
+	}
+	approved := false
+	for _, step := range result.Steps {
+		if strings.Contains(step.Name, "ap-south") {
+			t.Fatalf("expected later regions to be skipped, got step %s", step.Name)
+		}
+		if step.Name == "Await Approval: eu sign-off" && step.Status == "success" {
+			approved = true
+		}
+	}
+	if !approved {
+		t.Fatalf("expected the eu-west gate to pass before us-east, got %+v", result.Steps)
+	}
+}
+func TestFeatureFlagCompensation(t *testing.T) {
+	ctx := context.Background()
+	store := flags.NewFlagService(t.TempDir())
+	if err := store.SaveFlag(ctx, &sdk.Flag{Key: "checkout", ProjectID: "proj", Environment: "production", Enabled: false, Percentage: 25}); err != nil {
+		t.Fatalf("SaveFlag: %v", err)
+	}
+	de, err := NewDeploymentExecutor(fakeHealth{}, &fakeBalancer{}, fakeMonitor{}, NewFileStateStore(t.TempDir()), nil, nil, store, NewDeploymentHistory(t.TempDir()))
+	if err != nil {
+		t.Fatalf("NewDeploymentExecutor: %v", err)
+	}
+	state := newDeploymentState(&DeploymentConfig{ProjectID: "proj", Environment: "production", Strategy: StrategyProgressive, Version: "v2"})
+	run := &deploymentRun{state: state}
+	if err := de.applyFeatureFlags(ctx, run, map[string]bool{"checkout": true, "new-nav": true}); err != nil {
+		t.Fatalf("applyFeatureFlags: %v", err)
+	}
+	for _, key := range []string{"checkout", "new-nav"} {
+		flag, err := store.GetFlag(ctx, "proj", "production", key)
+		if err != nil || !flag.Enabled {
+			t.Fatalf("expected %s to be enabled during the rollout, got %+v (%v)", key, flag, err)
+		}
+	}
+	result, err := de.rollbackState(ctx, state, "metric gate failed")
+	if err == nil || result.Status != "rolled_back" {
+		t.Fatalf("expected the deployment to roll back, got %+v (%v)", result, err)
+	}
+	if _, err := store.GetFlag(ctx, "proj", "production", "new-nav"); !errors.Is(err, flags.ErrFlagNotFound) {
+		t.Fatalf("expected the flag created by the deployment to be deleted, got %v", err)
+	}
+	restored, err := store.GetFlag(ctx, "proj", "production", "checkout")
+	if err != nil {
+		t.Fatalf("GetFlag: %v", err)
+	}
+	if restored.Enabled || restored.Percentage != 25 {
+		t.Fatalf("expected the existing flag to be restored to disabled at 25%%, got %+v", restored)
+	}
+}
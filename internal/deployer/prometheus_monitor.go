@@ -0,0 +1,260 @@
+package deployer
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+var ErrNoSeries = errors.New("no series returned")
+var ErrUnknownMetric = errors.New("unknown metric")
+type MissingSeriesError struct {
+	Metric  string
+	Version string
+	Query   string
+}
+func (e *MissingSeriesError) Error() string {
+	return fmt.Sprintf("prometheus returned no series for %s of version %q (query: %s)", e.Metric, e.Version, e.Query)
+}
+func (e *MissingSeriesError) Unwrap() error {
+	return ErrNoSeries
+}
+type PrometheusQueries struct {
+	ErrorRate   string
+	Latency     string
+	LatencyP50  string
+	LatencyP95  string
+	LatencyP99  string
+	RequestRate string
+	CPUUsage    string
+	MemoryUsage string
+	Custom      map[string]string
+}
+type PrometheusMonitorConfig struct {
+	URL          string
+	VersionLabel string
+	ExtraLabels  map[string]string
+	BearerToken  string
+	Timeout      time.Duration
+	Queries      PrometheusQueries
+}
+type PrometheusMonitor struct {
+	baseURL     string
+	selector    func(version string) string
+	bearerToken string
+	httpClient  *http.Client
+	templates   map[string]*template.Template
+}
+func DefaultPrometheusQueries() PrometheusQueries {
+	return PrometheusQueries{
+		ErrorRate:   `sum(rate(http_requests_total{ {{.Selector}}, status=~"5.." }[5m])) / sum(rate(http_requests_total{ {{.Selector}} }[5m]))`,
+		Latency:     `sum(rate(http_request_duration_seconds_sum{ {{.Selector}} }[5m])) / sum(rate(http_request_duration_seconds_count{ {{.Selector}} }[5m]))`,
+		LatencyP50:  `histogram_quantile(0.50, sum by (le) (rate(http_request_duration_seconds_bucket{ {{.Selector}} }[5m])))`,
+		LatencyP95:  `histogram_quantile(0.95, sum by (le) (rate(http_request_duration_seconds_bucket{ {{.Selector}} }[5m])))`,
+		LatencyP99:  `histogram_quantile(0.99, sum by (le) (rate(http_request_duration_seconds_bucket{ {{.Selector}} }[5m])))`,
+		RequestRate: `sum(rate(http_requests_total{ {{.Selector}} }[5m]))`,
+		CPUUsage:    `avg(rate(container_cpu_usage_seconds_total{ {{.Selector}} }[5m]))`,
+		MemoryUsage: `avg(container_memory_working_set_bytes{ {{.Selector}} } / container_spec_memory_limit_bytes{ {{.Selector}} })`,
+	}
+}
+func NewPrometheusMonitor(cfg PrometheusMonitorConfig) (*PrometheusMonitor, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("prometheus URL is required")
+	}
+	if cfg.VersionLabel == "" {
+		cfg.VersionLabel = "version"
+	}
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	defaults := DefaultPrometheusQueries()
+	raw := map[string]string{
+		MetricErrorRate:   firstNonEmpty(cfg.Queries.ErrorRate, defaults.ErrorRate),
+		MetricLatency:     firstNonEmpty(cfg.Queries.Latency, defaults.Latency),
+		MetricLatencyP50:  firstNonEmpty(cfg.Queries.LatencyP50, defaults.LatencyP50),
+		MetricLatencyP95:  firstNonEmpty(cfg.Queries.LatencyP95, defaults.LatencyP95),
+		MetricLatencyP99:  firstNonEmpty(cfg.Queries.LatencyP99, defaults.LatencyP99),
+		MetricRequestRate: firstNonEmpty(cfg.Queries.RequestRate, defaults.RequestRate),
+		MetricCPUUsage:    firstNonEmpty(cfg.Queries.CPUUsage, defaults.CPUUsage),
+		MetricMemoryUsage: firstNonEmpty(cfg.Queries.MemoryUsage, defaults.MemoryUsage),
+	}
+	for name, query := range cfg.Queries.Custom {
+		raw[name] = query
+	}
+	templates := make(map[string]*template.Template, len(raw))
+	for name, query := range raw {
+		tmpl, err := template.New(name).Option("missingkey=error").Parse(query)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PromQL template for %s: %w", name, err)
+		}
+		templates[name] = tmpl
+	}
+	versionLabel := cfg.VersionLabel
+	extra := make([]string, 0, len(cfg.ExtraLabels))
+	for label, value := range cfg.ExtraLabels {
+		extra = append(extra, fmt.Sprintf("%s=%s", label, strconv.Quote(value)))
+	}
+	return &PrometheusMonitor{
+		baseURL: strings.TrimRight(cfg.URL, "/"),
+		selector: func(version string) string {
+			return strings.Join(append([]string{fmt.Sprintf("%s=%s", versionLabel, strconv.Quote(version))}, extra...), ", ")
+		},
+		bearerToken: cfg.BearerToken,
+		httpClient: &http.Client{
+			Timeout: timeout,
+		},
+		templates: templates,
+	}, nil
+}
+func (pm *PrometheusMonitor) GetMetrics(ctx context.Context, version string) (*DeploymentMetrics, error) {
+	errorRate, err := pm.GetMetric(ctx, version, MetricErrorRate)
+	if err != nil {
+		return nil, err
+	}
+	latency, err := pm.GetLatency(ctx, version)
+	if err != nil {
+		return nil, err
+	}
+	requestRate, err := pm.GetMetric(ctx, version, MetricRequestRate)
+	if err != nil {
+		return nil, err
+	}
+	cpu, err := pm.GetMetric(ctx, version, MetricCPUUsage)
+	if err != nil {
+		return nil, err
+	}
+	memory, err := pm.GetMetric(ctx, version, MetricMemoryUsage)
+	if err != nil {
+		return nil, err
+	}
+	return &DeploymentMetrics{
+		ErrorRate:   errorRate,
+		Latency:     latency,
+		RequestRate: requestRate,
+		CPUUsage:    cpu,
+		MemoryUsage: memory,
+		SuccessRate: 1 - errorRate,
+	}, nil
+}
+func (pm *PrometheusMonitor) GetErrorRate(ctx context.Context, version string) (float64, error) {
+	return pm.GetMetric(ctx, version, MetricErrorRate)
+}
+func (pm *PrometheusMonitor) GetLatency(ctx context.Context, version string) (time.Duration, error) {
+	seconds, err := pm.GetMetric(ctx, version, MetricLatency)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+func (pm *PrometheusMonitor) GetMetric(ctx context.Context, version, name string) (float64, error) {
+	tmpl, ok := pm.templates[name]
+	if !ok {
+		return 0, fmt.Errorf("%w: no PromQL template configured for metric %q", ErrUnknownMetric, name)
+	}
+	var query strings.Builder
+	if err := tmpl.Execute(&query, map[string]string{
+		"Selector": pm.selector(version),
+		"Version":  version,
+	}); err != nil {
+		return 0, fmt.Errorf("failed to render PromQL template for %s: %w", name, err)
+	}
+	value, err := pm.query(ctx, query.String())
+	if errors.Is(err, ErrNoSeries) {
+		return 0, &MissingSeriesError{Metric: name, Version: version, Query: query.String()}
+	}
+	if err != nil {
+		return 0, fmt.Errorf("prometheus query for %s of version %q failed: %w", name, version, err)
+	}
+	return value, nil
+}
+type prometheusResponse struct {
+	Status    string `json:"status"`
+	ErrorType string `json:"errorType"`
+	Error     string `json:"error"`
+	Data      struct {
+		ResultType string          `json:"resultType"`
+		Result     json.RawMessage `json:"result"`
+	} `json:"data"`
+}
+func (pm *PrometheusMonitor) query(ctx context.Context, query string) (float64, error) {
+	params := url.Values{}
+	params.Set("query", query)
+	params.Set("time", strconv.FormatInt(time.Now().Unix(), 10))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pm.baseURL+"/api/v1/query?"+params.Encode(), nil)
+	if err != nil {
+		return 0, err
+	}
+	if pm.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+pm.bearerToken)
+	}
+	resp, err := pm.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	var parsed prometheusResponse
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return 0, fmt.Errorf("unexpected response (HTTP %d): %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	if parsed.Status != "success" {
+		return 0, fmt.Errorf("%s: %s", parsed.ErrorType, parsed.Error)
+	}
+	var sample []interface{}
+	switch parsed.Data.ResultType {
+	case "scalar":
+		if err := json.Unmarshal(parsed.Data.Result, &sample); err != nil {
+			return 0, err
+		}
+	case "vector":
+		var vector []struct {
+			Value []interface{} `json:"value"`
+		}
+		if err := json.Unmarshal(parsed.Data.Result, &vector); err != nil {
+			return 0, err
+		}
+		if len(vector) == 0 {
+			return 0, ErrNoSeries
+		}
+		if len(vector) > 1 {
+			return 0, fmt.Errorf("query returned %d series, expected 1", len(vector))
+		}
+		sample = vector[0].Value
+	default:
+		return 0, fmt.Errorf("unsupported result type %q", parsed.Data.ResultType)
+	}
+	if len(sample) != 2 {
+		return 0, fmt.Errorf("malformed sample %v", sample)
+	}
+	raw, ok := sample[1].(string)
+	if !ok {
+		return 0, fmt.Errorf("malformed sample value %v", sample[1])
+	}
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return 0, fmt.Errorf("malformed sample value %q: %w", raw, err)
+	}
+	if math.IsNaN(value) {
+		return 0, ErrNoSeries
+	}
+	return value, nil
+}
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,165 @@
+package deployer
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+func fakePrometheus(t *testing.T, status int, body string, seen func(r *http.Request)) *PrometheusMonitor {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/query" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if seen != nil {
+			seen(r)
+		}
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(server.Close)
+	monitor, err := NewPrometheusMonitor(PrometheusMonitorConfig{
+		URL:         server.URL + "/",
+		ExtraLabels: map[string]string{"app": "web"},
+		BearerToken: "secret-token",
+		Queries: PrometheusQueries{
+			Custom: map[string]string{"checkout_rate": `sum(rate(checkouts_total{ {{.Selector}} }[1m]))`},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewPrometheusMonitor: %v", err)
+	}
+	return monitor
+}
+func TestPrometheusMonitorGetMetric(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		want    float64
+		wantErr error
+		errText string
+	}{
+		{
+			name:   "single vector sample",
+			status: http.StatusOK,
+			body:   `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1700000000,"0.025"]}]}}`,
+			want:   0.025,
+		},
+		{
+			name:   "scalar sample",
+			status: http.StatusOK,
+			body:   `{"status":"success","data":{"resultType":"scalar","result":[1700000000,"3.5"]}}`,
+			want:   3.5,
+		},
+		{
+			name:    "empty vector",
+			status:  http.StatusOK,
+			body:    `{"status":"success","data":{"resultType":"vector","result":[]}}`,
+			wantErr: ErrNoSeries,
+		},
+		{
+			name:    "NaN sample",
+			status:  http.StatusOK,
+			body:    `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1700000000,"NaN"]}]}}`,
+			wantErr: ErrNoSeries,
+		},
+		{
+			name:    "multiple series",
+			status:  http.StatusOK,
+			body:    `{"status":"success","data":{"resultType":"vector","result":[{"value":[1,"1"]},{"value":[1,"2"]}]}}`,
+			errText: "returned 2 series",
+		},
+		{
+			name:    "query error",
+			status:  http.StatusBadRequest,
+			body:    `{"status":"error","errorType":"bad_data","error":"parse error"}`,
+			errText: "bad_data: parse error",
+		},
+		{
+			name:    "non-JSON response",
+			status:  http.StatusBadGateway,
+			body:    `upstream unavailable`,
+			errText: "HTTP 502",
+		},
+		{
+			name:    "unsupported result type",
+			status:  http.StatusOK,
+			body:    `{"status":"success","data":{"resultType":"matrix","result":[]}}`,
+			errText: "unsupported result type",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			monitor := fakePrometheus(t, tt.status, tt.body, nil)
+			got, err := monitor.GetMetric(context.Background(), "v2", MetricErrorRate)
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("expected %v, got %v", tt.wantErr, err)
+				}
+				var missing *MissingSeriesError
+				if !errors.As(err, &missing) || missing.Metric != MetricErrorRate || missing.Version != "v2" {
+					t.Fatalf("expected MissingSeriesError for error_rate of v2, got %#v", err)
+				}
+			case tt.errText != "":
+				if err == nil || !strings.Contains(err.Error(), tt.errText) {
+					t.Fatalf("expected error containing %q, got %v", tt.errText, err)
+				}
+			case err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case got != tt.want:
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+func TestPrometheusMonitorRendersSelector(t *testing.T) {
+	var query, auth string
+	monitor := fakePrometheus(t, http.StatusOK, `{"status":"success","data":{"resultType":"vector","result":[{"value":[1,"42"]}]}}`, func(r *http.Request) {
+		query = r.URL.Query().Get("query")
+		auth = r.Header.Get("Authorization")
+	})
+	value, err := monitor.GetMetric(context.Background(), "v2", "checkout_rate")
+	if err != nil {
+		t.Fatalf("GetMetric: %v", err)
+	}
+	if value != 42 {
+		t.Fatalf("expected 42, got %v", value)
+	}
+	if want := `sum(rate(checkouts_total{ version="v2", app="web" }[1m]))`; query != want {
+		t.Fatalf("expected query %q, got %q", want, query)
+	}
+	if auth != "Bearer secret-token" {
+		t.Fatalf("expected bearer token, got %q", auth)
+	}
+	if _, err := monitor.GetMetric(context.Background(), "v2", "unknown"); err == nil {
+		t.Fatal("expected an error for a metric without a template")
+	}
+}
+func TestPrometheusMonitorGetLatency(t *testing.T) {
+	monitor := fakePrometheus(t, http.StatusOK, `{"status":"success","data":{"resultType":"vector","result":[{"value":[1,"0.25"]}]}}`, nil)
+	latency, err := monitor.GetLatency(context.Background(), "v2")
+	if err != nil {
+		t.Fatalf("GetLatency: %v", err)
+	}
+	if latency != 250*time.Millisecond {
+		t.Fatalf("expected 250ms, got %v", latency)
+	}
+}
+func TestNewPrometheusMonitorValidation(t *testing.T) {
+	if _, err := NewPrometheusMonitor(PrometheusMonitorConfig{}); err == nil {
+		t.Fatal("expected an error without a URL")
+	}
+	_, err := NewPrometheusMonitor(PrometheusMonitorConfig{
+		URL:     "http://prometheus.invalid",
+		Queries: PrometheusQueries{ErrorRate: "rate({{.Selector}"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "invalid PromQL template for error_rate") {
+		t.Fatalf("expected template error, got %v", err)
+	}
+}
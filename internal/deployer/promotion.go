@@ -0,0 +1,383 @@
+package deployer
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+const maxLineageDepth = 32
+var DefaultPromotionExclusions = []string{"DATABASE_URL", "REDIS_URL", "API_URL"}
+type PromotionTransform struct {
+	Key     string  `json:"key"`
+	Find    string  `json:"find,omitempty"`
+	Replace string  `json:"replace,omitempty"`
+	Value   *string `json:"value,omitempty"`
+}
+type PromotionRules struct {
+	ProjectID     string               `json:"project_id"`
+	Exclude       []string             `json:"exclude"`
+	Transforms    []PromotionTransform `json:"transforms,omitempty"`
+	RequireDigest bool                 `json:"require_digest"`
+	UpdatedAt     time.Time            `json:"updated_at"`
+}
+type PromotionRequest struct {
+	ProjectID        string             `json:"project_id"`
+	Source           string             `json:"source"`
+	Target           string             `json:"target"`
+	SourceDeployment string             `json:"source_deployment,omitempty"`
+	Strategy         DeploymentStrategy `json:"strategy,omitempty"`
+	RequestedBy      string             `json:"-"`
+}
+type PromotionPreview struct {
+	ProjectID         string         `json:"project_id"`
+	Source            string         `json:"source"`
+	Target            string         `json:"target"`
+	SourceDeployment  string         `json:"source_deployment"`
+	CurrentDeployment string         `json:"current_deployment,omitempty"`
+	Version           string         `json:"version"`
+	Image             string         `json:"image"`
+	ImageDigest       string         `json:"image_digest,omitempty"`
+	Changes           []ConfigChange `json:"changes"`
+	Excluded          []string       `json:"excluded,omitempty"`
+	Transformed       []string       `json:"transformed,omitempty"`
+	Warnings          []string       `json:"warnings,omitempty"`
+}
+type PromotionManager struct {
+	history  *DeploymentHistory
+	executor *DeploymentExecutor
+	envs     *EnvironmentManager
+}
+func NewPromotionManager(history *DeploymentHistory, executor *DeploymentExecutor, envs *EnvironmentManager) *PromotionManager {
+	return &PromotionManager{
+		history:  history,
+		executor: executor,
+		envs:     envs,
+	}
+}
+func imageDigest(image string) string {
+	if i := strings.LastIndex(image, "@"); i >= 0 {
+		return image[i+1:]
+	}
+	return ""
+}
+func (r *PromotionRules) validate() error {
+	for _, pattern := range r.Exclude {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid exclusion pattern %q: %w", pattern, err)
+		}
+	}
+	for _, transform := range r.Transforms {
+		if _, err := path.Match(transform.Key, ""); err != nil || transform.Key == "" {
+			return fmt.Errorf("invalid transform key %q", transform.Key)
+		}
+		if transform.Value == nil && transform.Find == "" {
+			return fmt.Errorf("transform for %s needs a value or a find string", transform.Key)
+		}
+	}
+	return nil
+}
+func (r *PromotionRules) excluded(key string) bool {
+	for _, pattern := range r.Exclude {
+		if matched, _ := path.Match(pattern, key); matched {
+			return true
+		}
+	}
+	return false
+}
+func (r *PromotionRules) transform(key, value string) (string, bool) {
+	original := value
+	for _, transform := range r.Transforms {
+		if matched, _ := path.Match(transform.Key, key); !matched {
+			continue
+		}
+		if transform.Value != nil {
+			value = *transform.Value
+		} else {
+			value = strings.ReplaceAll(value, transform.Find, transform.Replace)
+		}
+	}
+	return value, value != original
+}
+func (r *PromotionRules) apply(source, target map[string]string) (map[string]string, []string, []string) {
+	promoted := make(map[string]string, len(target)+len(source))
+	for k, v := range target {
+		promoted[k] = v
+	}
+	var excluded, transformed []string
+	for _, key := range mapKeys(source) {
+		if r.excluded(key) {
+			excluded = append(excluded, key)
+			continue
+		}
+		value, changed := r.transform(key, source[key])
+		if changed {
+			transformed = append(transformed, key)
+		}
+		promoted[key] = value
+	}
+	return promoted, excluded, transformed
+}
+func (em *EnvironmentManager) promotionRulesPath(projectID string) string {
+	return filepath.Join(em.storagePath, "promotion", projectID+".json")
+}
+func (em *EnvironmentManager) GetPromotionRules(ctx context.Context, projectID string) (*PromotionRules, error) {
+	data, err := os.ReadFile(em.promotionRulesPath(projectID))
+	if errors.Is(err, os.ErrNotExist) {
+		return &PromotionRules{
+			ProjectID:     projectID,
+			Exclude:       append([]string(nil), DefaultPromotionExclusions...),
+			RequireDigest: true,
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rules PromotionRules
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, err
+	}
+	return &rules, nil
+}
+func (em *EnvironmentManager) SavePromotionRules(ctx context.Context, rules *PromotionRules) error {
+	if rules.ProjectID == "" {
+		return errors.New("promotion rules require a project")
+	}
+	if err := rules.validate(); err != nil {
+		return err
+	}
+	rules.UpdatedAt = time.Now()
+	path := em.promotionRulesPath(rules.ProjectID)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(rules, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0644)
+}
+func (em *EnvironmentManager) promoteVariables(ctx context.Context, targetID, author string, rules *PromotionRules, source map[string]string) error {
+	if err := em.authorizeEnvironment(ctx, targetID, EnvActionPromote); err != nil {
+		return err
+	}
+	return em.modifyEnvironment(ctx, targetID, author, func(target *Environment) (map[string]int, error) {
+		if target.Locked {
+			return nil, fmt.Errorf("target environment is locked")
+		}
+		target.Variables, _, _ = rules.apply(source, target.Variables)
+		return nil, nil
+	})
+}
+type promotionPlan struct {
+	preview    *PromotionPreview
+	rules      *PromotionRules
+	source     *DeploymentRecord
+	sourceVars map[string]string
+	target     *Environment
+	live       *ConfigSnapshot
+	promote    *ConfigSnapshot
+}
+func (pm *PromotionManager) PreviewPromotion(ctx context.Context, req PromotionRequest) (*PromotionPreview, error) {
+	plan, err := pm.planPromotion(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return plan.preview, nil
+}
+func (pm *PromotionManager) sourceDeployment(ctx context.Context, req PromotionRequest) (*DeploymentRecord, error) {
+	if req.SourceDeployment == "" {
+		record, err := pm.history.GetLastSuccessfulDeployment(ctx, req.ProjectID, req.Source)
+		if err != nil {
+			return nil, fmt.Errorf("nothing to promote from %s: %w", req.Source, err)
+		}
+		return record, nil
+	}
+	record, err := pm.history.GetDeployment(ctx, req.SourceDeployment)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get source deployment: %w", err)
+	}
+	if record.ProjectID != req.ProjectID || record.Environment != req.Source {
+		return nil, fmt.Errorf("deployment %s does not belong to %s/%s", record.ID, req.ProjectID, req.Source)
+	}
+	if record.Status != "success" {
+		return nil, fmt.Errorf("deployment %s did not succeed (status %s) and cannot be promoted", record.ID, record.Status)
+	}
+	return record, nil
+}
+func (pm *PromotionManager) planPromotion(ctx context.Context, req PromotionRequest) (*promotionPlan, error) {
+	if req.Source == "" || req.Target == "" || req.Source == req.Target {
+		return nil, errors.New("promotion requires distinct source and target environments")
+	}
+	source, err := pm.sourceDeployment(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := pm.envs.GetPromotionRules(ctx, req.ProjectID)
+	if err != nil {
+		return nil, err
+	}
+	plan := &promotionPlan{rules: rules, source: source}
+	var warnings []string
+	digest := imageDigest(source.Image)
+	if digest == "" {
+		if rules.RequireDigest {
+			return nil, fmt.Errorf("image %s is not pinned to a digest and project %s requires digests for promotion", source.Image, req.ProjectID)
+		}
+		warnings = append(warnings, fmt.Sprintf("image %s is not pinned to a digest; the tag may point at a different build", source.Image))
+	}
+	snapshot := source.Snapshot
+	if snapshot == nil {
+		snapshot = &ConfigSnapshot{Version: source.Version, Image: source.Image}
+	}
+	plan.sourceVars = snapshot.Variables
+	if !snapshot.hasEnvironment() {
+		env, err := pm.envs.FindEnvironment(ctx, req.ProjectID, req.Source)
+		switch {
+		case err == nil:
+			plan.sourceVars = env.Variables
+			warnings = append(warnings, "source deployment did not capture its configuration; current source variables will be promoted")
+		case errors.Is(err, ErrEnvironmentNotFound):
+		default:
+			return nil, err
+		}
+	}
+	plan.live = &ConfigSnapshot{}
+	current, err := pm.history.GetLastSuccessfulDeployment(ctx, req.ProjectID, req.Target)
+	if err == nil {
+		if current.Snapshot != nil {
+			plan.live = current.Snapshot
+		} else {
+			plan.live = &ConfigSnapshot{Version: current.Version, Image: current.Image}
+		}
+	}
+	target, err := pm.envs.FindEnvironment(ctx, req.ProjectID, req.Target)
+	switch {
+	case err == nil:
+		if err := pm.envs.authorize(ctx, target, EnvActionPromote, EnvActionDeploy); err != nil {
+			return nil, err
+		}
+		if target.Locked {
+			return nil, fmt.Errorf("target environment is locked by %s", target.LockedBy)
+		}
+		live, err := pm.envs.CaptureConfig(ctx, req.ProjectID, req.Target)
+		if err != nil {
+			return nil, err
+		}
+		plan.target = target
+		plan.live = plan.live.withEnvironment(live)
+	case errors.Is(err, ErrEnvironmentNotFound):
+		warnings = append(warnings, fmt.Sprintf("%s has no managed environment; only the artifact will be promoted", req.Target))
+	default:
+		return nil, err
+	}
+	plan.promote = &ConfigSnapshot{
+		Version:            snapshot.Version,
+		Image:              snapshot.Image,
+		Replicas:           snapshot.Replicas,
+		HealthCheckURL:     snapshot.HealthCheckURL,
+		HealthCheckTimeout: snapshot.HealthCheckTimeout,
+		CapturedAt:         time.Now(),
+	}
+	if plan.promote.Version == "" {
+		plan.promote.Version = source.Version
+	}
+	if plan.promote.Image == "" {
+		plan.promote.Image = source.Image
+	}
+	plan.preview = &PromotionPreview{
+		ProjectID:        req.ProjectID,
+		Source:           req.Source,
+		Target:           req.Target,
+		SourceDeployment: source.ID,
+		Version:          plan.promote.Version,
+		Image:            plan.promote.Image,
+		ImageDigest:      digest,
+		Warnings:         warnings,
+	}
+	if current != nil {
+		plan.preview.CurrentDeployment = current.ID
+	}
+	if plan.target != nil {
+		promoted := plan.promote.withEnvironment(plan.live)
+		promoted.Variables, plan.preview.Excluded, plan.preview.Transformed = rules.apply(plan.sourceVars, plan.live.Variables)
+		plan.promote = promoted
+	}
+	plan.preview.Changes = diffSnapshots(plan.live, plan.promote)
+	return plan, nil
+}
+func (pm *PromotionManager) Promote(ctx context.Context, req PromotionRequest) (*DeploymentRecord, error) {
+	plan, err := pm.planPromotion(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	author := req.RequestedBy
+	if author == "" {
+		author = "system"
+	}
+	config := plan.promote.deploymentConfig(fmt.Sprintf("promote_%d", time.Now().UnixNano()), req.ProjectID, req.Target)
+	config.OrganizationID = plan.source.OrganizationID
+	config.GitCommit = plan.source.GitCommit
+	config.Team = plan.source.Team
+	config.PromotedFrom = plan.source.ID
+	if req.Strategy != "" {
+		config.Strategy = req.Strategy
+	}
+	if pm.executor.calendar != nil {
+		if err := pm.executor.calendar.Authorize(ctx, config, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+	if plan.target != nil {
+		if err := pm.envs.promoteVariables(ctx, plan.target.ID, author, plan.rules, plan.sourceVars); err != nil {
+			return nil, fmt.Errorf("failed to promote configuration: %w", err)
+		}
+	}
+	result, err := pm.executor.Execute(ctx, config)
+	if (err != nil || result.Status != "success") && plan.target != nil {
+		if restoreErr := pm.envs.RestoreConfig(ctx, req.ProjectID, req.Target, plan.live); restoreErr != nil {
+			fmt.Printf("⚠️  Failed to restore configuration after promotion failure: %v\n", restoreErr)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("promotion deployment failed: %w", err)
+	}
+	record := newDeploymentRecord(config, result, author)
+	record.Configuration["promoted_from_environment"] = req.Source
+	if plan.target != nil {
+		if live, err := pm.envs.CaptureConfig(ctx, req.ProjectID, req.Target); err == nil {
+			record.Snapshot = record.Snapshot.withEnvironment(live)
+		}
+	}
+	if err := pm.history.RecordDeployment(ctx, record); err != nil {
+		return nil, err
+	}
+	if result.Status == "success" {
+		fmt.Printf("🚀 Promoted %s from %s to %s as %s\n", plan.source.ID, req.Source, req.Target, record.ID)
+	}
+	return record, nil
+}
+func (pm *PromotionManager) Lineage(ctx context.Context, deploymentID string) ([]*DeploymentRecord, error) {
+	var lineage []*DeploymentRecord
+	seen := make(map[string]bool)
+	for id := deploymentID; id != "" && len(lineage) < maxLineageDepth; {
+		if seen[id] {
+			break
+		}
+		seen[id] = true
+		record, err := pm.history.GetDeployment(ctx, id)
+		if err != nil {
+			if len(lineage) == 0 {
+				return nil, err
+			}
+			break
+		}
+		lineage = append(lineage, record)
+		id = record.PromotedFrom
+	}
+	return lineage, nil
+}
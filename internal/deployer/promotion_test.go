@@ -0,0 +1,82 @@
+package deployer
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+func promotionManager(t *testing.T, calendar *CalendarManager) (*PromotionManager, *EnvironmentManager, *DeploymentHistory) {
+	t.Helper()
+	history := NewDeploymentHistory(t.TempDir())
+	lb := &fakeBalancer{weights: map[string]int{"v1": 100}}
+	de, err := NewDeploymentExecutor(fakeHealth{}, lb, fakeConversions{}, NewFileStateStore(t.TempDir()), nil, calendar, nil, history)
+	if err != nil {
+		t.Fatalf("NewDeploymentExecutor: %v", err)
+	}
+	envs := NewEnvironmentManagerWithKeyring(newStaticKeyring("k1", bytes.Repeat([]byte("k"), 32)), t.TempDir())
+	return NewPromotionManager(history, de, envs), envs, history
+}
+func recordStagingDeployment(t *testing.T, history *DeploymentHistory, image string) {
+	t.Helper()
+	err := history.RecordDeployment(context.Background(), &DeploymentRecord{
+		ID:             "staging-v2",
+		OrganizationID: "org",
+		ProjectID:      "proj",
+		Environment:    "staging",
+		Version:        "v2",
+		Image:          image,
+		Strategy:       StrategyDirect,
+		Status:         "success",
+		DeployedAt:     time.Now().Add(-time.Hour),
+		Snapshot: &ConfigSnapshot{
+			Version:        "v2",
+			Image:          image,
+			Replicas:       2,
+			HealthCheckURL: "http://app",
+			Variables:      map[string]string{"FEATURE": "on"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("RecordDeployment: %v", err)
+	}
+}
+func TestPromotionRequiresDigestByDefault(t *testing.T) {
+	pm, _, history := promotionManager(t, nil)
+	recordStagingDeployment(t, history, "registry.example.com/app:v2")
+	_, err := pm.PreviewPromotion(WithSystemPrincipal(context.Background()), PromotionRequest{ProjectID: "proj", Source: "staging", Target: "production"})
+	if err == nil || !strings.Contains(err.Error(), "not pinned to a digest") {
+		t.Fatalf("expected an unpinned image to be rejected, got %v", err)
+	}
+}
+func TestPromoteChecksCalendarBeforeChangingTarget(t *testing.T) {
+	ctx := WithSystemPrincipal(context.Background())
+	calendar := NewCalendarManager(t.TempDir(), nil)
+	err := calendar.SaveCalendar(ctx, &ChangeCalendar{
+		Name:           "freeze",
+		OrganizationID: "org",
+		Timezone:       "UTC",
+		FreezeWindows:  []FreezeWindow{{Name: "release freeze", Start: time.Now().Add(-time.Hour), End: time.Now().Add(time.Hour)}},
+	})
+	if err != nil {
+		t.Fatalf("SaveCalendar: %v", err)
+	}
+	pm, envs, history := promotionManager(t, calendar)
+	recordStagingDeployment(t, history, "registry.example.com/app@sha256:abc")
+	target := &Environment{ProjectID: "proj", Name: "production", Type: EnvironmentProduction, Variables: map[string]string{"FEATURE": "off"}}
+	if err := envs.CreateEnvironment(ctx, target); err != nil {
+		t.Fatalf("CreateEnvironment: %v", err)
+	}
+	_, err = pm.Promote(ctx, PromotionRequest{ProjectID: "proj", Source: "staging", Target: "production"})
+	if !errors.Is(err, ErrDeployWindowClosed) {
+		t.Fatalf("expected the freeze to block the promotion, got %v", err)
+	}
+	stored, err := envs.GetEnvironment(ctx, target.ID)
+	if err != nil {
+		t.Fatalf("GetEnvironment: %v", err)
+	}
+	if stored.Variables["FEATURE"] != "off" || stored.Revision != target.Revision {
+		t.Fatalf("blocked promotion changed the target: FEATURE=%s revision %d", stored.Variables["FEATURE"], stored.Revision)
+	}
+}
@@ -0,0 +1,254 @@
+package deployer
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+var ErrReleaseCycle = errors.New("release dependency graph has a cycle")
+type Release struct {
+	ID          string
+	Name        string
+	Services    []ReleaseService
+	MaxParallel int
+	RequestedBy string
+}
+type ReleaseService struct {
+	Name      string
+	Config    *DeploymentConfig
+	DependsOn []string
+}
+type ServiceResult struct {
+	Name         string            `json:"name"`
+	DeploymentID string            `json:"deployment_id,omitempty"`
+	Status       string            `json:"status"`
+	Error        string            `json:"error,omitempty"`
+	StartTime    time.Time         `json:"start_time,omitempty"`
+	EndTime      time.Time         `json:"end_time,omitempty"`
+	Result       *DeploymentResult `json:"-"`
+	RolledBackTo string            `json:"rolled_back_to,omitempty"`
+}
+type ReleaseResult struct {
+	ReleaseID     string           `json:"release_id"`
+	Name          string           `json:"name"`
+	Status        string           `json:"status"`
+	StartTime     time.Time        `json:"start_time"`
+	EndTime       time.Time        `json:"end_time"`
+	Services      []*ServiceResult `json:"services"`
+	RollbackOrder []string         `json:"rollback_order,omitempty"`
+	Error         string           `json:"error,omitempty"`
+}
+func (r *Release) order() ([]string, error) {
+	deps := make(map[string][]string, len(r.Services))
+	targets := make(map[[2]string]string, len(r.Services))
+	for _, svc := range r.Services {
+		if svc.Name == "" || svc.Config == nil {
+			return nil, fmt.Errorf("release services require a name and a deployment config")
+		}
+		if _, dup := deps[svc.Name]; dup {
+			return nil, fmt.Errorf("duplicate release service %q", svc.Name)
+		}
+		target := [2]string{svc.Config.ProjectID, svc.Config.Environment}
+		if other, dup := targets[target]; dup {
+			return nil, fmt.Errorf("services %q and %q both deploy to %s/%s, so their deployments could not be rolled back separately", other, svc.Name, target[0], target[1])
+		}
+		targets[target] = svc.Name
+		deps[svc.Name] = svc.DependsOn
+	}
+	indegree := make(map[string]int, len(deps))
+	dependents := make(map[string][]string)
+	for name, dependsOn := range deps {
+		for _, dep := range dependsOn {
+			if _, ok := deps[dep]; !ok {
+				return nil, fmt.Errorf("service %q depends on unknown service %q", name, dep)
+			}
+			indegree[name]++
+			dependents[dep] = append(dependents[dep], name)
+		}
+	}
+	var ready, order []string
+	for name := range deps {
+		if indegree[name] == 0 {
+			ready = append(ready, name)
+		}
+	}
+	for len(ready) > 0 {
+		sort.Strings(ready)
+		name := ready[0]
+		ready = ready[1:]
+		order = append(order, name)
+		for _, dependent := range dependents[name] {
+			indegree[dependent]--
+			if indegree[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+	if len(order) != len(deps) {
+		var stuck []string
+		for name := range deps {
+			if indegree[name] > 0 {
+				stuck = append(stuck, name)
+			}
+		}
+		sort.Strings(stuck)
+		return nil, fmt.Errorf("%w: %s", ErrReleaseCycle, strings.Join(stuck, ", "))
+	}
+	return order, nil
+}
+func (rm *RollbackManager) ExecuteRelease(ctx context.Context, release *Release) (*ReleaseResult, error) {
+	if _, err := release.order(); err != nil {
+		return nil, err
+	}
+	if release.ID == "" {
+		release.ID = fmt.Sprintf("release_%d", time.Now().UnixNano())
+	}
+	if release.RequestedBy == "" {
+		release.RequestedBy = "system"
+	}
+	result := &ReleaseResult{
+		ReleaseID: release.ID,
+		Name:      release.Name,
+		StartTime: time.Now(),
+	}
+	services := make(map[string]ReleaseService, len(release.Services))
+	results := make(map[string]*ServiceResult, len(release.Services))
+	previous := make(map[string]*DeploymentRecord, len(release.Services))
+	previousErrs := make(map[string]error, len(release.Services))
+	for _, svc := range release.Services {
+		services[svc.Name] = svc
+		previous[svc.Name], previousErrs[svc.Name] = rm.history.GetLastSuccessfulDeployment(ctx, svc.Config.ProjectID, svc.Config.Environment)
+		if svc.Config.DeploymentID == "" {
+			svc.Config.DeploymentID = fmt.Sprintf("%s-%s", release.ID, svc.Name)
+		}
+		results[svc.Name] = &ServiceResult{Name: svc.Name, DeploymentID: svc.Config.DeploymentID, Status: "pending"}
+		result.Services = append(result.Services, results[svc.Name])
+	}
+	parallel := release.MaxParallel
+	if parallel <= 0 {
+		parallel = len(release.Services)
+	}
+	fmt.Printf("📦 Starting release %s with %d services\n", release.ID, len(release.Services))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var completed []string
+	var failure error
+	running := 0
+	done := make(chan struct{}, len(release.Services))
+	for {
+		mu.Lock()
+		if failure == nil {
+			for _, svc := range release.Services {
+				if running >= parallel {
+					break
+				}
+				sr := results[svc.Name]
+				if sr.Status != "pending" || !dependenciesSucceeded(svc, results) {
+					continue
+				}
+				sr.Status = "running"
+				sr.StartTime = time.Now()
+				running++
+				wg.Add(1)
+				go func(svc ReleaseService, sr *ServiceResult) {
+					defer wg.Done()
+					fmt.Printf("🚀 Deploying %s (%s)\n", svc.Name, svc.Config.Version)
+					res, err := rm.executor.Execute(ctx, svc.Config)
+					if res != nil {
+						if _, recordErr := rm.RecordResult(ctx, svc.Config, res, release.RequestedBy); recordErr != nil && err == nil {
+							err = fmt.Errorf("failed to record deployment: %w", recordErr)
+						}
+					}
+					mu.Lock()
+					sr.EndTime = time.Now()
+					sr.Result = res
+					switch {
+					case err == nil && res != nil && res.Status == "success":
+						sr.Status = "success"
+						completed = append(completed, svc.Name)
+					default:
+						sr.Status = "failed"
+						if res != nil && res.Status != "" {
+							sr.Status = res.Status
+						}
+						if err == nil {
+							err = fmt.Errorf("deployment finished with status %s", sr.Status)
+						}
+						sr.Error = err.Error()
+						if failure == nil {
+							failure = fmt.Errorf("service %s failed: %w", svc.Name, err)
+						}
+					}
+					running--
+					mu.Unlock()
+					done <- struct{}{}
+				}(svc, sr)
+			}
+		}
+		idle := running == 0
+		mu.Unlock()
+		if idle {
+			break
+		}
+		<-done
+	}
+	wg.Wait()
+	for _, sr := range result.Services {
+		if sr.Status == "pending" {
+			sr.Status = "skipped"
+		}
+	}
+	if failure == nil {
+		result.Status = "success"
+		result.EndTime = time.Now()
+		fmt.Printf("✅ Release %s deployed %d services\n", release.ID, len(completed))
+		return result, nil
+	}
+	result.Error = failure.Error()
+	result.Status = "rolled_back"
+	fmt.Printf("🔄 Release %s failed, rolling back %d services\n", release.ID, len(completed))
+	var rollbackErrs []error
+	for i := len(completed) - 1; i >= 0; i-- {
+		name := completed[i]
+		sr := results[name]
+		result.RollbackOrder = append(result.RollbackOrder, name)
+		svc := services[name]
+		target := previous[name]
+		var err error
+		if target == nil {
+			err = fmt.Errorf("no earlier successful deployment of %s/%s to roll back to: %v", svc.Config.ProjectID, svc.Config.Environment, previousErrs[name])
+		} else {
+			fmt.Printf("⏪ Rolling %s back to %s (%s)\n", name, target.ID, target.Version)
+			var res *DeploymentResult
+			res, err = rm.Rollback(ctx, svc.Config.ProjectID, svc.Config.Environment, target.ID)
+			if err == nil && res.Status != "success" {
+				err = fmt.Errorf("rollback deployment finished with status %s", res.Status)
+			}
+		}
+		if err != nil {
+			sr.Error = fmt.Sprintf("rollback failed: %v", err)
+			rollbackErrs = append(rollbackErrs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		sr.Status = "rolled_back"
+		sr.RolledBackTo = target.ID
+	}
+	if len(rollbackErrs) > 0 {
+		result.Status = "failed"
+		failure = fmt.Errorf("%w; rollback failed: %w", failure, errors.Join(rollbackErrs...))
+		result.Error = failure.Error()
+	}
+	result.EndTime = time.Now()
+	return result, failure
+}
+func dependenciesSucceeded(svc ReleaseService, results map[string]*ServiceResult) bool {
+	for _, dep := range svc.DependsOn {
+		if results[dep].Status != "success" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,80 @@
+package deployer
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+func releaseManager(t *testing.T, failing string) (*RollbackManager, *DeploymentHistory) {
+	t.Helper()
+	history := NewDeploymentHistory(t.TempDir())
+	lb := &fakeBalancer{weights: map[string]int{"v1": 100}}
+	de, err := NewDeploymentExecutor(fakeHealth{failing: map[string]bool{failing: true}}, lb, fakeConversions{}, NewFileStateStore(t.TempDir()), nil, nil, nil, history)
+	if err != nil {
+		t.Fatalf("NewDeploymentExecutor: %v", err)
+	}
+	return NewRollbackManager(history, de, fakeConversions{}, nil), history
+}
+func testRelease() *Release {
+	return &Release{
+		ID: "rel",
+		Services: []ReleaseService{
+			{Name: "db", Config: &DeploymentConfig{ProjectID: "db", Environment: "production", Strategy: StrategyDirect, Version: "v2", Replicas: 2, HealthCheckURL: "http://db"}},
+			{Name: "api", DependsOn: []string{"db"}, Config: &DeploymentConfig{ProjectID: "api", Environment: "production", Strategy: StrategyDirect, Version: "v2", Replicas: 2, HealthCheckURL: "http://api"}},
+		},
+	}
+}
+func TestReleaseRollbackRedeploysPreviousVersion(t *testing.T) {
+	ctx := context.Background()
+	rm, history := releaseManager(t, "http://api")
+	for _, project := range []string{"db", "api"} {
+		err := history.RecordDeployment(ctx, &DeploymentRecord{ID: project + "-v1", ProjectID: project, Environment: "production", Version: "v1", Strategy: StrategyDirect, Status: "success", DeployedAt: time.Now().Add(-time.Hour)})
+		if err != nil {
+			t.Fatalf("RecordDeployment: %v", err)
+		}
+	}
+	result, err := rm.ExecuteRelease(ctx, testRelease())
+	if err == nil {
+		t.Fatal("expected the release to fail")
+	}
+	if result.Status != "rolled_back" {
+		t.Fatalf("expected the release to be rolled back, got %s: %s", result.Status, result.Error)
+	}
+	db := result.Services[0]
+	if db.Status != "rolled_back" || db.RolledBackTo != "db-v1" {
+		t.Fatalf("expected db to be rolled back to db-v1, got %+v", db)
+	}
+	latest, err := history.ListDeployments(ctx, "db", "production", 1)
+	if err != nil || len(latest) != 1 {
+		t.Fatalf("ListDeployments: %v", err)
+	}
+	if latest[0].Version != "v1" || latest[0].RollbackFrom == "" {
+		t.Fatalf("expected a rollback deployment of v1 to be recorded, got %+v", latest[0])
+	}
+}
+func TestReleaseRollbackFailsWithoutPreviousDeployment(t *testing.T) {
+	rm, _ := releaseManager(t, "http://api")
+	result, err := rm.ExecuteRelease(context.Background(), testRelease())
+	if err == nil || !strings.Contains(err.Error(), "no earlier successful deployment of db/production") {
+		t.Fatalf("expected a loud rollback failure, got %v", err)
+	}
+	if result.Status != "failed" || result.Services[0].Status == "rolled_back" {
+		t.Fatalf("expected the release to be reported as failed, got %s with db %s", result.Status, result.Services[0].Status)
+	}
+}
+func TestReleaseRejectsServicesSharingAnEnvironment(t *testing.T) {
+	rm, history := releaseManager(t, "")
+	release := testRelease()
+	release.Services[1].Config.ProjectID = "db"
+	_, err := rm.ExecuteRelease(context.Background(), release)
+	if err == nil || !strings.Contains(err.Error(), `services "db" and "api" both deploy to db/production`) {
+		t.Fatalf("expected the shared environment to be rejected, got %v", err)
+	}
+	records, err := history.ListDeployments(context.Background(), "db", "production", 10)
+	if err != nil {
+		t.Fatalf("ListDeployments: %v", err)
+	}
+	if len(records) != 0 {
+		t.Fatalf("expected nothing to be deployed, got %d records", len(records))
+	}
+}
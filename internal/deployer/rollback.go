@@ -1,36 +1,44 @@
 package deployer
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
-	"path/filepath"
-	"sort"
+	"strings"
 	"time"
 )
 type DeploymentHistory struct {
 	storagePath string
+	store       HistoryStore
 }
 type DeploymentRecord struct {
 	ID             string                 `json:"id"`
+	OrganizationID string                 `json:"organization_id,omitempty"`
 	ProjectID      string                 `json:"project_id"`
 	Environment    string                 `json:"environment"`
 	Version        string                 `json:"version"`
+	GitCommit      string                 `json:"git_commit,omitempty"`
+	Team           string                 `json:"team,omitempty"`
 	Image          string                 `json:"image"`
 	Strategy       DeploymentStrategy     `json:"strategy"`
 	Status         string                 `json:"status"`
 	DeployedAt     time.Time              `json:"deployed_at"`
 	DeployedBy     string                 `json:"deployed_by"`
 	RollbackFrom   string                 `json:"rollback_from,omitempty"`
+	PromotedFrom   string                 `json:"promoted_from,omitempty"`
 	Configuration  map[string]interface{} `json:"configuration"`
 	Metrics        *DeploymentMetrics     `json:"metrics,omitempty"`
 	Duration       time.Duration          `json:"duration"`
 	RollbackReason string                 `json:"rollback_reason,omitempty"`
+	Approvals      []ApprovalDecision     `json:"approvals,omitempty"`
+	Experiment     *ExperimentResult      `json:"experiment,omitempty"`
+	Snapshot       *ConfigSnapshot        `json:"snapshot,omitempty"`
+	Evaluations    []TriggerEvaluation    `json:"trigger_evaluations,omitempty"`
 }
 type RollbackManager struct {
 	history  *DeploymentHistory
 	executor *DeploymentExecutor
 	monitor  DeploymentMonitor
+	configs  ConfigSource
 }
 type RollbackTrigger struct {
 	ErrorRateThreshold    float64
@@ -41,122 +49,228 @@ type RollbackTrigger struct {
 	CPUThreshold          float64
 	MemoryThreshold       float64
 	CustomMetricThreshold map[string]float64
+	SustainFor            time.Duration
+	EvaluationInterval    time.Duration
+	Logic                 TriggerLogic
+	Conditions            []MetricCondition
+	Triggers              []*RollbackTrigger
 }
 func NewDeploymentHistory(storagePath string) *DeploymentHistory {
+	return NewDeploymentHistoryWithStore(storagePath, NewFileHistoryStore(storagePath))
+}
+func NewDeploymentHistoryWithStore(storagePath string, store HistoryStore) *DeploymentHistory {
 	return &DeploymentHistory{
 		storagePath: storagePath,
+		store:       store,
 	}
 }
-func NewRollbackManager(history *DeploymentHistory, executor *DeploymentExecutor, monitor DeploymentMonitor) *RollbackManager {
+func NewRollbackManager(history *DeploymentHistory, executor *DeploymentExecutor, monitor DeploymentMonitor, configs ConfigSource) *RollbackManager {
 	return &RollbackManager{
 		history:  history,
 		executor: executor,
 		monitor:  monitor,
+		configs:  configs,
 	}
 }
 func (dh *DeploymentHistory) RecordDeployment(ctx context.Context, record *DeploymentRecord) error {
 	if record.ID == "" {
 		record.ID = fmt.Sprintf("deploy_%d", time.Now().UnixNano())
 	}
-	if err := os.MkdirAll(dh.storagePath, 0755); err != nil {
-		return err
+	return dh.store.Save(ctx, record)
+}
+func (dh *DeploymentHistory) RecordResult(ctx context.Context, config *DeploymentConfig, result *DeploymentResult, deployedBy string) (*DeploymentRecord, error) {
+	record := newDeploymentRecord(config, result, deployedBy)
+	if err := dh.RecordDeployment(ctx, record); err != nil {
+		return nil, err
 	}
-	data, err := json.MarshalIndent(record, "", "  ")
-	if err != nil {
-		return err
+	return record, nil
+}
+func newDeploymentRecord(config *DeploymentConfig, result *DeploymentResult, deployedBy string) *DeploymentRecord {
+	record := &DeploymentRecord{
+		ID:             result.DeploymentID,
+		OrganizationID: config.OrganizationID,
+		ProjectID:      config.ProjectID,
+		Environment:    config.Environment,
+		Version:        config.Version,
+		GitCommit:      config.GitCommit,
+		Team:           config.Team,
+		Image:          config.Image,
+		Strategy:       config.Strategy,
+		Status:         result.Status,
+		DeployedAt:     result.StartTime,
+		DeployedBy:     deployedBy,
+		PromotedFrom:   config.PromotedFrom,
+		Configuration:  map[string]interface{}{},
+		Duration:       result.Duration(),
+		RollbackReason: result.RollbackReason,
+		Approvals:      result.Approvals,
+		Experiment:     result.Experiment,
+		Snapshot:       newConfigSnapshot(config),
+	}
+	if result.Experiment != nil && result.Experiment.Promoted {
+		record.Version = result.Experiment.WinnerVersion
+		record.Snapshot.Version = record.Version
 	}
-	filename := filepath.Join(dh.storagePath, fmt.Sprintf("%s.json", record.ID))
-	return os.WriteFile(filename, data, 0644)
+	return record
 }
 func (dh *DeploymentHistory) GetDeployment(ctx context.Context, deploymentID string) (*DeploymentRecord, error) {
-	filename := filepath.Join(dh.storagePath, fmt.Sprintf("%s.json", deploymentID))
-	data, err := os.ReadFile(filename)
+	return dh.store.Get(ctx, deploymentID)
+}
+func (dh *DeploymentHistory) QueryDeployments(ctx context.Context, query HistoryQuery) (*HistoryPage, error) {
+	return dh.store.List(ctx, query)
+}
+func (dh *DeploymentHistory) ListDeployments(ctx context.Context, projectID, environment string, limit int) ([]*DeploymentRecord, error) {
+	page, err := dh.store.List(ctx, HistoryQuery{
+		ProjectID:   projectID,
+		Environment: environment,
+		Limit:       limit,
+	})
 	if err != nil {
 		return nil, err
 	}
-	var record DeploymentRecord
-	if err := json.Unmarshal(data, &record); err != nil {
-		return nil, err
-	}
-	return &record, nil
+	return page.Records, nil
 }
-func (dh *DeploymentHistory) ListDeployments(ctx context.Context, projectID, environment string, limit int) ([]*DeploymentRecord, error) {
-	files, err := os.ReadDir(dh.storagePath)
+func (dh *DeploymentHistory) GetLastSuccessfulDeployment(ctx context.Context, projectID, environment string) (*DeploymentRecord, error) {
+	page, err := dh.store.List(ctx, HistoryQuery{
+		ProjectID:   projectID,
+		Environment: environment,
+		Status:      "success",
+		Limit:       1,
+	})
 	if err != nil {
 		return nil, err
 	}
-	var records []*DeploymentRecord
-	for _, file := range files {
-		if filepath.Ext(file.Name()) != ".json" {
-			continue
-		}
-		data, err := os.ReadFile(filepath.Join(dh.storagePath, file.Name()))
-		if err != nil {
-			continue
-		}
-		var record DeploymentRecord
-		if err := json.Unmarshal(data, &record); err != nil {
-			continue
+	if len(page.Records) == 0 {
+		return nil, fmt.Errorf("no successful deployment found")
+	}
+	return page.Records[0], nil
+}
+func (rm *RollbackManager) RecordResult(ctx context.Context, config *DeploymentConfig, result *DeploymentResult, deployedBy string) (*DeploymentRecord, error) {
+	record := newDeploymentRecord(config, result, deployedBy)
+	if rm.configs != nil {
+		env, err := rm.configs.CaptureConfig(ctx, config.ProjectID, config.Environment)
+		if err != nil && !errors.Is(err, ErrEnvironmentNotFound) {
+			return nil, fmt.Errorf("failed to snapshot environment configuration: %w", err)
 		}
-		if record.ProjectID == projectID && (environment == "" || record.Environment == environment) {
-			records = append(records, &record)
+		if env != nil {
+			record.Snapshot = record.Snapshot.withEnvironment(env)
 		}
 	}
-	sort.Slice(records, func(i, j int) bool {
-		return records[i].DeployedAt.After(records[j].DeployedAt)
-	})
-	if limit > 0 && len(records) > limit {
-		records = records[:limit]
+	if err := rm.history.RecordDeployment(ctx, record); err != nil {
+		return nil, err
 	}
-	return records, nil
+	return record, nil
 }
-func (dh *DeploymentHistory) GetLastSuccessfulDeployment(ctx context.Context, projectID, environment string) (*DeploymentRecord, error) {
-	deployments, err := dh.ListDeployments(ctx, projectID, environment, 100)
+type rollbackPlan struct {
+	preview *RollbackPreview
+	source  *DeploymentRecord
+	current *DeploymentRecord
+	live    *ConfigSnapshot
+	target  *ConfigSnapshot
+}
+func (rm *RollbackManager) PreviewRollback(ctx context.Context, projectID, environment, targetDeploymentID string) (*RollbackPreview, error) {
+	plan, err := rm.planRollback(ctx, projectID, environment, targetDeploymentID)
 	if err != nil {
 		return nil, err
 	}
-	for _, deployment := range deployments {
-		if deployment.Status == "success" {
-			return deployment, nil
-		}
-	}
-	return nil, fmt.Errorf("no successful deployment found")
+	return plan.preview, nil
 }
-func (rm *RollbackManager) Rollback(ctx context.Context, projectID, environment, targetDeploymentID string) (*DeploymentResult, error) {
+func (rm *RollbackManager) planRollback(ctx context.Context, projectID, environment, targetDeploymentID string) (*rollbackPlan, error) {
 	targetDeployment, err := rm.history.GetDeployment(ctx, targetDeploymentID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get target deployment: %w", err)
 	}
+	if targetDeployment.ProjectID != projectID || targetDeployment.Environment != environment {
+		return nil, fmt.Errorf("deployment %s does not belong to %s/%s", targetDeploymentID, projectID, environment)
+	}
 	currentDeployments, err := rm.history.ListDeployments(ctx, projectID, environment, 1)
 	if err != nil || len(currentDeployments) == 0 {
 		return nil, fmt.Errorf("failed to get current deployment")
 	}
-	currentDeployment := currentDeployments[0]
-	config := &DeploymentConfig{
-		Strategy:           StrategyDirect,
-		Version:            targetDeployment.Version,
-		Image:              targetDeployment.Image,
-		Replicas:           3,
-		HealthCheckURL:     "/health",
-		HealthCheckTimeout: 30 * time.Second,
+	plan := &rollbackPlan{source: targetDeployment, current: currentDeployments[0]}
+	plan.live = plan.current.Snapshot
+	if plan.live == nil {
+		plan.live = &ConfigSnapshot{Version: plan.current.Version, Image: plan.current.Image}
+	}
+	var warnings []string
+	if rm.configs != nil {
+		env, err := rm.configs.CaptureConfig(ctx, projectID, environment)
+		if err != nil && !errors.Is(err, ErrEnvironmentNotFound) {
+			return nil, fmt.Errorf("failed to read current environment configuration: %w", err)
+		}
+		if env != nil {
+			plan.live = plan.live.withEnvironment(env)
+		}
+	}
+	plan.target = targetDeployment.Snapshot
+	switch {
+	case plan.target == nil:
+		if !plan.live.hasDeploymentSettings() {
+			return nil, fmt.Errorf("neither deployment %s nor the current deployment %s recorded replica and health check settings; redeploy %s explicitly instead", targetDeployment.ID, plan.current.ID, targetDeployment.Version)
+		}
+		plan.target = plan.live.withEnvironment(plan.live)
+		plan.target.Version = targetDeployment.Version
+		plan.target.Image = targetDeployment.Image
+		warnings = append(warnings, "target deployment has no configuration snapshot; only the image will be rolled back")
+	case !plan.target.hasEnvironment():
+		plan.target = plan.target.withEnvironment(plan.live)
+		warnings = append(warnings, "target deployment did not capture environment configuration; variables and secrets will be kept")
+	case rm.configs == nil:
+		warnings = append(warnings, "no configuration source is attached; variables and secrets will be kept")
+	}
+	plan.preview = &RollbackPreview{
+		ProjectID:           projectID,
+		Environment:         environment,
+		CurrentDeployment:   plan.current.ID,
+		TargetDeployment:    targetDeployment.ID,
+		Changes:             diffSnapshots(plan.live, plan.target),
+		RestoresEnvironment: rm.configs != nil && targetDeployment.Snapshot.hasEnvironment(),
+		Warnings:            warnings,
+	}
+	return plan, nil
+}
+func (rm *RollbackManager) Rollback(ctx context.Context, projectID, environment, targetDeploymentID string) (*DeploymentResult, error) {
+	plan, err := rm.planRollback(ctx, projectID, environment, targetDeploymentID)
+	if err != nil {
+		return nil, err
+	}
+	if plan.preview.RestoresEnvironment {
+		if err := rm.configs.RestoreConfig(ctx, projectID, environment, plan.target); err != nil {
+			return nil, fmt.Errorf("failed to restore environment configuration: %w", err)
+		}
 	}
+	deploymentID := fmt.Sprintf("rollback_%d", time.Now().UnixNano())
+	config := plan.target.deploymentConfig(deploymentID, projectID, environment)
+	config.OrganizationID = plan.source.OrganizationID
+	config.GitCommit = plan.source.GitCommit
+	config.Team = plan.source.Team
 	result, err := rm.executor.Execute(ctx, config)
+	if (err != nil || result.Status != "success") && plan.preview.RestoresEnvironment && plan.live.hasEnvironment() {
+		if restoreErr := rm.configs.RestoreConfig(ctx, projectID, environment, plan.live); restoreErr != nil {
+			fmt.Printf("⚠️  Failed to restore configuration after rollback failure: %v\n", restoreErr)
+		}
+	}
 	if err != nil {
 		return nil, fmt.Errorf("rollback deployment failed: %w", err)
 	}
 	rollbackRecord := &DeploymentRecord{
+		ID:             deploymentID,
+		OrganizationID: plan.source.OrganizationID,
 		ProjectID:      projectID,
 		Environment:    environment,
-		Version:        targetDeployment.Version,
-		Image:          targetDeployment.Image,
+		Version:        plan.target.Version,
+		GitCommit:      plan.source.GitCommit,
+		Team:           plan.source.Team,
+		Image:          plan.target.Image,
 		Strategy:       StrategyDirect,
 		Status:         result.Status,
 		DeployedAt:     time.Now(),
 		DeployedBy:     "system",
-		RollbackFrom:   currentDeployment.ID,
-		Configuration:  targetDeployment.Configuration,
+		RollbackFrom:   plan.current.ID,
+		Configuration:  map[string]interface{}{"target_deployment": targetDeploymentID},
 		Duration:       result.Duration(),
 		RollbackReason: "Manual rollback",
+		Snapshot:       plan.target,
 	}
 	if err := rm.history.RecordDeployment(ctx, rollbackRecord); err != nil {
 		return result, fmt.Errorf("failed to record rollback: %w", err)
@@ -186,59 +300,123 @@ func (rm *RollbackManager) MonitorAndAutoRollback(ctx context.Context, deploymen
 			MemoryThreshold:     0.90,
 		}
 	}
-	ticker := time.NewTicker(30 * time.Second)
+	group, err := trigger.compile()
+	if err != nil {
+		return err
+	}
+	interval := trigger.EvaluationInterval
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	evaluator := &triggerEvaluator{
+		rm:            rm,
+		version:       deployment.Version,
+		breachedSince: make(map[*MetricCondition]time.Time),
+	}
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 	consecutiveFailures := 0
 	startTime := time.Now()
+	evaluated := false
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
 		case <-ticker.C:
-			if time.Since(startTime) > trigger.MonitoringWindow {
+			now := time.Now()
+			if evaluated && now.Sub(startTime) > trigger.MonitoringWindow {
 				return nil
 			}
+			evaluated = true
+			eval := TriggerEvaluation{At: now, Logic: group.logic}
 			metrics, err := rm.monitor.GetMetrics(ctx, deployment.Version)
 			if err != nil {
 				consecutiveFailures++
-				if consecutiveFailures >= trigger.ConsecutiveFailures {
+				eval.Reason = fmt.Sprintf("metrics unavailable (%d/%d): %v", consecutiveFailures, trigger.ConsecutiveFailures, err)
+				eval.Fired = trigger.ConsecutiveFailures > 0 && consecutiveFailures >= trigger.ConsecutiveFailures
+				rm.recordTriggerEvaluation(ctx, deployment, &eval)
+				if eval.Fired {
 					return rm.triggerAutoRollback(ctx, deployment, "Consecutive health check failures")
 				}
 				continue
 			}
 			consecutiveFailures = 0
-			if metrics.ErrorRate > trigger.ErrorRateThreshold {
-				return rm.triggerAutoRollback(ctx, deployment,
-					fmt.Sprintf("Error rate %.2f%% exceeds threshold %.2f%%",
-						metrics.ErrorRate*100, trigger.ErrorRateThreshold*100))
-			}
-			if metrics.Latency > trigger.LatencyThreshold {
-				return rm.triggerAutoRollback(ctx, deployment,
-					fmt.Sprintf("Latency %v exceeds threshold %v",
-						metrics.Latency, trigger.LatencyThreshold))
-			}
-			if metrics.CPUUsage > trigger.CPUThreshold {
-				return rm.triggerAutoRollback(ctx, deployment,
-					fmt.Sprintf("CPU usage %.2f%% exceeds threshold %.2f%%",
-						metrics.CPUUsage*100, trigger.CPUThreshold*100))
+			evaluator.failedRequests += metrics.RequestRate * metrics.ErrorRate * interval.Seconds()
+			fired, reasons := evaluator.evaluate(ctx, group, metrics, now, &eval)
+			eval.Fired = fired
+			if fired {
+				eval.Reason = strings.Join(reasons, fmt.Sprintf(" %s ", logicJoiner(group.logic)))
+			} else {
+				eval.Reason = "trigger conditions not met"
 			}
-			if metrics.MemoryUsage > trigger.MemoryThreshold {
-				return rm.triggerAutoRollback(ctx, deployment,
-					fmt.Sprintf("Memory usage %.2f%% exceeds threshold %.2f%%",
-						metrics.MemoryUsage*100, trigger.MemoryThreshold*100))
+			rm.recordTriggerEvaluation(ctx, deployment, &eval)
+			if fired {
+				return rm.triggerAutoRollback(ctx, deployment, eval.Reason)
 			}
 		}
 	}
 }
+func (rm *RollbackManager) recordTriggerEvaluation(ctx context.Context, deployment *DeploymentRecord, eval *TriggerEvaluation) {
+	logTriggerEvaluation(deployment.ID, eval)
+	if err := rm.persistTriggerEvaluation(ctx, deployment, eval); err != nil {
+		fmt.Printf("⚠️  Failed to persist trigger evaluation for %s: %v\n", deployment.ID, err)
+		deployment.Evaluations = appendTriggerEvaluation(deployment.Evaluations, *eval)
+	}
+}
+func (rm *RollbackManager) persistTriggerEvaluation(ctx context.Context, deployment *DeploymentRecord, eval *TriggerEvaluation) error {
+	latest, err := rm.history.GetDeployment(ctx, deployment.ID)
+	if err != nil {
+		return err
+	}
+	latest.Evaluations = appendTriggerEvaluation(latest.Evaluations, *eval)
+	if err := rm.history.RecordDeployment(ctx, latest); err != nil {
+		return err
+	}
+	*deployment = *latest
+	return nil
+}
+func appendTriggerEvaluation(evaluations []TriggerEvaluation, eval TriggerEvaluation) []TriggerEvaluation {
+	evaluations = append(evaluations, eval)
+	if len(evaluations) > maxTriggerEvaluations {
+		evaluations = evaluations[len(evaluations)-maxTriggerEvaluations:]
+	}
+	return evaluations
+}
 func (rm *RollbackManager) triggerAutoRollback(ctx context.Context, deployment *DeploymentRecord, reason string) error {
 	fmt.Printf("🔴 AUTO-ROLLBACK TRIGGERED: %s\n", reason)
-	result, err := rm.RollbackToLastSuccessful(ctx, deployment.ProjectID, deployment.Environment)
+	page, err := rm.history.QueryDeployments(ctx, HistoryQuery{
+		ProjectID:   deployment.ProjectID,
+		Environment: deployment.Environment,
+		Status:      "success",
+		Limit:       2,
+	})
+	var target *DeploymentRecord
+	if err == nil {
+		for _, record := range page.Records {
+			if record.ID != deployment.ID {
+				target = record
+				break
+			}
+		}
+		if target == nil {
+			err = fmt.Errorf("no previous successful deployment found")
+		}
+	}
+	var result *DeploymentResult
+	if err == nil {
+		result, err = rm.Rollback(ctx, deployment.ProjectID, deployment.Environment, target.ID)
+	}
 	if err != nil {
 		return fmt.Errorf("auto-rollback failed: %w", err)
 	}
+	if latest, err := rm.history.GetDeployment(ctx, deployment.ID); err == nil {
+		*deployment = *latest
+	}
 	deployment.Status = "rolled_back"
 	deployment.RollbackReason = reason
-	rm.history.RecordDeployment(ctx, deployment)
+	if err := rm.history.RecordDeployment(ctx, deployment); err != nil {
+		return fmt.Errorf("auto-rollback to %s completed but deployment %s could not be marked as rolled back: %w", target.ID, deployment.ID, err)
+	}
 	fmt.Printf("✅ Auto-rollback completed successfully in %v\n", result.Duration())
 	return nil
 }
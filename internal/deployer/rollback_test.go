@@ -0,0 +1,124 @@
+package deployer
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+func TestPlanRollbackWithoutSnapshots(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name         string
+		current      *ConfigSnapshot
+		wantErr      string
+		wantReplicas int
+		wantHealth   string
+	}{
+		{
+			name:    "neither deployment has settings",
+			wantErr: "recorded replica and health check settings",
+		},
+		{
+			name:    "current snapshot without replicas",
+			current: &ConfigSnapshot{Version: "v2"},
+			wantErr: "recorded replica and health check settings",
+		},
+		{
+			name:         "current settings are inherited",
+			current:      &ConfigSnapshot{Version: "v2", Replicas: 3, HealthCheckURL: "http://web/healthz", HealthCheckTimeout: time.Second},
+			wantReplicas: 3,
+			wantHealth:   "http://web/healthz",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			history := NewDeploymentHistory(t.TempDir())
+			records := []*DeploymentRecord{
+				{ID: "old", ProjectID: "proj", Environment: "production", Version: "v1", Image: "web:v1", Status: "success", DeployedAt: now.Add(-time.Hour)},
+				{ID: "new", ProjectID: "proj", Environment: "production", Version: "v2", Image: "web:v2", Status: "success", DeployedAt: now, Snapshot: tt.current},
+			}
+			for _, record := range records {
+				if err := history.RecordDeployment(ctx, record); err != nil {
+					t.Fatalf("RecordDeployment: %v", err)
+				}
+			}
+			rm := NewRollbackManager(history, nil, nil, nil)
+			plan, err := rm.planRollback(ctx, "proj", "production", "old")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("planRollback: %v", err)
+			}
+			config := plan.target.deploymentConfig("rb", "proj", "production")
+			if config.Version != "v1" || config.Image != "web:v1" || config.Replicas != tt.wantReplicas || config.HealthCheckURL != tt.wantHealth {
+				t.Fatalf("unexpected rollback target %+v", config)
+			}
+		})
+	}
+}
+func TestMonitorPersistsEachEvaluation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	history := NewDeploymentHistory(t.TempDir())
+	if err := history.RecordDeployment(ctx, &DeploymentRecord{ID: "web", ProjectID: "proj", Environment: "production", Version: "v2", Status: "success", DeployedAt: time.Now()}); err != nil {
+		t.Fatalf("RecordDeployment: %v", err)
+	}
+	rm := NewRollbackManager(history, nil, fakeConversions{}, nil)
+	done := make(chan error, 1)
+	go func() {
+		done <- rm.MonitorAndAutoRollback(ctx, "web", &RollbackTrigger{ErrorRateThreshold: 0.5, MonitoringWindow: time.Minute, EvaluationInterval: 5 * time.Millisecond})
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		record, err := history.GetDeployment(ctx, "web")
+		if err != nil {
+			t.Fatalf("GetDeployment: %v", err)
+		}
+		if len(record.Evaluations) >= 3 {
+			if record.Evaluations[0].Fired || record.Status != "success" {
+				t.Fatalf("expected healthy evaluations, got %+v", record)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected evaluations to be persisted while monitoring, got %d", len(record.Evaluations))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+}
+func TestMonitorEvaluatesOnceWithoutWindow(t *testing.T) {
+	ctx := context.Background()
+	history := NewDeploymentHistory(t.TempDir())
+	if err := history.RecordDeployment(ctx, &DeploymentRecord{ID: "web", ProjectID: "proj", Environment: "production", Version: "v2", Status: "success", DeployedAt: time.Now()}); err != nil {
+		t.Fatalf("RecordDeployment: %v", err)
+	}
+	rm := NewRollbackManager(history, nil, fakeConversions{}, nil)
+	if err := rm.MonitorAndAutoRollback(ctx, "web", &RollbackTrigger{ErrorRateThreshold: 0.5, EvaluationInterval: 5 * time.Millisecond}); err != nil {
+		t.Fatalf("MonitorAndAutoRollback: %v", err)
+	}
+	record, err := history.GetDeployment(ctx, "web")
+	if err != nil {
+		t.Fatalf("GetDeployment: %v", err)
+	}
+	if len(record.Evaluations) != 1 {
+		t.Fatalf("expected one evaluation without a monitoring window, got %d", len(record.Evaluations))
+	}
+}
+func TestFailedRequestsFallsBackWithoutQuery(t *testing.T) {
+	monitor := fakePrometheus(t, http.StatusOK, `{"status":"success","data":{"resultType":"vector","result":[]}}`, func(r *http.Request) {
+		t.Errorf("unexpected query %s", r.URL.Query().Get("query"))
+	})
+	te := &triggerEvaluator{rm: &RollbackManager{monitor: monitor}, version: "v2", failedRequests: 42}
+	value, err := te.metric(context.Background(), MetricFailedRequests, &DeploymentMetrics{})
+	if err != nil || value != 42 {
+		t.Fatalf("expected the locally counted failed requests, got %g (%v)", value, err)
+	}
+}
@@ -0,0 +1,245 @@
+package deployer
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+const MetricFailedRequests = "failed_requests"
+const maxTriggerEvaluations = 50
+type ComparisonOperator string
+const (
+	CompareGreaterThan    ComparisonOperator = "gt"
+	CompareGreaterOrEqual ComparisonOperator = "gte"
+	CompareLessThan       ComparisonOperator = "lt"
+	CompareLessOrEqual    ComparisonOperator = "lte"
+	CompareEqual          ComparisonOperator = "eq"
+	CompareNotEqual       ComparisonOperator = "ne"
+)
+type TriggerLogic string
+const (
+	TriggerAny TriggerLogic = "any"
+	TriggerAll TriggerLogic = "all"
+)
+type MetricCondition struct {
+	Metric    string             `json:"metric"`
+	Operator  ComparisonOperator `json:"operator"`
+	Threshold float64            `json:"threshold"`
+	For       time.Duration      `json:"for,omitempty"`
+}
+type ConditionResult struct {
+	Metric      string             `json:"metric"`
+	Operator    ComparisonOperator `json:"operator"`
+	Threshold   float64            `json:"threshold"`
+	Value       float64            `json:"value"`
+	Breached    bool               `json:"breached"`
+	BreachedFor time.Duration      `json:"breached_for,omitempty"`
+	Satisfied   bool               `json:"satisfied"`
+	Error       string             `json:"error,omitempty"`
+}
+type TriggerEvaluation struct {
+	At         time.Time         `json:"at"`
+	Logic      TriggerLogic      `json:"logic"`
+	Conditions []ConditionResult `json:"conditions"`
+	Fired      bool              `json:"fired"`
+	Reason     string            `json:"reason"`
+}
+func (op ComparisonOperator) compare(value, threshold float64) (bool, error) {
+	switch op {
+	case CompareGreaterThan, "":
+		return value > threshold, nil
+	case CompareGreaterOrEqual:
+		return value >= threshold, nil
+	case CompareLessThan:
+		return value < threshold, nil
+	case CompareLessOrEqual:
+		return value <= threshold, nil
+	case CompareEqual:
+		return value == threshold, nil
+	case CompareNotEqual:
+		return value != threshold, nil
+	default:
+		return false, fmt.Errorf("unknown comparison operator %q", op)
+	}
+}
+func (c *MetricCondition) String() string {
+	op := c.Operator
+	if op == "" {
+		op = CompareGreaterThan
+	}
+	s := fmt.Sprintf("%s %s %g", c.Metric, op, c.Threshold)
+	if c.For > 0 {
+		s += fmt.Sprintf(" for %v", c.For)
+	}
+	return s
+}
+type triggerGroup struct {
+	logic      TriggerLogic
+	conditions []*MetricCondition
+	groups     []*triggerGroup
+}
+func (t *RollbackTrigger) compile() (*triggerGroup, error) {
+	group := &triggerGroup{logic: t.Logic}
+	switch group.logic {
+	case "":
+		group.logic = TriggerAny
+	case TriggerAny, TriggerAll:
+	default:
+		return nil, fmt.Errorf("unknown trigger logic %q", t.Logic)
+	}
+	legacy := func(metric string, op ComparisonOperator, threshold float64) {
+		group.conditions = append(group.conditions, &MetricCondition{Metric: metric, Operator: op, Threshold: threshold, For: t.SustainFor})
+	}
+	if t.ErrorRateThreshold > 0 {
+		legacy(MetricErrorRate, CompareGreaterThan, t.ErrorRateThreshold)
+	}
+	if t.LatencyThreshold > 0 {
+		legacy(MetricLatency, CompareGreaterThan, t.LatencyThreshold.Seconds())
+	}
+	if t.CPUThreshold > 0 {
+		legacy(MetricCPUUsage, CompareGreaterThan, t.CPUThreshold)
+	}
+	if t.MemoryThreshold > 0 {
+		legacy(MetricMemoryUsage, CompareGreaterThan, t.MemoryThreshold)
+	}
+	if t.FailedRequestsCount > 0 {
+		legacy(MetricFailedRequests, CompareGreaterOrEqual, float64(t.FailedRequestsCount))
+	}
+	names := make([]string, 0, len(t.CustomMetricThreshold))
+	for name := range t.CustomMetricThreshold {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		legacy(name, CompareGreaterThan, t.CustomMetricThreshold[name])
+	}
+	for i := range t.Conditions {
+		condition := t.Conditions[i]
+		if condition.Metric == "" {
+			return nil, fmt.Errorf("trigger condition %d requires a metric", i)
+		}
+		if _, err := condition.Operator.compare(0, 0); err != nil {
+			return nil, err
+		}
+		group.conditions = append(group.conditions, &condition)
+	}
+	for _, nested := range t.Triggers {
+		child, err := nested.compile()
+		if err != nil {
+			return nil, err
+		}
+		group.groups = append(group.groups, child)
+	}
+	if len(group.conditions) == 0 && len(group.groups) == 0 {
+		return nil, fmt.Errorf("rollback trigger has no conditions")
+	}
+	return group, nil
+}
+type triggerEvaluator struct {
+	rm             *RollbackManager
+	version        string
+	breachedSince  map[*MetricCondition]time.Time
+	failedRequests float64
+}
+func (te *triggerEvaluator) evaluate(ctx context.Context, group *triggerGroup, metrics *DeploymentMetrics, now time.Time, eval *TriggerEvaluation) (bool, []string) {
+	var matched []string
+	satisfied := 0
+	for _, condition := range group.conditions {
+		result := ConditionResult{Metric: condition.Metric, Operator: condition.Operator, Threshold: condition.Threshold}
+		if result.Operator == "" {
+			result.Operator = CompareGreaterThan
+		}
+		value, err := te.metric(ctx, condition.Metric, metrics)
+		if err == nil {
+			result.Value = value
+			result.Breached, err = condition.Operator.compare(value, condition.Threshold)
+		}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		if result.Breached {
+			since, ok := te.breachedSince[condition]
+			if !ok {
+				since = now
+				te.breachedSince[condition] = now
+			}
+			result.BreachedFor = now.Sub(since)
+			result.Satisfied = result.BreachedFor >= condition.For
+		} else {
+			delete(te.breachedSince, condition)
+		}
+		if result.Satisfied {
+			satisfied++
+			matched = append(matched, fmt.Sprintf("%s (value %g)", condition.String(), result.Value))
+		}
+		eval.Conditions = append(eval.Conditions, result)
+	}
+	for _, child := range group.groups {
+		fired, reasons := te.evaluate(ctx, child, metrics, now, eval)
+		if fired {
+			satisfied++
+			matched = append(matched, "("+strings.Join(reasons, fmt.Sprintf(" %s ", logicJoiner(child.logic)))+")")
+		}
+	}
+	total := len(group.conditions) + len(group.groups)
+	if group.logic == TriggerAll {
+		return satisfied == total, matched
+	}
+	return satisfied > 0, matched
+}
+func logicJoiner(logic TriggerLogic) string {
+	if logic == TriggerAll {
+		return "AND"
+	}
+	return "OR"
+}
+func (te *triggerEvaluator) metric(ctx context.Context, name string, metrics *DeploymentMetrics) (float64, error) {
+	querier, hasQuerier := te.rm.monitor.(MetricQuerier)
+	switch name {
+	case MetricErrorRate:
+		return metrics.ErrorRate, nil
+	case MetricLatency:
+		return metrics.Latency.Seconds(), nil
+	case MetricCPUUsage:
+		return metrics.CPUUsage, nil
+	case MetricMemoryUsage:
+		return metrics.MemoryUsage, nil
+	case MetricRequestRate:
+		return metrics.RequestRate, nil
+	case MetricFailedRequests:
+		if !hasQuerier {
+			return te.failedRequests, nil
+		}
+		value, err := querier.GetMetric(ctx, te.version, name)
+		if errors.Is(err, ErrUnknownMetric) {
+			return te.failedRequests, nil
+		}
+		return value, err
+	}
+	if !hasQuerier {
+		return 0, fmt.Errorf("monitor does not support metric %q", name)
+	}
+	return querier.GetMetric(ctx, te.version, name)
+}
+func logTriggerEvaluation(deploymentID string, eval *TriggerEvaluation) {
+	var parts []string
+	for _, c := range eval.Conditions {
+		state := "ok"
+		switch {
+		case c.Error != "":
+			state = "error: " + c.Error
+		case c.Satisfied:
+			state = "breached"
+		case c.Breached:
+			state = fmt.Sprintf("breached for %v", c.BreachedFor.Round(time.Millisecond))
+		}
+		parts = append(parts, fmt.Sprintf("%s=%g %s %g [%s]", c.Metric, c.Value, c.Operator, c.Threshold, state))
+	}
+	icon := "🟢"
+	if eval.Fired {
+		icon = "🔴"
+	}
+	fmt.Printf("%s Rollback trigger %s (%s): %s — %s\n", icon, deploymentID, eval.Logic, strings.Join(parts, ", "), eval.Reason)
+}
@@ -0,0 +1,333 @@
+package deployer
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+var ErrSecretVersionNotFound = errors.New("secret version not found")
+var DefaultSecretRetention = SecretRetention{MaxVersions: 20}
+type SecretRetention struct {
+	MaxVersions int           `json:"max_versions"`
+	MaxAge      time.Duration `json:"max_age,omitempty"`
+}
+type SecretVersion struct {
+	Version      int       `json:"version"`
+	Value        string    `json:"value,omitempty"`
+	Fingerprint  string    `json:"fingerprint,omitempty"`
+	Author       string    `json:"author"`
+	CreatedAt    time.Time `json:"created_at"`
+	Deleted      bool      `json:"deleted,omitempty"`
+	RestoredFrom int       `json:"restored_from,omitempty"`
+}
+type SecretHistory struct {
+	EnvironmentID string                     `json:"environment_id"`
+	Retention     SecretRetention            `json:"retention"`
+	Secrets       map[string][]SecretVersion `json:"secrets"`
+}
+func (h *SecretHistory) active(key string) *SecretVersion {
+	versions := h.Secrets[key]
+	if len(versions) == 0 || versions[len(versions)-1].Deleted {
+		return nil
+	}
+	return &versions[len(versions)-1]
+}
+func (h *SecretHistory) find(key string, version int) *SecretVersion {
+	for i := range h.Secrets[key] {
+		if h.Secrets[key][i].Version == version {
+			return &h.Secrets[key][i]
+		}
+	}
+	return nil
+}
+func (h *SecretHistory) append(key string, version SecretVersion) {
+	versions := h.Secrets[key]
+	version.Version = 1
+	if len(versions) > 0 {
+		version.Version = versions[len(versions)-1].Version + 1
+	}
+	h.Secrets[key] = append(versions, version)
+}
+func (h *SecretHistory) prune(now time.Time) {
+	for key, versions := range h.Secrets {
+		if len(versions) <= 1 {
+			continue
+		}
+		latest := versions[len(versions)-1]
+		kept := make([]SecretVersion, 0, len(versions))
+		for i, v := range versions[:len(versions)-1] {
+			if h.Retention.MaxVersions > 0 && len(versions)-i > h.Retention.MaxVersions {
+				continue
+			}
+			if h.Retention.MaxAge > 0 && now.Sub(v.CreatedAt) > h.Retention.MaxAge {
+				continue
+			}
+			kept = append(kept, v)
+		}
+		h.Secrets[key] = append(kept, latest)
+	}
+}
+func (em *EnvironmentManager) recordSecretVersions(env *Environment, author string, restoredFrom map[string]int) error {
+	unlock, err := em.lockEnvironment(env.ID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return em.appendSecretVersions(env, author, restoredFrom)
+}
+func (em *EnvironmentManager) appendSecretVersions(env *Environment, author string, restoredFrom map[string]int) error {
+	if author == "" {
+		author = "system"
+	}
+	history, err := em.loadSecretHistory(env.ID)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	changed := false
+	for _, key := range mapKeys(env.Secrets) {
+		if current := history.active(key); current != nil && em.unchangedSecret(current, env.Secrets[key]) {
+			continue
+		}
+		sealed, err := em.encrypt(env.Secrets[key])
+		if err != nil {
+			return err
+		}
+		history.append(key, SecretVersion{
+			Value:        sealed,
+			Fingerprint:  em.keyring.Fingerprint(env.Secrets[key]),
+			Author:       author,
+			CreatedAt:    now,
+			RestoredFrom: restoredFrom[key],
+		})
+		changed = true
+	}
+	for key := range history.Secrets {
+		if _, ok := env.Secrets[key]; !ok && history.active(key) != nil {
+			history.append(key, SecretVersion{Author: author, CreatedAt: now, Deleted: true})
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	history.prune(now)
+	return em.writeSecretHistory(history)
+}
+func (em *EnvironmentManager) SetSecretAs(ctx context.Context, envID, key, value, author string) (*SecretVersion, error) {
+	if err := em.authorizeEnvironment(ctx, envID, EnvActionSecrets); err != nil {
+		return nil, err
+	}
+	err := em.modifyEnvironment(ctx, envID, author, func(env *Environment) (map[string]int, error) {
+		if env.Secrets == nil {
+			env.Secrets = make(map[string]string)
+		}
+		env.Secrets[key] = value
+		return nil, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return em.activeSecretVersion(envID, key)
+}
+func (em *EnvironmentManager) DeleteSecret(ctx context.Context, envID, key, author string) error {
+	if err := em.authorizeEnvironment(ctx, envID, EnvActionSecrets); err != nil {
+		return err
+	}
+	return em.modifyEnvironment(ctx, envID, author, func(env *Environment) (map[string]int, error) {
+		if _, ok := env.Secrets[key]; !ok {
+			return nil, fmt.Errorf("secret not found: %s", key)
+		}
+		delete(env.Secrets, key)
+		return nil, nil
+	})
+}
+func (em *EnvironmentManager) ListSecrets(ctx context.Context, envID string) (map[string]SecretVersion, error) {
+	if err := em.authorizeEnvironment(ctx, envID, EnvActionView); err != nil {
+		return nil, err
+	}
+	if _, err := em.loadEnvironment(envID); err != nil {
+		return nil, err
+	}
+	history, err := em.loadSecretHistory(envID)
+	if err != nil {
+		return nil, err
+	}
+	secrets := make(map[string]SecretVersion)
+	for key := range history.Secrets {
+		if active := history.active(key); active != nil {
+			secrets[key] = redactSecretVersion(*active)
+		}
+	}
+	return secrets, nil
+}
+func (em *EnvironmentManager) ListSecretVersions(ctx context.Context, envID, key string) ([]SecretVersion, error) {
+	if err := em.authorizeEnvironment(ctx, envID, EnvActionView); err != nil {
+		return nil, err
+	}
+	history, err := em.loadSecretHistory(envID)
+	if err != nil {
+		return nil, err
+	}
+	versions := history.Secrets[key]
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("secret not found: %s", key)
+	}
+	redacted := make([]SecretVersion, len(versions))
+	for i, v := range versions {
+		redacted[len(versions)-1-i] = redactSecretVersion(v)
+	}
+	return redacted, nil
+}
+func (em *EnvironmentManager) GetSecretVersion(ctx context.Context, envID, key string, version int) (string, error) {
+	if err := em.authorizeEnvironment(ctx, envID, EnvActionSecrets); err != nil {
+		return "", err
+	}
+	history, err := em.loadSecretHistory(envID)
+	if err != nil {
+		return "", err
+	}
+	v := history.find(key, version)
+	if v == nil || v.Deleted {
+		return "", fmt.Errorf("%w: %s@%d", ErrSecretVersionNotFound, key, version)
+	}
+	return em.decrypt(v.Value)
+}
+func (em *EnvironmentManager) RestoreSecretVersion(ctx context.Context, envID, key string, version int, author string) (*SecretVersion, error) {
+	if err := em.authorizeEnvironment(ctx, envID, EnvActionSecrets); err != nil {
+		return nil, err
+	}
+	if err := em.PinSecretVersions(ctx, envID, map[string]int{key: version}, author, false); err != nil {
+		return nil, err
+	}
+	return em.activeSecretVersion(envID, key)
+}
+func (em *EnvironmentManager) PinSecretVersions(ctx context.Context, envID string, versions map[string]int, author string, exclusive bool) error {
+	return em.modifyEnvironment(ctx, envID, author, func(env *Environment) (map[string]int, error) {
+		if exclusive || env.Secrets == nil {
+			env.Secrets = make(map[string]string, len(versions))
+		}
+		return em.resolveSecretVersions(env, versions)
+	})
+}
+func (em *EnvironmentManager) resolveSecretVersions(env *Environment, versions map[string]int) (map[string]int, error) {
+	history, err := em.loadSecretHistory(env.ID)
+	if err != nil {
+		return nil, err
+	}
+	restoredFrom := make(map[string]int, len(versions))
+	for key, version := range versions {
+		v := history.find(key, version)
+		if v == nil || v.Deleted {
+			return nil, fmt.Errorf("%w: %s@%d (it may have been pruned by the retention policy)", ErrSecretVersionNotFound, key, version)
+		}
+		plaintext, err := em.decrypt(v.Value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt secret %s@%d: %w", key, version, err)
+		}
+		env.Secrets[key] = plaintext
+		if active := history.active(key); active == nil || active.Version != version {
+			restoredFrom[key] = version
+		}
+	}
+	return restoredFrom, nil
+}
+func (em *EnvironmentManager) ActiveSecretVersions(ctx context.Context, envID string) (map[string]int, error) {
+	history, err := em.loadSecretHistory(envID)
+	if err != nil {
+		return nil, err
+	}
+	versions := make(map[string]int)
+	for key := range history.Secrets {
+		if active := history.active(key); active != nil {
+			versions[key] = active.Version
+		}
+	}
+	return versions, nil
+}
+func (em *EnvironmentManager) SetSecretRetention(ctx context.Context, envID string, retention SecretRetention) error {
+	if err := em.authorizeEnvironment(ctx, envID, EnvActionSecrets); err != nil {
+		return err
+	}
+	if retention.MaxVersions < 0 || retention.MaxAge < 0 {
+		return fmt.Errorf("retention limits must not be negative")
+	}
+	if _, err := em.loadEnvironment(envID); err != nil {
+		return err
+	}
+	unlock, err := em.lockEnvironment(envID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	history, err := em.loadSecretHistory(envID)
+	if err != nil {
+		return err
+	}
+	history.Retention = retention
+	history.prune(time.Now())
+	return em.writeSecretHistory(history)
+}
+func (em *EnvironmentManager) activeSecretVersion(envID, key string) (*SecretVersion, error) {
+	history, err := em.loadSecretHistory(envID)
+	if err != nil {
+		return nil, err
+	}
+	active := history.active(key)
+	if active == nil {
+		return nil, fmt.Errorf("secret not found: %s", key)
+	}
+	redacted := redactSecretVersion(*active)
+	return &redacted, nil
+}
+func (em *EnvironmentManager) unchangedSecret(current *SecretVersion, value string) bool {
+	if em.keyring.MatchesFingerprint(current.Fingerprint, value) {
+		return true
+	}
+	plaintext, err := em.decrypt(current.Value)
+	return err == nil && subtle.ConstantTimeCompare([]byte(plaintext), []byte(value)) == 1
+}
+func redactSecretVersion(v SecretVersion) SecretVersion {
+	v.Value = ""
+	v.Fingerprint = ""
+	return v
+}
+func (em *EnvironmentManager) secretHistoryPath(envID string) string {
+	return filepath.Join(em.storagePath, "secrets", envID+".json")
+}
+func (em *EnvironmentManager) loadSecretHistory(envID string) (*SecretHistory, error) {
+	history := &SecretHistory{EnvironmentID: envID, Retention: DefaultSecretRetention, Secrets: make(map[string][]SecretVersion)}
+	data, err := os.ReadFile(em.secretHistoryPath(envID))
+	if errors.Is(err, os.ErrNotExist) {
+		return history, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, history); err != nil {
+		return nil, fmt.Errorf("failed to parse secret history for %s: %w", envID, err)
+	}
+	if history.Secrets == nil {
+		history.Secrets = make(map[string][]SecretVersion)
+	}
+	return history, nil
+}
+func (em *EnvironmentManager) writeSecretHistory(history *SecretHistory) error {
+	path := em.secretHistoryPath(history.EnvironmentID)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	for _, versions := range history.Secrets {
+		sort.Slice(versions, func(i, j int) bool { return versions[i].Version < versions[j].Version })
+	}
+	data, err := json.MarshalIndent(history, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0600)
+}
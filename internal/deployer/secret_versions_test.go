@@ -0,0 +1,43 @@
+package deployer
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+func TestSecretVersionsHideFingerprints(t *testing.T) {
+	ctx := WithSystemPrincipal(context.Background())
+	em := NewEnvironmentManagerWithKeyring(newStaticKeyring("k1", bytes.Repeat([]byte("k"), 32)), t.TempDir())
+	env := &Environment{ProjectID: "proj", Name: "staging", Type: EnvironmentStaging}
+	if err := em.CreateEnvironment(ctx, env); err != nil {
+		t.Fatalf("CreateEnvironment: %v", err)
+	}
+	for _, value := range []string{"one", "one", "two"} {
+		if _, err := em.SetSecretAs(ctx, env.ID, "TOKEN", value, "alice"); err != nil {
+			t.Fatalf("SetSecretAs: %v", err)
+		}
+	}
+	versions, err := em.ListSecretVersions(ctx, env.ID, "TOKEN")
+	if err != nil {
+		t.Fatalf("ListSecretVersions: %v", err)
+	}
+	if len(versions) != 2 {
+		t.Fatalf("expected an unchanged value not to add a version, got %d versions", len(versions))
+	}
+	for _, v := range versions {
+		if v.Fingerprint != "" || v.Value != "" {
+			t.Fatalf("expected version %d to be redacted, got %+v", v.Version, v)
+		}
+	}
+	history, err := em.loadSecretHistory(env.ID)
+	if err != nil {
+		t.Fatalf("loadSecretHistory: %v", err)
+	}
+	stored := history.active("TOKEN").Fingerprint
+	if !strings.HasPrefix(stored, "k1:") || !em.keyring.MatchesFingerprint(stored, "two") || em.keyring.MatchesFingerprint(stored, "one") {
+		t.Fatalf("expected a fingerprint of the current value keyed by k1, got %q", stored)
+	}
+	if other := newStaticKeyring("k1", bytes.Repeat([]byte("x"), 32)); other.MatchesFingerprint(stored, "two") {
+		t.Fatal("expected fingerprints to depend on the key material")
+	}
+}
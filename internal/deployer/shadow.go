@@ -0,0 +1,379 @@
+package deployer
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+const maxShadowBody = 1 << 20
+type ShadowConfig struct {
+	PrimaryVersion        string
+	MirrorPercentage      int
+	Duration              time.Duration
+	CompareBodies         bool
+	MinRequests           int
+	MaxStatusMismatchRate float64
+	MaxLatencyIncrease    float64
+	MaxBodyMismatchRate   float64
+}
+type TrafficMirror interface {
+	MirrorTraffic(ctx context.Context, primaryVersion, shadowVersion string, percentage int) error
+	StopMirroring(ctx context.Context, shadowVersion string) error
+	ShadowSamples(ctx context.Context, shadowVersion string, since time.Time) ([]ShadowSample, error)
+}
+type MirroringLoadBalancer struct {
+	LoadBalancer
+	proxy *ShadowProxy
+}
+func NewMirroringLoadBalancer(lb LoadBalancer, proxy *ShadowProxy) *MirroringLoadBalancer {
+	return &MirroringLoadBalancer{LoadBalancer: lb, proxy: proxy}
+}
+func (m *MirroringLoadBalancer) MirrorTraffic(ctx context.Context, primaryVersion, shadowVersion string, percentage int) error {
+	return m.proxy.MirrorTraffic(ctx, primaryVersion, shadowVersion, percentage)
+}
+func (m *MirroringLoadBalancer) StopMirroring(ctx context.Context, shadowVersion string) error {
+	return m.proxy.StopMirroring(ctx, shadowVersion)
+}
+func (m *MirroringLoadBalancer) ShadowSamples(ctx context.Context, shadowVersion string, since time.Time) ([]ShadowSample, error) {
+	return m.proxy.ShadowSamples(ctx, shadowVersion, since)
+}
+type ShadowSample struct {
+	Method          string        `json:"method"`
+	Path            string        `json:"path"`
+	PrimaryStatus   int           `json:"primary_status"`
+	ShadowStatus    int           `json:"shadow_status"`
+	PrimaryLatency  time.Duration `json:"primary_latency"`
+	ShadowLatency   time.Duration `json:"shadow_latency"`
+	PrimaryBodyHash string        `json:"primary_body_hash,omitempty"`
+	ShadowBodyHash  string        `json:"shadow_body_hash,omitempty"`
+	ShadowError     string        `json:"shadow_error,omitempty"`
+	At              time.Time     `json:"at"`
+}
+type ShadowDivergence struct {
+	Method        string `json:"method"`
+	Path          string `json:"path"`
+	PrimaryStatus int    `json:"primary_status"`
+	ShadowStatus  int    `json:"shadow_status"`
+	Kind          string `json:"kind"`
+	Detail        string `json:"detail,omitempty"`
+}
+type ShadowReport struct {
+	PrimaryVersion     string             `json:"primary_version"`
+	ShadowVersion      string             `json:"shadow_version"`
+	Requests           int                `json:"requests"`
+	StatusMismatches   int                `json:"status_mismatches"`
+	StatusMismatchRate float64            `json:"status_mismatch_rate"`
+	BodyMismatches     int                `json:"body_mismatches"`
+	BodyMismatchRate   float64            `json:"body_mismatch_rate"`
+	ShadowErrors       int                `json:"shadow_errors"`
+	PrimaryLatencyP50  time.Duration      `json:"primary_latency_p50"`
+	PrimaryLatencyP95  time.Duration      `json:"primary_latency_p95"`
+	ShadowLatencyP50   time.Duration      `json:"shadow_latency_p50"`
+	ShadowLatencyP95   time.Duration      `json:"shadow_latency_p95"`
+	LatencyIncrease    float64            `json:"latency_increase"`
+	Divergent          bool               `json:"divergent"`
+	Reasons            []string           `json:"reasons,omitempty"`
+	Examples           []ShadowDivergence `json:"examples,omitempty"`
+	GeneratedAt        time.Time          `json:"generated_at"`
+}
+func (sc *ShadowConfig) withDefaults() ShadowConfig {
+	cfg := *sc
+	if cfg.MirrorPercentage <= 0 {
+		cfg.MirrorPercentage = 10
+	}
+	if cfg.MirrorPercentage > 100 {
+		cfg.MirrorPercentage = 100
+	}
+	if cfg.Duration <= 0 {
+		cfg.Duration = 10 * time.Minute
+	}
+	if cfg.MinRequests <= 0 {
+		cfg.MinRequests = 100
+	}
+	if cfg.MaxStatusMismatchRate <= 0 {
+		cfg.MaxStatusMismatchRate = 0.01
+	}
+	if cfg.MaxLatencyIncrease <= 0 {
+		cfg.MaxLatencyIncrease = 0.2
+	}
+	if cfg.MaxBodyMismatchRate <= 0 {
+		cfg.MaxBodyMismatchRate = 0.05
+	}
+	return cfg
+}
+func (de *DeploymentExecutor) executeShadow(ctx context.Context, run *deploymentRun, config *DeploymentConfig) (*DeploymentResult, error) {
+	if config.ShadowConfig == nil {
+		return de.finish(ctx, run, "failed", fmt.Errorf("shadow config required for shadow deployment"))
+	}
+	shadowCfg := config.ShadowConfig.withDefaults()
+	mirror, ok := de.loadBalancer.(TrafficMirror)
+	if !ok {
+		return de.finish(ctx, run, "failed", fmt.Errorf("load balancer does not support traffic mirroring"))
+	}
+	if err := de.runStep(ctx, run, "Deploy Shadow", nil, sleepStep(2*time.Second)); err != nil {
+		return de.finish(ctx, run, "failed", err)
+	}
+	if err := de.runStep(ctx, run, "Health Check Shadow", nil, de.healthCheckStep(config)); err != nil {
+		return de.finish(ctx, run, "failed", err)
+	}
+	stopMirror := &Compensation{Type: CompensationStopMirror, Version: config.Version}
+	name := fmt.Sprintf("Mirror %d%% of Traffic to Shadow", shadowCfg.MirrorPercentage)
+	err := de.runStep(ctx, run, name, stopMirror, func(ctx context.Context) error {
+		return mirror.MirrorTraffic(ctx, shadowCfg.PrimaryVersion, config.Version, shadowCfg.MirrorPercentage)
+	})
+	if err != nil {
+		return de.rollback(ctx, run, "Failed to mirror traffic to shadow", err)
+	}
+	var report *ShadowReport
+	err = de.runStep(ctx, run, "Compare Shadow Traffic", nil, func(ctx context.Context) error {
+		since := run.current().StartTime
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(shadowCfg.Duration):
+		}
+		samples, err := mirror.ShadowSamples(ctx, config.Version, since)
+		if err != nil {
+			return fmt.Errorf("failed to collect shadow samples: %w", err)
+		}
+		report = buildShadowReport(shadowCfg, config.Version, samples)
+		run.current().Shadow = report
+		if report.Divergent {
+			return fmt.Errorf("shadow diverged from primary: %s", strings.Join(report.Reasons, "; "))
+		}
+		return nil
+	})
+	if err != nil {
+		reason := "Shadow comparison failed"
+		if report != nil && report.Divergent {
+			reason = "Shadow diverged from primary"
+		}
+		return de.rollback(ctx, run, reason, err)
+	}
+	err = de.runStep(ctx, run, "Stop Mirroring", nil, func(ctx context.Context) error {
+		return mirror.StopMirroring(ctx, config.Version)
+	})
+	if err != nil {
+		return de.rollback(ctx, run, "Failed to stop mirroring", err)
+	}
+	return de.complete(ctx, run)
+}
+func buildShadowReport(cfg ShadowConfig, shadowVersion string, samples []ShadowSample) *ShadowReport {
+	report := &ShadowReport{
+		PrimaryVersion: cfg.PrimaryVersion,
+		ShadowVersion:  shadowVersion,
+		Requests:       len(samples),
+		GeneratedAt:    time.Now(),
+	}
+	var primaryLatencies, shadowLatencies []float64
+	compared := 0
+	for _, sample := range samples {
+		if sample.ShadowError != "" {
+			report.ShadowErrors++
+			report.StatusMismatches++
+			report.addExample(sample, "error", sample.ShadowError)
+			continue
+		}
+		primaryLatencies = append(primaryLatencies, float64(sample.PrimaryLatency))
+		shadowLatencies = append(shadowLatencies, float64(sample.ShadowLatency))
+		if sample.PrimaryStatus != sample.ShadowStatus {
+			report.StatusMismatches++
+			report.addExample(sample, "status", "")
+			continue
+		}
+		if cfg.CompareBodies && sample.PrimaryBodyHash != "" {
+			compared++
+			if sample.PrimaryBodyHash != sample.ShadowBodyHash {
+				report.BodyMismatches++
+				report.addExample(sample, "body", "")
+			}
+		}
+	}
+	if report.Requests > 0 {
+		report.StatusMismatchRate = float64(report.StatusMismatches) / float64(report.Requests)
+	}
+	if compared > 0 {
+		report.BodyMismatchRate = float64(report.BodyMismatches) / float64(compared)
+	}
+	if len(primaryLatencies) > 0 {
+		report.PrimaryLatencyP50 = time.Duration(percentile(primaryLatencies, 0.5))
+		report.PrimaryLatencyP95 = time.Duration(percentile(primaryLatencies, 0.95))
+		report.ShadowLatencyP50 = time.Duration(percentile(shadowLatencies, 0.5))
+		report.ShadowLatencyP95 = time.Duration(percentile(shadowLatencies, 0.95))
+		report.LatencyIncrease = relativeChange(float64(report.PrimaryLatencyP95), float64(report.ShadowLatencyP95))
+	}
+	if report.Requests < cfg.MinRequests {
+		report.Reasons = append(report.Reasons, fmt.Sprintf("only %d mirrored requests, need %d", report.Requests, cfg.MinRequests))
+	}
+	if report.StatusMismatchRate > cfg.MaxStatusMismatchRate {
+		report.Reasons = append(report.Reasons, fmt.Sprintf("status mismatch rate %.2f%% exceeds %.2f%%",
+			report.StatusMismatchRate*100, cfg.MaxStatusMismatchRate*100))
+	}
+	if report.LatencyIncrease > cfg.MaxLatencyIncrease {
+		report.Reasons = append(report.Reasons, fmt.Sprintf("p95 latency %v is %+.1f%% over primary %v",
+			report.ShadowLatencyP95, report.LatencyIncrease*100, report.PrimaryLatencyP95))
+	}
+	if cfg.CompareBodies && report.BodyMismatchRate > cfg.MaxBodyMismatchRate {
+		report.Reasons = append(report.Reasons, fmt.Sprintf("body mismatch rate %.2f%% exceeds %.2f%%",
+			report.BodyMismatchRate*100, cfg.MaxBodyMismatchRate*100))
+	}
+	report.Divergent = len(report.Reasons) > 0
+	return report
+}
+func (r *ShadowReport) addExample(sample ShadowSample, kind, detail string) {
+	if len(r.Examples) >= 20 {
+		return
+	}
+	r.Examples = append(r.Examples, ShadowDivergence{
+		Method:        sample.Method,
+		Path:          sample.Path,
+		PrimaryStatus: sample.PrimaryStatus,
+		ShadowStatus:  sample.ShadowStatus,
+		Kind:          kind,
+		Detail:        detail,
+	})
+}
+func percentile(values []float64, p float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	idx := int(p * float64(len(sorted)-1))
+	return sorted[idx]
+}
+type ShadowProxy struct {
+	primary       *url.URL
+	shadow        *url.URL
+	client        *http.Client
+	mu            sync.Mutex
+	percentage    int
+	compareBodies bool
+	maxSamples    int
+	samples       []ShadowSample
+}
+func NewShadowProxy(primaryURL, shadowURL string, compareBodies bool) (*ShadowProxy, error) {
+	primary, err := url.Parse(primaryURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid primary URL: %w", err)
+	}
+	shadow, err := url.Parse(shadowURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid shadow URL: %w", err)
+	}
+	return &ShadowProxy{
+		primary: primary,
+		shadow:  shadow,
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		compareBodies: compareBodies,
+		maxSamples:    10000,
+	}, nil
+}
+func (sp *ShadowProxy) MirrorTraffic(ctx context.Context, primaryVersion, shadowVersion string, percentage int) error {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.percentage = percentage
+	return nil
+}
+func (sp *ShadowProxy) StopMirroring(ctx context.Context, shadowVersion string) error {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.percentage = 0
+	return nil
+}
+func (sp *ShadowProxy) ShadowSamples(ctx context.Context, shadowVersion string, since time.Time) ([]ShadowSample, error) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	var samples []ShadowSample
+	for _, sample := range sp.samples {
+		if !sample.At.Before(since) {
+			samples = append(samples, sample)
+		}
+	}
+	return samples, nil
+}
+func (sp *ShadowProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxShadowBody+1))
+	if err != nil {
+		http.Error(w, "failed to read request body", http.StatusBadRequest)
+		return
+	}
+	oversized := len(body) > maxShadowBody
+	start := time.Now()
+	resp, err := sp.forward(r.Context(), sp.primary, r, io.MultiReader(bytes.NewReader(body), r.Body))
+	if err != nil {
+		http.Error(w, "upstream unavailable", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	for key, values := range resp.Header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	hash := sha256.New()
+	io.Copy(io.MultiWriter(w, hash), resp.Body)
+	sample := ShadowSample{
+		Method:         r.Method,
+		Path:           r.URL.Path,
+		PrimaryStatus:  resp.StatusCode,
+		PrimaryLatency: time.Since(start),
+		At:             start,
+	}
+	if sp.compareBodies {
+		sample.PrimaryBodyHash = hex.EncodeToString(hash.Sum(nil))
+	}
+	sp.mu.Lock()
+	mirror := !oversized && sp.percentage > 0 && rand.Intn(100) < sp.percentage && (r.Method == http.MethodGet || r.Method == http.MethodHead)
+	sp.mu.Unlock()
+	if mirror {
+		go sp.mirror(r.Clone(context.Background()), body, sample)
+	}
+}
+func (sp *ShadowProxy) mirror(r *http.Request, body []byte, sample ShadowSample) {
+	start := time.Now()
+	resp, err := sp.forward(r.Context(), sp.shadow, r, bytes.NewReader(body))
+	sample.ShadowLatency = time.Since(start)
+	if err != nil {
+		sample.ShadowError = err.Error()
+	} else {
+		hash := sha256.New()
+		io.Copy(hash, resp.Body)
+		resp.Body.Close()
+		sample.ShadowStatus = resp.StatusCode
+		if sp.compareBodies {
+			sample.ShadowBodyHash = hex.EncodeToString(hash.Sum(nil))
+		}
+	}
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.samples = append(sp.samples, sample)
+	if len(sp.samples) > sp.maxSamples {
+		sp.samples = sp.samples[len(sp.samples)-sp.maxSamples:]
+	}
+}
+func (sp *ShadowProxy) forward(ctx context.Context, target *url.URL, r *http.Request, body io.Reader) (*http.Response, error) {
+	u := *target
+	u.Path = strings.TrimRight(target.Path, "/") + r.URL.Path
+	u.RawQuery = r.URL.RawQuery
+	req, err := http.NewRequestWithContext(ctx, r.Method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = r.ContentLength
+	req.Header = r.Header.Clone()
+	req.Header.Del("Connection")
+	return sp.client.Do(req)
+}
@@ -0,0 +1,211 @@
+package deployer
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+type shadowBackend struct {
+	mu     sync.Mutex
+	bodies []int
+	status int
+	reply  string
+}
+func (b *shadowBackend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	b.mu.Lock()
+	b.bodies = append(b.bodies, len(body))
+	b.mu.Unlock()
+	w.WriteHeader(b.status)
+	io.WriteString(w, b.reply)
+}
+func (b *shadowBackend) requests() []int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]int(nil), b.bodies...)
+}
+func shadowProxy(t *testing.T, primary, shadow *shadowBackend) *ShadowProxy {
+	t.Helper()
+	primaryServer := httptest.NewServer(primary)
+	t.Cleanup(primaryServer.Close)
+	shadowServer := httptest.NewServer(shadow)
+	t.Cleanup(shadowServer.Close)
+	proxy, err := NewShadowProxy(primaryServer.URL, shadowServer.URL, true)
+	if err != nil {
+		t.Fatalf("NewShadowProxy: %v", err)
+	}
+	lb := NewMirroringLoadBalancer(&fakeBalancer{weights: map[string]int{"v1": 100}}, proxy)
+	if err := lb.MirrorTraffic(context.Background(), "v1", "v2", 100); err != nil {
+		t.Fatalf("MirrorTraffic: %v", err)
+	}
+	return proxy
+}
+func waitForSamples(t *testing.T, proxy *ShadowProxy, want int) []ShadowSample {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		samples, err := proxy.ShadowSamples(context.Background(), "v2", time.Time{})
+		if err != nil {
+			t.Fatalf("ShadowSamples: %v", err)
+		}
+		if len(samples) >= want {
+			return samples
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d shadow samples, got %d", want, len(samples))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+func TestShadowProxyMirrorsRequests(t *testing.T) {
+	tests := []struct {
+		name       string
+		reply      string
+		wantStatus int
+		wantMatch  bool
+	}{
+		{name: "identical responses", reply: "ok", wantStatus: http.StatusOK, wantMatch: true},
+		{name: "different bodies", reply: "changed", wantStatus: http.StatusOK, wantMatch: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary := &shadowBackend{status: http.StatusOK, reply: "ok"}
+			shadow := &shadowBackend{status: tt.wantStatus, reply: tt.reply}
+			proxy := shadowProxy(t, primary, shadow)
+			rec := httptest.NewRecorder()
+			proxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/items?page=2", nil))
+			if rec.Code != http.StatusOK || rec.Body.String() != "ok" {
+				t.Fatalf("expected the primary response, got %d %q", rec.Code, rec.Body.String())
+			}
+			sample := waitForSamples(t, proxy, 1)[0]
+			if sample.Path != "/items" || sample.ShadowStatus != tt.wantStatus || sample.ShadowError != "" {
+				t.Fatalf("unexpected sample %+v", sample)
+			}
+			if (sample.PrimaryBodyHash == sample.ShadowBodyHash) != tt.wantMatch {
+				t.Fatalf("expected body match %v, got primary %s shadow %s", tt.wantMatch, sample.PrimaryBodyHash, sample.ShadowBodyHash)
+			}
+		})
+	}
+}
+func TestShadowProxySendsOversizedBodiesOnlyToPrimary(t *testing.T) {
+	primary := &shadowBackend{status: http.StatusOK, reply: "ok"}
+	shadow := &shadowBackend{status: http.StatusOK, reply: "ok"}
+	proxy := shadowProxy(t, primary, shadow)
+	body := bytes.Repeat([]byte("x"), maxShadowBody+10)
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/upload", bytes.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected the primary response, got %d", rec.Code)
+	}
+	if got := primary.requests(); len(got) != 1 || got[0] != len(body) {
+		t.Fatalf("expected the primary to receive all %d bytes, got %v", len(body), got)
+	}
+	proxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/small", strings.NewReader("small")))
+	samples := waitForSamples(t, proxy, 1)
+	if len(samples) != 1 || samples[0].Path != "/small" {
+		t.Fatalf("expected only the small request to be mirrored, got %+v", samples)
+	}
+	if got := shadow.requests(); len(got) != 1 || got[0] != len("small") {
+		t.Fatalf("expected the shadow to see only the small request, got %v", got)
+	}
+}
+func TestBuildShadowReport(t *testing.T) {
+	sample := func(primaryStatus, shadowStatus int, shadowLatency time.Duration, shadowHash, shadowErr string) ShadowSample {
+		return ShadowSample{
+			Method:          http.MethodGet,
+			Path:            "/items",
+			PrimaryStatus:   primaryStatus,
+			ShadowStatus:    shadowStatus,
+			PrimaryLatency:  100 * time.Millisecond,
+			ShadowLatency:   shadowLatency,
+			PrimaryBodyHash: "a",
+			ShadowBodyHash:  shadowHash,
+			ShadowError:     shadowErr,
+		}
+	}
+	repeat := func(n int, s ShadowSample) []ShadowSample {
+		samples := make([]ShadowSample, n)
+		for i := range samples {
+			samples[i] = s
+		}
+		return samples
+	}
+	healthy := sample(200, 200, 100*time.Millisecond, "a", "")
+	tests := []struct {
+		name          string
+		samples       []ShadowSample
+		wantDivergent bool
+		wantReason    string
+		check         func(t *testing.T, report *ShadowReport)
+	}{
+		{
+			name:    "matching traffic",
+			samples: repeat(10, healthy),
+			check: func(t *testing.T, report *ShadowReport) {
+				if report.StatusMismatches != 0 || report.BodyMismatches != 0 || report.LatencyIncrease != 0 {
+					t.Fatalf("expected no differences, got %+v", report)
+				}
+			},
+		},
+		{
+			name:          "too few requests",
+			samples:       repeat(3, healthy),
+			wantDivergent: true,
+			wantReason:    "only 3 mirrored requests",
+		},
+		{
+			name:          "status mismatch",
+			samples:       append(repeat(9, healthy), sample(200, 500, 100*time.Millisecond, "", "")),
+			wantDivergent: true,
+			wantReason:    "status mismatch rate 10.00%",
+			check: func(t *testing.T, report *ShadowReport) {
+				if len(report.Examples) != 1 || report.Examples[0].Kind != "status" {
+					t.Fatalf("expected a status example, got %+v", report.Examples)
+				}
+			},
+		},
+		{
+			name:          "shadow errors count as mismatches",
+			samples:       append(repeat(9, healthy), sample(200, 0, 0, "", "connection refused")),
+			wantDivergent: true,
+			wantReason:    "status mismatch rate",
+			check: func(t *testing.T, report *ShadowReport) {
+				if report.ShadowErrors != 1 || report.Examples[0].Detail != "connection refused" {
+					t.Fatalf("expected the shadow error to be reported, got %+v", report)
+				}
+			},
+		},
+		{
+			name:          "body mismatch",
+			samples:       append(repeat(9, healthy), sample(200, 200, 100*time.Millisecond, "b", "")),
+			wantDivergent: true,
+			wantReason:    "body mismatch rate 10.00%",
+		},
+		{
+			name:          "latency regression",
+			samples:       repeat(10, sample(200, 200, 150*time.Millisecond, "a", "")),
+			wantDivergent: true,
+			wantReason:    "p95 latency 150ms is +50.0% over primary 100ms",
+		},
+	}
+	cfg := (&ShadowConfig{PrimaryVersion: "v1", CompareBodies: true, MinRequests: 5, MaxBodyMismatchRate: 0.05}).withDefaults()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := buildShadowReport(cfg, "v2", tt.samples)
+			if report.Requests != len(tt.samples) || report.Divergent != tt.wantDivergent {
+				t.Fatalf("expected divergent=%v over %d requests, got %+v", tt.wantDivergent, len(tt.samples), report)
+			}
+			if tt.wantReason != "" && !strings.Contains(strings.Join(report.Reasons, "; "), tt.wantReason) {
+				t.Fatalf("expected reason %q, got %v", tt.wantReason, report.Reasons)
+			}
+			if tt.check != nil {
+				tt.check(t, report)
+			}
+		})
+	}
+}
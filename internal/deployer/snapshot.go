@@ -0,0 +1,235 @@
+package deployer
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+var ErrEnvironmentNotFound = errors.New("environment not found")
+type ConfigSnapshot struct {
+	Version            string              `json:"version"`
+	Image              string              `json:"image"`
+	Replicas           int                 `json:"replicas"`
+	HealthCheckURL     string              `json:"health_check_url"`
+	HealthCheckTimeout time.Duration       `json:"health_check_timeout"`
+	EnvironmentID      string              `json:"environment_id,omitempty"`
+	Variables          map[string]string   `json:"variables,omitempty"`
+	SecretVersions     map[string]int      `json:"secret_refs,omitempty"`
+	SealedSecrets      map[string]string   `json:"sealed_secrets,omitempty"`
+	Resources          *ResourceAllocation `json:"resources,omitempty"`
+	CapturedAt         time.Time           `json:"captured_at"`
+}
+type ConfigChange struct {
+	Field string `json:"field"`
+	From  string `json:"from,omitempty"`
+	To    string `json:"to,omitempty"`
+}
+type RollbackPreview struct {
+	ProjectID           string         `json:"project_id"`
+	Environment         string         `json:"environment"`
+	CurrentDeployment   string         `json:"current_deployment"`
+	TargetDeployment    string         `json:"target_deployment"`
+	Changes             []ConfigChange `json:"changes"`
+	RestoresEnvironment bool           `json:"restores_environment"`
+	Warnings            []string       `json:"warnings,omitempty"`
+}
+type ConfigSource interface {
+	CaptureConfig(ctx context.Context, projectID, environment string) (*ConfigSnapshot, error)
+	RestoreConfig(ctx context.Context, projectID, environment string, snapshot *ConfigSnapshot) error
+}
+func newConfigSnapshot(config *DeploymentConfig) *ConfigSnapshot {
+	return &ConfigSnapshot{
+		Version:            config.Version,
+		Image:              config.Image,
+		Replicas:           config.Replicas,
+		HealthCheckURL:     config.HealthCheckURL,
+		HealthCheckTimeout: config.HealthCheckTimeout,
+		CapturedAt:         time.Now(),
+	}
+}
+func (s *ConfigSnapshot) hasEnvironment() bool {
+	return s != nil && s.EnvironmentID != ""
+}
+func (s *ConfigSnapshot) hasDeploymentSettings() bool {
+	return s != nil && s.Replicas > 0
+}
+func (s *ConfigSnapshot) deploymentConfig(deploymentID, projectID, environment string) *DeploymentConfig {
+	return &DeploymentConfig{
+		DeploymentID:       deploymentID,
+		ProjectID:          projectID,
+		Environment:        environment,
+		Strategy:           StrategyDirect,
+		Version:            s.Version,
+		Image:              s.Image,
+		Replicas:           s.Replicas,
+		HealthCheckURL:     s.HealthCheckURL,
+		HealthCheckTimeout: s.HealthCheckTimeout,
+	}
+}
+func diffSnapshots(from, to *ConfigSnapshot) []ConfigChange {
+	var changes []ConfigChange
+	field := func(name, a, b string) {
+		if a != b {
+			changes = append(changes, ConfigChange{Field: name, From: a, To: b})
+		}
+	}
+	field("version", from.Version, to.Version)
+	field("image", from.Image, to.Image)
+	field("replicas", fmt.Sprint(from.Replicas), fmt.Sprint(to.Replicas))
+	field("health_check_url", from.HealthCheckURL, to.HealthCheckURL)
+	field("health_check_timeout", from.HealthCheckTimeout.String(), to.HealthCheckTimeout.String())
+	if !from.hasEnvironment() || !to.hasEnvironment() {
+		return changes
+	}
+	for _, key := range mapKeys(from.Variables, to.Variables) {
+		field("variables."+key, from.Variables[key], to.Variables[key])
+	}
+	secretKeys := make(map[string]string)
+	for key := range from.SecretVersions {
+		secretKeys[key] = ""
+	}
+	for key := range to.SecretVersions {
+		secretKeys[key] = ""
+	}
+	for _, key := range mapKeys(secretKeys) {
+		a, inFrom := from.SecretVersions[key]
+		b, inTo := to.SecretVersions[key]
+		switch {
+		case a == b:
+		case !inFrom:
+			changes = append(changes, ConfigChange{Field: "secrets." + key, To: fmt.Sprintf("version %d", b)})
+		case !inTo:
+			changes = append(changes, ConfigChange{Field: "secrets." + key, From: fmt.Sprintf("version %d", a)})
+		default:
+			changes = append(changes, ConfigChange{Field: "secrets." + key, From: fmt.Sprintf("version %d", a), To: fmt.Sprintf("version %d", b)})
+		}
+	}
+	var fromRes, toRes ResourceAllocation
+	if from.Resources != nil {
+		fromRes = *from.Resources
+	}
+	if to.Resources != nil {
+		toRes = *to.Resources
+	}
+	field("resources.min_cpu", fromRes.MinCPU, toRes.MinCPU)
+	field("resources.max_cpu", fromRes.MaxCPU, toRes.MaxCPU)
+	field("resources.min_memory", fromRes.MinMemory, toRes.MinMemory)
+	field("resources.max_memory", fromRes.MaxMemory, toRes.MaxMemory)
+	field("resources.min_replicas", fmt.Sprint(fromRes.MinReplicas), fmt.Sprint(toRes.MinReplicas))
+	field("resources.max_replicas", fmt.Sprint(fromRes.MaxReplicas), fmt.Sprint(toRes.MaxReplicas))
+	field("resources.storage_size", fromRes.StorageSize, toRes.StorageSize)
+	field("resources.auto_scale", fmt.Sprint(fromRes.AutoScale), fmt.Sprint(toRes.AutoScale))
+	return changes
+}
+func mapKeys(maps ...map[string]string) []string {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, m := range maps {
+		for key := range m {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+func (em *EnvironmentManager) findEnvironment(projectID, name string) (*Environment, error) {
+	files, err := os.ReadDir(em.storagePath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, file := range files {
+		if filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+		env, err := em.loadEnvironment(file.Name()[:len(file.Name())-5])
+		if err != nil {
+			continue
+		}
+		if env.ProjectID == projectID && env.Name == name {
+			return env, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s/%s", ErrEnvironmentNotFound, projectID, name)
+}
+func (em *EnvironmentManager) FindEnvironment(ctx context.Context, projectID, name string) (*Environment, error) {
+	env, err := em.findEnvironment(projectID, name)
+	if err != nil {
+		return nil, err
+	}
+	return em.GetEnvironment(ctx, env.ID)
+}
+func (em *EnvironmentManager) CaptureConfig(ctx context.Context, projectID, environment string) (*ConfigSnapshot, error) {
+	found, err := em.findEnvironment(projectID, environment)
+	if err != nil {
+		return nil, err
+	}
+	env, err := em.getEnvironment(found.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := em.recordSecretVersions(env, "", nil); err != nil {
+		return nil, fmt.Errorf("failed to record secret versions: %w", err)
+	}
+	versions, err := em.ActiveSecretVersions(ctx, env.ID)
+	if err != nil {
+		return nil, err
+	}
+	resources := env.Resources
+	snapshot := &ConfigSnapshot{
+		EnvironmentID:  env.ID,
+		Variables:      make(map[string]string, len(env.Variables)),
+		SecretVersions: versions,
+		Resources:      &resources,
+		CapturedAt:     time.Now(),
+	}
+	for k, v := range env.Variables {
+		snapshot.Variables[k] = v
+	}
+	return snapshot, nil
+}
+func (em *EnvironmentManager) RestoreConfig(ctx context.Context, projectID, environment string, snapshot *ConfigSnapshot) error {
+	if !snapshot.hasEnvironment() {
+		return fmt.Errorf("snapshot does not include environment configuration")
+	}
+	found, err := em.findEnvironment(projectID, environment)
+	if err != nil {
+		return err
+	}
+	return em.modifyEnvironment(ctx, found.ID, "rollback", func(env *Environment) (map[string]int, error) {
+		env.Variables = make(map[string]string, len(snapshot.Variables))
+		for k, v := range snapshot.Variables {
+			env.Variables[k] = v
+		}
+		if snapshot.Resources != nil {
+			env.Resources = *snapshot.Resources
+		}
+		if snapshot.SecretVersions != nil || snapshot.SealedSecrets == nil {
+			env.Secrets = make(map[string]string, len(snapshot.SecretVersions))
+			return em.resolveSecretVersions(env, snapshot.SecretVersions)
+		}
+		env.Secrets = make(map[string]string, len(snapshot.SealedSecrets))
+		for k, sealed := range snapshot.SealedSecrets {
+			plaintext, err := em.decrypt(sealed)
+			if err != nil {
+				return nil, fmt.Errorf("snapshot secret %s cannot be decrypted: %w", k, err)
+			}
+			env.Secrets[k] = plaintext
+		}
+		return nil, nil
+	})
+}
+func (s *ConfigSnapshot) withEnvironment(env *ConfigSnapshot) *ConfigSnapshot {
+	merged := *s
+	merged.EnvironmentID = env.EnvironmentID
+	merged.Variables = env.Variables
+	merged.SecretVersions = env.SecretVersions
+	merged.SealedSecrets = env.SealedSecrets
+	merged.Resources = env.Resources
+	return &merged
+}
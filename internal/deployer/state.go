@@ -0,0 +1,337 @@
+package deployer
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+var (
+	ErrDeploymentStateNotFound = errors.New("deployment state not found")
+	ErrDeploymentStateConflict = errors.New("deployment state was modified concurrently")
+)
+const maxStateRetries = 5
+type DeploymentPhase string
+const (
+	PhasePending          DeploymentPhase = "pending"
+	PhaseRunning          DeploymentPhase = "running"
+	PhaseAwaitingApproval DeploymentPhase = "awaiting_approval"
+	PhaseSucceeded        DeploymentPhase = "succeeded"
+	PhaseFailed           DeploymentPhase = "failed"
+	PhaseRollingBack      DeploymentPhase = "rolling_back"
+	PhaseRolledBack       DeploymentPhase = "rolled_back"
+)
+func (p DeploymentPhase) InFlight() bool {
+	return p == PhasePending || p == PhaseRunning || p == PhaseAwaitingApproval || p == PhaseRollingBack
+}
+type CompensationType string
+const (
+	CompensationNone          CompensationType = "none"
+	CompensationTrafficWeight CompensationType = "set_traffic_weight"
+	CompensationSwitchTraffic CompensationType = "switch_traffic"
+	CompensationRemoveRoute   CompensationType = "remove_routing_rule"
+	CompensationFeatureFlag   CompensationType = "set_feature_flag"
+	CompensationStopMirror    CompensationType = "stop_mirroring"
+	CompensationClearSplit    CompensationType = "clear_traffic_split"
+	CompensationRestoreColour CompensationType = "restore_live_colour"
+)
+type Compensation struct {
+	Type        CompensationType `json:"type"`
+	Version     string           `json:"version,omitempty"`
+	Weight      int              `json:"weight,omitempty"`
+	FromVersion string           `json:"from_version,omitempty"`
+	ToVersion   string           `json:"to_version,omitempty"`
+	Rule        string           `json:"rule,omitempty"`
+	Flag        string           `json:"flag,omitempty"`
+	Enabled     bool             `json:"enabled,omitempty"`
+	RemoveFlag  bool             `json:"remove_flag,omitempty"`
+}
+type DeploymentState struct {
+	ID              string             `json:"id"`
+	Revision        int64              `json:"revision"`
+	Config          *DeploymentConfig  `json:"config"`
+	Phase           DeploymentPhase    `json:"phase"`
+	Steps           []DeploymentStep   `json:"steps"`
+	StartTime       time.Time          `json:"start_time"`
+	EndTime         time.Time          `json:"end_time,omitempty"`
+	UpdatedAt       time.Time          `json:"updated_at"`
+	Error           string             `json:"error,omitempty"`
+	RollbackReason  string             `json:"rollback_reason,omitempty"`
+	PendingApproval *PendingApproval   `json:"pending_approval,omitempty"`
+	Approvals       []ApprovalDecision `json:"approvals,omitempty"`
+}
+type StateStore interface {
+	Save(ctx context.Context, state *DeploymentState) error
+	Load(ctx context.Context, deploymentID string) (*DeploymentState, error)
+	ListInFlight(ctx context.Context) ([]*DeploymentState, error)
+}
+type FileStateStore struct {
+	storagePath string
+}
+func NewFileStateStore(storagePath string) *FileStateStore {
+	return &FileStateStore{
+		storagePath: storagePath,
+	}
+}
+func (fs *FileStateStore) Save(ctx context.Context, state *DeploymentState) error {
+	unlock, err := lockFile(filepath.Join(fs.storagePath, state.ID+".lock"))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	current, err := fs.Load(ctx, state.ID)
+	switch {
+	case errors.Is(err, ErrDeploymentStateNotFound):
+		if state.Revision != 0 {
+			return fmt.Errorf("%w: %s no longer exists", ErrDeploymentStateConflict, state.ID)
+		}
+	case err != nil:
+		return err
+	case current.Revision != state.Revision:
+		return fmt.Errorf("%w: %s is at revision %d, update was based on %d", ErrDeploymentStateConflict, state.ID, current.Revision, state.Revision)
+	}
+	state.Revision++
+	state.UpdatedAt = time.Now()
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(filepath.Join(fs.storagePath, state.ID+".json"), data, 0644)
+	}
+	if err != nil {
+		state.Revision--
+		return err
+	}
+	return nil
+}
+func (fs *FileStateStore) Load(ctx context.Context, deploymentID string) (*DeploymentState, error) {
+	data, err := os.ReadFile(filepath.Join(fs.storagePath, deploymentID+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrDeploymentStateNotFound, deploymentID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state DeploymentState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to decode deployment state %s: %w", deploymentID, err)
+	}
+	return &state, nil
+}
+func (fs *FileStateStore) ListInFlight(ctx context.Context) ([]*DeploymentState, error) {
+	files, err := os.ReadDir(fs.storagePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var states []*DeploymentState
+	for _, file := range files {
+		if filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+		state, err := fs.Load(ctx, file.Name()[:len(file.Name())-5])
+		if err != nil {
+			continue
+		}
+		if state.Phase.InFlight() {
+			states = append(states, state)
+		}
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].StartTime.Before(states[j].StartTime)
+	})
+	return states, nil
+}
+type deploymentRun struct {
+	state  *DeploymentState
+	cursor int
+}
+func (run *deploymentRun) current() *DeploymentStep {
+	return &run.state.Steps[run.cursor-1]
+}
+func newDeploymentState(config *DeploymentConfig) *DeploymentState {
+	if config.DeploymentID == "" {
+		config.DeploymentID = fmt.Sprintf("deploy_%d", time.Now().UnixNano())
+	}
+	return &DeploymentState{
+		ID:        config.DeploymentID,
+		Config:    config,
+		Phase:     PhasePending,
+		Steps:     []DeploymentStep{},
+		StartTime: time.Now(),
+	}
+}
+func (de *DeploymentExecutor) saveState(ctx context.Context, state *DeploymentState) error {
+	for attempt := 0; ; attempt++ {
+		err := de.stateStore.Save(ctx, state)
+		if !errors.Is(err, ErrDeploymentStateConflict) || attempt+1 >= maxStateRetries {
+			return err
+		}
+		latest, loadErr := de.stateStore.Load(ctx, state.ID)
+		if loadErr != nil {
+			return fmt.Errorf("%v; reload failed: %w", err, loadErr)
+		}
+		state.Approvals = mergeApprovals(latest.Approvals, state.Approvals)
+		state.Revision = latest.Revision
+	}
+}
+func (de *DeploymentExecutor) runStep(ctx context.Context, run *deploymentRun, name string, compensation *Compensation, fn func(ctx context.Context) error) error {
+	idx := run.cursor
+	run.cursor++
+	if idx < len(run.state.Steps) {
+		existing := run.state.Steps[idx]
+		if existing.Name != name {
+			return fmt.Errorf("cannot resume deployment %s: step %d is %q, expected %q", run.state.ID, idx+1, existing.Name, name)
+		}
+		if existing.Status == "success" {
+			return nil
+		}
+	} else {
+		run.state.Steps = append(run.state.Steps, DeploymentStep{Name: name})
+	}
+	step := &run.state.Steps[idx]
+	step.Status = "running"
+	step.StartTime = time.Now()
+	step.EndTime = time.Time{}
+	step.Error = ""
+	step.Attempts++
+	if compensation != nil {
+		step.Compensation = compensation
+	}
+	if err := de.saveState(ctx, run.state); err != nil {
+		return fmt.Errorf("failed to persist step %q: %w", name, err)
+	}
+	err := fn(ctx)
+	step = &run.state.Steps[idx]
+	step.EndTime = time.Now()
+	if err != nil {
+		step.Status = "failed"
+		step.Error = err.Error()
+	} else {
+		step.Status = "success"
+	}
+	if saveErr := de.saveState(ctx, run.state); saveErr != nil && err == nil {
+		return fmt.Errorf("failed to persist step %q: %w", name, saveErr)
+	}
+	return err
+}
+func (de *DeploymentExecutor) restoreCompensation(ctx context.Context, config *DeploymentConfig) (*Compensation, error) {
+	distribution, err := de.loadBalancer.GetTrafficDistribution(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read live traffic distribution: %w", err)
+	}
+	previous, previousWeight := "", 0
+	for version, weight := range distribution {
+		if version == config.Version || weight <= 0 {
+			continue
+		}
+		if weight > previousWeight || (weight == previousWeight && version < previous) {
+			previous, previousWeight = version, weight
+		}
+	}
+	if previous == "" {
+		return &Compensation{Type: CompensationTrafficWeight, Version: config.Version, Weight: 0}, nil
+	}
+	return &Compensation{Type: CompensationSwitchTraffic, FromVersion: config.Version, ToVersion: previous}, nil
+}
+func (de *DeploymentExecutor) compensate(ctx context.Context, run *deploymentRun, reason string) error {
+	run.state.Phase = PhaseRollingBack
+	run.state.RollbackReason = reason
+	if err := de.saveState(ctx, run.state); err != nil {
+		return fmt.Errorf("failed to persist rollback: %w", err)
+	}
+	if err := de.runRollbackHooks(ctx, run, HookPreRollback); err != nil {
+		return err
+	}
+	applied := make(map[Compensation]bool)
+	for i := len(run.state.Steps) - 1; i >= 0; i-- {
+		step := &run.state.Steps[i]
+		if step.Compensation == nil || step.Status == "compensated" {
+			continue
+		}
+		comp := *step.Compensation
+		if comp.Type != CompensationNone && !applied[comp] {
+			if err := de.applyCompensation(ctx, run.state.Config, comp); err != nil {
+				step.Error = fmt.Sprintf("compensation failed: %v", err)
+				de.saveState(ctx, run.state)
+				return fmt.Errorf("failed to compensate step %q: %w", step.Name, err)
+			}
+			applied[comp] = true
+		}
+		step.Status = "compensated"
+		if err := de.saveState(ctx, run.state); err != nil {
+			return fmt.Errorf("failed to persist rollback: %w", err)
+		}
+	}
+	return de.runRollbackHooks(ctx, run, HookPostRollback)
+}
+func (de *DeploymentExecutor) applyCompensation(ctx context.Context, config *DeploymentConfig, comp Compensation) error {
+	switch comp.Type {
+	case CompensationTrafficWeight:
+		return de.loadBalancer.SetTrafficWeight(ctx, comp.Version, comp.Weight)
+	case CompensationSwitchTraffic:
+		return de.loadBalancer.SwitchTraffic(ctx, comp.FromVersion, comp.ToVersion)
+	case CompensationRemoveRoute:
+		router, ok := de.loadBalancer.(RuleRouter)
+		if !ok {
+			return fmt.Errorf("load balancer does not support rule-based routing")
+		}
+		return router.RemoveRoutingRule(ctx, comp.Rule)
+	case CompensationRestoreColour:
+		return de.restoreColour(ctx, config, comp)
+	case CompensationClearSplit:
+		router, ok := de.loadBalancer.(ExperimentRouter)
+		if !ok {
+			return fmt.Errorf("load balancer does not support experiment traffic splits")
+		}
+		return router.ClearSplit(ctx, comp.Rule)
+	case CompensationStopMirror:
+		mirror, ok := de.loadBalancer.(TrafficMirror)
+		if !ok {
+			return fmt.Errorf("load balancer does not support traffic mirroring")
+		}
+		return mirror.StopMirroring(ctx, comp.Version)
+	case CompensationFeatureFlag:
+		if de.flags == nil {
+			return fmt.Errorf("no feature flag store configured")
+		}
+		if comp.RemoveFlag {
+			return de.flags.DeleteFlag(ctx, config.ProjectID, config.Environment, comp.Flag)
+		}
+		_, _, err := de.flags.SetFlag(ctx, config.ProjectID, config.Environment, comp.Flag, comp.Enabled)
+		return err
+	case CompensationNone:
+		return nil
+	default:
+		return fmt.Errorf("unknown compensation type: %s", comp.Type)
+	}
+}
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	if err := os.Chmod(tmpName, perm); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return os.Rename(tmpName, path)
+}
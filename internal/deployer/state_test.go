@@ -0,0 +1,223 @@
+package deployer
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+type fakeBalancer struct {
+	mu      sync.Mutex
+	weights map[string]int
+	calls   []string
+}
+func (f *fakeBalancer) record(call string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, call)
+}
+func (f *fakeBalancer) SetTrafficWeight(ctx context.Context, version string, weight int) error {
+	f.record("weight:" + version)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.weights == nil {
+		f.weights = make(map[string]int)
+	}
+	f.weights[version] = weight
+	return nil
+}
+func (f *fakeBalancer) GetTrafficDistribution(ctx context.Context) (map[string]int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	distribution := make(map[string]int, len(f.weights))
+	for version, weight := range f.weights {
+		distribution[version] = weight
+	}
+	return distribution, nil
+}
+func (f *fakeBalancer) SwitchTraffic(ctx context.Context, fromVersion, toVersion string) error {
+	f.record("switch:" + fromVersion + "->" + toVersion)
+	return nil
+}
+type fakeHealth struct {
+	failing map[string]bool
+}
+func (f fakeHealth) Check(ctx context.Context, url string, timeout time.Duration) error {
+	if f.failing[url] {
+		return fmt.Errorf("%s is unhealthy", url)
+	}
+	return nil
+}
+func (fakeHealth) CheckMultiple(ctx context.Context, urls []string, timeout time.Duration) (int, error) {
+	return len(urls), nil
+}
+type fakeMonitor struct{}
+func (fakeMonitor) GetMetrics(ctx context.Context, version string) (*DeploymentMetrics, error) {
+	return &DeploymentMetrics{}, nil
+}
+func (fakeMonitor) GetErrorRate(ctx context.Context, version string) (float64, error) {
+	return 0, nil
+}
+func (fakeMonitor) GetLatency(ctx context.Context, version string) (time.Duration, error) {
+	return 0, nil
+}
+func stateExecutor(t *testing.T, store StateStore) (*DeploymentExecutor, *fakeBalancer) {
+	t.Helper()
+	lb := &fakeBalancer{weights: map[string]int{"v1": 100}}
+	de, err := NewDeploymentExecutor(fakeHealth{}, lb, fakeMonitor{}, store, nil, nil, nil, NewDeploymentHistory(t.TempDir()))
+	if err != nil {
+		t.Fatalf("NewDeploymentExecutor: %v", err)
+	}
+	return de, lb
+}
+func savedState(t *testing.T, de *DeploymentExecutor, phase DeploymentPhase, steps ...DeploymentStep) *DeploymentState {
+	t.Helper()
+	state := newDeploymentState(&DeploymentConfig{ProjectID: "proj", Environment: "production", Strategy: StrategyCanary, Version: "v2"})
+	state.Phase = phase
+	state.Steps = steps
+	if err := de.stateStore.Save(context.Background(), state); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	return state
+}
+func TestRunStepPersistsBeforeRunning(t *testing.T) {
+	ctx := context.Background()
+	store := NewFileStateStore(t.TempDir())
+	de, _ := stateExecutor(t, store)
+	state := savedState(t, de, PhaseRunning)
+	run := &deploymentRun{state: state}
+	comp := &Compensation{Type: CompensationTrafficWeight, Version: "v2"}
+	err := de.runStep(ctx, run, "Shift 10% Traffic", comp, func(ctx context.Context) error {
+		stored, err := store.Load(ctx, state.ID)
+		if err != nil {
+			return err
+		}
+		step := stored.Steps[0]
+		if step.Status != "running" || step.Attempts != 1 || step.Compensation == nil || *step.Compensation != *comp {
+			t.Errorf("expected the running step and its compensation to be persisted first, got %+v", step)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("runStep: %v", err)
+	}
+	stored, err := store.Load(ctx, state.ID)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(stored.Steps) != 1 || stored.Steps[0].Status != "success" || stored.Steps[0].EndTime.IsZero() {
+		t.Fatalf("expected the finished step to be persisted, got %+v", stored.Steps)
+	}
+}
+func TestRunStepResumesByName(t *testing.T) {
+	ctx := context.Background()
+	de, _ := stateExecutor(t, NewFileStateStore(t.TempDir()))
+	state := savedState(t, de, PhaseRunning,
+		DeploymentStep{Name: "Deploy Canary", Status: "success", Attempts: 1},
+		DeploymentStep{Name: "Shift 10% Traffic", Status: "running", Attempts: 1},
+	)
+	run := &deploymentRun{state: state}
+	var ran []string
+	step := func(name string) func(ctx context.Context) error {
+		return func(ctx context.Context) error {
+			ran = append(ran, name)
+			return nil
+		}
+	}
+	for _, name := range []string{"Deploy Canary", "Shift 10% Traffic", "Shift 50% Traffic"} {
+		if err := de.runStep(ctx, run, name, nil, step(name)); err != nil {
+			t.Fatalf("runStep %s: %v", name, err)
+		}
+	}
+	if strings.Join(ran, ",") != "Shift 10% Traffic,Shift 50% Traffic" {
+		t.Fatalf("expected only unfinished steps to run, got %v", ran)
+	}
+	if state.Steps[1].Attempts != 2 || len(state.Steps) != 3 {
+		t.Fatalf("expected the interrupted step to be retried once, got %+v", state.Steps)
+	}
+	mismatched := &deploymentRun{state: state}
+	err := de.runStep(ctx, mismatched, "Deploy Blue", nil, step("Deploy Blue"))
+	if err == nil || !strings.Contains(err.Error(), `expected "Deploy Blue"`) {
+		t.Fatalf("expected a renamed step to stop the resume, got %v", err)
+	}
+}
+func TestCompensateRunsInReverseOrder(t *testing.T) {
+	ctx := context.Background()
+	store := NewFileStateStore(t.TempDir())
+	de, lb := stateExecutor(t, store)
+	weight := func(version string) *Compensation {
+		return &Compensation{Type: CompensationTrafficWeight, Version: version}
+	}
+	state := savedState(t, de, PhaseRunning,
+		DeploymentStep{Name: "Deploy Canary", Status: "success"},
+		DeploymentStep{Name: "Shift 10% Traffic", Status: "success", Compensation: weight("v1")},
+		DeploymentStep{Name: "Shift 25% Traffic", Status: "compensated", Compensation: weight("v3")},
+		DeploymentStep{Name: "Shift 50% Traffic", Status: "success", Compensation: weight("v2")},
+		DeploymentStep{Name: "Shift 100% Traffic", Status: "failed", Compensation: weight("v2")},
+	)
+	result, err := de.rollbackState(ctx, state, "canary failed")
+	if err == nil || result.Status != "rolled_back" {
+		t.Fatalf("expected the deployment to be rolled back, got %+v (%v)", result, err)
+	}
+	if got := strings.Join(lb.calls, ","); got != "weight:v2,weight:v1" {
+		t.Fatalf("expected compensations newest first and once each, got %s", got)
+	}
+	stored, err := store.Load(ctx, state.ID)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if stored.Phase != PhaseRolledBack || stored.RollbackReason != "canary failed" {
+		t.Fatalf("unexpected phase %s (%s)", stored.Phase, stored.RollbackReason)
+	}
+	for _, step := range stored.Steps[1:] {
+		if step.Status != "compensated" {
+			t.Fatalf("expected %s to be compensated, got %s", step.Name, step.Status)
+		}
+	}
+	if stored.Steps[0].Status != "success" {
+		t.Fatalf("expected a step without compensation to be left alone, got %s", stored.Steps[0].Status)
+	}
+}
+func TestRecoverInFlight(t *testing.T) {
+	tests := []struct {
+		name       string
+		resume     bool
+		phase      DeploymentPhase
+		wantStatus string
+		wantCalls  string
+	}{
+		{name: "rolls back running deployments", phase: PhaseRunning, wantStatus: "rolled_back", wantCalls: "weight:v2"},
+		{name: "finishes an interrupted rollback", resume: true, phase: PhaseRollingBack, wantStatus: "rolled_back", wantCalls: "weight:v2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := NewFileStateStore(t.TempDir())
+			de, _ := stateExecutor(t, store)
+			state := savedState(t, de, tt.phase,
+				DeploymentStep{Name: "Deploy Canary", Status: "success"},
+				DeploymentStep{Name: "Shift 10% Traffic", Status: "running", Compensation: &Compensation{Type: CompensationTrafficWeight, Version: "v2"}},
+			)
+			finished := savedState(t, de, PhaseSucceeded)
+			restarted, lb := stateExecutor(t, store)
+			results, err := restarted.RecoverInFlight(ctx, tt.resume)
+			if err != nil && !strings.Contains(err.Error(), "rolled back") {
+				t.Fatalf("RecoverInFlight: %v", err)
+			}
+			if len(results) != 1 || results[0].DeploymentID != state.ID || results[0].Status != tt.wantStatus {
+				t.Fatalf("expected %s to be recovered as %s, got %+v", state.ID, tt.wantStatus, results)
+			}
+			if got := strings.Join(lb.calls, ","); got != tt.wantCalls {
+				t.Fatalf("expected compensation %s, got %s", tt.wantCalls, got)
+			}
+			if _, err := restarted.Resume(ctx, finished.ID); err == nil {
+				t.Fatal("expected a finished deployment not to resume")
+			}
+			if _, err := restarted.Resume(ctx, "missing"); !errors.Is(err, ErrDeploymentStateNotFound) {
+				t.Fatalf("expected a missing deployment to be reported, got %v", err)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package deployer
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 )
@@ -12,10 +13,18 @@ const (
 	StrategyCanary      DeploymentStrategy = "canary"
 	StrategyRecreate    DeploymentStrategy = "recreate"
 	StrategyProgressive DeploymentStrategy = "progressive"
+	StrategyShadow      DeploymentStrategy = "shadow"
+	StrategyExperiment  DeploymentStrategy = "experiment"
 )
 type DeploymentConfig struct {
+	DeploymentID       string
+	OrganizationID     string
+	ProjectID          string
+	Environment        string
 	Strategy           DeploymentStrategy
 	Version            string
+	GitCommit          string
+	Team               string
 	Image              string
 	Replicas           int
 	HealthCheckURL     string
@@ -23,6 +32,12 @@ type DeploymentConfig struct {
 	RolloutConfig      *RolloutConfig
 	CanaryConfig       *CanaryConfig
 	ProgressiveConfig  *ProgressiveConfig
+	ShadowConfig       *ShadowConfig
+	ExperimentConfig   *ExperimentConfig
+	BlueGreenConfig    *BlueGreenConfig
+	Hooks              []DeploymentHook
+	BreakGlass         *BreakGlassOverride
+	PromotedFrom       string
 }
 type RolloutConfig struct {
 	MaxSurge       int
@@ -30,6 +45,7 @@ type RolloutConfig struct {
 	BatchSize      int
 	BatchDelay     time.Duration
 	AutoRollback   bool
+	Gates          []ApprovalGate
 }
 type CanaryConfig struct {
 	InitialWeight    int
@@ -38,12 +54,18 @@ type CanaryConfig struct {
 	SuccessMetrics   []string
 	FailureThreshold float64
 	AutoPromote      bool
+	Analysis         *CanaryAnalysisConfig
+	Gates            []ApprovalGate
 }
 type ProgressiveConfig struct {
-	UserSegments      []UserSegment
-	GeographicRollout []string
-	TimeSchedule      []TimeWindow
-	FeatureFlags      map[string]bool
+	UserSegments          []UserSegment
+	GeographicRollout     []string
+	TimeSchedule          []TimeWindow
+	FeatureFlags          map[string]bool
+	Gates                 []ApprovalGate
+	StageDuration         time.Duration
+	FailureThreshold      float64
+	RegionHealthCheckURLs map[string]string
 }
 type UserSegment struct {
 	Name       string
@@ -59,6 +81,11 @@ type DeploymentExecutor struct {
 	healthChecker HealthChecker
 	loadBalancer  LoadBalancer
 	monitor       DeploymentMonitor
+	stateStore    StateStore
+	hookRunner    HookRunner
+	calendar      *CalendarManager
+	flags         FlagStore
+	colours       ColourStore
 }
 type HealthChecker interface {
 	Check(ctx context.Context, url string, timeout time.Duration) error
@@ -82,71 +109,240 @@ type DeploymentMetrics struct {
 	MemoryUsage float64
 	SuccessRate float64
 }
-func NewDeploymentExecutor(hc HealthChecker, lb LoadBalancer, mon DeploymentMonitor) *DeploymentExecutor {
+func NewDeploymentExecutor(
+	hc HealthChecker,
+	lb LoadBalancer,
+	mon DeploymentMonitor,
+	store StateStore,
+	hookRunner HookRunner,
+	calendar *CalendarManager,
+	flags FlagStore,
+	history *DeploymentHistory,
+) (*DeploymentExecutor, error) {
+	if store == nil {
+		return nil, errors.New("deployment executor requires a durable state store")
+	}
+	if history == nil {
+		return nil, errors.New("deployment executor requires the deployment history for blue/green colour state")
+	}
+	if hookRunner == nil {
+		hookRunner = DockerHookRunner{}
+	}
 	return &DeploymentExecutor{
 		healthChecker: hc,
 		loadBalancer:  lb,
 		monitor:       mon,
-	}
+		stateStore:    store,
+		hookRunner:    hookRunner,
+		calendar:      calendar,
+		flags:         flags,
+		colours:       history,
+	}, nil
 }
 func (de *DeploymentExecutor) Execute(ctx context.Context, config *DeploymentConfig) (*DeploymentResult, error) {
+	switch config.Strategy {
+	case StrategyDirect, StrategyRolling, StrategyBlueGreen, StrategyRecreate:
+	case StrategyCanary:
+		if config.CanaryConfig != nil {
+			if err := validateCanary(config.CanaryConfig); err != nil {
+				return nil, err
+			}
+		}
+	case StrategyProgressive:
+		if config.ProgressiveConfig == nil {
+			return nil, fmt.Errorf("progressive config required for progressive deployment")
+		}
+	case StrategyShadow:
+		if config.ShadowConfig == nil {
+			return nil, fmt.Errorf("shadow config required for shadow deployment")
+		}
+	case StrategyExperiment:
+		if config.ExperimentConfig == nil {
+			return nil, fmt.Errorf("experiment config required for experiment deployment")
+		}
+		if err := validateExperiment(config.ExperimentConfig); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown deployment strategy: %s", config.Strategy)
+	}
+	if de.calendar != nil {
+		if err := de.calendar.Authorize(ctx, config, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+	state := newDeploymentState(config)
+	if err := de.saveState(ctx, state); err != nil {
+		return nil, fmt.Errorf("failed to persist deployment state: %w", err)
+	}
+	return de.run(ctx, state)
+}
+func (de *DeploymentExecutor) Resume(ctx context.Context, deploymentID string) (*DeploymentResult, error) {
+	state, err := de.stateStore.Load(ctx, deploymentID)
+	if err != nil {
+		return nil, err
+	}
+	switch state.Phase {
+	case PhasePending, PhaseRunning, PhaseAwaitingApproval:
+		return de.run(ctx, state)
+	case PhaseRollingBack:
+		return de.rollbackState(ctx, state, state.RollbackReason)
+	default:
+		return nil, fmt.Errorf("deployment %s already finished with phase %s", deploymentID, state.Phase)
+	}
+}
+func (de *DeploymentExecutor) RollbackInFlight(ctx context.Context, deploymentID, reason string) (*DeploymentResult, error) {
+	state, err := de.stateStore.Load(ctx, deploymentID)
+	if err != nil {
+		return nil, err
+	}
+	if !state.Phase.InFlight() {
+		return nil, fmt.Errorf("deployment %s already finished with phase %s", deploymentID, state.Phase)
+	}
+	return de.rollbackState(ctx, state, reason)
+}
+func (de *DeploymentExecutor) RecoverInFlight(ctx context.Context, resume bool) ([]*DeploymentResult, error) {
+	states, err := de.stateStore.ListInFlight(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list in-flight deployments: %w", err)
+	}
+	var results []*DeploymentResult
+	var errs []error
+	for _, state := range states {
+		var result *DeploymentResult
+		var err error
+		if resume || state.Phase == PhaseRollingBack {
+			result, err = de.Resume(ctx, state.ID)
+		} else {
+			result, err = de.rollbackState(ctx, state, "Executor restarted during deployment")
+		}
+		if result != nil {
+			results = append(results, result)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("deployment %s: %w", state.ID, err))
+		}
+	}
+	return results, errors.Join(errs...)
+}
+func (de *DeploymentExecutor) run(ctx context.Context, state *DeploymentState) (*DeploymentResult, error) {
+	state.Phase = PhaseRunning
+	if err := de.saveState(ctx, state); err != nil {
+		return nil, fmt.Errorf("failed to persist deployment state: %w", err)
+	}
+	run := &deploymentRun{state: state}
+	config := state.Config
+	for _, phase := range []HookPhase{HookPreDeploy, HookRelease} {
+		if policy, err := de.runHooks(ctx, run, phase); err != nil {
+			return de.handleHookFailure(ctx, run, policy, err)
+		}
+	}
 	switch config.Strategy {
 	case StrategyDirect:
-		return de.executeDirect(ctx, config)
+		return de.executeDirect(ctx, run, config)
 	case StrategyRolling:
-		return de.executeRolling(ctx, config)
+		return de.executeRolling(ctx, run, config)
 	case StrategyBlueGreen:
-		return de.executeBlueGreen(ctx, config)
+		return de.executeBlueGreen(ctx, run, config)
 	case StrategyCanary:
-		return de.executeCanary(ctx, config)
+		return de.executeCanary(ctx, run, config)
 	case StrategyRecreate:
-		return de.executeRecreate(ctx, config)
+		return de.executeRecreate(ctx, run, config)
 	case StrategyProgressive:
-		return de.executeProgressive(ctx, config)
+		return de.executeProgressive(ctx, run, config)
+	case StrategyShadow:
+		return de.executeShadow(ctx, run, config)
+	case StrategyExperiment:
+		return de.executeExperiment(ctx, run, config)
 	default:
 		return nil, fmt.Errorf("unknown deployment strategy: %s", config.Strategy)
 	}
 }
-func (de *DeploymentExecutor) executeDirect(ctx context.Context, config *DeploymentConfig) (*DeploymentResult, error) {
-	result := &DeploymentResult{
-		Strategy:  config.Strategy,
-		Version:   config.Version,
-		StartTime: time.Now(),
-		Steps:     []DeploymentStep{},
-	}
-	step1 := DeploymentStep{
-		Name:      "Deploy All Instances",
-		StartTime: time.Now(),
-	}
-	time.Sleep(2 * time.Second)
-	step1.EndTime = time.Now()
-	step1.Status = "success"
-	result.Steps = append(result.Steps, step1)
-	step2 := DeploymentStep{
-		Name:      "Health Check",
-		StartTime: time.Now(),
-	}
-	if err := de.healthChecker.Check(ctx, config.HealthCheckURL, config.HealthCheckTimeout); err != nil {
-		step2.Status = "failed"
-		step2.Error = err.Error()
-		result.Status = "failed"
-		result.EndTime = time.Now()
-		return result, err
-	}
-	step2.EndTime = time.Now()
-	step2.Status = "success"
-	result.Steps = append(result.Steps, step2)
-	result.Status = "success"
-	result.EndTime = time.Now()
-	return result, nil
+func (de *DeploymentExecutor) rollbackState(ctx context.Context, state *DeploymentState, reason string) (*DeploymentResult, error) {
+	run := &deploymentRun{state: state}
+	if err := de.compensate(ctx, run, reason); err != nil {
+		return de.finish(ctx, run, "failed", err)
+	}
+	return de.finish(ctx, run, "rolled_back", fmt.Errorf("deployment rolled back: %s", reason))
 }
-func (de *DeploymentExecutor) executeRolling(ctx context.Context, config *DeploymentConfig) (*DeploymentResult, error) {
+func (de *DeploymentExecutor) rollback(ctx context.Context, run *deploymentRun, reason string, cause error) (*DeploymentResult, error) {
+	if err := de.compensate(ctx, run, reason); err != nil {
+		return de.finish(ctx, run, "failed", fmt.Errorf("%v; rollback failed: %w", cause, err))
+	}
+	return de.finish(ctx, run, "rolled_back", cause)
+}
+func (de *DeploymentExecutor) complete(ctx context.Context, run *deploymentRun) (*DeploymentResult, error) {
+	if policy, err := de.runHooks(ctx, run, HookPostDeploy); err != nil {
+		return de.handleHookFailure(ctx, run, policy, err)
+	}
+	return de.finish(ctx, run, "success", nil)
+}
+func (de *DeploymentExecutor) finish(ctx context.Context, run *deploymentRun, status string, err error) (*DeploymentResult, error) {
+	state := run.state
+	switch status {
+	case "success":
+		state.Phase = PhaseSucceeded
+	case "rolled_back":
+		state.Phase = PhaseRolledBack
+	default:
+		state.Phase = PhaseFailed
+	}
+	state.EndTime = time.Now()
+	if err != nil {
+		state.Error = err.Error()
+	}
+	if saveErr := de.saveState(ctx, state); saveErr != nil && err == nil {
+		err = fmt.Errorf("failed to persist deployment state: %w", saveErr)
+	}
 	result := &DeploymentResult{
-		Strategy:  config.Strategy,
-		Version:   config.Version,
-		StartTime: time.Now(),
-		Steps:     []DeploymentStep{},
+		DeploymentID:   state.ID,
+		Strategy:       state.Config.Strategy,
+		Version:        state.Config.Version,
+		Status:         status,
+		StartTime:      state.StartTime,
+		EndTime:        state.EndTime,
+		Steps:          state.Steps,
+		RollbackReason: state.RollbackReason,
+		Approvals:      state.Approvals,
 	}
+	for _, step := range state.Steps {
+		if step.Analysis != nil {
+			result.CanaryAnalyses = append(result.CanaryAnalyses, step.Analysis)
+		}
+		if step.Shadow != nil {
+			result.ShadowReport = step.Shadow
+		}
+		if step.Experiment != nil {
+			result.Experiment = step.Experiment
+		}
+	}
+	return result, err
+}
+func (de *DeploymentExecutor) healthCheckStep(config *DeploymentConfig) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		return de.healthChecker.Check(ctx, config.HealthCheckURL, config.HealthCheckTimeout)
+	}
+}
+func sleepStep(d time.Duration) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		time.Sleep(d)
+		return nil
+	}
+}
+func (de *DeploymentExecutor) executeDirect(ctx context.Context, run *deploymentRun, config *DeploymentConfig) (*DeploymentResult, error) {
+	restore, err := de.restoreCompensation(ctx, config)
+	if err != nil {
+		return de.finish(ctx, run, "failed", err)
+	}
+	if err := de.runStep(ctx, run, "Deploy All Instances", restore, sleepStep(2*time.Second)); err != nil {
+		return de.rollback(ctx, run, "Failed to deploy instances", err)
+	}
+	if err := de.runStep(ctx, run, "Health Check", nil, de.healthCheckStep(config)); err != nil {
+		return de.rollback(ctx, run, "Health check failed", err)
+	}
+	return de.complete(ctx, run)
+}
+func (de *DeploymentExecutor) executeRolling(ctx context.Context, run *deploymentRun, config *DeploymentConfig) (*DeploymentResult, error) {
 	rolloutCfg := config.RolloutConfig
 	if rolloutCfg == nil {
 		rolloutCfg = &RolloutConfig{
@@ -157,113 +353,38 @@ func (de *DeploymentExecutor) executeRolling(ctx context.Context, config *Deploy
 			AutoRollback:   true,
 		}
 	}
+	restore, err := de.restoreCompensation(ctx, config)
+	if err != nil {
+		return de.finish(ctx, run, "failed", err)
+	}
 	totalBatches := (config.Replicas + rolloutCfg.BatchSize - 1) / rolloutCfg.BatchSize
 	for batch := 1; batch <= totalBatches; batch++ {
-		step := DeploymentStep{
-			Name:      fmt.Sprintf("Deploy Batch %d/%d", batch, totalBatches),
-			StartTime: time.Now(),
-		}
-		time.Sleep(2 * time.Second)
-		if err := de.healthChecker.Check(ctx, config.HealthCheckURL, config.HealthCheckTimeout); err != nil {
-			step.Status = "failed"
-			step.Error = err.Error()
-			result.Steps = append(result.Steps, step)
+		name := fmt.Sprintf("Deploy Batch %d/%d", batch, totalBatches)
+		err := de.runStep(ctx, run, name, restore, func(ctx context.Context) error {
+			time.Sleep(2 * time.Second)
+			return de.healthChecker.Check(ctx, config.HealthCheckURL, config.HealthCheckTimeout)
+		})
+		if err != nil {
 			if rolloutCfg.AutoRollback {
-				result.Status = "rolled_back"
-				result.RollbackReason = fmt.Sprintf("Health check failed for batch %d", batch)
-				result.EndTime = time.Now()
-				return result, fmt.Errorf("deployment failed and rolled back: %w", err)
+				return de.rollback(ctx, run, fmt.Sprintf("Health check failed for batch %d", batch),
+					fmt.Errorf("deployment failed and rolled back: %w", err))
+			}
+			return de.finish(ctx, run, "failed", err)
+		}
+		for _, gate := range rolloutCfg.Gates {
+			if gate.AfterBatch == batch && batch < totalBatches {
+				if err := de.awaitApproval(ctx, run, gate); err != nil {
+					return de.rollback(ctx, run, fmt.Sprintf("Approval gate %q not passed", gate.Name), err)
+				}
 			}
-			result.Status = "failed"
-			result.EndTime = time.Now()
-			return result, err
 		}
-		step.EndTime = time.Now()
-		step.Status = "success"
-		result.Steps = append(result.Steps, step)
 		if batch < totalBatches {
 			time.Sleep(rolloutCfg.BatchDelay)
 		}
 	}
-	result.Status = "success"
-	result.EndTime = time.Now()
-	return result, nil
+	return de.complete(ctx, run)
 }
-func (de *DeploymentExecutor) executeBlueGreen(ctx context.Context, config *DeploymentConfig) (*DeploymentResult, error) {
-	result := &DeploymentResult{
-		Strategy:  config.Strategy,
-		Version:   config.Version,
-		StartTime: time.Now(),
-		Steps:     []DeploymentStep{},
-	}
-	step1 := DeploymentStep{
-		Name:      "Deploy Green Environment",
-		StartTime: time.Now(),
-	}
-	time.Sleep(3 * time.Second)
-	step1.EndTime = time.Now()
-	step1.Status = "success"
-	result.Steps = append(result.Steps, step1)
-	step2 := DeploymentStep{
-		Name:      "Health Check Green Environment",
-		StartTime: time.Now(),
-	}
-	if err := de.healthChecker.Check(ctx, config.HealthCheckURL, config.HealthCheckTimeout); err != nil {
-		step2.Status = "failed"
-		step2.Error = err.Error()
-		result.Steps = append(result.Steps, step2)
-		result.Status = "failed"
-		result.EndTime = time.Now()
-		return result, err
-	}
-	step2.EndTime = time.Now()
-	step2.Status = "success"
-	result.Steps = append(result.Steps, step2)
-	step3 := DeploymentStep{
-		Name:      "Switch Traffic to Green",
-		StartTime: time.Now(),
-	}
-	if err := de.loadBalancer.SwitchTraffic(ctx, "blue", "green"); err != nil {
-		step3.Status = "failed"
-		step3.Error = err.Error()
-		result.Steps = append(result.Steps, step3)
-		result.Status = "failed"
-		result.EndTime = time.Now()
-		return result, err
-	}
-	step3.EndTime = time.Now()
-	step3.Status = "success"
-	result.Steps = append(result.Steps, step3)
-	step4 := DeploymentStep{
-		Name:      "Monitor Green Environment",
-		StartTime: time.Now(),
-	}
-	time.Sleep(30 * time.Second)
-	metrics, err := de.monitor.GetMetrics(ctx, config.Version)
-	if err == nil && metrics.ErrorRate > 0.05 {
-		step4.Status = "failed"
-		step4.Error = "High error rate detected"
-		result.Steps = append(result.Steps, step4)
-		de.loadBalancer.SwitchTraffic(ctx, "green", "blue")
-		result.Status = "rolled_back"
-		result.RollbackReason = "High error rate in green environment"
-		result.EndTime = time.Now()
-		return result, fmt.Errorf("deployment rolled back due to high error rate")
-	}
-	step4.EndTime = time.Now()
-	step4.Status = "success"
-	result.Steps = append(result.Steps, step4)
-	result.Status = "success"
-	result.EndTime = time.Now()
-	return result, nil
-}
-func (de *DeploymentExecutor) executeCanary(ctx context.Context, config *DeploymentConfig) (*DeploymentResult, error) {
-	result := &DeploymentResult{
-		Strategy:  config.Strategy,
-		Version:   config.Version,
-		StartTime: time.Now(),
-		Steps:     []DeploymentStep{},
-	}
+func (de *DeploymentExecutor) executeCanary(ctx context.Context, run *deploymentRun, config *DeploymentConfig) (*DeploymentResult, error) {
 	canaryCfg := config.CanaryConfig
 	if canaryCfg == nil {
 		canaryCfg = &CanaryConfig{
@@ -274,138 +395,152 @@ func (de *DeploymentExecutor) executeCanary(ctx context.Context, config *Deploym
 			AutoPromote:      true,
 		}
 	}
-	step1 := DeploymentStep{
-		Name:      "Deploy Canary",
-		StartTime: time.Now(),
+	if err := de.runStep(ctx, run, "Deploy Canary", nil, sleepStep(2*time.Second)); err != nil {
+		return de.finish(ctx, run, "failed", err)
 	}
-	time.Sleep(2 * time.Second)
-	step1.EndTime = time.Now()
-	step1.Status = "success"
-	result.Steps = append(result.Steps, step1)
+	resetWeight := &Compensation{Type: CompensationTrafficWeight, Version: config.Version, Weight: 0}
 	allWeights := append([]int{canaryCfg.InitialWeight}, canaryCfg.Increments...)
+	passedGates := make(map[string]bool)
 	for i, weight := range allWeights {
-		step := DeploymentStep{
-			Name:      fmt.Sprintf("Route %d%% Traffic to Canary", weight),
-			StartTime: time.Now(),
-		}
-		if err := de.loadBalancer.SetTrafficWeight(ctx, config.Version, weight); err != nil {
-			step.Status = "failed"
-			step.Error = err.Error()
-			result.Steps = append(result.Steps, step)
-			result.Status = "failed"
-			result.EndTime = time.Now()
-			return result, err
+		for _, gate := range canaryCfg.Gates {
+			if weight > gate.AfterWeight && !passedGates[gate.Name] {
+				passedGates[gate.Name] = true
+				if err := de.awaitApproval(ctx, run, gate); err != nil {
+					return de.rollback(ctx, run, fmt.Sprintf("Approval gate %q not passed", gate.Name), err)
+				}
+			}
 		}
-		step.EndTime = time.Now()
-		step.Status = "success"
-		result.Steps = append(result.Steps, step)
-		monitorStep := DeploymentStep{
-			Name:      fmt.Sprintf("Monitor Canary at %d%%", weight),
-			StartTime: time.Now(),
+		err := de.runStep(ctx, run, fmt.Sprintf("Route %d%% Traffic to Canary", weight), resetWeight, func(ctx context.Context) error {
+			return de.loadBalancer.SetTrafficWeight(ctx, config.Version, weight)
+		})
+		if err != nil {
+			return de.rollback(ctx, run, fmt.Sprintf("Failed to route %d%% traffic to canary", weight), err)
 		}
-		time.Sleep(canaryCfg.StepDuration)
-		errorRate, err := de.monitor.GetErrorRate(ctx, config.Version)
-		if err == nil && errorRate > canaryCfg.FailureThreshold {
-			monitorStep.Status = "failed"
-			monitorStep.Error = fmt.Sprintf("Error rate %.2f%% exceeds threshold", errorRate*100)
-			result.Steps = append(result.Steps, monitorStep)
-			de.loadBalancer.SetTrafficWeight(ctx, config.Version, 0)
-			result.Status = "rolled_back"
-			result.RollbackReason = fmt.Sprintf("High error rate at %d%% traffic", weight)
-			result.EndTime = time.Now()
-			return result, fmt.Errorf("canary deployment rolled back")
+		if canaryCfg.Analysis != nil {
+			var analysis *CanaryAnalysis
+			err = de.runStep(ctx, run, fmt.Sprintf("Analyze Canary at %d%%", weight), nil, func(ctx context.Context) error {
+				var err error
+				analysis, err = de.analyzeCanary(ctx, config, canaryCfg, weight)
+				if err != nil {
+					return err
+				}
+				run.current().Analysis = analysis
+				if analysis.Verdict == VerdictFail {
+					return fmt.Errorf("%s", analysis.Judgement)
+				}
+				return nil
+			})
+			if err != nil {
+				reason := fmt.Sprintf("Canary analysis failed at %d%% traffic", weight)
+				if analysis != nil {
+					reason = analysis.Judgement
+				}
+				return de.rollback(ctx, run, reason, fmt.Errorf("canary deployment rolled back"))
+			}
+		} else {
+			err = de.runStep(ctx, run, fmt.Sprintf("Monitor Canary at %d%%", weight), nil, func(ctx context.Context) error {
+				time.Sleep(canaryCfg.StepDuration)
+				errorRate, err := de.monitor.GetErrorRate(ctx, config.Version)
+				if err == nil && errorRate > canaryCfg.FailureThreshold {
+					return fmt.Errorf("Error rate %.2f%% exceeds threshold", errorRate*100)
+				}
+				return nil
+			})
+			if err != nil {
+				return de.rollback(ctx, run, fmt.Sprintf("High error rate at %d%% traffic", weight),
+					fmt.Errorf("canary deployment rolled back"))
+			}
 		}
-		monitorStep.EndTime = time.Now()
-		monitorStep.Status = "success"
-		result.Steps = append(result.Steps, monitorStep)
 		if i < len(allWeights)-1 {
 			time.Sleep(5 * time.Second)
 		}
 	}
-	result.Status = "success"
-	result.EndTime = time.Now()
-	return result, nil
-}
-func (de *DeploymentExecutor) executeRecreate(ctx context.Context, config *DeploymentConfig) (*DeploymentResult, error) {
-	result := &DeploymentResult{
-		Strategy:  config.Strategy,
-		Version:   config.Version,
-		StartTime: time.Now(),
-		Steps:     []DeploymentStep{},
-	}
-	step1 := DeploymentStep{
-		Name:      "Delete Old Version",
-		StartTime: time.Now(),
-	}
-	time.Sleep(1 * time.Second)
-	step1.EndTime = time.Now()
-	step1.Status = "success"
-	result.Steps = append(result.Steps, step1)
-	step2 := DeploymentStep{
-		Name:      "Deploy New Version",
-		StartTime: time.Now(),
-	}
-	time.Sleep(3 * time.Second)
-	step2.EndTime = time.Now()
-	step2.Status = "success"
-	result.Steps = append(result.Steps, step2)
-	step3 := DeploymentStep{
-		Name:      "Health Check",
-		StartTime: time.Now(),
-	}
-	if err := de.healthChecker.Check(ctx, config.HealthCheckURL, config.HealthCheckTimeout); err != nil {
-		step3.Status = "failed"
-		step3.Error = err.Error()
-		result.Steps = append(result.Steps, step3)
-		result.Status = "failed"
-		result.EndTime = time.Now()
-		return result, err
-	}
-	step3.EndTime = time.Now()
-	step3.Status = "success"
-	result.Steps = append(result.Steps, step3)
-	result.Status = "success"
-	result.EndTime = time.Now()
-	return result, nil
+	return de.complete(ctx, run)
 }
-func (de *DeploymentExecutor) executeProgressive(ctx context.Context, config *DeploymentConfig) (*DeploymentResult, error) {
-	result := &DeploymentResult{
-		Strategy:  config.Strategy,
-		Version:   config.Version,
-		StartTime: time.Now(),
-		Steps:     []DeploymentStep{},
+func (de *DeploymentExecutor) executeRecreate(ctx context.Context, run *deploymentRun, config *DeploymentConfig) (*DeploymentResult, error) {
+	restore, err := de.restoreCompensation(ctx, config)
+	if err != nil {
+		return de.finish(ctx, run, "failed", err)
+	}
+	if err := de.runStep(ctx, run, "Delete Old Version", restore, sleepStep(1*time.Second)); err != nil {
+		return de.rollback(ctx, run, "Failed to delete old version", err)
 	}
-	progCfg := config.ProgressiveConfig
-	if progCfg == nil {
-		return nil, fmt.Errorf("progressive config required for progressive deployment")
+	if err := de.runStep(ctx, run, "Deploy New Version", restore, sleepStep(3*time.Second)); err != nil {
+		return de.rollback(ctx, run, "Failed to deploy new version", err)
 	}
+	if err := de.runStep(ctx, run, "Health Check", nil, de.healthCheckStep(config)); err != nil {
+		return de.rollback(ctx, run, "Health check failed", err)
+	}
+	return de.complete(ctx, run)
+}
+func (de *DeploymentExecutor) executeProgressive(ctx context.Context, run *deploymentRun, config *DeploymentConfig) (*DeploymentResult, error) {
+	if config.ProgressiveConfig == nil {
+		return de.finish(ctx, run, "failed", fmt.Errorf("progressive config required for progressive deployment"))
+	}
+	progCfg := config.ProgressiveConfig.withDefaults()
+	rules := make([]RoutingRule, 0, len(progCfg.UserSegments))
 	for _, segment := range progCfg.UserSegments {
-		step := DeploymentStep{
-			Name:      fmt.Sprintf("Deploy to %s (%d%%)", segment.Name, segment.Percentage),
-			StartTime: time.Now(),
+		rule, err := compileSegment(config, segment)
+		if err != nil {
+			return de.finish(ctx, run, "failed", err)
+		}
+		rules = append(rules, rule)
+	}
+	if err := de.runStep(ctx, run, "Deploy Progressive Release", nil, sleepStep(2*time.Second)); err != nil {
+		return de.finish(ctx, run, "failed", err)
+	}
+	if err := de.applyFeatureFlags(ctx, run, progCfg.FeatureFlags); err != nil {
+		return de.rollback(ctx, run, "Failed to apply feature flags", err)
+	}
+	for i, segment := range progCfg.UserSegments {
+		stage := fmt.Sprintf("segment %s (%d%%)", segment.Name, segment.Percentage)
+		if err := de.progressiveStage(ctx, run, config, progCfg, stage, rules[i], ""); err != nil {
+			return de.rollback(ctx, run, fmt.Sprintf("Progressive rollout failed at %s", stage), err)
+		}
+		if err := de.awaitStageGates(ctx, run, &progCfg, segment.Name); err != nil {
+			return de.rollback(ctx, run, err.Error(), err)
 		}
-		time.Sleep(2 * time.Second)
-		step.EndTime = time.Now()
-		step.Status = "success"
-		result.Steps = append(result.Steps, step)
-		time.Sleep(30 * time.Second)
 	}
 	for _, region := range progCfg.GeographicRollout {
-		step := DeploymentStep{
-			Name:      fmt.Sprintf("Deploy to %s", region),
-			StartTime: time.Now(),
+		stage := fmt.Sprintf("region %s", region)
+		if err := de.progressiveStage(ctx, run, config, progCfg, stage, regionRule(config, region), region); err != nil {
+			return de.rollback(ctx, run, fmt.Sprintf("Progressive rollout failed in %s", stage), err)
+		}
+		if err := de.awaitStageGates(ctx, run, &progCfg, region); err != nil {
+			return de.rollback(ctx, run, err.Error(), err)
+		}
+	}
+	resetWeight := &Compensation{Type: CompensationTrafficWeight, Version: config.Version, Weight: 0}
+	err := de.runStep(ctx, run, "Route All Traffic to New Version", resetWeight, func(ctx context.Context) error {
+		return de.loadBalancer.SetTrafficWeight(ctx, config.Version, 100)
+	})
+	if err != nil {
+		return de.rollback(ctx, run, "Failed to promote progressive release", err)
+	}
+	err = de.runStep(ctx, run, "Remove Progressive Routing Rules", nil, func(ctx context.Context) error {
+		router, ok := de.loadBalancer.(RuleRouter)
+		if !ok {
+			return nil
 		}
-		time.Sleep(2 * time.Second)
-		step.EndTime = time.Now()
-		step.Status = "success"
-		result.Steps = append(result.Steps, step)
-		time.Sleep(30 * time.Second)
-	}
-	result.Status = "success"
-	result.EndTime = time.Now()
-	return result, nil
+		for _, rule := range rules {
+			if err := router.RemoveRoutingRule(ctx, rule.Name); err != nil {
+				return err
+			}
+		}
+		for _, region := range progCfg.GeographicRollout {
+			if err := router.RemoveRoutingRule(ctx, regionRule(config, region).Name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return de.rollback(ctx, run, "Failed to clean up progressive routing rules", err)
+	}
+	return de.complete(ctx, run)
 }
 type DeploymentResult struct {
+	DeploymentID   string
 	Strategy       DeploymentStrategy
 	Version        string
 	Status         string
@@ -413,13 +548,23 @@ type DeploymentResult struct {
 	EndTime        time.Time
 	Steps          []DeploymentStep
 	RollbackReason string
+	CanaryAnalyses []*CanaryAnalysis
+	Approvals      []ApprovalDecision
+	ShadowReport   *ShadowReport
+	Experiment     *ExperimentResult
 }
 type DeploymentStep struct {
-	Name      string
-	Status    string
-	StartTime time.Time
-	EndTime   time.Time
-	Error     string
+	Name         string
+	Status       string
+	StartTime    time.Time
+	EndTime      time.Time
+	Error        string
+	Attempts     int
+	Compensation *Compensation
+	Analysis     *CanaryAnalysis
+	Shadow       *ShadowReport
+	Experiment   *ExperimentResult
+	Output       string
 }
 func (dr *DeploymentResult) Duration() time.Duration {
 	return dr.EndTime.Sub(dr.StartTime)
@@ -0,0 +1,254 @@
+package dora
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+	"github.com/opsagent/opsagent/internal/cicd"
+	"github.com/opsagent/opsagent/internal/deployer"
+)
+type GroupBy string
+const (
+	GroupByNone        GroupBy = ""
+	GroupByProject     GroupBy = "project"
+	GroupByEnvironment GroupBy = "environment"
+	GroupByTeam        GroupBy = "team"
+)
+type BuildLookup interface {
+	GetBuildsByCommits(ctx context.Context, projectID string, commits []string) (map[string]*cicd.Build, error)
+}
+type Query struct {
+	OrganizationID string    `json:"organization_id,omitempty"`
+	ProjectID      string    `json:"project_id,omitempty"`
+	Environment    string    `json:"environment,omitempty"`
+	Team           string    `json:"team,omitempty"`
+	From           time.Time `json:"from"`
+	To             time.Time `json:"to"`
+	GroupBy        GroupBy   `json:"group_by,omitempty"`
+}
+type DurationStats struct {
+	Samples int           `json:"samples"`
+	Median  time.Duration `json:"median"`
+	P90     time.Duration `json:"p90"`
+	Mean    time.Duration `json:"mean"`
+}
+type Metrics struct {
+	Deployments         int           `json:"deployments"`
+	FailedDeployments   int           `json:"failed_deployments"`
+	DeploymentFrequency float64       `json:"deployment_frequency_per_day"`
+	LeadTime            DurationStats `json:"lead_time_for_changes"`
+	ChangeFailureRate   float64       `json:"change_failure_rate"`
+	TimeToRestore       DurationStats `json:"time_to_restore"`
+	Unrestored          int           `json:"unrestored_failures"`
+}
+type Report struct {
+	Query   Query               `json:"query"`
+	Overall Metrics             `json:"overall"`
+	Groups  map[string]*Metrics `json:"groups,omitempty"`
+}
+type Service struct {
+	history *deployer.DeploymentHistory
+	builds  BuildLookup
+}
+func NewService(history *deployer.DeploymentHistory, builds BuildLookup) *Service {
+	return &Service{
+		history: history,
+		builds:  builds,
+	}
+}
+func (s *Service) Report(ctx context.Context, query Query) (*Report, error) {
+	if query.To.IsZero() {
+		query.To = time.Now()
+	}
+	if query.From.IsZero() {
+		query.From = query.To.AddDate(0, -3, 0)
+	}
+	if !query.From.Before(query.To) {
+		return nil, fmt.Errorf("report window start must be before its end")
+	}
+	switch query.GroupBy {
+	case GroupByNone, GroupByProject, GroupByEnvironment, GroupByTeam:
+	default:
+		return nil, fmt.Errorf("unsupported grouping %q", query.GroupBy)
+	}
+	records, err := s.records(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	leadTimes, err := s.leadTimes(ctx, records)
+	if err != nil {
+		return nil, err
+	}
+	report := &Report{Query: query}
+	report.Overall = compute(records, query, leadTimes)
+	if query.GroupBy != GroupByNone {
+		grouped := make(map[string][]*deployer.DeploymentRecord)
+		for _, record := range records {
+			key := groupKey(record, query.GroupBy)
+			grouped[key] = append(grouped[key], record)
+		}
+		report.Groups = make(map[string]*Metrics, len(grouped))
+		for key, group := range grouped {
+			metrics := compute(group, query, leadTimes)
+			report.Groups[key] = &metrics
+		}
+	}
+	return report, nil
+}
+func groupKey(record *deployer.DeploymentRecord, groupBy GroupBy) string {
+	var key string
+	switch groupBy {
+	case GroupByProject:
+		key = record.ProjectID
+	case GroupByEnvironment:
+		key = record.Environment
+	case GroupByTeam:
+		key = record.Team
+	}
+	if key == "" {
+		return "unassigned"
+	}
+	return key
+}
+func (s *Service) records(ctx context.Context, query Query) ([]*deployer.DeploymentRecord, error) {
+	var records []*deployer.DeploymentRecord
+	historyQuery := deployer.HistoryQuery{
+		ProjectID:   query.ProjectID,
+		Environment: query.Environment,
+		Limit:       500,
+	}
+	for {
+		page, err := s.history.QueryDeployments(ctx, historyQuery)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read deployment history: %w", err)
+		}
+		for _, record := range page.Records {
+			if record.DeployedAt.Before(query.From) {
+				return records, nil
+			}
+			if record.DeployedAt.After(query.To) || (query.Team != "" && record.Team != query.Team) ||
+				(query.OrganizationID != "" && record.OrganizationID != query.OrganizationID) {
+				continue
+			}
+			records = append(records, record)
+		}
+		if page.NextCursor == "" {
+			return records, nil
+		}
+		historyQuery.Cursor = page.NextCursor
+	}
+}
+func compute(records []*deployer.DeploymentRecord, query Query, leads map[string]time.Duration) Metrics {
+	var metrics Metrics
+	ordered := make([]*deployer.DeploymentRecord, len(records))
+	copy(ordered, records)
+	sort.Slice(ordered, func(i, j int) bool {
+		return ordered[i].DeployedAt.Before(ordered[j].DeployedAt)
+	})
+	rolledBack := make(map[string]bool)
+	for _, record := range ordered {
+		if record.RollbackFrom != "" {
+			rolledBack[record.RollbackFrom] = true
+		}
+	}
+	var leadTimes, restoreTimes []time.Duration
+	failedSince := make(map[string]time.Time)
+	for _, record := range ordered {
+		stream := record.ProjectID + "/" + record.Environment
+		failed := record.Status == "failed" || record.Status == "rolled_back" || rolledBack[record.ID]
+		if record.RollbackFrom == "" {
+			metrics.Deployments++
+			if failed {
+				metrics.FailedDeployments++
+			} else if lead, ok := leads[record.ID]; ok {
+				leadTimes = append(leadTimes, lead)
+			}
+		}
+		if failed {
+			if _, open := failedSince[stream]; !open {
+				failedSince[stream] = record.DeployedAt
+			}
+			continue
+		}
+		if since, open := failedSince[stream]; open && record.Status == "success" {
+			restoreTimes = append(restoreTimes, record.DeployedAt.Add(record.Duration).Sub(since))
+			delete(failedSince, stream)
+		}
+	}
+	metrics.Unrestored = len(failedSince)
+	days := query.To.Sub(query.From).Hours() / 24
+	if days > 0 {
+		metrics.DeploymentFrequency = float64(metrics.Deployments) / days
+	}
+	if metrics.Deployments > 0 {
+		metrics.ChangeFailureRate = float64(metrics.FailedDeployments) / float64(metrics.Deployments)
+	}
+	metrics.LeadTime = durationStats(leadTimes)
+	metrics.TimeToRestore = durationStats(restoreTimes)
+	return metrics
+}
+func (s *Service) leadTimes(ctx context.Context, records []*deployer.DeploymentRecord) (map[string]time.Duration, error) {
+	leadTimes := make(map[string]time.Duration)
+	if s.builds == nil {
+		return leadTimes, nil
+	}
+	byProject := make(map[string][]*deployer.DeploymentRecord)
+	for _, record := range records {
+		if commit := deployedCommit(record); commit != "" && record.RollbackFrom == "" {
+			byProject[record.ProjectID] = append(byProject[record.ProjectID], record)
+		}
+	}
+	for projectID, projectRecords := range byProject {
+		seen := make(map[string]bool)
+		var commits []string
+		for _, record := range projectRecords {
+			if commit := deployedCommit(record); !seen[commit] {
+				seen[commit] = true
+				commits = append(commits, commit)
+			}
+		}
+		builds, err := s.builds.GetBuildsByCommits(ctx, projectID, commits)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up builds for %s: %w", projectID, err)
+		}
+		for _, record := range projectRecords {
+			build, ok := builds[deployedCommit(record)]
+			if !ok {
+				continue
+			}
+			if lead := record.DeployedAt.Add(record.Duration).Sub(build.CommitTime()); lead >= 0 {
+				leadTimes[record.ID] = lead
+			}
+		}
+	}
+	return leadTimes, nil
+}
+func deployedCommit(record *deployer.DeploymentRecord) string {
+	if record.GitCommit != "" {
+		return record.GitCommit
+	}
+	return record.Version
+}
+func durationStats(values []time.Duration) DurationStats {
+	stats := DurationStats{Samples: len(values)}
+	if len(values) == 0 {
+		return stats
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	var total time.Duration
+	for _, v := range values {
+		total += v
+	}
+	stats.Mean = total / time.Duration(len(values))
+	stats.Median = percentile(values, 0.5)
+	stats.P90 = percentile(values, 0.9)
+	return stats
+}
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	pos := float64(len(sorted)-1) * p
+	lo := int(pos)
+	if lo+1 >= len(sorted) {
+		return sorted[lo]
+	}
+	return sorted[lo] + time.Duration((pos-float64(lo))*float64(sorted[lo+1]-sorted[lo]))
+}
@@ -0,0 +1,146 @@
+package dora
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+	"github.com/opsagent/opsagent/internal/cicd"
+	"github.com/opsagent/opsagent/internal/deployer"
+)
+var windowStart = time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC)
+func at(day, hour int) time.Time {
+	return windowStart.Add(time.Duration(day)*24*time.Hour + time.Duration(hour)*time.Hour)
+}
+type fakeBuilds map[string]time.Time
+func (f fakeBuilds) GetBuildsByCommits(ctx context.Context, projectID string, commits []string) (map[string]*cicd.Build, error) {
+	builds := make(map[string]*cicd.Build)
+	for _, commit := range commits {
+		if committed, ok := f[commit]; ok {
+			builds[commit] = &cicd.Build{ProjectID: projectID, GitCommit: commit, Metadata: map[string]interface{}{"committed_at": committed.Format(time.RFC3339)}}
+		}
+	}
+	return builds, nil
+}
+func fixtureService(t *testing.T) *Service {
+	t.Helper()
+	history := deployer.NewDeploymentHistory(t.TempDir())
+	records := []*deployer.DeploymentRecord{
+		{ID: "a0", ProjectID: "api", Team: "payments", Status: "success", DeployedAt: at(-1, 10), GitCommit: "c0"},
+		{ID: "a1", ProjectID: "api", Team: "payments", Status: "success", DeployedAt: at(1, 10), Duration: 10 * time.Minute, GitCommit: "c1"},
+		{ID: "a2", ProjectID: "api", Team: "payments", Status: "failed", DeployedAt: at(2, 10), Duration: 5 * time.Minute, GitCommit: "c2"},
+		{ID: "a3", ProjectID: "api", Team: "payments", Status: "success", DeployedAt: at(2, 11), Duration: 30 * time.Minute, GitCommit: "c3"},
+		{ID: "a4", ProjectID: "api", Team: "payments", Status: "success", DeployedAt: at(3, 10), GitCommit: "c4"},
+		{ID: "r1", ProjectID: "api", Team: "payments", Status: "success", DeployedAt: at(3, 12), GitCommit: "c3", RollbackFrom: "a4"},
+		{ID: "w1", ProjectID: "web", Team: "growth", Status: "success", DeployedAt: at(4, 9), Version: "c5"},
+		{ID: "w2", ProjectID: "web", Team: "growth", Status: "rolled_back", DeployedAt: at(5, 9), Version: "c6"},
+		{ID: "w3", ProjectID: "web", Team: "growth", Status: "success", DeployedAt: at(11, 9), Version: "c7"},
+		{ID: "x1", OrganizationID: "other", ProjectID: "ops", Status: "success", DeployedAt: at(6, 9)},
+	}
+	for _, record := range records {
+		if record.OrganizationID == "" {
+			record.OrganizationID = "org"
+		}
+		record.Environment = "production"
+		if err := history.RecordDeployment(context.Background(), record); err != nil {
+			t.Fatalf("RecordDeployment: %v", err)
+		}
+	}
+	return NewService(history, fakeBuilds{
+		"c1": at(1, 8),
+		"c2": at(2, 9),
+		"c3": at(2, 10).Add(30 * time.Minute),
+		"c5": at(3, 9),
+	})
+}
+func assertMetrics(t *testing.T, name string, got, want Metrics) {
+	t.Helper()
+	if got != want {
+		t.Fatalf("%s: expected\n%+v\ngot\n%+v", name, want, got)
+	}
+}
+func TestReportOverWindow(t *testing.T) {
+	report, err := fixtureService(t).Report(context.Background(), Query{OrganizationID: "org", From: at(0, 0), To: at(10, 0)})
+	if err != nil {
+		t.Fatalf("Report: %v", err)
+	}
+	assertMetrics(t, "overall", report.Overall, Metrics{
+		Deployments:         6,
+		FailedDeployments:   3,
+		DeploymentFrequency: 0.6,
+		LeadTime: DurationStats{
+			Samples: 3,
+			Median:  2*time.Hour + 10*time.Minute,
+			P90:     19*time.Hour + 38*time.Minute,
+			Mean:    9*time.Hour + 3*time.Minute + 20*time.Second,
+		},
+		ChangeFailureRate: 0.5,
+		TimeToRestore: DurationStats{
+			Samples: 2,
+			Median:  time.Hour + 45*time.Minute,
+			P90:     time.Hour + 57*time.Minute,
+			Mean:    time.Hour + 45*time.Minute,
+		},
+		Unrestored: 1,
+	})
+	if report.Groups != nil {
+		t.Fatalf("expected no groups without a grouping, got %v", report.Groups)
+	}
+}
+func TestReportPerTeam(t *testing.T) {
+	service := fixtureService(t)
+	report, err := service.Report(context.Background(), Query{OrganizationID: "org", From: at(0, 0), To: at(10, 0), GroupBy: GroupByTeam})
+	if err != nil {
+		t.Fatalf("Report: %v", err)
+	}
+	if len(report.Groups) != 2 {
+		t.Fatalf("expected a group per team, got %v", report.Groups)
+	}
+	payments := Metrics{
+		Deployments:         4,
+		FailedDeployments:   2,
+		DeploymentFrequency: 0.4,
+		LeadTime:            DurationStats{Samples: 2, Median: time.Hour + 35*time.Minute, P90: 2*time.Hour + 3*time.Minute, Mean: time.Hour + 35*time.Minute},
+		ChangeFailureRate:   0.5,
+		TimeToRestore:       DurationStats{Samples: 2, Median: time.Hour + 45*time.Minute, P90: time.Hour + 57*time.Minute, Mean: time.Hour + 45*time.Minute},
+	}
+	growth := Metrics{
+		Deployments:         2,
+		FailedDeployments:   1,
+		DeploymentFrequency: 0.2,
+		LeadTime:            DurationStats{Samples: 1, Median: 24 * time.Hour, P90: 24 * time.Hour, Mean: 24 * time.Hour},
+		ChangeFailureRate:   0.5,
+		Unrestored:          1,
+	}
+	assertMetrics(t, "payments", *report.Groups["payments"], payments)
+	assertMetrics(t, "growth", *report.Groups["growth"], growth)
+	filtered, err := service.Report(context.Background(), Query{OrganizationID: "org", Team: "growth", From: at(0, 0), To: at(10, 0)})
+	if err != nil {
+		t.Fatalf("Report: %v", err)
+	}
+	assertMetrics(t, "team filter", filtered.Overall, growth)
+}
+func TestReportWithoutBuilds(t *testing.T) {
+	service := fixtureService(t)
+	service.builds = nil
+	report, err := service.Report(context.Background(), Query{OrganizationID: "org", ProjectID: "api", From: at(0, 0), To: at(4, 0)})
+	if err != nil {
+		t.Fatalf("Report: %v", err)
+	}
+	if report.Overall.Deployments != 4 || report.Overall.DeploymentFrequency != 1 || report.Overall.LeadTime.Samples != 0 {
+		t.Fatalf("expected four deployments a day apart and no lead times, got %+v", report.Overall)
+	}
+}
+func TestReportRejectsInvalidQueries(t *testing.T) {
+	service := fixtureService(t)
+	for _, tt := range []struct {
+		query   Query
+		wantErr string
+	}{
+		{query: Query{From: at(2, 0), To: at(1, 0)}, wantErr: "window start must be before its end"},
+		{query: Query{From: at(0, 0), To: at(1, 0), GroupBy: "region"}, wantErr: `unsupported grouping "region"`},
+	} {
+		if _, err := service.Report(context.Background(), tt.query); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Fatalf("expected %q, got %v", tt.wantErr, err)
+		}
+	}
+}
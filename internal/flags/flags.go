@@ -0,0 +1,212 @@
+package flags
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	sdk "github.com/opsagent/opsagent/pkg/flags"
+)
+var ErrFlagNotFound = errors.New("feature flag not found")
+var validKey = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+type FlagService struct {
+	storagePath string
+	mu          sync.Mutex
+}
+func NewFlagService(storagePath string) *FlagService {
+	return &FlagService{
+		storagePath: storagePath,
+	}
+}
+func (fs *FlagService) SaveFlag(ctx context.Context, flag *sdk.Flag) error {
+	if err := validateFlag(flag); err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	existing, err := fs.load(flag.ProjectID, flag.Environment, flag.Key)
+	switch {
+	case errors.Is(err, ErrFlagNotFound):
+		flag.CreatedAt = time.Now()
+		flag.Revision = 1
+	case err != nil:
+		return err
+	default:
+		flag.CreatedAt = existing.CreatedAt
+		flag.Revision = existing.Revision + 1
+	}
+	flag.UpdatedAt = time.Now()
+	return fs.write(flag)
+}
+func (fs *FlagService) GetFlag(ctx context.Context, projectID, environment, key string) (*sdk.Flag, error) {
+	return fs.load(projectID, environment, key)
+}
+func (fs *FlagService) ListFlags(ctx context.Context, projectID, environment string) ([]*sdk.Flag, error) {
+	dir, err := fs.dir(projectID, environment)
+	if err != nil {
+		return nil, err
+	}
+	files, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []*sdk.Flag{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	flags := []*sdk.Flag{}
+	for _, file := range files {
+		if filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+		flag, err := fs.load(projectID, environment, strings.TrimSuffix(file.Name(), ".json"))
+		if err != nil {
+			continue
+		}
+		flags = append(flags, flag)
+	}
+	sort.Slice(flags, func(i, j int) bool {
+		return flags[i].Key < flags[j].Key
+	})
+	return flags, nil
+}
+func (fs *FlagService) DeleteFlag(ctx context.Context, projectID, environment, key string) error {
+	path, err := fs.path(projectID, environment, key)
+	if err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+func (fs *FlagService) SetFlag(ctx context.Context, projectID, environment, key string, enabled bool) (bool, bool, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	flag, err := fs.load(projectID, environment, key)
+	if errors.Is(err, ErrFlagNotFound) {
+		flag = &sdk.Flag{
+			Key:         key,
+			ProjectID:   projectID,
+			Environment: environment,
+			Percentage:  100,
+			CreatedAt:   time.Now(),
+		}
+		if err := validateFlag(flag); err != nil {
+			return false, false, err
+		}
+		flag.Enabled = enabled
+		flag.Revision = 1
+		flag.UpdatedBy = "deployment"
+		flag.UpdatedAt = time.Now()
+		return false, false, fs.write(flag)
+	}
+	if err != nil {
+		return false, false, err
+	}
+	previous := flag.Enabled
+	flag.Enabled = enabled
+	flag.Revision++
+	flag.UpdatedBy = "deployment"
+	flag.UpdatedAt = time.Now()
+	return previous, true, fs.write(flag)
+}
+func (fs *FlagService) Evaluate(ctx context.Context, projectID, environment string, keys []string, evalCtx sdk.EvaluationContext) (map[string]sdk.Evaluation, error) {
+	results := make(map[string]sdk.Evaluation)
+	if len(keys) == 0 {
+		flags, err := fs.ListFlags(ctx, projectID, environment)
+		if err != nil {
+			return nil, err
+		}
+		for _, flag := range flags {
+			results[flag.Key] = sdk.Evaluate(flag, evalCtx)
+		}
+		return results, nil
+	}
+	for _, key := range keys {
+		flag, err := fs.load(projectID, environment, key)
+		if err != nil && !errors.Is(err, ErrFlagNotFound) {
+			return nil, err
+		}
+		result := sdk.Evaluate(flag, evalCtx)
+		result.Key = key
+		results[key] = result
+	}
+	return results, nil
+}
+func validateFlag(flag *sdk.Flag) error {
+	if flag.ProjectID == "" || flag.Environment == "" {
+		return errors.New("flag requires a project and an environment")
+	}
+	if !validKey.MatchString(flag.Key) {
+		return fmt.Errorf("invalid flag key %q", flag.Key)
+	}
+	if flag.Percentage < 0 || flag.Percentage > 100 {
+		return fmt.Errorf("flag percentage must be between 0 and 100, got %d", flag.Percentage)
+	}
+	for _, rule := range flag.Rules {
+		if err := rule.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+func (fs *FlagService) dir(projectID, environment string) (string, error) {
+	if !validKey.MatchString(projectID) || !validKey.MatchString(environment) {
+		return "", fmt.Errorf("invalid project or environment")
+	}
+	return filepath.Join(fs.storagePath, projectID, environment), nil
+}
+func (fs *FlagService) path(projectID, environment, key string) (string, error) {
+	dir, err := fs.dir(projectID, environment)
+	if err != nil {
+		return "", err
+	}
+	if !validKey.MatchString(key) {
+		return "", fmt.Errorf("invalid flag key %q", key)
+	}
+	return filepath.Join(dir, key+".json"), nil
+}
+func (fs *FlagService) load(projectID, environment, key string) (*sdk.Flag, error) {
+	path, err := fs.path(projectID, environment, key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrFlagNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var flag sdk.Flag
+	if err := json.Unmarshal(data, &flag); err != nil {
+		return nil, err
+	}
+	return &flag, nil
+}
+func (fs *FlagService) write(flag *sdk.Flag) error {
+	path, err := fs.path(flag.ProjectID, flag.Environment, flag.Key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(flag, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
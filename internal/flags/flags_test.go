@@ -0,0 +1,124 @@
+package flags
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	sdk "github.com/opsagent/opsagent/pkg/flags"
+)
+func TestSaveFlagTracksRevisions(t *testing.T) {
+	ctx := context.Background()
+	fs := NewFlagService(t.TempDir())
+	flag := &sdk.Flag{Key: "checkout", ProjectID: "proj", Environment: "production", Enabled: true, Percentage: 50}
+	if err := fs.SaveFlag(ctx, flag); err != nil {
+		t.Fatalf("SaveFlag: %v", err)
+	}
+	created := flag.CreatedAt
+	update := &sdk.Flag{Key: "checkout", ProjectID: "proj", Environment: "production", Percentage: 10}
+	if err := fs.SaveFlag(ctx, update); err != nil {
+		t.Fatalf("SaveFlag: %v", err)
+	}
+	stored, err := fs.GetFlag(ctx, "proj", "production", "checkout")
+	if err != nil {
+		t.Fatalf("GetFlag: %v", err)
+	}
+	if stored.Revision != 2 || !stored.CreatedAt.Equal(created) || stored.Enabled || stored.Percentage != 10 {
+		t.Fatalf("unexpected stored flag %+v", stored)
+	}
+	if _, err := fs.GetFlag(ctx, "proj", "staging", "checkout"); !errors.Is(err, ErrFlagNotFound) {
+		t.Fatalf("expected flags to be scoped to their environment, got %v", err)
+	}
+}
+func TestSaveFlagValidates(t *testing.T) {
+	tests := []struct {
+		name    string
+		flag    sdk.Flag
+		wantErr string
+	}{
+		{name: "missing environment", flag: sdk.Flag{Key: "a", ProjectID: "proj"}, wantErr: "requires a project and an environment"},
+		{name: "path in the key", flag: sdk.Flag{Key: "../secrets", ProjectID: "proj", Environment: "production"}, wantErr: "invalid flag key"},
+		{name: "percentage", flag: sdk.Flag{Key: "a", ProjectID: "proj", Environment: "production", Percentage: 101}, wantErr: "between 0 and 100"},
+		{name: "unknown operator", flag: sdk.Flag{Key: "a", ProjectID: "proj", Environment: "production", Rules: []sdk.TargetingRule{{Attribute: "plan", Operator: "matches", Values: []string{"pro"}}}}, wantErr: `unknown targeting operator "matches"`},
+		{name: "rule without values", flag: sdk.Flag{Key: "a", ProjectID: "proj", Environment: "production", Rules: []sdk.TargetingRule{{Attribute: "plan", Operator: sdk.OperatorIn}}}, wantErr: "requires at least one value"},
+		{name: "project outside the store", flag: sdk.Flag{Key: "a", ProjectID: "..", Environment: "production"}, wantErr: "invalid project or environment"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flag := tt.flag
+			err := NewFlagService(t.TempDir()).SaveFlag(context.Background(), &flag)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+func TestSetFlagReportsPreviousState(t *testing.T) {
+	ctx := context.Background()
+	fs := NewFlagService(t.TempDir())
+	previous, existed, err := fs.SetFlag(ctx, "proj", "production", "new-nav", true)
+	if err != nil || previous || existed {
+		t.Fatalf("expected a new flag to be created, got previous=%v existed=%v (%v)", previous, existed, err)
+	}
+	created, err := fs.GetFlag(ctx, "proj", "production", "new-nav")
+	if err != nil {
+		t.Fatalf("GetFlag: %v", err)
+	}
+	if !created.Enabled || created.Percentage != 100 || created.UpdatedBy != "deployment" || created.Revision != 1 {
+		t.Fatalf("unexpected created flag %+v", created)
+	}
+	previous, existed, err = fs.SetFlag(ctx, "proj", "production", "new-nav", false)
+	if err != nil || !previous || !existed {
+		t.Fatalf("expected the previous value to be reported, got previous=%v existed=%v (%v)", previous, existed, err)
+	}
+	if err := fs.DeleteFlag(ctx, "proj", "production", "new-nav"); err != nil {
+		t.Fatalf("DeleteFlag: %v", err)
+	}
+	if err := fs.DeleteFlag(ctx, "proj", "production", "new-nav"); err != nil {
+		t.Fatalf("expected deleting a missing flag to succeed, got %v", err)
+	}
+	flags, err := fs.ListFlags(ctx, "proj", "production")
+	if err != nil || len(flags) != 0 {
+		t.Fatalf("expected no flags after delete, got %v (%v)", flags, err)
+	}
+}
+func TestEvaluate(t *testing.T) {
+	ctx := context.Background()
+	fs := NewFlagService(t.TempDir())
+	for _, flag := range []*sdk.Flag{
+		{Key: "beta", ProjectID: "proj", Environment: "production", Enabled: true, Percentage: 0, Rules: []sdk.TargetingRule{{Name: "staff", Attribute: "email", Operator: sdk.OperatorContains, Values: []string{"@opsagent.dev"}, Serve: true}}},
+		{Key: "dark-mode", ProjectID: "proj", Environment: "production", Enabled: false, Percentage: 100},
+		{Key: "search", ProjectID: "proj", Environment: "production", Enabled: true, Percentage: 100},
+	} {
+		if err := fs.SaveFlag(ctx, flag); err != nil {
+			t.Fatalf("SaveFlag: %v", err)
+		}
+	}
+	evalCtx := sdk.EvaluationContext{UserID: "u1", Attributes: map[string]string{"email": "dev@opsagent.dev"}}
+	all, err := fs.Evaluate(ctx, "proj", "production", nil, evalCtx)
+	if err != nil {
+		t.Fatalf("Evaluate: %v", err)
+	}
+	want := map[string]sdk.Evaluation{
+		"beta":      {Key: "beta", Enabled: true, Reason: sdk.ReasonRule, Rule: "staff"},
+		"dark-mode": {Key: "dark-mode", Reason: sdk.ReasonDisabled},
+		"search":    {Key: "search", Enabled: true, Reason: sdk.ReasonDefault},
+	}
+	if len(all) != len(want) {
+		t.Fatalf("expected %d evaluations, got %+v", len(want), all)
+	}
+	for key, w := range want {
+		if all[key] != w {
+			t.Fatalf("expected %s to evaluate to %+v, got %+v", key, w, all[key])
+		}
+	}
+	some, err := fs.Evaluate(ctx, "proj", "production", []string{"beta", "missing"}, sdk.EvaluationContext{UserID: "u2"})
+	if err != nil {
+		t.Fatalf("Evaluate: %v", err)
+	}
+	if len(some) != 2 || some["beta"].Reason != sdk.ReasonRollout || some["beta"].Enabled {
+		t.Fatalf("expected untargeted users to fall through to a 0%% rollout, got %+v", some["beta"])
+	}
+	if some["missing"] != (sdk.Evaluation{Key: "missing", Reason: sdk.ReasonNotFound}) {
+		t.Fatalf("expected the missing flag to be reported, got %+v", some["missing"])
+	}
+}
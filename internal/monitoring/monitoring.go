@@ -221,6 +221,38 @@ func (ms *MonitoringService) triggerAlert(alert *Alert, metric *Metric) {
 		go ms.sendNotification(channel, title, message, alert.Severity)
 	}
 }
+func (ms *MonitoringService) RaiseAlert(ctx context.Context, projectID, environmentID, alertType, severity, title, message string, metadata map[string]interface{}) error {
+	var exists bool
+	err := ms.db.QueryRowContext(ctx, `
+		SELECT EXISTS(
+			SELECT 1 FROM alerts
+			WHERE alert_type = $1 AND project_id = $2 AND status = 'triggered'
+			AND metadata->>'environment_id' = $3
+		)
+	`, alertType, projectID, environmentID).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to check existing alerts: %w", err)
+	}
+	if exists {
+		return nil
+	}
+	if metadata == nil {
+		metadata = map[string]interface{}{}
+	}
+	metadata["environment_id"] = environmentID
+	metadataJSON, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("failed to marshal alert metadata: %w", err)
+	}
+	_, err = ms.db.ExecContext(ctx, `
+		INSERT INTO alerts (project_id, alert_type, severity, title, message, status, metadata)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, projectID, alertType, severity, title, message, "triggered", metadataJSON)
+	if err != nil {
+		return fmt.Errorf("failed to raise alert: %w", err)
+	}
+	return nil
+}
 func (ms *MonitoringService) sendNotification(channel, title, message, severity string) {
 	switch channel {
 	case "email":
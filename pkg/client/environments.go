@@ -0,0 +1,62 @@
+package client
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+type SecretRules struct {
+	Patterns []string `json:"patterns,omitempty"`
+	Secrets  []string `json:"secrets,omitempty"`
+	Plain    []string `json:"plain,omitempty"`
+}
+type ImportRequest struct {
+	Format   string       `json:"format"`
+	Content  string       `json:"content"`
+	Mode     string       `json:"mode,omitempty"`
+	DryRun   bool         `json:"dry_run"`
+	Rules    *SecretRules `json:"rules,omitempty"`
+	Revision int64        `json:"revision,omitempty"`
+}
+type EnvChange struct {
+	Key    string `json:"key"`
+	Kind   string `json:"kind"`
+	Action string `json:"action"`
+	From   string `json:"from,omitempty"`
+	To     string `json:"to,omitempty"`
+}
+type ImportResult struct {
+	EnvironmentID string      `json:"environment_id"`
+	DryRun        bool        `json:"dry_run"`
+	Changes       []EnvChange `json:"changes"`
+	Variables     int         `json:"variables"`
+	Secrets       int         `json:"secrets"`
+	Revision      int64       `json:"revision"`
+}
+func (c *Client) ImportEnvironment(ctx context.Context, projectID, envName string, req ImportRequest) (*ImportResult, error) {
+	path := fmt.Sprintf("/api/v1/projects/%s/environments/%s/import", url.PathEscape(projectID), url.PathEscape(envName))
+	resp, err := c.post(ctx, path, req)
+	if err != nil {
+		return nil, err
+	}
+	var result ImportResult
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return &result, nil
+}
+func (c *Client) ExportEnvironment(ctx context.Context, projectID, envName, format string, revealSecrets bool) ([]byte, error) {
+	query := url.Values{}
+	if format != "" {
+		query.Set("format", format)
+	}
+	if revealSecrets {
+		query.Set("reveal_secrets", strconv.FormatBool(true))
+	}
+	path := fmt.Sprintf("/api/v1/projects/%s/environments/%s/export", url.PathEscape(projectID), url.PathEscape(envName))
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	return c.get(ctx, path)
+}
@@ -0,0 +1,77 @@
+package client
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+)
+type PromotionRequest struct {
+	Source           string `json:"source"`
+	Target           string `json:"target"`
+	SourceDeployment string `json:"source_deployment,omitempty"`
+	Strategy         string `json:"strategy,omitempty"`
+}
+type ConfigChange struct {
+	Field string `json:"field"`
+	From  string `json:"from,omitempty"`
+	To    string `json:"to,omitempty"`
+}
+type PromotionPreview struct {
+	ProjectID         string         `json:"project_id"`
+	Source            string         `json:"source"`
+	Target            string         `json:"target"`
+	SourceDeployment  string         `json:"source_deployment"`
+	CurrentDeployment string         `json:"current_deployment,omitempty"`
+	Version           string         `json:"version"`
+	Image             string         `json:"image"`
+	ImageDigest       string         `json:"image_digest,omitempty"`
+	Changes           []ConfigChange `json:"changes"`
+	Excluded          []string       `json:"excluded,omitempty"`
+	Transformed       []string       `json:"transformed,omitempty"`
+	Warnings          []string       `json:"warnings,omitempty"`
+}
+type DeploymentRecord struct {
+	ID           string    `json:"id"`
+	ProjectID    string    `json:"project_id"`
+	Environment  string    `json:"environment"`
+	Version      string    `json:"version"`
+	Image        string    `json:"image"`
+	Status       string    `json:"status"`
+	DeployedAt   time.Time `json:"deployed_at"`
+	DeployedBy   string    `json:"deployed_by"`
+	PromotedFrom string    `json:"promoted_from,omitempty"`
+}
+func (c *Client) PreviewPromotion(ctx context.Context, projectID string, req PromotionRequest) (*PromotionPreview, error) {
+	resp, err := c.post(ctx, fmt.Sprintf("/api/v1/projects/%s/promotions/preview", url.PathEscape(projectID)), req)
+	if err != nil {
+		return nil, err
+	}
+	var preview PromotionPreview
+	if err := json.Unmarshal(resp, &preview); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return &preview, nil
+}
+func (c *Client) Promote(ctx context.Context, projectID string, req PromotionRequest) (*DeploymentRecord, error) {
+	resp, err := c.post(ctx, fmt.Sprintf("/api/v1/projects/%s/promotions", url.PathEscape(projectID)), req)
+	if err != nil {
+		return nil, err
+	}
+	var record DeploymentRecord
+	if err := json.Unmarshal(resp, &record); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return &record, nil
+}
+func (c *Client) DeploymentLineage(ctx context.Context, projectID, deploymentID string) ([]DeploymentRecord, error) {
+	resp, err := c.get(ctx, fmt.Sprintf("/api/v1/projects/%s/deployments/%s/lineage", url.PathEscape(projectID), url.PathEscape(deploymentID)))
+	if err != nil {
+		return nil, err
+	}
+	var lineage []DeploymentRecord
+	if err := json.Unmarshal(resp, &lineage); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return lineage, nil
+}
@@ -0,0 +1,290 @@
+package flags
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+type Operator string
+const (
+	OperatorEquals     Operator = "equals"
+	OperatorNotEquals  Operator = "not_equals"
+	OperatorIn         Operator = "in"
+	OperatorNotIn      Operator = "not_in"
+	OperatorContains   Operator = "contains"
+	OperatorStartsWith Operator = "starts_with"
+)
+const (
+	ReasonDisabled = "disabled"
+	ReasonRule     = "rule"
+	ReasonRollout  = "rollout"
+	ReasonDefault  = "default"
+	ReasonNotFound = "not_found"
+)
+type Flag struct {
+	Key         string          `json:"key"`
+	ProjectID   string          `json:"project_id"`
+	Environment string          `json:"environment"`
+	Description string          `json:"description,omitempty"`
+	Enabled     bool            `json:"enabled"`
+	Percentage  int             `json:"percentage"`
+	Rules       []TargetingRule `json:"rules,omitempty"`
+	Revision    int             `json:"revision"`
+	UpdatedBy   string          `json:"updated_by,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+type TargetingRule struct {
+	Name      string   `json:"name,omitempty"`
+	Attribute string   `json:"attribute"`
+	Operator  Operator `json:"operator"`
+	Values    []string `json:"values"`
+	Serve     bool     `json:"serve"`
+}
+type EvaluationContext struct {
+	UserID     string            `json:"user_id,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+type Evaluation struct {
+	Key     string `json:"key"`
+	Enabled bool   `json:"enabled"`
+	Reason  string `json:"reason"`
+	Rule    string `json:"rule,omitempty"`
+}
+func Evaluate(flag *Flag, evalCtx EvaluationContext) Evaluation {
+	if flag == nil {
+		return Evaluation{Reason: ReasonNotFound}
+	}
+	result := Evaluation{Key: flag.Key}
+	if !flag.Enabled {
+		result.Reason = ReasonDisabled
+		return result
+	}
+	for i, rule := range flag.Rules {
+		if rule.matches(evalCtx) {
+			result.Enabled = rule.Serve
+			result.Reason = ReasonRule
+			result.Rule = rule.Name
+			if result.Rule == "" {
+				result.Rule = fmt.Sprintf("%d", i)
+			}
+			return result
+		}
+	}
+	if flag.Percentage < 100 {
+		result.Reason = ReasonRollout
+		result.Enabled = evalCtx.UserID != "" && Bucket(flag.Key, evalCtx.UserID) < flag.Percentage
+		return result
+	}
+	result.Enabled = true
+	result.Reason = ReasonDefault
+	return result
+}
+func Bucket(key, userID string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	h.Write([]byte{0})
+	h.Write([]byte(userID))
+	return int(h.Sum32() % 100)
+}
+func (r TargetingRule) Validate() error {
+	if r.Attribute == "" {
+		return fmt.Errorf("targeting rule requires an attribute")
+	}
+	switch r.Operator {
+	case OperatorEquals, OperatorNotEquals, OperatorIn, OperatorNotIn, OperatorContains, OperatorStartsWith:
+	default:
+		return fmt.Errorf("unknown targeting operator %q", r.Operator)
+	}
+	if len(r.Values) == 0 {
+		return fmt.Errorf("targeting rule on %q requires at least one value", r.Attribute)
+	}
+	return nil
+}
+func (r TargetingRule) matches(evalCtx EvaluationContext) bool {
+	value, ok := evalCtx.Attributes[r.Attribute]
+	if r.Attribute == "user_id" {
+		value, ok = evalCtx.UserID, evalCtx.UserID != ""
+	}
+	if !ok {
+		return r.Operator == OperatorNotEquals || r.Operator == OperatorNotIn
+	}
+	switch r.Operator {
+	case OperatorEquals:
+		return value == r.Values[0]
+	case OperatorNotEquals:
+		return value != r.Values[0]
+	case OperatorIn:
+		return contains(r.Values, value)
+	case OperatorNotIn:
+		return !contains(r.Values, value)
+	case OperatorContains:
+		for _, v := range r.Values {
+			if strings.Contains(value, v) {
+				return true
+			}
+		}
+	case OperatorStartsWith:
+		for _, v := range r.Values {
+			if strings.HasPrefix(value, v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+type Config struct {
+	BaseURL         string
+	Token           string
+	ProjectID       string
+	Environment     string
+	RefreshInterval time.Duration
+	Timeout         time.Duration
+}
+type Client struct {
+	cfg        Config
+	httpClient *http.Client
+	mu         sync.RWMutex
+	flags      map[string]*Flag
+	lastErr    error
+}
+func NewClient(cfg Config) *Client {
+	if cfg.RefreshInterval == 0 {
+		cfg.RefreshInterval = 30 * time.Second
+	}
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	return &Client{
+		cfg: cfg,
+		httpClient: &http.Client{
+			Timeout: timeout,
+		},
+		flags: make(map[string]*Flag),
+	}
+}
+func (c *Client) Start(ctx context.Context) error {
+	if err := c.Refresh(ctx); err != nil {
+		return err
+	}
+	go func() {
+		ticker := time.NewTicker(c.cfg.RefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				c.Refresh(ctx)
+			}
+		}
+	}()
+	return nil
+}
+func (c *Client) Refresh(ctx context.Context) error {
+	path := fmt.Sprintf("/api/v1/projects/%s/environments/%s/flags",
+		url.PathEscape(c.cfg.ProjectID), url.PathEscape(c.cfg.Environment))
+	body, err := c.request(ctx, http.MethodGet, path, nil)
+	if err == nil {
+		var list []*Flag
+		if err = json.Unmarshal(body, &list); err == nil {
+			flags := make(map[string]*Flag, len(list))
+			for _, flag := range list {
+				flags[flag.Key] = flag
+			}
+			c.mu.Lock()
+			c.flags = flags
+			c.lastErr = nil
+			c.mu.Unlock()
+			return nil
+		}
+		err = fmt.Errorf("failed to parse flags: %w", err)
+	}
+	c.mu.Lock()
+	c.lastErr = err
+	c.mu.Unlock()
+	return err
+}
+func (c *Client) Evaluate(key string, evalCtx EvaluationContext) Evaluation {
+	c.mu.RLock()
+	flag := c.flags[key]
+	c.mu.RUnlock()
+	result := Evaluate(flag, evalCtx)
+	result.Key = key
+	return result
+}
+func (c *Client) Bool(key string, evalCtx EvaluationContext, fallback bool) bool {
+	result := c.Evaluate(key, evalCtx)
+	if result.Reason == ReasonNotFound {
+		return fallback
+	}
+	return result.Enabled
+}
+func (c *Client) EvaluateRemote(ctx context.Context, evalCtx EvaluationContext, keys ...string) (map[string]Evaluation, error) {
+	path := fmt.Sprintf("/api/v1/projects/%s/environments/%s/flags/evaluate",
+		url.PathEscape(c.cfg.ProjectID), url.PathEscape(c.cfg.Environment))
+	body, err := c.request(ctx, http.MethodPost, path, map[string]interface{}{
+		"context": evalCtx,
+		"flags":   keys,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var results map[string]Evaluation
+	if err := json.Unmarshal(body, &results); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return results, nil
+}
+func (c *Client) LastError() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.lastErr
+}
+func (c *Client) request(ctx context.Context, method, path string, body interface{}) ([]byte, error) {
+	var bodyReader io.Reader
+	if body != nil {
+		jsonBody, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal request: %w", err)
+		}
+		bodyReader = bytes.NewReader(jsonBody)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(c.cfg.BaseURL, "/")+path, bodyReader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.cfg.Token)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("API error: %s", string(respBody))
+	}
+	return respBody, nil
+}
@@ -0,0 +1,135 @@
+package flags
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+func TestEvaluateTargeting(t *testing.T) {
+	rule := func(op Operator, values ...string) TargetingRule {
+		return TargetingRule{Name: string(op), Attribute: "plan", Operator: op, Values: values, Serve: true}
+	}
+	tests := []struct {
+		name       string
+		rule       TargetingRule
+		attributes map[string]string
+		want       bool
+	}{
+		{name: "equals", rule: rule(OperatorEquals, "pro"), attributes: map[string]string{"plan": "pro"}, want: true},
+		{name: "equals misses", rule: rule(OperatorEquals, "pro"), attributes: map[string]string{"plan": "free"}},
+		{name: "not equals", rule: rule(OperatorNotEquals, "pro"), attributes: map[string]string{"plan": "free"}, want: true},
+		{name: "not equals without the attribute", rule: rule(OperatorNotEquals, "pro"), want: true},
+		{name: "in", rule: rule(OperatorIn, "pro", "team"), attributes: map[string]string{"plan": "team"}, want: true},
+		{name: "in without the attribute", rule: rule(OperatorIn, "pro")},
+		{name: "not in", rule: rule(OperatorNotIn, "pro", "team"), attributes: map[string]string{"plan": "free"}, want: true},
+		{name: "not in misses", rule: rule(OperatorNotIn, "pro", "team"), attributes: map[string]string{"plan": "pro"}},
+		{name: "contains", rule: rule(OperatorContains, "enter"), attributes: map[string]string{"plan": "enterprise"}, want: true},
+		{name: "starts with", rule: rule(OperatorStartsWith, "ent"), attributes: map[string]string{"plan": "enterprise"}, want: true},
+		{name: "starts with misses", rule: rule(OperatorStartsWith, "pro"), attributes: map[string]string{"plan": "enterprise"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flag := &Flag{Key: "f", Enabled: true, Percentage: 0, Rules: []TargetingRule{tt.rule}}
+			result := Evaluate(flag, EvaluationContext{UserID: "u1", Attributes: tt.attributes})
+			if tt.want && (result.Reason != ReasonRule || !result.Enabled || result.Rule != tt.rule.Name) {
+				t.Fatalf("expected the rule to serve the flag, got %+v", result)
+			}
+			if !tt.want && (result.Reason != ReasonRollout || result.Enabled) {
+				t.Fatalf("expected a fall through to the 0%% rollout, got %+v", result)
+			}
+		})
+	}
+}
+func TestEvaluateOrderAndRollout(t *testing.T) {
+	flag := &Flag{Key: "f", Enabled: true, Percentage: 100, Rules: []TargetingRule{
+		{Attribute: "user_id", Operator: OperatorIn, Values: []string{"blocked"}, Serve: false},
+		{Name: "everyone", Attribute: "user_id", Operator: OperatorStartsWith, Values: []string{""}, Serve: true},
+	}}
+	if result := Evaluate(flag, EvaluationContext{UserID: "blocked"}); result.Enabled || result.Rule != "0" {
+		t.Fatalf("expected the first matching rule to win and be named by index, got %+v", result)
+	}
+	if result := Evaluate(&Flag{Key: "f", Enabled: false, Rules: flag.Rules}, EvaluationContext{UserID: "u1"}); result.Enabled || result.Reason != ReasonDisabled {
+		t.Fatalf("expected a disabled flag to ignore its rules, got %+v", result)
+	}
+	rollout := &Flag{Key: "rollout", Enabled: true, Percentage: 30}
+	enabled := 0
+	for i := 0; i < 1000; i++ {
+		userID := "user-" + strconv.Itoa(i)
+		result := Evaluate(rollout, EvaluationContext{UserID: userID})
+		if result.Enabled != (Bucket("rollout", userID) < 30) || result.Reason != ReasonRollout {
+			t.Fatalf("expected %s to follow its bucket, got %+v", userID, result)
+		}
+		if result.Enabled {
+			enabled++
+		}
+	}
+	if enabled < 230 || enabled > 370 {
+		t.Fatalf("expected about 30%% of users, got %d of 1000", enabled)
+	}
+	if Evaluate(rollout, EvaluationContext{}).Enabled {
+		t.Fatal("expected anonymous users to stay out of a partial rollout")
+	}
+}
+func TestClient(t *testing.T) {
+	var auth, evaluated string
+	failing := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		if failing {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/v1/projects/proj/environments/production/flags":
+			json.NewEncoder(w).Encode([]*Flag{
+				{Key: "search", Enabled: true, Percentage: 100},
+				{Key: "dark-mode", Enabled: false, Percentage: 100},
+			})
+		case "/api/v1/projects/proj/environments/production/flags/evaluate":
+			var body struct {
+				Context EvaluationContext `json:"context"`
+				Flags   []string          `json:"flags"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			evaluated = body.Context.UserID + ":" + strings.Join(body.Flags, ",")
+			json.NewEncoder(w).Encode(map[string]Evaluation{"search": {Key: "search", Enabled: true, Reason: ReasonDefault}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	ctx := context.Background()
+	client := NewClient(Config{BaseURL: server.URL + "/", Token: "secret", ProjectID: "proj", Environment: "production"})
+	if !client.Bool("search", EvaluationContext{}, true) {
+		t.Fatal("expected the fallback before the first refresh")
+	}
+	if err := client.Refresh(ctx); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if auth != "Bearer secret" {
+		t.Fatalf("expected the token to be sent, got %q", auth)
+	}
+	if !client.Bool("search", EvaluationContext{}, false) || client.Bool("dark-mode", EvaluationContext{}, true) || !client.Bool("missing", EvaluationContext{}, true) {
+		t.Fatal("expected cached flags to be evaluated and unknown flags to use the fallback")
+	}
+	if result := client.Evaluate("missing", EvaluationContext{}); result.Key != "missing" || result.Reason != ReasonNotFound {
+		t.Fatalf("unexpected evaluation %+v", result)
+	}
+	results, err := client.EvaluateRemote(ctx, EvaluationContext{UserID: "u1"}, "search", "beta")
+	if err != nil {
+		t.Fatalf("EvaluateRemote: %v", err)
+	}
+	if evaluated != "u1:search,beta" || !results["search"].Enabled {
+		t.Fatalf("unexpected remote evaluation %q %+v", evaluated, results)
+	}
+	failing = true
+	if err := client.Refresh(ctx); err == nil || !strings.Contains(err.Error(), "unavailable") {
+		t.Fatalf("expected the API error, got %v", err)
+	}
+	if client.LastError() == nil || !client.Bool("search", EvaluationContext{}, false) {
+		t.Fatal("expected a failed refresh to keep the last known flags and report the error")
+	}
+}